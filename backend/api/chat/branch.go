@@ -0,0 +1,123 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	chatmodel "csdeepseek/backend/models/chat"
+	"csdeepseek/backend/services/memory"
+	"csdeepseek/backend/services/profile"
+	"csdeepseek/backend/services/session"
+)
+
+// Turn actions. A plain turn sends a new message; the others work on the
+// earlier message named by message_id.
+const (
+	actionSend = ""
+	// actionEdit adds the message as a new version of a user message and
+	// answers it
+	actionEdit = "edit"
+	// actionRegenerate answers the question of an assistant reply again
+	actionRegenerate = "regenerate"
+	// actionSwitch makes the branch through a message active, without
+	// answering anything
+	actionSwitch = "switch"
+)
+
+var (
+	errUnknownAction    = errors.New("unknown action")
+	errInvalidUserToken = errors.New("invalid user token")
+)
+
+// turnStart is what a turn adds to the session before the model is asked.
+type turnStart struct {
+	// ParentID is the message the reply answers
+	ParentID string
+	// UserMessage is the stored question; nil when regenerating
+	UserMessage *chatmodel.Message
+	Memories    []memory.Memory
+}
+
+// openSession picks how a turn finds its session: a new message may start
+// one with the named profile, owned by the user userToken was issued for,
+// anything else works on a session that already exists.
+func openSession(action, profileName, userToken string) func(context.Context, *session.Service, string, string, string) (*session.Session, func(), error) {
+	if action == actionSend {
+		return func(ctx context.Context, sessionService *session.Service, sessionID, token, userID string) (*session.Session, func(), error) {
+			return sessionForUser(ctx, sessionService, sessionID, token, userID, userToken, profileName)
+		}
+	}
+	return existingSession
+}
+
+// startTurn adds the user's side of a turn to the session.
+func startTurn(ctx context.Context, sessionService *session.Service, memoryService *memory.Service, sess *session.Session, action, messageID, message string) (*turnStart, error) {
+	switch action {
+	case actionSend:
+		memories := recallMemories(ctx, memoryService, sessionService, sess, message)
+		msg := chatmodel.NewMessage(chatmodel.RoleUser, message)
+		if err := sessionService.AddMessage(ctx, sess.ID, msg); err != nil {
+			return nil, err
+		}
+		return &turnStart{ParentID: msg.ID, UserMessage: &msg, Memories: memories}, nil
+	case actionEdit:
+		msg, err := sessionService.EditMessage(ctx, sess.ID, messageID, message)
+		if err != nil {
+			return nil, err
+		}
+		return &turnStart{ParentID: msg.ID, UserMessage: &msg}, nil
+	case actionRegenerate:
+		parentID, err := sessionService.ReplyTo(ctx, sess.ID, messageID)
+		if err != nil {
+			return nil, err
+		}
+		return &turnStart{ParentID: parentID}, nil
+	}
+	return nil, errUnknownAction
+}
+
+// validateTurn checks the fields a turn's action needs, returning the error
+// to show the client.
+func validateTurn(action, sessionID, messageID, message string) string {
+	switch action {
+	case actionSend:
+		return ""
+	case actionEdit, actionRegenerate, actionSwitch:
+	default:
+		return "Unknown action"
+	}
+	if sessionID == "" || messageID == "" {
+		return "Session ID and message ID are required"
+	}
+	if action == actionEdit && message == "" {
+		return "Message is required"
+	}
+	return ""
+}
+
+// clientError maps the errors a client can cause while starting a turn to
+// a message and status code. It reports false for internal errors.
+func clientError(err error) (string, int, bool) {
+	switch {
+	case errors.Is(err, session.ErrInvalidToken):
+		return "Invalid session token", http.StatusForbidden, true
+	case errors.Is(err, errInvalidUserToken):
+		return "Invalid user token", http.StatusUnauthorized, true
+	case errors.Is(err, session.ErrNotFound):
+		return "Session not found", http.StatusNotFound, true
+	case errors.Is(err, session.ErrMessageNotFound):
+		return "Message not found", http.StatusNotFound, true
+	case errors.Is(err, session.ErrWrongRole):
+		return "Message cannot be used for this action", http.StatusBadRequest, true
+	case errors.Is(err, session.ErrLimitExceeded):
+		return "Session limit reached", http.StatusTooManyRequests, true
+	case errors.Is(err, session.ErrMessageTooLarge):
+		return "Message too large", http.StatusRequestEntityTooLarge, true
+	case errors.Is(err, errUnknownAction):
+		return "Unknown action", http.StatusBadRequest, true
+	case errors.Is(err, profile.ErrNotFound):
+		return "Unknown profile", http.StatusBadRequest, true
+	}
+	return "", 0, false
+}
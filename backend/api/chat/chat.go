@@ -7,33 +7,84 @@ import (
 	"net/http"
 	"time"
 
+	chatmodel "csdeepseek/backend/models/chat"
 	"csdeepseek/backend/services/llm"
+	"csdeepseek/backend/services/memory"
+	"csdeepseek/backend/services/profile"
+	"csdeepseek/backend/services/retrieval"
 	"csdeepseek/backend/services/session"
-	"csdeepseek/backend/services/vector"
+	"csdeepseek/backend/services/summary"
 )
 
 type Handler struct {
 	llmService     *llm.Service
-	vectorService  *vector.Service
 	sessionService *session.Service
+	memoryService  *memory.Service
+	summaryService *summary.Service
+	profiles       *profile.Service
+	retriever      *retrieval.Service
 }
 
 type ChatRequest struct {
 	SessionID string `json:"session_id"`
+	// SessionToken is the token returned with the session, required to
+	// continue it; the user's own token from the sign-in service works too
+	SessionToken string `json:"session_token,omitempty"`
+	// UserID identifies a returning user across sessions; empty for
+	// anonymous chats, which have no long-term memory
+	UserID string `json:"user_id,omitempty"`
+	// UserToken is the token the sign-in service issued for UserID. It is
+	// required to start a session for the user, and long-term memory is
+	// only used with it.
+	UserToken string `json:"user_token,omitempty"`
 	Message   string `json:"message"`
+	// Profile names the assistant profile of a new session; empty means
+	// the default one. Continued sessions keep the profile they have.
+	Profile string `json:"profile,omitempty"`
+	// Action is empty to send Message, "edit" to send it as a new version
+	// of the user message MessageID, or "regenerate" to answer the question
+	// of the reply MessageID again. Edits and regenerations start a branch
+	// of the conversation, which becomes the one continued.
+	Action    string `json:"action,omitempty"`
+	MessageID string `json:"message_id,omitempty"`
+	// Debug asks for the turn's trace in the response
+	Debug bool `json:"debug,omitempty"`
 }
 
 type ChatResponse struct {
-	SessionID string    `json:"session_id"`
-	Message   string    `json:"message"`
-	Timestamp time.Time `json:"timestamp"`
+	SessionID    string    `json:"session_id"`
+	SessionToken string    `json:"session_token"`
+	Message      string    `json:"message"`
+	Timestamp    time.Time `json:"timestamp"`
+	// Profile is the assistant profile the session uses
+	Profile string `json:"profile"`
+	// Reply and UserMessage are the stored messages of the turn, with their
+	// IDs and generation details
+	Reply       *chatmodel.Message `json:"reply,omitempty"`
+	UserMessage *chatmodel.Message `json:"user_message,omitempty"`
+	Debug       *TurnTrace         `json:"debug,omitempty"`
 }
 
-func NewHandler(llmService *llm.Service, vectorService *vector.Service, sessionService *session.Service) *Handler {
+// TurnTrace records what happened while answering a turn, for debugging.
+type TurnTrace struct {
+	Retrieval *retrieval.Trace `json:"retrieval,omitempty"`
+	Memories  []memory.Memory  `json:"memories,omitempty"`
+}
+
+// NewHandler creates a chat handler. retriever may be nil to answer without
+// the knowledge base, profiles to give every session the built-in default
+// profile.
+func NewHandler(llmService *llm.Service, retriever *retrieval.Service, sessionService *session.Service, memoryService *memory.Service, summaryService *summary.Service, profiles *profile.Service) *Handler {
+	if profiles == nil {
+		profiles, _ = profile.New(nil, "")
+	}
 	return &Handler{
 		llmService:     llmService,
-		vectorService:  vectorService,
 		sessionService: sessionService,
+		memoryService:  memoryService,
+		summaryService: summaryService,
+		profiles:       profiles,
+		retriever:      retriever,
 	}
 }
 
@@ -66,71 +117,111 @@ func (h *Handler) HandleChat(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
 	defer cancel()
 
-	// Get or create session
-	var sess *session.Session
-	var err error
-	if req.SessionID == "" {
-		sess, err = h.sessionService.CreateSession(ctx)
-		if err != nil {
-			log.Printf("Failed to create session: %v", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+	if req.UserID != "" {
+		if err := memory.ValidateUserID(req.UserID); err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
 			return
 		}
-	} else {
-		sess, err = h.sessionService.GetSession(ctx, req.SessionID)
-		if err != nil {
-			log.Printf("Session not found, creating new session: %v", err)
-			sess, err = h.sessionService.CreateSession(ctx)
-			if err != nil {
-				log.Printf("Failed to create session: %v", err)
-				http.Error(w, "Internal server error", http.StatusInternalServerError)
-				return
-			}
-		}
+	}
+	if req.Action == actionSwitch {
+		http.Error(w, "Unknown action", http.StatusBadRequest)
+		return
+	}
+	if msg := validateTurn(req.Action, req.SessionID, req.MessageID, req.Message); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	if _, err := h.profiles.Get(req.Profile); err != nil {
+		msg, code, _ := clientError(err)
+		http.Error(w, msg, code)
+		return
 	}
 
-	// Add user message to session
-	if err := h.sessionService.AddMessage(ctx, sess.ID, session.Message{
-		Role:    "user",
-		Content: req.Message,
-	}); err != nil {
+	// Get or create session
+	sess, unlock, err := openSession(req.Action, req.Profile, req.UserToken)(ctx, h.sessionService, req.SessionID, req.SessionToken, req.UserID)
+	if msg, code, ok := clientError(err); ok {
+		http.Error(w, msg, code)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to create session: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer unlock()
+	prof := h.profiles.ForSession(sess.Profile)
+	memoryService := memoryFor(h.memoryService, prof, h.sessionService, sess, req.UserToken)
+
+	// Add the user's side of the turn. A returning user's new session starts
+	// with what we remember of them.
+	start, err := startTurn(ctx, h.sessionService, memoryService, sess, req.Action, req.MessageID, req.Message)
+	if msg, code, ok := clientError(err); ok {
+		http.Error(w, msg, code)
+		return
+	}
+	if err != nil {
 		log.Printf("Failed to add message: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
-
-	// Convert session.Messages to []llm.Message
-	llmMessages := make([]llm.Message, len(sess.Messages))
-	for i, m := range sess.Messages {
-		llmMessages[i] = llm.Message{
-			Role:    m.Role,
-			Content: m.Content,
-		}
+	trace := TurnTrace{Memories: start.Memories}
+	sess, err = h.sessionService.GetSession(ctx, sess.ID)
+	if err != nil {
+		log.Printf("Failed to reload session: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
 	}
 
-	// Generate response using full session history
-	response, err := h.llmService.GenerateResponse(ctx, llmMessages)
+	// The model sees only the branch leading to the question
+	branch := sess.PathTo(start.ParentID)
+	question := branch[len(branch)-1].Content
+	llmMessages := branchHistory(h.summaryService, sess, branch)
+
+	// Give the model the knowledge base passages for the turn
+	var citations []chatmodel.Citation
+	llmMessages, citations, trace.Retrieval = withKnowledge(ctx, h.retriever, prof, llmMessages, question)
+
+	// Generate response using the branch's history, as the profile's
+	// assistant
+	started := time.Now()
+	completion, err := h.llmService.Complete(ctx, prof.Messages(llmMessages), prof.Options())
 	if err != nil {
 		log.Printf("Failed to generate response: %v", err)
+		failed := chatmodel.NewMessage(chatmodel.RoleAssistant, "")
+		failed.Status = chatmodel.StatusFailed
+		failed.ParentID = start.ParentID
+		failed.LatencyMillis = time.Since(started).Milliseconds()
+		if err := h.sessionService.AddMessage(ctx, sess.ID, failed); err != nil {
+			log.Printf("Failed to record failed reply: %v", err)
+		}
 		http.Error(w, "Failed to generate response", http.StatusInternalServerError)
 		return
 	}
 
 	// Add assistant message to session
-	if err := h.sessionService.AddMessage(ctx, sess.ID, session.Message{
-		Role:    "assistant",
-		Content: response,
-	}); err != nil {
+	reply := completion.Message()
+	reply.ParentID = start.ParentID
+	reply.Citations = citations
+	if err := h.sessionService.AddMessage(ctx, sess.ID, reply); err != nil {
 		log.Printf("Failed to add message: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
+	rememberTurn(memoryService, sess, append(llmMessages, llm.Message{Role: reply.Role, Content: reply.Content}))
+	summarizeTurn(h.summaryService, sess.ID)
 
 	// Send response
 	resp := ChatResponse{
-		SessionID: sess.ID,
-		Message:   response,
-		Timestamp: time.Now(),
+		SessionID:    sess.ID,
+		SessionToken: h.sessionService.Token(sess.ID, sess.UserID),
+		Message:      reply.Content,
+		Timestamp:    time.Now(),
+		Profile:      prof.Name,
+		Reply:        &reply,
+		UserMessage:  start.UserMessage,
+	}
+	if req.Debug {
+		resp.Debug = &trace
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,42 @@
+package chat
+
+import (
+	"context"
+	"log"
+
+	chatmodel "csdeepseek/backend/models/chat"
+	"csdeepseek/backend/services/llm"
+	"csdeepseek/backend/services/profile"
+	"csdeepseek/backend/services/retrieval"
+)
+
+// withKnowledge retrieves knowledge base passages for a turn from the
+// profile's collection, or the configured one, and gives them to the model
+// right before question, the last of messages. It returns the messages to
+// send, the citations of the passages and the retrieval trace. When the
+// profile does not use the knowledge base, retriever is nil, or retrieval
+// fails, the messages are returned unchanged.
+func withKnowledge(ctx context.Context, retriever *retrieval.Service, prof *profile.Profile, messages []llm.Message, question string) ([]llm.Message, []chatmodel.Citation, *retrieval.Trace) {
+	if retriever == nil || !prof.Uses(profile.ToolKnowledge) {
+		return messages, nil, nil
+	}
+	collection := prof.Collection
+	if collection == "" {
+		collection = retriever.Collection()
+	}
+	if collection == "" {
+		return messages, nil, nil
+	}
+
+	history := messages[:len(messages)-1]
+	results, trace, err := retriever.RetrieveFrom(ctx, collection, history, question)
+	if err != nil {
+		log.Printf("Retrieval failed, answering without context: %v", err)
+		return messages, nil, trace
+	}
+	if len(results) == 0 {
+		return messages, nil, trace
+	}
+	latest := messages[len(messages)-1]
+	return append(history[:len(history):len(history)], retrieval.ContextMessage(results), latest), retrieval.Citations(results), trace
+}
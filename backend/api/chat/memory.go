@@ -0,0 +1,125 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	chatmodel "csdeepseek/backend/models/chat"
+	"csdeepseek/backend/services/llm"
+	"csdeepseek/backend/services/memory"
+	"csdeepseek/backend/services/profile"
+	"csdeepseek/backend/services/session"
+)
+
+// extractWindow is how many of the latest messages memory extraction sees
+// after each turn.
+const extractWindow = 4
+
+// sessionForUser returns the session to continue, creating a new one owned
+// by userID with the named profile when sessionID is empty or unknown. The
+// profile of a continued session is kept. Continuing a session needs
+// the token issued for it and userID; otherwise session.ErrInvalidToken is
+// returned. Creating one for a user needs userToken, the token the sign-in
+// service issued for userID; otherwise errInvalidUserToken is returned. The
+// session's turn lock is held until the returned unlock is called, and the
+// session is read under it.
+func sessionForUser(ctx context.Context, sessionService *session.Service, sessionID, token, userID, userToken, profileName string) (*session.Session, func(), error) {
+	if sessionID != "" {
+		sess, unlock, err := existingSession(ctx, sessionService, sessionID, token, userID)
+		if err == nil || errors.Is(err, session.ErrInvalidToken) {
+			return sess, unlock, err
+		}
+		log.Printf("Session not found, creating new session: %v", err)
+	}
+
+	if userID != "" && sessionService.VerifyUserToken(userToken, userID) != nil {
+		return nil, nil, errInvalidUserToken
+	}
+	sess, err := sessionService.CreateSessionWithProfile(ctx, userID, profileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	unlock, err := sessionService.LockTurn(ctx, sess.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sess, unlock, nil
+}
+
+// existingSession is sessionForUser for turns that only make sense in an
+// existing session, such as editing one of its messages.
+func existingSession(ctx context.Context, sessionService *session.Service, sessionID, token, userID string) (*session.Session, func(), error) {
+	if err := sessionService.VerifyToken(token, sessionID, userID); err != nil {
+		return nil, nil, err
+	}
+	unlock, err := sessionService.LockTurn(ctx, sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	sess, err := sessionService.GetSessionForUser(ctx, userID, sessionID)
+	if err != nil {
+		unlock()
+		return nil, nil, err
+	}
+	return sess, unlock, nil
+}
+
+// memoryFor returns memoryService when the session's profile uses long-term
+// memory and the turn carries userToken, the token the sign-in service
+// issued for the session's user, and nil, which disables it, otherwise.
+// Naming a user is not enough to read or add to what we remember of them.
+func memoryFor(memoryService *memory.Service, p *profile.Profile, sessionService *session.Service, sess *session.Session, userToken string) *memory.Service {
+	if !p.Uses(profile.ToolMemory) || sess.UserID == "" {
+		return nil
+	}
+	if sessionService.VerifyUserToken(userToken, sess.UserID) != nil {
+		return nil
+	}
+	return memoryService
+}
+
+// recallMemories starts a new session of a returning user with a system
+// message holding the memories relevant to their first message. It returns
+// the memories used.
+func recallMemories(ctx context.Context, memoryService *memory.Service, sessionService *session.Service, sess *session.Session, message string) []memory.Memory {
+	if memoryService == nil || !memoryService.Enabled() || sess.UserID == "" || len(sess.Messages) > 0 {
+		return nil
+	}
+
+	memories, err := memoryService.Recall(ctx, sess.UserID, message)
+	if err != nil {
+		log.Printf("Failed to recall memories: %v", err)
+		return nil
+	}
+	if len(memories) == 0 {
+		return nil
+	}
+	if err := sessionService.AddMessage(ctx, sess.ID, chatmodel.NewMessage(chatmodel.RoleSystem, memory.SystemPrompt(memories))); err != nil {
+		log.Printf("Failed to add memories to session: %v", err)
+		return nil
+	}
+	return memories
+}
+
+// rememberTurn extracts memories from the latest messages of a user's
+// session in the background, so the reply is not held up by the LLM call.
+func rememberTurn(memoryService *memory.Service, sess *session.Session, messages []llm.Message) {
+	if memoryService == nil || !memoryService.Enabled() || sess.UserID == "" {
+		return
+	}
+	if len(messages) > extractWindow {
+		messages = messages[len(messages)-extractWindow:]
+	}
+	messages = append([]llm.Message(nil), messages...)
+	userID, sessionID := sess.UserID, sess.ID
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		defer cancel()
+		if _, err := memoryService.Extract(ctx, userID, sessionID, messages); err != nil {
+			log.Printf("Failed to extract memories: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,38 @@
+package chat
+
+import (
+	"context"
+	"log"
+	"time"
+
+	chatmodel "csdeepseek/backend/models/chat"
+	"csdeepseek/backend/services/llm"
+	"csdeepseek/backend/services/session"
+	"csdeepseek/backend/services/summary"
+)
+
+// branchHistory returns the model's messages for a branch of the session,
+// shortened with the session's summary when there is one.
+func branchHistory(summaryService *summary.Service, sess *session.Session, branch []chatmodel.Message) []llm.Message {
+	if summaryService == nil {
+		return llm.WireMessages(branch)
+	}
+	return summaryService.History(sess, branch)
+}
+
+// summarizeTurn refreshes the session's title and summary in the
+// background. A new title reaches the session's subscribers through the
+// session service.
+func summarizeTurn(summaryService *summary.Service, sessionID string) {
+	if summaryService == nil || !summaryService.Enabled() {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		defer cancel()
+		if _, err := summaryService.Refresh(ctx, sessionID); err != nil {
+			log.Printf("Failed to summarize session: %v", err)
+		}
+	}()
+}
@@ -5,47 +5,107 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gorilla/websocket"
 
+	chatmodel "csdeepseek/backend/models/chat"
 	"csdeepseek/backend/services/llm"
+	"csdeepseek/backend/services/memory"
+	"csdeepseek/backend/services/profile"
+	"csdeepseek/backend/services/retrieval"
 	"csdeepseek/backend/services/session"
+	"csdeepseek/backend/services/summary"
 )
 
 type WSHandler struct {
 	llmService     llm.LLMStreamer
+	retriever      *retrieval.Service
 	sessionService *session.Service
+	memoryService  *memory.Service
+	summaryService *summary.Service
+	profiles       *profile.Service
 }
 
 type wsChatRequest struct {
-	SessionID string `json:"session_id"`
-	Message   string `json:"message"`
+	SessionID    string `json:"session_id"`
+	SessionToken string `json:"session_token,omitempty"`
+	UserID       string `json:"user_id,omitempty"`
+	UserToken    string `json:"user_token,omitempty"`
+	Message      string `json:"message"`
+	// UserToken, Profile, Action and MessageID work as in ChatRequest. "switch" also makes the
+	// branch through MessageID active, answering with just "done".
+	// "subscribe" follows the session without sending anything, answering
+	// with "subscribed".
+	Profile   string `json:"profile,omitempty"`
+	Action    string `json:"action,omitempty"`
+	MessageID string `json:"message_id,omitempty"`
+	// Debug asks for the turn's trace with "done"
+	Debug bool `json:"debug,omitempty"`
 }
 
 type wsChatToken struct {
-	Type    string `json:"type"` // "token" or "done" or "error"
+	// Type is "token", "done" or "error" while answering a turn. Changes to
+	// the sessions the connection chatted in or subscribed to may arrive
+	// at any time: "message" carries a message added elsewhere, such as on
+	// another device, "token" with a SessionID a piece of a reply streamed
+	// elsewhere, "title" the session's new title in Content, and "deleted"
+	// ends the session.
+	Type    string `json:"type"`
 	Content string `json:"content"`
+	// SessionID and SessionToken are sent with "done", for continuing the
+	// session
+	SessionID    string `json:"session_id,omitempty"`
+	SessionToken string `json:"session_token,omitempty"`
+	// MessageID is the stored reply's ID, sent with "done"; after a switch
+	// it is the new active leaf. With "token" and "message" it names the
+	// message concerned.
+	MessageID string `json:"message_id,omitempty"`
+	// Message is the message added, sent with "message"
+	Message *chatmodel.Message `json:"message,omitempty"`
+	// Citations are the knowledge base passages the reply was given, sent
+	// with "done"
+	Citations []chatmodel.Citation `json:"citations,omitempty"`
+	// Debug is the turn's trace, sent with "done" when the request asked
+	// for it
+	Debug *TurnTrace `json:"debug,omitempty"`
 }
 
+// actionSubscribe follows a session's changes without starting a turn.
+const actionSubscribe = "subscribe"
+
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool { return true },
 }
 
-func NewWSHandler(llmService llm.LLMStreamer, sessionService *session.Service) *WSHandler {
+// NewWSHandler creates a streaming chat handler. retriever may be nil to
+// answer without the knowledge base, memoryService to disable long-term
+// memory, summaryService to disable titles and summaries, profiles to give
+// every session the built-in default profile.
+func NewWSHandler(llmService llm.LLMStreamer, retriever *retrieval.Service, sessionService *session.Service, memoryService *memory.Service, summaryService *summary.Service, profiles *profile.Service) *WSHandler {
+	if profiles == nil {
+		profiles, _ = profile.New(nil, "")
+	}
 	return &WSHandler{
 		llmService:     llmService,
+		retriever:      retriever,
 		sessionService: sessionService,
+		memoryService:  memoryService,
+		summaryService: summaryService,
+		profiles:       profiles,
 	}
 }
 
 func (h *WSHandler) HandleWSChat(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade failed: %v", err)
 		return
 	}
+	conn := newWSConn(ws)
 	defer conn.Close()
+	defer conn.unfollowAll()
 
 	for {
 		_, msg, err := conn.ReadMessage()
@@ -60,53 +120,190 @@ func (h *WSHandler) HandleWSChat(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-		defer cancel()
-
-		// Get or create session
-		sess, err := h.sessionService.GetSession(ctx, req.SessionID)
-		if err != nil {
-			// Create new session if not found
-			sess, err = h.sessionService.CreateSession(ctx)
-			if err != nil {
-				conn.WriteJSON(wsChatToken{Type: "error", Content: "Failed to create session"})
-				continue
-			}
-		}
-
-		// Add user message to session
-		err = h.sessionService.AddMessage(ctx, sess.ID, session.Message{
-			Role:    "user",
-			Content: req.Message,
-		})
-		if err != nil {
-			conn.WriteJSON(wsChatToken{Type: "error", Content: "Failed to add message"})
+		if req.Action == actionSubscribe {
+			h.subscribe(conn, req)
 			continue
 		}
+		h.handleTurn(conn, req)
+	}
+}
 
-		// Convert session.Messages to []llm.Message
-		llmMessages := make([]llm.Message, len(sess.Messages))
-		for i, m := range sess.Messages {
-			llmMessages[i] = llm.Message{
-				Role:    m.Role,
-				Content: m.Content,
-			}
-		}
+// subscribe lets the connection follow a session it has not chatted in,
+// such as one continued on another device.
+func (h *WSHandler) subscribe(conn *wsConn, req wsChatRequest) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-		// Call DeepSeek with streaming (pseudo-code, replace with actual streaming logic)
-		stream, err := h.llmService.StreamResponse(ctx, llmMessages)
-		if err != nil {
-			conn.WriteJSON(wsChatToken{Type: "error", Content: "Failed to stream response"})
-			continue
+	if req.SessionID == "" {
+		conn.WriteJSON(wsChatToken{Type: "error", Content: "Session ID is required"})
+		return
+	}
+	err := h.sessionService.VerifyToken(req.SessionToken, req.SessionID, req.UserID)
+	if err == nil {
+		_, err = h.sessionService.GetSessionForUser(ctx, req.UserID, req.SessionID)
+	}
+	if msg, _, ok := clientError(err); ok {
+		conn.WriteJSON(wsChatToken{Type: "error", Content: msg})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load session: %v", err)
+		conn.WriteJSON(wsChatToken{Type: "error", Content: "Failed to load session"})
+		return
+	}
+	conn.follow(h.sessionService, req.SessionID)
+	conn.WriteJSON(wsChatToken{Type: "subscribed", SessionID: req.SessionID})
+}
+
+// handleTurn answers one chat message, or an edit or regeneration of an
+// earlier one, streaming the reply. The session's turn lock is held
+// throughout so turns on one session do not interleave. Other connections
+// following the session see the turn as it happens.
+func (h *WSHandler) handleTurn(conn *wsConn, req wsChatRequest) {
+	ctx, cancel := context.WithTimeout(session.WithSource(context.Background(), conn.id), 60*time.Second)
+	defer cancel()
+
+	if req.UserID != "" {
+		if err := memory.ValidateUserID(req.UserID); err != nil {
+			conn.WriteJSON(wsChatToken{Type: "error", Content: "Invalid user ID"})
+			return
 		}
+	}
+
+	if msg := validateTurn(req.Action, req.SessionID, req.MessageID, req.Message); msg != "" {
+		conn.WriteJSON(wsChatToken{Type: "error", Content: msg})
+		return
+	}
+	if _, err := h.profiles.Get(req.Profile); err != nil {
+		msg, _, _ := clientError(err)
+		conn.WriteJSON(wsChatToken{Type: "error", Content: msg})
+		return
+	}
 
-		for token := range stream {
-			if token.Type == "error" {
-				conn.WriteJSON(wsChatToken{Type: "error", Content: token.Content})
-				break
+	// Get or create session
+	sess, unlock, err := openSession(req.Action, req.Profile, req.UserToken)(ctx, h.sessionService, req.SessionID, req.SessionToken, req.UserID)
+	if msg, _, ok := clientError(err); ok {
+		conn.WriteJSON(wsChatToken{Type: "error", Content: msg})
+		return
+	}
+	if err != nil {
+		conn.WriteJSON(wsChatToken{Type: "error", Content: "Failed to create session"})
+		return
+	}
+	defer unlock()
+	conn.follow(h.sessionService, sess.ID)
+
+	if req.Action == actionSwitch {
+		h.switchBranch(ctx, conn, sess, req.MessageID)
+		return
+	}
+
+	prof := h.profiles.ForSession(sess.Profile)
+	memoryService := memoryFor(h.memoryService, prof, h.sessionService, sess, req.UserToken)
+
+	// Add the user's side of the turn
+	start, err := startTurn(ctx, h.sessionService, memoryService, sess, req.Action, req.MessageID, req.Message)
+	if msg, _, ok := clientError(err); ok {
+		conn.WriteJSON(wsChatToken{Type: "error", Content: msg})
+		return
+	}
+	if err != nil {
+		conn.WriteJSON(wsChatToken{Type: "error", Content: "Failed to add message"})
+		return
+	}
+	trace := TurnTrace{Memories: start.Memories}
+	sess, err = h.sessionService.GetSession(ctx, sess.ID)
+	if err != nil {
+		conn.WriteJSON(wsChatToken{Type: "error", Content: "Failed to load session"})
+		return
+	}
+
+	// The model sees only the branch leading to the question, and the
+	// knowledge base passages for it
+	branch := sess.PathTo(start.ParentID)
+	llmMessages := branchHistory(h.summaryService, sess, branch)
+	var citations []chatmodel.Citation
+	llmMessages, citations, trace.Retrieval = withKnowledge(ctx, h.retriever, prof, llmMessages, branch[len(branch)-1].Content)
+
+	// Call DeepSeek with streaming, as the profile's assistant
+	started := time.Now()
+	stream, err := h.llmService.StreamResponse(ctx, prof.Messages(llmMessages), prof.Options())
+	if err != nil {
+		conn.WriteJSON(wsChatToken{Type: "error", Content: "Failed to stream response"})
+		return
+	}
+
+	var response strings.Builder
+	reply := chatmodel.NewMessage(chatmodel.RoleAssistant, "")
+	reply.ParentID = start.ParentID
+	reply.Status = chatmodel.StatusPartial
+	for token := range stream {
+		if token.Type == "error" {
+			conn.WriteJSON(wsChatToken{Type: "error", Content: token.Content})
+			reply.Status = chatmodel.StatusFailed
+			break
+		}
+		if token.Type == "done" {
+			reply.Status = chatmodel.StatusComplete
+			reply.Model = token.Model
+			if token.Usage != nil {
+				reply.PromptTokens = token.Usage.PromptTokens
+				reply.CompletionTokens = token.Usage.CompletionTokens
 			}
-			conn.WriteJSON(wsChatToken{Type: "token", Content: token.Content})
+			continue
 		}
-		conn.WriteJSON(wsChatToken{Type: "done", Content: ""})
+		response.WriteString(token.Content)
+		conn.WriteJSON(wsChatToken{Type: "token", Content: token.Content})
+		h.sessionService.Publish(ctx, session.Event{Type: session.EventStreamToken, SessionID: sess.ID, MessageID: reply.ID, Token: token.Content})
+	}
+	reply.Content = response.String()
+	reply.LatencyMillis = time.Since(started).Milliseconds()
+	if reply.Status == chatmodel.StatusComplete && reply.Content == "" {
+		reply.Status = chatmodel.StatusFailed
+	}
+	if reply.Status == chatmodel.StatusComplete {
+		reply.Citations = citations
+	}
+
+	// Add assistant message to session, keeping failed and cut off replies
+	// out of later history but on record
+	if err := h.sessionService.AddMessage(ctx, sess.ID, reply); err != nil {
+		log.Printf("Failed to add message: %v", err)
+	}
+	done := wsChatToken{
+		Type:         "done",
+		SessionID:    sess.ID,
+		SessionToken: h.sessionService.Token(sess.ID, sess.UserID),
+		MessageID:    reply.ID,
+		Citations:    reply.Citations,
+	}
+	if req.Debug {
+		done.Debug = &trace
+	}
+	conn.WriteJSON(done)
+
+	if reply.Complete() {
+		rememberTurn(memoryService, sess, append(llmMessages, llm.Message{Role: reply.Role, Content: reply.Content}))
+		summarizeTurn(h.summaryService, sess.ID)
+	}
+}
+
+// switchBranch makes the branch through messageID active.
+func (h *WSHandler) switchBranch(ctx context.Context, conn *wsConn, sess *session.Session, messageID string) {
+	leaf, err := h.sessionService.SwitchBranch(ctx, sess.ID, messageID)
+	if msg, _, ok := clientError(err); ok {
+		conn.WriteJSON(wsChatToken{Type: "error", Content: msg})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to switch branch: %v", err)
+		conn.WriteJSON(wsChatToken{Type: "error", Content: "Failed to switch branch"})
+		return
 	}
+	conn.WriteJSON(wsChatToken{
+		Type:         "done",
+		SessionID:    sess.ID,
+		SessionToken: h.sessionService.Token(sess.ID, sess.UserID),
+		MessageID:    leaf,
+	})
 }
@@ -5,17 +5,28 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
+	chatmodel "csdeepseek/backend/models/chat"
 	"csdeepseek/backend/services/llm"
+	"csdeepseek/backend/services/memory"
+	"csdeepseek/backend/services/profile"
+	"csdeepseek/backend/services/retrieval"
 	"csdeepseek/backend/services/session"
+	"csdeepseek/backend/services/summary"
+	"csdeepseek/backend/services/vector"
 )
 
 type mockLLMService struct{}
 
-func (m *mockLLMService) StreamResponse(ctx context.Context, messages []llm.Message) (<-chan llm.LlmStreamToken, error) {
+func (m *mockLLMService) StreamResponse(ctx context.Context, messages []llm.Message, opts llm.Options) (<-chan llm.LlmStreamToken, error) {
 	ch := make(chan llm.LlmStreamToken)
 	go func() {
 		defer close(ch)
@@ -29,7 +40,7 @@ func (m *mockLLMService) StreamResponse(ctx context.Context, messages []llm.Mess
 func TestWSChatHandler_Basic(t *testing.T) {
 	sessSvc := session.NewService()
 	llmSvc := &mockLLMService{}
-	h := NewWSHandler(llmSvc, sessSvc)
+	h := NewWSHandler(llmSvc, nil, sessSvc, nil, nil, nil)
 
 	ts := httptest.NewServer(http.HandlerFunc(h.HandleWSChat))
 	defer ts.Close()
@@ -71,3 +82,618 @@ func TestWSChatHandler_Basic(t *testing.T) {
 		t.Fatalf("Expected at least one token 'Hello', got: %v", tokens)
 	}
 }
+
+type memoryCompleter struct{}
+
+func (memoryCompleter) GenerateResponse(ctx context.Context, messages []llm.Message) (string, error) {
+	return "[]", nil
+}
+
+func TestWSChatHandler_RecallsMemoriesForNewSessions(t *testing.T) {
+	t.Setenv("VECTOR_STORE_DIR", "")
+	t.Setenv("USER_TOKEN_SECRET", "test-secret")
+	sessSvc := session.NewService()
+	memSvc := memory.NewService(memoryCompleter{}, vector.NewServiceWithEmbedder(vector.NewHashEmbedder(64)))
+	_, err := memSvc.Add(context.Background(), memory.Memory{UserID: "alice", Text: "Owns a Pixel 8 phone."})
+	require.NoError(t, err)
+	h := NewWSHandler(&mockLLMService{}, nil, sessSvc, memSvc, nil, nil)
+
+	ts := httptest.NewServer(http.HandlerFunc(h.HandleWSChat))
+	defer ts.Close()
+	c, _, err := websocket.DefaultDialer.Dial("ws"+ts.URL[len("http"):], nil)
+	require.NoError(t, err)
+	defer c.Close()
+	turn := func(req wsChatRequest) wsChatToken {
+		require.NoError(t, c.WriteJSON(req))
+		for {
+			var resp wsChatToken
+			require.NoError(t, c.ReadJSON(&resp))
+			if resp.Type == "done" || resp.Type == "error" {
+				return resp
+			}
+		}
+	}
+
+	done := turn(wsChatRequest{UserID: "alice", UserToken: sessSvc.UserToken("alice"), Message: "My phone will not charge"})
+	require.Equal(t, "done", done.Type, done.Content)
+	sess, err := sessSvc.GetSession(context.Background(), done.SessionID)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", sess.UserID)
+	require.GreaterOrEqual(t, len(sess.Messages), 2)
+	assert.Equal(t, "system", sess.Messages[0].Role)
+	assert.Contains(t, sess.Messages[0].Content, "Owns a Pixel 8 phone.")
+}
+
+func TestWSChatHandler_MemoryNeedsUserToken(t *testing.T) {
+	t.Setenv("VECTOR_STORE_DIR", "")
+	t.Setenv("USER_TOKEN_SECRET", "test-secret")
+	sessSvc := session.NewServiceWithStore(session.NewMemoryStore())
+	memSvc := memory.NewService(memoryCompleter{}, vector.NewServiceWithEmbedder(vector.NewHashEmbedder(64)))
+	_, err := memSvc.Add(context.Background(), memory.Memory{UserID: "alice", Text: "Owns a Pixel 8 phone."})
+	require.NoError(t, err)
+	streamer := &recordingLLMService{}
+	h := NewWSHandler(streamer, nil, sessSvc, memSvc, nil, nil)
+	sess, err := sessSvc.CreateSessionForUser(context.Background(), "alice")
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(http.HandlerFunc(h.HandleWSChat))
+	defer ts.Close()
+	c, _, err := websocket.DefaultDialer.Dial("ws"+ts.URL[len("http"):], nil)
+	require.NoError(t, err)
+	defer c.Close()
+
+	// The session's own token lets the turn through, but only the user
+	// token unlocks what we remember of alice
+	require.NoError(t, c.WriteJSON(wsChatRequest{SessionID: sess.ID, SessionToken: sessSvc.Token(sess.ID, "alice"), UserID: "alice", UserToken: "forged", Message: "What phone do I have?"}))
+	for {
+		var resp wsChatToken
+		require.NoError(t, c.ReadJSON(&resp))
+		require.NotEqual(t, "error", resp.Type, resp.Content)
+		if resp.Type == "done" {
+			break
+		}
+	}
+
+	for _, m := range streamer.messages {
+		assert.NotContains(t, m.Content, "Pixel 8")
+	}
+	sess, err = sessSvc.GetSession(context.Background(), sess.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "user", sess.Messages[0].Role)
+}
+
+// slowLLMService streams a reply slowly and records how many calls overlap.
+type slowLLMService struct {
+	mu         sync.Mutex
+	active     int
+	maxActive  int
+	historyLen []int
+}
+
+func (m *slowLLMService) StreamResponse(ctx context.Context, messages []llm.Message, opts llm.Options) (<-chan llm.LlmStreamToken, error) {
+	m.mu.Lock()
+	m.active++
+	m.maxActive = max(m.maxActive, m.active)
+	m.historyLen = append(m.historyLen, len(messages))
+	m.mu.Unlock()
+
+	ch := make(chan llm.LlmStreamToken)
+	go func() {
+		defer close(ch)
+		time.Sleep(20 * time.Millisecond)
+		m.mu.Lock()
+		m.active--
+		m.mu.Unlock()
+		ch <- llm.LlmStreamToken{Type: "token", Content: "ok"}
+	}()
+	return ch, nil
+}
+
+func TestWSChatHandler_SerializesTurnsPerSession(t *testing.T) {
+	sessSvc := session.NewService()
+	sess, err := sessSvc.CreateSession(context.Background())
+	require.NoError(t, err)
+	llmSvc := &slowLLMService{}
+	h := NewWSHandler(llmSvc, nil, sessSvc, nil, nil, nil)
+
+	ts := httptest.NewServer(http.HandlerFunc(h.HandleWSChat))
+	defer ts.Close()
+
+	// Two devices send to the same session at once
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c, _, err := websocket.DefaultDialer.Dial("ws"+ts.URL[len("http"):], nil)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer c.Close()
+			assert.NoError(t, c.WriteJSON(wsChatRequest{SessionID: sess.ID, SessionToken: sessSvc.Token(sess.ID, ""), Message: "hello"}))
+			for {
+				var resp wsChatToken
+				if !assert.NoError(t, c.ReadJSON(&resp)) || resp.Type == "done" {
+					return
+				}
+				if !assert.NotEqual(t, "error", resp.Type, resp.Content) {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, llmSvc.maxActive, "turns on one session overlapped")
+	assert.ElementsMatch(t, []int{1, 2}, llmSvc.historyLen)
+}
+
+func TestWSChatHandler_SessionTokens(t *testing.T) {
+	t.Setenv("USER_TOKEN_SECRET", "test-secret")
+	sessSvc := session.NewService()
+	h := NewWSHandler(&mockLLMService{}, nil, sessSvc, nil, nil, nil)
+	ts := httptest.NewServer(http.HandlerFunc(h.HandleWSChat))
+	defer ts.Close()
+	c, _, err := websocket.DefaultDialer.Dial("ws"+ts.URL[len("http"):], nil)
+	require.NoError(t, err)
+	defer c.Close()
+
+	turn := func(req wsChatRequest) wsChatToken {
+		require.NoError(t, c.WriteJSON(req))
+		for {
+			var resp wsChatToken
+			require.NoError(t, c.ReadJSON(&resp))
+			if resp.Type == "done" || resp.Type == "error" {
+				return resp
+			}
+		}
+	}
+
+	// Only the user can start sessions in their name
+	for _, token := range []string{"", sessSvc.UserToken("mallory")} {
+		resp := turn(wsChatRequest{UserID: "alice", UserToken: token, Message: "impostor"})
+		assert.Equal(t, wsChatToken{Type: "error", Content: "Invalid user token"}, resp)
+	}
+	sessions, err := sessSvc.ListSessions(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+
+	done := turn(wsChatRequest{UserID: "alice", UserToken: sessSvc.UserToken("alice"), Message: "first"})
+	require.Equal(t, "done", done.Type)
+	require.NotEmpty(t, done.SessionID)
+	require.NotEmpty(t, done.SessionToken)
+
+	// The token continues the session
+	next := turn(wsChatRequest{SessionID: done.SessionID, SessionToken: done.SessionToken, UserID: "alice", Message: "second"})
+	require.Equal(t, "done", next.Type)
+	assert.Equal(t, done.SessionID, next.SessionID)
+
+	// Knowing the ID is not enough, and the token is bound to the user
+	for _, req := range []wsChatRequest{
+		{SessionID: done.SessionID, UserID: "alice", Message: "no token"},
+		{SessionID: done.SessionID, SessionToken: "forged", UserID: "alice", Message: "bad token"},
+		{SessionID: done.SessionID, SessionToken: done.SessionToken, UserID: "mallory", Message: "other user"},
+	} {
+		resp := turn(req)
+		assert.Equal(t, "error", resp.Type, req.Message)
+		assert.Equal(t, "Invalid session token", resp.Content)
+	}
+
+	sess, err := sessSvc.GetSession(context.Background(), done.SessionID)
+	require.NoError(t, err)
+	assert.Len(t, sess.Messages, 4, "rejected turns leave the session untouched")
+}
+
+// failingLLMService streams part of a reply, then fails.
+type failingLLMService struct{}
+
+func (failingLLMService) StreamResponse(ctx context.Context, messages []llm.Message, opts llm.Options) (<-chan llm.LlmStreamToken, error) {
+	ch := make(chan llm.LlmStreamToken, 2)
+	ch <- llm.LlmStreamToken{Type: "token", Content: "Try resett"}
+	ch <- llm.LlmStreamToken{Type: "error", Content: "upstream timeout"}
+	close(ch)
+	return ch, nil
+}
+
+func TestWSChatHandler_StoresReplies(t *testing.T) {
+	sessSvc := session.NewService()
+	sess, err := sessSvc.CreateSession(context.Background())
+	require.NoError(t, err)
+
+	send := func(streamer llm.LLMStreamer, message string) wsChatToken {
+		ts := httptest.NewServer(http.HandlerFunc(NewWSHandler(streamer, nil, sessSvc, nil, nil, nil).HandleWSChat))
+		defer ts.Close()
+		c, _, err := websocket.DefaultDialer.Dial("ws"+ts.URL[len("http"):], nil)
+		require.NoError(t, err)
+		defer c.Close()
+		require.NoError(t, c.WriteJSON(wsChatRequest{SessionID: sess.ID, SessionToken: sessSvc.Token(sess.ID, ""), Message: message}))
+		for {
+			var resp wsChatToken
+			require.NoError(t, c.ReadJSON(&resp))
+			if resp.Type == "done" {
+				return resp
+			}
+		}
+	}
+
+	failed := send(failingLLMService{}, "My router blinks red")
+	done := send(&mockLLMService{}, "Still blinking")
+
+	got, err := sessSvc.GetSession(context.Background(), sess.ID)
+	require.NoError(t, err)
+	require.Len(t, got.Messages, 4)
+	assert.Equal(t, failed.MessageID, got.Messages[1].ID)
+	assert.Equal(t, chatmodel.StatusFailed, got.Messages[1].Status)
+	assert.Equal(t, "Try resett", got.Messages[1].Content)
+	assert.Equal(t, done.MessageID, got.Messages[3].ID)
+	assert.Equal(t, chatmodel.StatusComplete, got.Messages[3].Status)
+	assert.Equal(t, "Hello, world!", got.Messages[3].Content)
+	for _, m := range got.Messages {
+		assert.NotEmpty(t, m.ID)
+		assert.False(t, m.CreatedAt.IsZero())
+	}
+	assert.Len(t, llm.WireMessages(got.Messages), 3, "the failed reply is not sent to the model again")
+}
+
+// echoLLMService replies with the user messages it was sent, joined by "|".
+type echoLLMService struct{}
+
+func (echoLLMService) StreamResponse(ctx context.Context, messages []llm.Message, opts llm.Options) (<-chan llm.LlmStreamToken, error) {
+	var questions []string
+	for _, m := range messages {
+		if m.Role == chatmodel.RoleUser {
+			questions = append(questions, m.Content)
+		}
+	}
+	ch := make(chan llm.LlmStreamToken, 2)
+	ch <- llm.LlmStreamToken{Type: "token", Content: strings.Join(questions, "|")}
+	ch <- llm.LlmStreamToken{Type: "done"}
+	close(ch)
+	return ch, nil
+}
+
+func TestWSChatHandler_Branching(t *testing.T) {
+	sessSvc := session.NewService()
+	h := NewWSHandler(echoLLMService{}, nil, sessSvc, nil, nil, nil)
+	ts := httptest.NewServer(http.HandlerFunc(h.HandleWSChat))
+	defer ts.Close()
+	c, _, err := websocket.DefaultDialer.Dial("ws"+ts.URL[len("http"):], nil)
+	require.NoError(t, err)
+	defer c.Close()
+
+	var sessionID, token string
+	turn := func(req wsChatRequest) (wsChatToken, string) {
+		req.SessionID, req.SessionToken = sessionID, token
+		require.NoError(t, c.WriteJSON(req))
+		var content strings.Builder
+		for {
+			var resp wsChatToken
+			require.NoError(t, c.ReadJSON(&resp))
+			switch resp.Type {
+			case "token":
+				content.WriteString(resp.Content)
+			case "done":
+				sessionID, token = resp.SessionID, resp.SessionToken
+				return resp, content.String()
+			case "error":
+				return resp, resp.Content
+			}
+		}
+	}
+	path := func() []string {
+		sess, err := sessSvc.GetSession(context.Background(), sessionID)
+		require.NoError(t, err)
+		var out []string
+		for _, m := range sess.ActivePath() {
+			out = append(out, m.Content)
+		}
+		return out
+	}
+
+	turn(wsChatRequest{Message: "q1"})
+	turn(wsChatRequest{Message: "q2"})
+	sess, err := sessSvc.GetSession(context.Background(), sessionID)
+	require.NoError(t, err)
+	q2, a2 := sess.Messages[2], sess.Messages[3]
+
+	// Editing a question answers from the edited branch only
+	done, reply := turn(wsChatRequest{Action: "edit", MessageID: q2.ID, Message: "q2 edited"})
+	require.Equal(t, "done", done.Type)
+	assert.Equal(t, "q1|q2 edited", reply)
+	assert.Equal(t, []string{"q1", "q1", "q2 edited", "q1|q2 edited"}, path())
+
+	// Regenerating the original answer goes back to the original branch
+	done, reply = turn(wsChatRequest{Action: "regenerate", MessageID: a2.ID})
+	require.Equal(t, "done", done.Type)
+	assert.Equal(t, "q1|q2", reply)
+	assert.Equal(t, []string{"q1", "q1", "q2", "q1|q2"}, path())
+	sess, err = sessSvc.GetSession(context.Background(), sessionID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{a2.ID, done.MessageID}, sess.Versions(a2.ID))
+
+	// Switching shows the edited branch again
+	edited := sess.Versions(q2.ID)[1]
+	done, _ = turn(wsChatRequest{Action: "switch", MessageID: edited})
+	require.Equal(t, "done", done.Type)
+	assert.Equal(t, []string{"q1", "q1", "q2 edited", "q1|q2 edited"}, path())
+	done, reply = turn(wsChatRequest{Message: "q3"})
+	require.Equal(t, "done", done.Type)
+	assert.Equal(t, "q1|q2 edited|q3", reply)
+
+	for _, tc := range []struct {
+		req  wsChatRequest
+		want string
+	}{
+		{wsChatRequest{Action: "edit", MessageID: a2.ID, Message: "x"}, "Message cannot be used for this action"},
+		{wsChatRequest{Action: "regenerate", MessageID: q2.ID}, "Message cannot be used for this action"},
+		{wsChatRequest{Action: "switch", MessageID: "msg_missing"}, "Message not found"},
+		{wsChatRequest{Action: "edit", MessageID: q2.ID}, "Message is required"},
+		{wsChatRequest{Action: "delete", MessageID: q2.ID}, "Unknown action"},
+	} {
+		resp, _ := turn(tc.req)
+		assert.Equal(t, "error", resp.Type)
+		assert.Equal(t, tc.want, resp.Content, tc.req.Action)
+	}
+}
+
+// titleCompleter answers summary requests with a fixed title.
+type titleCompleter struct{}
+
+func (titleCompleter) GenerateResponse(ctx context.Context, messages []llm.Message) (string, error) {
+	return `{"title": "Greeting", "summary": "The user said hello."}`, nil
+}
+
+func TestWSChatHandler_PushesTitles(t *testing.T) {
+	sessSvc := session.NewService()
+	h := NewWSHandler(&mockLLMService{}, nil, sessSvc, nil, summary.NewService(titleCompleter{}, sessSvc), nil)
+	ts := httptest.NewServer(http.HandlerFunc(h.HandleWSChat))
+	defer ts.Close()
+	c, _, err := websocket.DefaultDialer.Dial("ws"+ts.URL[len("http"):], nil)
+	require.NoError(t, err)
+	defer c.Close()
+
+	require.NoError(t, c.WriteJSON(wsChatRequest{Message: "hello"}))
+	var sessionID string
+	for {
+		var resp wsChatToken
+		require.NoError(t, c.SetReadDeadline(time.Now().Add(5*time.Second)))
+		require.NoError(t, c.ReadJSON(&resp))
+		if resp.Type == "done" {
+			sessionID = resp.SessionID
+		}
+		if resp.Type == "title" {
+			assert.Equal(t, sessionID, resp.SessionID)
+			assert.Equal(t, "Greeting", resp.Content)
+			break
+		}
+	}
+
+	sess, err := sessSvc.GetSession(context.Background(), sessionID)
+	require.NoError(t, err)
+	assert.Equal(t, "Greeting", sess.Title)
+	assert.True(t, sess.AutoTitle)
+	assert.Equal(t, "The user said hello.", sess.Summary)
+}
+
+func TestWSChatHandler_SyncsDevices(t *testing.T) {
+	t.Setenv("USER_TOKEN_SECRET", "test-secret")
+	sessSvc := session.NewServiceWithStore(session.NewMemoryStore())
+	h := NewWSHandler(&mockLLMService{}, nil, sessSvc, nil, nil, nil)
+	ts := httptest.NewServer(http.HandlerFunc(h.HandleWSChat))
+	defer ts.Close()
+	dial := func() *websocket.Conn {
+		c, _, err := websocket.DefaultDialer.Dial("ws"+ts.URL[len("http"):], nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+	read := func(c *websocket.Conn) wsChatToken {
+		var resp wsChatToken
+		require.NoError(t, c.SetReadDeadline(time.Now().Add(5*time.Second)))
+		require.NoError(t, c.ReadJSON(&resp))
+		return resp
+	}
+	readUntil := func(c *websocket.Conn, typ string) []wsChatToken {
+		var got []wsChatToken
+		for {
+			resp := read(c)
+			got = append(got, resp)
+			if resp.Type == typ || resp.Type == "error" {
+				return got
+			}
+		}
+	}
+	desktop, phone := dial(), dial()
+
+	require.NoError(t, desktop.WriteJSON(wsChatRequest{UserID: "alice", UserToken: sessSvc.UserToken("alice"), Message: "first"}))
+	done := readUntil(desktop, "done")[2]
+	require.Equal(t, "done", done.Type)
+
+	// Another user's device cannot follow the session
+	require.NoError(t, phone.WriteJSON(wsChatRequest{Action: "subscribe", SessionID: done.SessionID, SessionToken: done.SessionToken, UserID: "mallory"}))
+	assert.Equal(t, wsChatToken{Type: "error", Content: "Invalid session token"}, read(phone))
+	require.NoError(t, phone.WriteJSON(wsChatRequest{Action: "subscribe", SessionID: done.SessionID, SessionToken: done.SessionToken, UserID: "alice"}))
+	assert.Equal(t, wsChatToken{Type: "subscribed", SessionID: done.SessionID}, read(phone))
+
+	// The phone sees the desktop's turn live, the desktop no echo of it
+	require.NoError(t, desktop.WriteJSON(wsChatRequest{SessionID: done.SessionID, SessionToken: done.SessionToken, UserID: "alice", Message: "second"}))
+	own := readUntil(desktop, "done")
+	assert.Equal(t, []string{"token", "token", "done"}, []string{own[0].Type, own[1].Type, own[2].Type})
+	reply := own[2].MessageID
+
+	seen := readUntil(phone, "message")
+	require.Len(t, seen, 1)
+	assert.Equal(t, "second", seen[0].Message.Content)
+	seen = readUntil(phone, "message")
+	require.Len(t, seen, 3)
+	assert.Equal(t, wsChatToken{Type: "token", SessionID: done.SessionID, MessageID: reply, Content: "Hello"}, seen[0])
+	assert.Equal(t, ", world!", seen[1].Content)
+	assert.Equal(t, reply, seen[2].MessageID)
+	assert.Equal(t, "Hello, world!", seen[2].Message.Content)
+
+	// Changes made elsewhere reach both
+	require.NoError(t, sessSvc.DeleteSession(context.Background(), done.SessionID))
+	for _, c := range []*websocket.Conn{desktop, phone} {
+		assert.Equal(t, wsChatToken{Type: "deleted", SessionID: done.SessionID}, read(c))
+	}
+}
+
+// recordingLLMService replies like mockLLMService and records what it was
+// asked.
+type recordingLLMService struct {
+	mockLLMService
+	mu       sync.Mutex
+	messages []llm.Message
+	opts     llm.Options
+}
+
+func (m *recordingLLMService) StreamResponse(ctx context.Context, messages []llm.Message, opts llm.Options) (<-chan llm.LlmStreamToken, error) {
+	m.mu.Lock()
+	m.messages, m.opts = messages, opts
+	m.mu.Unlock()
+	return m.mockLLMService.StreamResponse(ctx, messages, opts)
+}
+
+func TestWSChatHandler_AppliesProfile(t *testing.T) {
+	t.Setenv("VECTOR_STORE_DIR", "")
+	t.Setenv("USER_TOKEN_SECRET", "test-secret")
+	sessSvc := session.NewServiceWithStore(session.NewMemoryStore())
+	memSvc := memory.NewService(memoryCompleter{}, vector.NewServiceWithEmbedder(vector.NewHashEmbedder(64)))
+	_, err := memSvc.Add(context.Background(), memory.Memory{UserID: "alice", Text: "Owns a Pixel 8 phone."})
+	require.NoError(t, err)
+	temperature := 0.2
+	profiles, err := profile.New([]profile.Profile{
+		{Name: "support", SystemPrompt: "You are a support agent.", Model: "deepseek-reasoner", Temperature: &temperature, Tools: []string{}},
+		{Name: "casual", SystemPrompt: "Be casual."},
+	}, "")
+	require.NoError(t, err)
+	streamer := &recordingLLMService{}
+	h := NewWSHandler(streamer, nil, sessSvc, memSvc, nil, profiles)
+	ts := httptest.NewServer(http.HandlerFunc(h.HandleWSChat))
+	defer ts.Close()
+	c, _, err := websocket.DefaultDialer.Dial("ws"+ts.URL[len("http"):], nil)
+	require.NoError(t, err)
+	defer c.Close()
+	turn := func(req wsChatRequest) wsChatToken {
+		require.NoError(t, c.WriteJSON(req))
+		for {
+			var resp wsChatToken
+			require.NoError(t, c.ReadJSON(&resp))
+			if resp.Type == "done" || resp.Type == "error" {
+				return resp
+			}
+		}
+	}
+
+	aliceToken := sessSvc.UserToken("alice")
+	assert.Equal(t, wsChatToken{Type: "error", Content: "Unknown profile"}, turn(wsChatRequest{UserID: "alice", UserToken: aliceToken, Message: "Hi", Profile: "pirate"}))
+
+	done := turn(wsChatRequest{UserID: "alice", UserToken: aliceToken, Message: "My phone will not charge", Profile: "support"})
+	require.Equal(t, "done", done.Type, done.Content)
+	sess, err := sessSvc.GetSession(context.Background(), done.SessionID)
+	require.NoError(t, err)
+	assert.Equal(t, "support", sess.Profile)
+	require.Len(t, sess.Messages, 2, "the profile uses no memory, so none is recalled")
+	assert.Equal(t, "user", sess.Messages[0].Role)
+	assert.Equal(t, []llm.Message{
+		{Role: "system", Content: "You are a support agent."},
+		{Role: "user", Content: "My phone will not charge"},
+	}, streamer.messages)
+	assert.Equal(t, llm.Options{Model: "deepseek-reasoner", Temperature: &temperature}, streamer.opts)
+
+	// A continued session keeps its profile
+	done = turn(wsChatRequest{SessionID: done.SessionID, SessionToken: done.SessionToken, UserID: "alice", UserToken: aliceToken, Message: "Still dead", Profile: "casual"})
+	require.Equal(t, "done", done.Type, done.Content)
+	assert.Equal(t, "You are a support agent.", streamer.messages[0].Content)
+	assert.Len(t, streamer.messages, 4)
+
+	// Without a profile, the default one uses memory
+	done = turn(wsChatRequest{UserID: "alice", UserToken: aliceToken, Message: "My phone will not charge"})
+	require.Equal(t, "done", done.Type, done.Content)
+	sess, err = sessSvc.GetSession(context.Background(), done.SessionID)
+	require.NoError(t, err)
+	assert.Empty(t, sess.Profile)
+	assert.Equal(t, "system", sess.Messages[0].Role)
+	assert.Equal(t, llm.Options{}, streamer.opts)
+}
+
+func TestWSConn_DropsSlowClients(t *testing.T) {
+	sessSvc := session.NewServiceWithStore(session.NewMemoryStore())
+	conns := make(chan *wsConn, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		require.NoError(t, err)
+		conns <- newWSConn(ws)
+	}))
+	defer ts.Close()
+	c, _, err := websocket.DefaultDialer.Dial("ws"+ts.URL[len("http"):], nil)
+	require.NoError(t, err)
+	defer c.Close()
+	conn := <-conns
+	defer conn.Close()
+	conn.follow(sessSvc, "sess_a")
+
+	// The client never reads, so its socket fills up and then its queue;
+	// publishing must not wait for it meanwhile
+	chunk := strings.Repeat("x", 64<<10)
+	started := time.Now()
+	for i := 0; i < 4*wsOutboxSize; i++ {
+		sessSvc.Publish(context.Background(), session.Event{Type: session.EventStreamToken, SessionID: "sess_a", Token: chunk})
+	}
+	assert.Less(t, time.Since(started), wsWriteWait, "publishing does not block on a slow client")
+
+	select {
+	case <-conn.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the slow client was not disconnected")
+	}
+	assert.ErrorIs(t, conn.WriteJSON(wsChatToken{Type: "token"}), errWSClosed)
+}
+
+func TestWSChatHandler_SearchesProfileCollection(t *testing.T) {
+	t.Setenv("VECTOR_STORE_DIR", "")
+	t.Setenv("RAG_COLLECTION", "catalog")
+	t.Setenv("QUERY_REWRITE", "false")
+	ctx := context.Background()
+	vectorSvc := vector.NewServiceWithEmbedder(vector.NewHashEmbedder(64))
+	for name, text := range map[string]string{
+		"catalog": "The red kettle costs 30 euros.",
+		"manuals": "Hold the kettle's power button for ten seconds to reset it.",
+	} {
+		_, err := vectorSvc.CreateCollection(vector.Collection{Name: name})
+		require.NoError(t, err)
+		_, err = vectorSvc.IngestDocument(ctx, name, name+"-doc", text, nil)
+		require.NoError(t, err)
+	}
+	profiles, err := profile.New([]profile.Profile{{Name: "support", Collection: "manuals"}}, "support")
+	require.NoError(t, err)
+	streamer := &recordingLLMService{}
+	h := NewWSHandler(streamer, retrieval.NewService(memoryCompleter{}, vectorSvc), session.NewServiceWithStore(session.NewMemoryStore()), nil, nil, profiles)
+	ts := httptest.NewServer(http.HandlerFunc(h.HandleWSChat))
+	defer ts.Close()
+	c, _, err := websocket.DefaultDialer.Dial("ws"+ts.URL[len("http"):], nil)
+	require.NoError(t, err)
+	defer c.Close()
+
+	require.NoError(t, c.WriteJSON(wsChatRequest{Message: "How do I reset the kettle?", Debug: true}))
+	var done wsChatToken
+	for done.Type != "done" {
+		require.NoError(t, c.SetReadDeadline(time.Now().Add(5*time.Second)))
+		require.NoError(t, c.ReadJSON(&done))
+		require.NotEqual(t, "error", done.Type, done.Content)
+	}
+
+	require.Len(t, streamer.messages, 2)
+	assert.Equal(t, "system", streamer.messages[0].Role)
+	assert.Contains(t, streamer.messages[0].Content, "power button", "the profile's collection is searched")
+	assert.NotContains(t, streamer.messages[0].Content, "30 euros", "not the configured one")
+	assert.Equal(t, "How do I reset the kettle?", streamer.messages[1].Content)
+	require.NotEmpty(t, done.Citations)
+	assert.Equal(t, "manuals-doc", done.Citations[0].DocumentID)
+
+	// Asked for, the trace shows what was searched for
+	require.NotNil(t, done.Debug)
+	require.NotNil(t, done.Debug.Retrieval)
+	assert.Equal(t, "manuals", done.Debug.Retrieval.Collection)
+	assert.Equal(t, "How do I reset the kettle?", done.Debug.Retrieval.Query)
+}
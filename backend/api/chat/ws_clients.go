@@ -0,0 +1,162 @@
+package chat
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	chatmodel "csdeepseek/backend/models/chat"
+	"csdeepseek/backend/services/session"
+)
+
+const (
+	// wsWriteWait bounds each write to a client
+	wsWriteWait = 10 * time.Second
+	// wsOutboxSize is how many messages may wait for a slow client before
+	// the connection is closed
+	wsOutboxSize = 256
+)
+
+var errWSClosed = errors.New("websocket connection closed")
+
+// wsConn is a WebSocket connection that session events can be written to as
+// well as the turn being answered. Writes are queued and sent by the
+// connection's own goroutine, so a slow client never holds up the turn or
+// the event bus that writes to it.
+type wsConn struct {
+	*websocket.Conn
+	// id marks the events the connection causes, so they are not echoed
+	// back to it
+	id string
+
+	out       chan interface{}
+	done      chan struct{}
+	closeOnce sync.Once
+
+	subsMu sync.Mutex
+	subs   map[string]func()
+}
+
+func newWSConn(ws *websocket.Conn) *wsConn {
+	b := make([]byte, 8)
+	rand.Read(b)
+	c := &wsConn{
+		Conn: ws,
+		id:   "ws_" + hex.EncodeToString(b),
+		out:  make(chan interface{}, wsOutboxSize),
+		done: make(chan struct{}),
+		subs: make(map[string]func()),
+	}
+	go c.writeLoop()
+	return c
+}
+
+// WriteJSON queues v for the client. A client that falls wsOutboxSize
+// messages behind is disconnected rather than sent a conversation with
+// gaps; it reloads the session when it reconnects.
+func (c *wsConn) WriteJSON(v interface{}) error {
+	select {
+	case <-c.done:
+		return errWSClosed
+	default:
+	}
+	select {
+	case c.out <- v:
+		return nil
+	default:
+		log.Printf("WebSocket client %s fell %d messages behind, closing the connection", c.id, wsOutboxSize)
+		c.Close()
+		return errWSClosed
+	}
+}
+
+// writeLoop sends the queued messages, one at a time as the connection
+// requires, until the connection is closed or a write fails.
+func (c *wsConn) writeLoop() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case v := <-c.out:
+			c.Conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.Conn.WriteJSON(v); err != nil {
+				log.Printf("WebSocket write error: %v", err)
+				c.Close()
+				return
+			}
+		}
+	}
+}
+
+// Close stops the writer and closes the connection, which also ends the
+// handler's read loop.
+func (c *wsConn) Close() error {
+	err := errWSClosed
+	c.closeOnce.Do(func() {
+		close(c.done)
+		err = c.Conn.Close()
+	})
+	return err
+}
+
+// follow subscribes the connection to a session's events, so it sees what
+// other devices and background work do in the session. The caller must
+// have checked that the connection may see the session.
+func (c *wsConn) follow(sessions *session.Service, sessionID string) {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	if c.subs[sessionID] != nil {
+		return
+	}
+	c.subs[sessionID] = sessions.Subscribe(sessionID, c.forward)
+}
+
+func (c *wsConn) unfollow(sessionID string) {
+	c.subsMu.Lock()
+	unsubscribe := c.subs[sessionID]
+	delete(c.subs, sessionID)
+	c.subsMu.Unlock()
+	if unsubscribe != nil {
+		unsubscribe()
+	}
+}
+
+// unfollowAll ends every subscription of a closed connection.
+func (c *wsConn) unfollowAll() {
+	c.subsMu.Lock()
+	subs := c.subs
+	c.subs = make(map[string]func())
+	c.subsMu.Unlock()
+	for _, unsubscribe := range subs {
+		unsubscribe()
+	}
+}
+
+// forward writes a session event to the client. Tokens of a reply streamed
+// to another connection carry the session and reply IDs, unlike those of
+// the connection's own turns.
+func (c *wsConn) forward(ev session.Event) {
+	if ev.Source == c.id {
+		return
+	}
+	switch ev.Type {
+	case session.EventStreamToken:
+		c.WriteJSON(wsChatToken{Type: "token", SessionID: ev.SessionID, MessageID: ev.MessageID, Content: ev.Token})
+	case session.EventMessageAdded:
+		if ev.Message == nil || ev.Message.Role == chatmodel.RoleSystem {
+			// System messages hold recalled memories, not conversation
+			return
+		}
+		c.WriteJSON(wsChatToken{Type: "message", SessionID: ev.SessionID, MessageID: ev.Message.ID, Message: ev.Message})
+	case session.EventTitleChanged:
+		c.WriteJSON(wsChatToken{Type: "title", SessionID: ev.SessionID, Content: ev.Title})
+	case session.EventSessionDeleted:
+		c.WriteJSON(wsChatToken{Type: "deleted", SessionID: ev.SessionID})
+		c.unfollow(ev.SessionID)
+	}
+}
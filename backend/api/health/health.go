@@ -29,11 +29,19 @@ type HealthResponse struct {
 		} `json:"memory_stats"`
 	} `json:"runtime"`
 	Sessions struct {
-		Total     int `json:"total"`
-		Active    int `json:"active"`
-		Inactive  int `json:"inactive"`
-		MaxAge    int `json:"max_age_seconds"`
-		CleanupIn int `json:"cleanup_in_seconds"`
+		Total     int                `json:"total"`
+		Active    int                `json:"active"`
+		Inactive  int                `json:"inactive"`
+		MaxAge    int                `json:"max_age_seconds"`
+		CleanupIn int                `json:"cleanup_in_seconds"`
+		Limits    session.Limits     `json:"limits"`
+		Enforced  session.LimitStats `json:"enforced"`
+		Retention struct {
+			IdleTTL  int `json:"idle_ttl_seconds"`
+			MaxAge   int `json:"max_age_seconds"`
+			TrashTTL int `json:"trash_ttl_seconds"`
+		} `json:"retention"`
+		LastCleanup *session.CleanupReport `json:"last_cleanup,omitempty"`
 	} `json:"sessions"`
 }
 
@@ -67,6 +75,7 @@ func (h *Handler) HandleHealth(w http.ResponseWriter, r *http.Request) {
 
 	// Get session stats
 	sessions, _ := h.sessionService.ListSessions(r.Context())
+	retention := h.sessionService.Retention()
 	now := time.Now()
 	active := 0
 	inactive := 0
@@ -110,19 +119,33 @@ func (h *Handler) HandleHealth(w http.ResponseWriter, r *http.Request) {
 			},
 		},
 		Sessions: struct {
-			Total     int `json:"total"`
-			Active    int `json:"active"`
-			Inactive  int `json:"inactive"`
-			MaxAge    int `json:"max_age_seconds"`
-			CleanupIn int `json:"cleanup_in_seconds"`
+			Total     int                `json:"total"`
+			Active    int                `json:"active"`
+			Inactive  int                `json:"inactive"`
+			MaxAge    int                `json:"max_age_seconds"`
+			CleanupIn int                `json:"cleanup_in_seconds"`
+			Limits    session.Limits     `json:"limits"`
+			Enforced  session.LimitStats `json:"enforced"`
+			Retention struct {
+				IdleTTL  int `json:"idle_ttl_seconds"`
+				MaxAge   int `json:"max_age_seconds"`
+				TrashTTL int `json:"trash_ttl_seconds"`
+			} `json:"retention"`
+			LastCleanup *session.CleanupReport `json:"last_cleanup,omitempty"`
 		}{
-			Total:     len(sessions),
-			Active:    active,
-			Inactive:  inactive,
-			MaxAge:    3600, // 1 hour default
-			CleanupIn: 600,  // 10 minutes default
+			Total:       len(sessions),
+			Active:      active,
+			Inactive:    inactive,
+			MaxAge:      int(retention.IdleTTL.Seconds()),
+			CleanupIn:   600, // 10 minutes default
+			Limits:      h.sessionService.Limits(),
+			Enforced:    h.sessionService.LimitStats(),
+			LastCleanup: h.sessionService.LastCleanup(),
 		},
 	}
+	resp.Sessions.Retention.IdleTTL = int(retention.IdleTTL.Seconds())
+	resp.Sessions.Retention.MaxAge = int(retention.MaxAge.Seconds())
+	resp.Sessions.Retention.TrashTTL = int(retention.TrashTTL.Seconds())
 
 	// Send response
 	w.Header().Set("Content-Type", "application/json")
@@ -11,6 +11,7 @@ import (
 	"csdeepseek/backend/services/session"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type healthResponse struct {
@@ -43,13 +44,14 @@ func TestHandleHealth_Basic(t *testing.T) {
 }
 
 func TestHandleHealth_WithSessions(t *testing.T) {
-	sessSvc := session.NewService()
+	store := session.NewMemoryStore()
+	sessSvc := session.NewServiceWithStore(store)
 	ctx := context.Background()
-	// Add one active and one inactive session
+	// Add one active and one inactive session. Sessions returned by the
+	// service are snapshots, so the inactive one is stored already old.
 	_, _ = sessSvc.CreateSession(ctx)
-	inactive, _ := sessSvc.CreateSession(ctx)
-	// Make inactive session old
-	inactive.UpdatedAt = time.Now().Add(-2 * time.Hour)
+	old := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, store.Create(ctx, &session.Session{ID: "sess_inactive", CreatedAt: old, UpdatedAt: old}))
 
 	h := NewHandler(sessSvc)
 	req := httptest.NewRequest(http.MethodGet, "/api/health", nil)
@@ -0,0 +1,372 @@
+package knowledge
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"csdeepseek/backend/services/vector"
+)
+
+const (
+	maxUploadSize  = 10 << 20 // 10 MB
+	defaultSearchK = 5
+	maxSearchK     = 50
+)
+
+// Handler serves the knowledge base API: collections of documents that are
+// chunked, embedded and searched by the vector service.
+type Handler struct {
+	vectorService *vector.Service
+	apiKey        string
+}
+
+type CollectionRequest struct {
+	Name        string              `json:"name"`
+	Description string              `json:"description"`
+	Rerank      vector.RerankConfig `json:"rerank"`
+}
+
+type CollectionsResponse struct {
+	Collections []vector.CollectionInfo `json:"collections"`
+}
+
+type DocumentRequest struct {
+	Collection string            `json:"collection"`
+	ID         string            `json:"id"`
+	Text       string            `json:"text"`
+	Metadata   map[string]string `json:"metadata"`
+}
+
+type DocumentResponse struct {
+	Collection string `json:"collection"`
+	ID         string `json:"id"`
+	Chunks     int    `json:"chunks"`
+}
+
+type DocumentsResponse struct {
+	Documents []vector.Document `json:"documents"`
+}
+
+type SearchRequest struct {
+	Query  string         `json:"query"`
+	K      int            `json:"k"`
+	Filter *vector.Filter `json:"filter,omitempty"`
+}
+
+type SearchResponse struct {
+	Results []vector.SearchResult `json:"results"`
+}
+
+// NewHandler creates a knowledge base handler. Every request must carry
+// KNOWLEDGE_API_KEY as a bearer token; without a key configured, the API
+// refuses all requests.
+func NewHandler(vectorService *vector.Service) *Handler {
+	h := &Handler{
+		vectorService: vectorService,
+		apiKey:        os.Getenv("KNOWLEDGE_API_KEY"),
+	}
+	if h.apiKey == "" {
+		log.Println("KNOWLEDGE_API_KEY is not set, the knowledge base API is disabled")
+	}
+	return h
+}
+
+// HandleCollections serves /api/collections: GET lists collections, POST
+// creates one.
+func (h *Handler) HandleCollections(w http.ResponseWriter, r *http.Request) {
+	if !h.preflight(w, r, "GET, POST, OPTIONS") {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, CollectionsResponse{Collections: h.vectorService.ListCollections()})
+
+	case "POST":
+		var req CollectionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		info, err := h.vectorService.CreateCollection(vector.Collection{
+			Name:        req.Name,
+			Description: req.Description,
+			Rerank:      req.Rerank,
+		})
+		if err != nil {
+			writeError(w, "Failed to create collection", err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, info)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleCollection serves /api/collections/{name}: GET returns the
+// collection, PUT replaces its description and search settings, DELETE
+// removes it with all its documents.
+func (h *Handler) HandleCollection(w http.ResponseWriter, r *http.Request) {
+	if !h.preflight(w, r, "GET, PUT, DELETE, OPTIONS") {
+		return
+	}
+	name := r.PathValue("name")
+
+	switch r.Method {
+	case "GET":
+		info, err := h.vectorService.GetCollection(name)
+		if err != nil {
+			writeError(w, "Failed to get collection", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, info)
+
+	case "PUT":
+		var req CollectionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		info, err := h.vectorService.UpdateCollection(vector.Collection{
+			Name:        name,
+			Description: req.Description,
+			Rerank:      req.Rerank,
+		})
+		if err != nil {
+			writeError(w, "Failed to update collection", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, info)
+
+	case "DELETE":
+		if err := h.vectorService.DeleteCollection(r.Context(), name); err != nil {
+			writeError(w, "Failed to delete collection", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleSearch serves POST /api/collections/{name}/search and returns the
+// matching chunks with every score the search computed.
+func (h *Handler) HandleSearch(w http.ResponseWriter, r *http.Request) {
+	if !h.preflight(w, r, "POST, OPTIONS") {
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req SearchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		http.Error(w, "Query is required", http.StatusBadRequest)
+		return
+	}
+	if req.K <= 0 {
+		req.K = defaultSearchK
+	}
+	req.K = min(req.K, maxSearchK)
+
+	name := r.PathValue("name")
+	if _, err := h.vectorService.GetCollection(name); err != nil {
+		writeError(w, "Failed to search", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	results, err := h.vectorService.Search(ctx, vector.SearchRequest{
+		Namespace: name,
+		Query:     req.Query,
+		K:         req.K,
+		Filter:    req.Filter,
+	})
+	if err != nil {
+		writeError(w, "Failed to search", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, SearchResponse{Results: results})
+}
+
+// HandleDocuments serves /api/documents: GET ?collection= lists documents
+// with their chunk counts, POST ingests a document. Documents are uploaded
+// either as JSON or as a multipart form with a text file in "file" and the
+// "collection", optional "id" (defaulting to the file name) and optional
+// "metadata" (a JSON object) fields. Uploading an existing ID replaces the
+// document.
+func (h *Handler) HandleDocuments(w http.ResponseWriter, r *http.Request) {
+	if !h.preflight(w, r, "GET, POST, OPTIONS") {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		docs, err := h.vectorService.ListDocuments(r.URL.Query().Get("collection"))
+		if err != nil {
+			writeError(w, "Failed to list documents", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, DocumentsResponse{Documents: docs})
+
+	case "POST":
+		r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+		req, err := parseDocumentRequest(r)
+		if err != nil {
+			http.Error(w, "Invalid document: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
+		defer cancel()
+
+		chunks, err := h.vectorService.IngestDocument(ctx, req.Collection, req.ID, req.Text, req.Metadata)
+		if err != nil {
+			writeError(w, "Failed to ingest document", err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, DocumentResponse{Collection: req.Collection, ID: req.ID, Chunks: chunks})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleDocument serves /api/documents/{id}?collection=: GET returns the
+// document with its chunks, DELETE removes it.
+func (h *Handler) HandleDocument(w http.ResponseWriter, r *http.Request) {
+	if !h.preflight(w, r, "GET, DELETE, OPTIONS") {
+		return
+	}
+	collection := r.URL.Query().Get("collection")
+	id := r.PathValue("id")
+
+	switch r.Method {
+	case "GET":
+		doc, err := h.vectorService.GetDocument(collection, id)
+		if err != nil {
+			writeError(w, "Failed to get document", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, doc)
+
+	case "DELETE":
+		if err := h.vectorService.DeleteDocument(r.Context(), collection, id); err != nil {
+			writeError(w, "Failed to delete document", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// preflight sets the CORS headers, answers OPTIONS requests and checks the
+// API key. It reports whether the request should be handled further.
+func (h *Handler) preflight(w http.ResponseWriter, r *http.Request, methods string) bool {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", methods)
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return false
+	}
+
+	if h.apiKey == "" {
+		http.Error(w, "Knowledge base API is disabled", http.StatusForbidden)
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.apiKey)) != 1 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func parseDocumentRequest(r *http.Request) (DocumentRequest, error) {
+	var req DocumentRequest
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return req, errors.New("invalid JSON body")
+		}
+		return req, nil
+	}
+
+	if err := r.ParseMultipartForm(maxUploadSize); err != nil {
+		return req, errors.New("invalid multipart form")
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		return req, errors.New("missing file")
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return req, errors.New("failed to read file")
+	}
+	if !utf8.Valid(data) {
+		return req, errors.New("file must be UTF-8 text")
+	}
+
+	req.Collection = r.FormValue("collection")
+	req.ID = r.FormValue("id")
+	if req.ID == "" {
+		req.ID = filepath.Base(header.Filename)
+	}
+	req.Text = string(data)
+	if md := r.FormValue("metadata"); md != "" {
+		if err := json.Unmarshal([]byte(md), &req.Metadata); err != nil {
+			return req, errors.New("metadata must be a JSON object of strings")
+		}
+	}
+	return req, nil
+}
+
+// writeError maps service errors to status codes. Validation errors are
+// returned to the client; anything else is logged.
+func writeError(w http.ResponseWriter, msg string, err error) {
+	switch {
+	case errors.Is(err, vector.ErrCollectionNotFound), errors.Is(err, vector.ErrDocumentNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, vector.ErrCollectionExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, vector.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("%s: %v", msg, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+package knowledge
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"csdeepseek/backend/services/vector"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestMux(t *testing.T) *http.ServeMux {
+	t.Setenv("VECTOR_STORE_DIR", "")
+	t.Setenv("KNOWLEDGE_API_KEY", "secret")
+	h := NewHandler(vector.NewServiceWithEmbedder(vector.NewHashEmbedder(64)))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/collections", h.HandleCollections)
+	mux.HandleFunc("/api/collections/{name}", h.HandleCollection)
+	mux.HandleFunc("/api/collections/{name}/search", h.HandleSearch)
+	mux.HandleFunc("/api/documents", h.HandleDocuments)
+	mux.HandleFunc("/api/documents/{id}", h.HandleDocument)
+	return mux
+}
+
+func do(mux http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer secret")
+	rw := httptest.NewRecorder()
+	mux.ServeHTTP(rw, req)
+	return rw
+}
+
+func TestKnowledgeAPI_CollectionLifecycle(t *testing.T) {
+	mux := newTestMux(t)
+
+	rw := do(mux, http.MethodPost, "/api/collections", `{"name":"faq","description":"Support answers"}`)
+	require.Equal(t, http.StatusCreated, rw.Code)
+	assert.Equal(t, http.StatusConflict, do(mux, http.MethodPost, "/api/collections", `{"name":"faq"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do(mux, http.MethodPost, "/api/collections", `{"name":"no/slashes"}`).Code)
+
+	rw = do(mux, http.MethodPost, "/api/documents", `{"collection":"faq","id":"reset","text":"Hold the reset button for ten seconds."}`)
+	require.Equal(t, http.StatusCreated, rw.Code)
+	var doc DocumentResponse
+	require.NoError(t, json.NewDecoder(rw.Body).Decode(&doc))
+	assert.Equal(t, 1, doc.Chunks)
+
+	rw = do(mux, http.MethodGet, "/api/documents?collection=faq", "")
+	require.Equal(t, http.StatusOK, rw.Code)
+	var docs DocumentsResponse
+	require.NoError(t, json.NewDecoder(rw.Body).Decode(&docs))
+	require.Len(t, docs.Documents, 1)
+	assert.Equal(t, "reset", docs.Documents[0].ID)
+	assert.Equal(t, 1, docs.Documents[0].ChunkCount)
+
+	rw = do(mux, http.MethodPost, "/api/collections/faq/search", `{"query":"reset button","k":3}`)
+	require.Equal(t, http.StatusOK, rw.Code)
+	var search SearchResponse
+	require.NoError(t, json.NewDecoder(rw.Body).Decode(&search))
+	require.Len(t, search.Results, 1)
+	assert.Greater(t, search.Results[0].Score, 0.0)
+	assert.Greater(t, search.Results[0].KeywordScore, 0.0)
+
+	assert.Equal(t, http.StatusBadRequest, do(mux, http.MethodPost, "/api/collections/faq/search", `{"query":"x","filter":{}}`).Code)
+	assert.Equal(t, http.StatusNotFound, do(mux, http.MethodPost, "/api/collections/nope/search", `{"query":"x"}`).Code)
+
+	assert.Equal(t, http.StatusNoContent, do(mux, http.MethodDelete, "/api/documents/reset?collection=faq", "").Code)
+	assert.Equal(t, http.StatusNotFound, do(mux, http.MethodDelete, "/api/documents/reset?collection=faq", "").Code)
+
+	assert.Equal(t, http.StatusNoContent, do(mux, http.MethodDelete, "/api/collections/faq", "").Code)
+	assert.Equal(t, http.StatusNotFound, do(mux, http.MethodGet, "/api/collections/faq", "").Code)
+}
+
+func TestKnowledgeAPI_MultipartUpload(t *testing.T) {
+	mux := newTestMux(t)
+	require.Equal(t, http.StatusCreated, do(mux, http.MethodPost, "/api/collections", `{"name":"manuals"}`).Code)
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	require.NoError(t, form.WriteField("collection", "manuals"))
+	require.NoError(t, form.WriteField("metadata", `{"product":"router"}`))
+	file, err := form.CreateFormFile("file", "setup.md")
+	require.NoError(t, err)
+	_, err = file.Write([]byte("# Setup\n\nPlug in the router.\n\nWait for the light."))
+	require.NoError(t, err)
+	require.NoError(t, form.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/api/documents", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer secret")
+	rw := httptest.NewRecorder()
+	mux.ServeHTTP(rw, req)
+	require.Equal(t, http.StatusCreated, rw.Code, rw.Body.String())
+
+	rw = do(mux, http.MethodGet, "/api/documents/setup.md?collection=manuals", "")
+	require.Equal(t, http.StatusOK, rw.Code)
+	var doc vector.Document
+	require.NoError(t, json.NewDecoder(rw.Body).Decode(&doc))
+	assert.Equal(t, map[string]string{"product": "router"}, doc.Metadata)
+	assert.Equal(t, []string{"# Setup\n\nPlug in the router.\n\nWait for the light."}, doc.Chunks)
+}
+
+func TestKnowledgeAPI_RequiresAPIKey(t *testing.T) {
+	t.Setenv("VECTOR_STORE_DIR", "")
+	t.Setenv("KNOWLEDGE_API_KEY", "secret")
+	h := NewHandler(vector.NewServiceWithEmbedder(vector.NewHashEmbedder(64)))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/collections", nil)
+	rw := httptest.NewRecorder()
+	h.HandleCollections(rw, req)
+	assert.Equal(t, http.StatusUnauthorized, rw.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/collections", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rw = httptest.NewRecorder()
+	h.HandleCollections(rw, req)
+	assert.Equal(t, http.StatusOK, rw.Code)
+
+	// Preflight requests never carry credentials
+	req = httptest.NewRequest(http.MethodOptions, "/api/collections", nil)
+	rw = httptest.NewRecorder()
+	h.HandleCollections(rw, req)
+	assert.Equal(t, http.StatusOK, rw.Code)
+}
+
+func TestKnowledgeAPI_DisabledWithoutAPIKey(t *testing.T) {
+	t.Setenv("VECTOR_STORE_DIR", "")
+	t.Setenv("KNOWLEDGE_API_KEY", "")
+	h := NewHandler(vector.NewServiceWithEmbedder(vector.NewHashEmbedder(64)))
+
+	for _, auth := range []string{"", "Bearer ", "Bearer anything"} {
+		req := httptest.NewRequest(http.MethodPost, "/api/collections", strings.NewReader(`{"name":"faq"}`))
+		req.Header.Set("Authorization", auth)
+		rw := httptest.NewRecorder()
+		h.HandleCollections(rw, req)
+		assert.Equal(t, http.StatusForbidden, rw.Code, "Authorization %q", auth)
+	}
+}
@@ -0,0 +1,164 @@
+package memories
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"csdeepseek/backend/services/memory"
+	"csdeepseek/backend/services/session"
+)
+
+// Handler lets users see and remove what the assistant remembers about them.
+// Every request needs the user token the sign-in service issued for the
+// user, sent as "Authorization: Bearer"; without USER_TOKEN_SECRET none is
+// accepted.
+type Handler struct {
+	memoryService  *memory.Service
+	sessionService *session.Service
+}
+
+type MemoryRequest struct {
+	UserID string `json:"user_id"`
+	Text   string `json:"text"`
+	Kind   string `json:"kind"`
+}
+
+type MemoriesResponse struct {
+	Memories []memory.Memory `json:"memories"`
+}
+
+type DeleteResponse struct {
+	Deleted int `json:"deleted"`
+}
+
+func NewHandler(memoryService *memory.Service, sessionService *session.Service) *Handler {
+	return &Handler{
+		memoryService:  memoryService,
+		sessionService: sessionService,
+	}
+}
+
+// HandleMemories serves /api/memories?user_id=: GET lists the user's
+// memories, POST adds one, DELETE forgets all of them.
+func (h *Handler) HandleMemories(w http.ResponseWriter, r *http.Request) {
+	if !preflight(w, r, "GET, POST, DELETE, OPTIONS") {
+		return
+	}
+	userID := r.URL.Query().Get("user_id")
+
+	switch r.Method {
+	case "GET":
+		if !h.authorize(w, r, userID) {
+			return
+		}
+		memories, err := h.memoryService.List(r.Context(), userID)
+		if err != nil {
+			log.Printf("Failed to list memories: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, MemoriesResponse{Memories: memories})
+
+	case "POST":
+		var req MemoryRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if !h.authorize(w, r, req.UserID) {
+			return
+		}
+		if req.Text == "" {
+			http.Error(w, "Text is required", http.StatusBadRequest)
+			return
+		}
+		m, err := h.memoryService.Add(r.Context(), memory.Memory{UserID: req.UserID, Text: req.Text, Kind: req.Kind})
+		if err != nil {
+			log.Printf("Failed to add memory: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusCreated, m)
+
+	case "DELETE":
+		if !h.authorize(w, r, userID) {
+			return
+		}
+		deleted, err := h.memoryService.DeleteAll(r.Context(), userID)
+		if err != nil {
+			log.Printf("Failed to delete memories: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, DeleteResponse{Deleted: deleted})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleMemory serves DELETE /api/memories/{id}?user_id=.
+func (h *Handler) HandleMemory(w http.ResponseWriter, r *http.Request) {
+	if !preflight(w, r, "DELETE, OPTIONS") {
+		return
+	}
+	if r.Method != "DELETE" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	if !h.authorize(w, r, userID) {
+		return
+	}
+	if err := h.memoryService.Delete(r.Context(), userID, r.PathValue("id")); err != nil {
+		if errors.Is(err, memory.ErrNotFound) {
+			http.Error(w, "Memory not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to delete memory: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorize checks that the request carries the user token of userID,
+// writing the error response and reporting false if it does not.
+func (h *Handler) authorize(w http.ResponseWriter, r *http.Request, userID string) bool {
+	if err := memory.ValidateUserID(userID); err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if err := h.sessionService.VerifyUserToken(token, userID); err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// preflight sets the CORS headers and answers OPTIONS requests. It reports
+// whether the request should be handled further.
+func preflight(w http.ResponseWriter, r *http.Request, methods string) bool {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", methods)
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
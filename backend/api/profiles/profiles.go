@@ -0,0 +1,64 @@
+package profiles
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"csdeepseek/backend/services/profile"
+)
+
+// Handler lists the assistant profiles clients can start a session with.
+type Handler struct {
+	profiles *profile.Service
+}
+
+// ProfileSummary describes a profile to clients. Its prompt and generation
+// settings stay on the server.
+type ProfileSummary struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Default is set for the profile of sessions created without one
+	Default bool `json:"default"`
+}
+
+type ProfilesResponse struct {
+	Profiles []ProfileSummary `json:"profiles"`
+}
+
+func NewHandler(profiles *profile.Service) *Handler {
+	return &Handler{
+		profiles: profiles,
+	}
+}
+
+// HandleProfiles serves GET /api/profiles, listing the profiles in the
+// order they are configured.
+func (h *Handler) HandleProfiles(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp := ProfilesResponse{Profiles: []ProfileSummary{}}
+	for _, p := range h.profiles.List() {
+		resp.Profiles = append(resp.Profiles, ProfileSummary{
+			Name:        p.Name,
+			Description: p.Description,
+			Default:     p.Name == h.profiles.DefaultName(),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+package profiles
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"csdeepseek/backend/services/profile"
+)
+
+func TestHandleProfiles(t *testing.T) {
+	profiles, err := profile.New([]profile.Profile{
+		{Name: "support", Description: "Answers product questions", SystemPrompt: "You are a support agent."},
+		{Name: "casual"},
+	}, "support")
+	require.NoError(t, err)
+	h := NewHandler(profiles)
+
+	rw := httptest.NewRecorder()
+	h.HandleProfiles(rw, httptest.NewRequest(http.MethodGet, "/api/profiles", nil))
+	require.Equal(t, http.StatusOK, rw.Code)
+	assert.NotContains(t, rw.Body.String(), "support agent", "prompts are not shown to clients")
+	var resp ProfilesResponse
+	require.NoError(t, json.NewDecoder(rw.Body).Decode(&resp))
+	assert.Equal(t, []ProfileSummary{
+		{Name: "support", Description: "Answers product questions", Default: true},
+		{Name: "casual"},
+	}, resp.Profiles)
+
+	rw = httptest.NewRecorder()
+	h.HandleProfiles(rw, httptest.NewRequest(http.MethodPost, "/api/profiles", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rw.Code)
+}
@@ -0,0 +1,187 @@
+package search
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"csdeepseek/backend/models/chat"
+	"csdeepseek/backend/services/memory"
+	"csdeepseek/backend/services/search"
+	"csdeepseek/backend/services/session"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// Handler searches conversation history. Users search their own sessions
+// with ?user_id=, presenting the user token the sign-in service issued them
+// as a bearer token. Support agents presenting SUPPORT_API_KEY instead may
+// search every user's sessions, with user_id as an optional filter.
+type Handler struct {
+	searchService  *search.Service
+	sessionService *session.Service
+	supportKey     string
+}
+
+type SearchResponse struct {
+	Results []search.Hit `json:"results"`
+	Total   int          `json:"total"`
+	Offset  int          `json:"offset"`
+	Limit   int          `json:"limit"`
+}
+
+func NewHandler(searchService *search.Service, sessionService *session.Service) *Handler {
+	return &Handler{
+		searchService:  searchService,
+		sessionService: sessionService,
+		supportKey:     os.Getenv("SUPPORT_API_KEY"),
+	}
+}
+
+// HandleSearch serves GET /api/search?q=&user_id=&session_id=&role=&from=
+// &to=&offset=&limit=. from and to are RFC 3339 times or dates; a date as
+// to includes that whole day.
+func (h *Handler) HandleSearch(w http.ResponseWriter, r *http.Request) {
+	if !preflight(w, r, "GET, OPTIONS") {
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	q := search.Query{
+		Text:      query.Get("q"),
+		UserID:    query.Get("user_id"),
+		SessionID: query.Get("session_id"),
+		Role:      query.Get("role"),
+	}
+	agent := h.isSupportAgent(r)
+	if q.UserID != "" || !agent {
+		if err := memory.ValidateUserID(q.UserID); err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+	}
+	if !agent && h.sessionService.VerifyUserToken(bearerToken(r), q.UserID) != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if strings.TrimSpace(q.Text) == "" {
+		http.Error(w, "Query is required", http.StatusBadRequest)
+		return
+	}
+	if q.Role != "" && q.Role != chat.RoleUser && q.Role != chat.RoleAssistant {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+	var err error
+	if q.From, err = timeParam(query.Get("from"), false); err != nil {
+		http.Error(w, "Invalid from", http.StatusBadRequest)
+		return
+	}
+	if q.To, err = timeParam(query.Get("to"), true); err != nil {
+		http.Error(w, "Invalid to", http.StatusBadRequest)
+		return
+	}
+	q.Offset, err = intParam(query.Get("offset"), 0)
+	if err != nil || q.Offset < 0 {
+		http.Error(w, "Invalid offset", http.StatusBadRequest)
+		return
+	}
+	q.Limit, err = intParam(query.Get("limit"), defaultPageSize)
+	if err != nil || q.Limit < 1 {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
+		return
+	}
+	q.Limit = min(q.Limit, maxPageSize)
+
+	hits, total, err := h.searchService.Search(r.Context(), q)
+	switch {
+	case errors.Is(err, search.ErrDisabled):
+		http.Error(w, "Search is disabled", http.StatusNotFound)
+		return
+	case errors.Is(err, search.ErrEmptyQuery):
+		http.Error(w, "Query has no searchable terms", http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Printf("Failed to search history: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, SearchResponse{
+		Results: hits,
+		Total:   total,
+		Offset:  q.Offset,
+		Limit:   q.Limit,
+	})
+}
+
+func (h *Handler) isSupportAgent(r *http.Request) bool {
+	if h.supportKey == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(bearerToken(r)), []byte(h.supportKey)) == 1
+}
+
+func bearerToken(r *http.Request) string {
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// timeParam reads an RFC 3339 time or a date. A date read as an end bound
+// stands for the end of that day.
+func timeParam(v string, end bool) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+func intParam(v string, def int) (int, error) {
+	if v == "" {
+		return def, nil
+	}
+	return strconv.Atoi(v)
+}
+
+// preflight sets the CORS headers and answers OPTIONS requests. It reports
+// whether the request should be handled further.
+func preflight(w http.ResponseWriter, r *http.Request, methods string) bool {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", methods)
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	chatmodel "csdeepseek/backend/models/chat"
+	"csdeepseek/backend/services/search"
+	"csdeepseek/backend/services/session"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchAPI(t *testing.T) {
+	t.Setenv("HISTORY_SEARCH_ENABLED", "")
+	t.Setenv("SUPPORT_API_KEY", "agent-key")
+	t.Setenv("USER_TOKEN_SECRET", "test-secret")
+	sessionService := session.NewServiceWithStore(session.NewMemoryStore())
+	h := NewHandler(search.NewService(sessionService), sessionService)
+	ctx := context.Background()
+
+	at := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, userID := range []string{"alice", "bob"} {
+		sess, err := sessionService.CreateSessionForUser(ctx, userID)
+		require.NoError(t, err)
+		require.NoError(t, sessionService.AddMessage(ctx, sess.ID, session.Message{Role: chatmodel.RoleUser, Content: "My printer jams", CreatedAt: at}))
+	}
+
+	do := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rw := httptest.NewRecorder()
+		h.HandleSearch(rw, req)
+		return rw
+	}
+	decode := func(rw *httptest.ResponseRecorder) SearchResponse {
+		require.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+		var resp SearchResponse
+		require.NoError(t, json.NewDecoder(rw.Body).Decode(&resp))
+		return resp
+	}
+
+	alice := sessionService.UserToken("alice")
+	resp := decode(do("/api/search?user_id=alice&q=printer", alice))
+	require.Len(t, resp.Results, 1)
+	assert.Equal(t, "alice", resp.Results[0].UserID)
+	assert.Equal(t, "My <mark>printer</mark> jams", resp.Results[0].Snippet)
+	assert.Equal(t, 20, resp.Limit)
+
+	assert.Len(t, decode(do("/api/search?q=printer", "agent-key")).Results, 2, "agents search every user")
+	assert.Len(t, decode(do("/api/search?q=printer&user_id=bob", "agent-key")).Results, 1)
+	assert.Len(t, decode(do("/api/search?user_id=alice&q=printer&from=2026-05-01&to=2026-05-01", alice)).Results, 1)
+	assert.Empty(t, decode(do("/api/search?user_id=alice&q=printer&from=2026-05-02", alice)).Results)
+	assert.Empty(t, decode(do("/api/search?user_id=alice&q=printer&role=assistant", alice)).Results)
+
+	for path, code := range map[string]int{
+		"/api/search?q=printer":                        http.StatusBadRequest,
+		"/api/search?user_id=alice":                    http.StatusBadRequest,
+		"/api/search?user_id=alice&q=%3F%21":           http.StatusBadRequest,
+		"/api/search?user_id=alice&q=printer&role=x":   http.StatusBadRequest,
+		"/api/search?user_id=alice&q=printer&from=may": http.StatusBadRequest,
+		"/api/search?user_id=alice&q=printer&limit=0":  http.StatusBadRequest,
+	} {
+		assert.Equal(t, code, do(path, alice).Code, path)
+	}
+	assert.Equal(t, http.StatusBadRequest, do("/api/search?q=printer", "wrong-key").Code)
+
+	// Naming a user is not enough to search their history, nor is another
+	// user's token
+	assert.Equal(t, http.StatusUnauthorized, do("/api/search?user_id=alice&q=printer", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, do("/api/search?user_id=bob&q=printer", alice).Code)
+}
@@ -0,0 +1,653 @@
+package sessions
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"csdeepseek/backend/services/export"
+	"csdeepseek/backend/services/importer"
+	"csdeepseek/backend/services/memory"
+	"csdeepseek/backend/services/session"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+	maxTitleLength  = 100
+	// maxImportSize caps an uploaded import file
+	maxImportSize = 32 << 20
+	// exportWriteWait is how long a client may take to read each part of an
+	// archive being exported; the deadline moves on with every write, so
+	// large exports are not cut off by the server's write timeout.
+	exportWriteWait = 30 * time.Second
+)
+
+// Handler lets users browse and manage their chat sessions. Every request
+// names the user with ?user_id= and proves it with the user token from the
+// sign-in service, sent as "Authorization: Bearer". Routes on a single
+// session also accept that session's token in X-Session-Token instead.
+// Only the user's own sessions are visible.
+type Handler struct {
+	sessionService *session.Service
+}
+
+// SessionSummary describes a session without its messages.
+type SessionSummary struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title,omitempty"`
+	Summary   string    `json:"summary,omitempty"`
+	Pinned    bool      `json:"pinned"`
+	Profile   string    `json:"profile,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// MessageCount counts the messages of the active branch
+	MessageCount int `json:"message_count"`
+	// DeletedAt is set for sessions in the trash
+	DeletedAt time.Time `json:"deleted_at,omitzero"`
+}
+
+type SessionsResponse struct {
+	Sessions []SessionSummary `json:"sessions"`
+	Total    int              `json:"total"`
+	Offset   int              `json:"offset"`
+	Limit    int              `json:"limit"`
+}
+
+// TrashResponse lists the sessions in the user's trash, most recently
+// deleted first.
+type TrashResponse struct {
+	Sessions []TrashedSession `json:"sessions"`
+}
+
+// TrashedSession is a session in the trash. It can be restored until
+// PurgeAt.
+type TrashedSession struct {
+	SessionSummary
+	PurgeAt time.Time `json:"purge_at"`
+}
+
+// SessionResponse is a session with the messages of its active branch.
+type SessionResponse struct {
+	SessionSummary
+	Messages []BranchMessage `json:"messages"`
+}
+
+// BranchMessage is a message of the active branch.
+type BranchMessage struct {
+	session.Message
+	// Versions lists the IDs of the message's alternatives, itself
+	// included, oldest first; omitted when it has none
+	Versions []string `json:"versions,omitempty"`
+}
+
+// BranchRequest makes the branch through MessageID active.
+type BranchRequest struct {
+	MessageID string `json:"message_id"`
+}
+
+// UpdateRequest changes a session's title or pinned state. Omitted fields
+// are left unchanged.
+type UpdateRequest struct {
+	Title  *string `json:"title"`
+	Pinned *bool   `json:"pinned"`
+}
+
+// ImportResponse reports what an import did with each conversation of the
+// file.
+type ImportResponse struct {
+	Format   string           `json:"format"`
+	Imported []ImportedResult `json:"imported"`
+	Failed   []FailedResult   `json:"failed"`
+}
+
+type ImportedResult struct {
+	// Index is the conversation's position in the file, from 0
+	Index   int            `json:"index"`
+	Session SessionSummary `json:"session"`
+	// SessionToken lets the client continue the new session in /api/chat
+	SessionToken string `json:"session_token"`
+}
+
+type FailedResult struct {
+	Index int    `json:"index"`
+	Title string `json:"title,omitempty"`
+	Error string `json:"error"`
+}
+
+func NewHandler(sessionService *session.Service) *Handler {
+	return &Handler{
+		sessionService: sessionService,
+	}
+}
+
+func (h *Handler) summarize(sess *session.Session) SessionSummary {
+	return SessionSummary{
+		ID:           sess.ID,
+		Title:        sess.Title,
+		Summary:      sess.Summary,
+		Pinned:       sess.Pinned,
+		Profile:      sess.Profile,
+		CreatedAt:    sess.CreatedAt,
+		UpdatedAt:    sess.UpdatedAt,
+		MessageCount: len(sess.ActivePath()),
+		DeletedAt:    sess.DeletedAt,
+	}
+}
+
+// HandleSessions serves GET /api/sessions?user_id=&offset=&limit=, listing
+// the user's sessions with pinned ones first, then newest first.
+func (h *Handler) HandleSessions(w http.ResponseWriter, r *http.Request) {
+	if !preflight(w, r, "GET, OPTIONS") {
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	userID, ok := h.authorizeUser(w, r)
+	if !ok {
+		return
+	}
+	offset, err := intParam(query.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		http.Error(w, "Invalid offset", http.StatusBadRequest)
+		return
+	}
+	limit, err := intParam(query.Get("limit"), defaultPageSize)
+	if err != nil || limit < 1 || limit > maxPageSize {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
+		return
+	}
+
+	page, total, err := h.sessionService.ListUserSessions(r.Context(), userID, offset, limit)
+	if err != nil {
+		log.Printf("Failed to list sessions: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	resp := SessionsResponse{
+		Sessions: make([]SessionSummary, 0, len(page)),
+		Total:    total,
+		Offset:   offset,
+		Limit:    limit,
+	}
+	for _, sess := range page {
+		resp.Sessions = append(resp.Sessions, h.summarize(sess))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// HandleSession serves /api/sessions/{id}?user_id=: GET returns the session
+// with the messages of its active branch, PATCH renames or pins it, DELETE
+// moves it to the trash. Sessions of other users answer 404, exactly like
+// missing ones.
+func (h *Handler) HandleSession(w http.ResponseWriter, r *http.Request) {
+	if !preflight(w, r, "GET, PATCH, DELETE, OPTIONS") {
+		return
+	}
+
+	userID, ok := h.authorizeSession(w, r)
+	if !ok {
+		return
+	}
+	id := r.PathValue("id")
+
+	switch r.Method {
+	case "GET":
+		sess, ok := h.ownedSession(w, r, userID, id)
+		if !ok {
+			return
+		}
+		writeJSON(w, http.StatusOK, h.sessionResponse(sess))
+
+	case "PATCH":
+		var req UpdateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.Title != nil {
+			title := strings.TrimSpace(*req.Title)
+			if utf8.RuneCountInString(title) > maxTitleLength {
+				http.Error(w, "Title is too long", http.StatusBadRequest)
+				return
+			}
+			req.Title = &title
+		}
+		if _, ok := h.ownedSession(w, r, userID, id); !ok {
+			return
+		}
+		update := session.SessionUpdate{Title: req.Title, Pinned: req.Pinned}
+		if req.Title != nil {
+			// A title the user chose is never replaced by a generated one
+			auto := false
+			update.AutoTitle = &auto
+		}
+		if err := h.sessionService.UpdateSession(r.Context(), id, update); err != nil {
+			h.writeError(w, "update", err)
+			return
+		}
+		sess, ok := h.ownedSession(w, r, userID, id)
+		if !ok {
+			return
+		}
+		writeJSON(w, http.StatusOK, h.summarize(sess))
+
+	case "DELETE":
+		if _, ok := h.ownedSession(w, r, userID, id); !ok {
+			return
+		}
+		if err := h.sessionService.DeleteSession(r.Context(), id); err != nil {
+			h.writeError(w, "delete", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleTrash serves GET /api/sessions/trash?user_id=, listing the user's
+// deleted sessions that can still be restored.
+func (h *Handler) HandleTrash(w http.ResponseWriter, r *http.Request) {
+	if !preflight(w, r, "GET, OPTIONS") {
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := h.authorizeUser(w, r)
+	if !ok {
+		return
+	}
+	trashed, err := h.sessionService.ListTrash(r.Context(), userID)
+	if err != nil {
+		log.Printf("Failed to list trash: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	keep := h.sessionService.Retention().TrashTTL
+	resp := TrashResponse{Sessions: make([]TrashedSession, 0, len(trashed))}
+	for _, sess := range trashed {
+		resp.Sessions = append(resp.Sessions, TrashedSession{
+			SessionSummary: h.summarize(sess),
+			PurgeAt:        sess.DeletedAt.Add(keep),
+		})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// HandleRestore serves POST /api/sessions/{id}/restore?user_id=, taking a
+// session out of the trash. It returns the session's summary.
+func (h *Handler) HandleRestore(w http.ResponseWriter, r *http.Request) {
+	if !preflight(w, r, "POST, OPTIONS") {
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := h.authorizeSession(w, r)
+	if !ok {
+		return
+	}
+	sess, err := h.sessionService.RestoreSession(r.Context(), userID, r.PathValue("id"))
+	if err != nil {
+		h.writeError(w, "restore", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, h.summarize(sess))
+}
+
+// HandleBranch serves POST /api/sessions/{id}/branch?user_id=, switching
+// the session to the branch through a message, such as another version of
+// an edited question. It returns the session like GET /api/sessions/{id}.
+func (h *Handler) HandleBranch(w http.ResponseWriter, r *http.Request) {
+	if !preflight(w, r, "POST, OPTIONS") {
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := h.authorizeSession(w, r)
+	if !ok {
+		return
+	}
+	id := r.PathValue("id")
+
+	var req BranchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MessageID == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if _, ok := h.ownedSession(w, r, userID, id); !ok {
+		return
+	}
+	unlock, err := h.sessionService.LockTurn(r.Context(), id)
+	if err != nil {
+		h.writeError(w, "lock", err)
+		return
+	}
+	defer unlock()
+	if _, err := h.sessionService.SwitchBranch(r.Context(), id, req.MessageID); err != nil {
+		if errors.Is(err, session.ErrMessageNotFound) {
+			http.Error(w, "Message not found", http.StatusNotFound)
+			return
+		}
+		h.writeError(w, "switch branch of", err)
+		return
+	}
+	sess, ok := h.ownedSession(w, r, userID, id)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, h.sessionResponse(sess))
+}
+
+// HandleExport serves GET /api/sessions/{id}/export?user_id=&format=, a
+// download of the session as md (the default), json or html.
+func (h *Handler) HandleExport(w http.ResponseWriter, r *http.Request) {
+	if !preflight(w, r, "GET, OPTIONS") {
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	userID, ok := h.authorizeSession(w, r)
+	if !ok {
+		return
+	}
+	format, err := export.ParseFormat(query.Get("format"))
+	if err != nil {
+		http.Error(w, "Invalid format", http.StatusBadRequest)
+		return
+	}
+	sess, ok := h.ownedSession(w, r, userID, r.PathValue("id"))
+	if !ok {
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := export.Write(&buf, sess, format); err != nil {
+		log.Printf("Failed to export session: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeDownload(w, format.ContentType(), format.Filename(sess), buf.Bytes())
+}
+
+// HandleExportAll serves GET /api/sessions/export?user_id=&format=, a zip
+// archive of all the user's sessions.
+func (h *Handler) HandleExportAll(w http.ResponseWriter, r *http.Request) {
+	if !preflight(w, r, "GET, OPTIONS") {
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	userID, ok := h.authorizeUser(w, r)
+	if !ok {
+		return
+	}
+	format, err := export.ParseFormat(query.Get("format"))
+	if err != nil {
+		http.Error(w, "Invalid format", http.StatusBadRequest)
+		return
+	}
+
+	// The archive is streamed as it is built, so the response has no length
+	// and an error after the first write can only abort it
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": "sessions-" + string(format) + ".zip"}))
+	out := &deadlineWriter{w: w, rc: http.NewResponseController(w)}
+	if err := export.WriteZip(out, h.sessionService.UserSessions(r.Context(), userID), format); err != nil {
+		log.Printf("Failed to export sessions: %v", err)
+		if !out.written {
+			w.Header().Del("Content-Disposition")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// deadlineWriter pushes the response's write deadline exportWriteWait into
+// the future before every write, so a streamed download runs as long as the
+// client keeps reading.
+type deadlineWriter struct {
+	w       io.Writer
+	rc      *http.ResponseController
+	written bool
+}
+
+func (d *deadlineWriter) Write(p []byte) (int, error) {
+	// Not every ResponseWriter supports deadlines; those have none to move
+	d.rc.SetWriteDeadline(time.Now().Add(exportWriteWait))
+	d.written = true
+	return d.w.Write(p)
+}
+
+// HandleImport serves POST /api/sessions/import?user_id=, creating a
+// session for each conversation in the uploaded file: an OpenAI message
+// array, a ChatGPT conversations.json or its export zip, or our own JSON
+// export. The file is the request body or the multipart field "file". A
+// conversation that cannot be imported is listed in the response with the
+// reason, and the rest are imported regardless.
+func (h *Handler) HandleImport(w http.ResponseWriter, r *http.Request) {
+	if !preflight(w, r, "POST, OPTIONS") {
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := h.authorizeUser(w, r)
+	if !ok {
+		return
+	}
+	data, err := readImportFile(w, r)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Invalid upload", http.StatusBadRequest)
+		return
+	}
+
+	format, conversations, err := importer.Parse(data)
+	if err != nil {
+		http.Error(w, "Unrecognized file: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := ImportResponse{
+		Format:   format,
+		Imported: []ImportedResult{},
+		Failed:   []FailedResult{},
+	}
+	for _, conv := range conversations {
+		if conv.Err == nil {
+			sess, err := h.sessionService.ImportSession(r.Context(), userID, conv.Session)
+			if err == nil {
+				resp.Imported = append(resp.Imported, ImportedResult{
+					Index:        conv.Index,
+					Session:      h.summarize(sess),
+					SessionToken: h.sessionService.Token(sess.ID, sess.UserID),
+				})
+				continue
+			}
+			log.Printf("Failed to import conversation %d: %v", conv.Index, err)
+			conv.Err = err
+		}
+		resp.Failed = append(resp.Failed, FailedResult{Index: conv.Index, Title: conv.Title, Error: conv.Err.Error()})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// readImportFile reads the uploaded file from a multipart form or the raw
+// request body.
+func readImportFile(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return io.ReadAll(r.Body)
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			if err == io.EOF {
+				return nil, fmt.Errorf("no file in upload")
+			}
+			return nil, err
+		}
+		if part.FormName() == "file" {
+			defer part.Close()
+			return io.ReadAll(part)
+		}
+		part.Close()
+	}
+}
+
+// writeDownload sends data as a file attachment.
+func writeDownload(w http.ResponseWriter, contentType, filename string, data []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		log.Printf("Failed to write download: %v", err)
+	}
+}
+
+func (h *Handler) sessionResponse(sess *session.Session) SessionResponse {
+	path := sess.ActivePath()
+	resp := SessionResponse{
+		SessionSummary: h.summarize(sess),
+		Messages:       make([]BranchMessage, 0, len(path)),
+	}
+	for _, msg := range path {
+		bm := BranchMessage{Message: msg}
+		if versions := sess.Versions(msg.ID); len(versions) > 1 {
+			bm.Versions = versions
+		}
+		resp.Messages = append(resp.Messages, bm)
+	}
+	return resp
+}
+
+// ownedSession loads a session owned by userID, writing the error response
+// and reporting false if there is none.
+func (h *Handler) ownedSession(w http.ResponseWriter, r *http.Request, userID, id string) (*session.Session, bool) {
+	sess, err := h.sessionService.GetSessionForUser(r.Context(), userID, id)
+	if err != nil {
+		h.writeError(w, "get", err)
+		return nil, false
+	}
+	return sess, true
+}
+
+// authorizeUser checks that the request carries the user token of the user
+// named by ?user_id=, writing the error response and reporting false if it
+// does not. Routes spanning all of a user's sessions accept nothing else.
+func (h *Handler) authorizeUser(w http.ResponseWriter, r *http.Request) (string, bool) {
+	userID := r.URL.Query().Get("user_id")
+	if err := memory.ValidateUserID(userID); err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return "", false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if err := h.sessionService.VerifyUserToken(token, userID); err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return "", false
+	}
+	return userID, true
+}
+
+// authorizeSession is authorizeUser for routes on the session named by the
+// path, which also accept the session's own token in X-Session-Token.
+func (h *Handler) authorizeSession(w http.ResponseWriter, r *http.Request) (string, bool) {
+	userID := r.URL.Query().Get("user_id")
+	if err := memory.ValidateUserID(userID); err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return "", false
+	}
+	token := r.Header.Get("X-Session-Token")
+	if token == "" {
+		token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	if err := h.sessionService.VerifyToken(token, r.PathValue("id"), userID); err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return "", false
+	}
+	return userID, true
+}
+
+func (h *Handler) writeError(w http.ResponseWriter, op string, err error) {
+	if errors.Is(err, session.ErrNotFound) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("Failed to %s session: %v", op, err)
+	http.Error(w, "Internal server error", http.StatusInternalServerError)
+}
+
+func intParam(v string, def int) (int, error) {
+	if v == "" {
+		return def, nil
+	}
+	return strconv.Atoi(v)
+}
+
+// preflight sets the CORS headers and answers OPTIONS requests. It reports
+// whether the request should be handled further.
+func preflight(w http.ResponseWriter, r *http.Request, methods string) bool {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", methods)
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Session-Token")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
@@ -0,0 +1,447 @@
+package sessions
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	chatmodel "csdeepseek/backend/models/chat"
+	"csdeepseek/backend/services/export"
+	"csdeepseek/backend/services/session"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestMux(t *testing.T) (*http.ServeMux, *session.Service) {
+	t.Setenv("USER_TOKEN_SECRET", "test-secret")
+	sessionService := session.NewServiceWithStore(session.NewMemoryStore())
+	h := NewHandler(sessionService)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/sessions", h.HandleSessions)
+	mux.HandleFunc("/api/sessions/{id}", h.HandleSession)
+	mux.HandleFunc("/api/sessions/{id}/branch", h.HandleBranch)
+	mux.HandleFunc("/api/sessions/{id}/restore", h.HandleRestore)
+	mux.HandleFunc("/api/sessions/{id}/export", h.HandleExport)
+	mux.HandleFunc("/api/sessions/export", h.HandleExportAll)
+	mux.HandleFunc("/api/sessions/import", h.HandleImport)
+	mux.HandleFunc("/api/sessions/trash", h.HandleTrash)
+	return mux, sessionService
+}
+
+// signedIn adds the user token of the request's user_id, as a client signed
+// in as that user sends it.
+func signedIn(sessionService *session.Service, req *http.Request) *http.Request {
+	req.Header.Set("Authorization", "Bearer "+sessionService.UserToken(req.URL.Query().Get("user_id")))
+	return req
+}
+
+func TestSessionsAPI(t *testing.T) {
+	mux, sessionService := newTestMux(t)
+	ctx := context.Background()
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		mux.ServeHTTP(rw, signedIn(sessionService, httptest.NewRequest(method, path, strings.NewReader(body))))
+		return rw
+	}
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		sess, err := sessionService.CreateSessionForUser(ctx, "alice")
+		require.NoError(t, err)
+		ids = append(ids, sess.ID)
+	}
+	require.NoError(t, sessionService.AddMessage(ctx, ids[0], session.Message{Role: "user", Content: "My printer jams"}))
+	_, err := sessionService.CreateSessionForUser(ctx, "bob")
+	require.NoError(t, err)
+
+	// List, paginated
+	rw := do(http.MethodGet, "/api/sessions?user_id=alice&limit=2", "")
+	require.Equal(t, http.StatusOK, rw.Code)
+	var list SessionsResponse
+	require.NoError(t, json.NewDecoder(rw.Body).Decode(&list))
+	assert.Equal(t, 3, list.Total)
+	require.Len(t, list.Sessions, 2)
+	assert.Equal(t, ids[0], list.Sessions[0].ID, "most recently active first")
+	assert.Equal(t, 1, list.Sessions[0].MessageCount)
+
+	rw = do(http.MethodGet, "/api/sessions?user_id=alice&limit=2&offset=2", "")
+	require.NoError(t, json.NewDecoder(rw.Body).Decode(&list))
+	assert.Len(t, list.Sessions, 1)
+
+	// Rename and pin
+	auto, generated := true, "Router"
+	require.NoError(t, sessionService.UpdateSession(ctx, ids[2], session.SessionUpdate{Title: &generated, AutoTitle: &auto}))
+	rw = do(http.MethodPatch, "/api/sessions/"+ids[2]+"?user_id=alice", `{"title":"  Router setup  ","pinned":true}`)
+	require.Equal(t, http.StatusOK, rw.Code)
+	var summary SessionSummary
+	require.NoError(t, json.NewDecoder(rw.Body).Decode(&summary))
+	assert.Equal(t, "Router setup", summary.Title)
+	assert.True(t, summary.Pinned)
+	renamed, err := sessionService.GetSession(ctx, ids[2])
+	require.NoError(t, err)
+	assert.False(t, renamed.AutoTitle, "renaming keeps the title from being regenerated")
+
+	rw = do(http.MethodGet, "/api/sessions?user_id=alice", "")
+	require.NoError(t, json.NewDecoder(rw.Body).Decode(&list))
+	assert.Equal(t, ids[2], list.Sessions[0].ID, "pinned first")
+
+	// Fetch with messages
+	rw = do(http.MethodGet, "/api/sessions/"+ids[0]+"?user_id=alice", "")
+	require.Equal(t, http.StatusOK, rw.Code)
+	var full SessionResponse
+	require.NoError(t, json.NewDecoder(rw.Body).Decode(&full))
+	require.Len(t, full.Messages, 1)
+	assert.Equal(t, "My printer jams", full.Messages[0].Content)
+	assert.NotEmpty(t, full.Messages[0].ID)
+
+	// Delete
+	assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/api/sessions/"+ids[1]+"?user_id=alice", "").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/api/sessions/"+ids[1]+"?user_id=alice", "").Code)
+}
+
+func TestSessionsAPI_Trash(t *testing.T) {
+	t.Setenv("SESSION_TRASH_DAYS", "7")
+	mux, sessionService := newTestMux(t)
+	ctx := context.Background()
+	do := func(method, path string) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		mux.ServeHTTP(rw, signedIn(sessionService, httptest.NewRequest(method, path, nil)))
+		return rw
+	}
+
+	sess, err := sessionService.CreateSessionForUser(ctx, "alice")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/api/sessions/"+sess.ID+"?user_id=alice").Code)
+
+	rw := do(http.MethodGet, "/api/sessions/trash?user_id=alice")
+	require.Equal(t, http.StatusOK, rw.Code)
+	var trash TrashResponse
+	require.NoError(t, json.NewDecoder(rw.Body).Decode(&trash))
+	require.Len(t, trash.Sessions, 1)
+	assert.Equal(t, sess.ID, trash.Sessions[0].ID)
+	assert.Equal(t, 7*24*time.Hour, trash.Sessions[0].PurgeAt.Sub(trash.Sessions[0].DeletedAt))
+
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/api/sessions/"+sess.ID+"/restore?user_id=bob").Code)
+	rw = do(http.MethodPost, "/api/sessions/"+sess.ID+"/restore?user_id=alice")
+	require.Equal(t, http.StatusOK, rw.Code)
+	var summary SessionSummary
+	require.NoError(t, json.NewDecoder(rw.Body).Decode(&summary))
+	assert.True(t, summary.DeletedAt.IsZero())
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/api/sessions/"+sess.ID+"?user_id=alice").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/api/sessions/"+sess.ID+"/restore?user_id=alice").Code, "only trashed sessions can be restored")
+}
+
+func TestSessionsAPI_Ownership(t *testing.T) {
+	mux, sessionService := newTestMux(t)
+	ctx := context.Background()
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		mux.ServeHTTP(rw, signedIn(sessionService, httptest.NewRequest(method, path, strings.NewReader(body))))
+		return rw
+	}
+
+	sess, err := sessionService.CreateSessionForUser(ctx, "alice")
+	require.NoError(t, err)
+	anonymous, err := sessionService.CreateSession(ctx)
+	require.NoError(t, err)
+
+	// Another user's session looks exactly like a missing one
+	for _, path := range []string{"/api/sessions/" + sess.ID + "?user_id=bob", "/api/sessions/missing?user_id=bob"} {
+		assert.Equal(t, http.StatusNotFound, do(http.MethodGet, path, "").Code)
+		assert.Equal(t, http.StatusNotFound, do(http.MethodPatch, path, `{"title":"mine now"}`).Code)
+		assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, path, "").Code)
+	}
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/api/sessions/"+anonymous.ID, "").Code)
+
+	got, err := sessionService.GetSession(ctx, sess.ID)
+	require.NoError(t, err)
+	assert.Empty(t, got.Title)
+
+	rw := do(http.MethodGet, "/api/sessions?user_id=bob", "")
+	var list SessionsResponse
+	require.NoError(t, json.NewDecoder(rw.Body).Decode(&list))
+	assert.Empty(t, list.Sessions)
+}
+
+func TestSessionsAPI_Validation(t *testing.T) {
+	mux, sessionService := newTestMux(t)
+	sess, err := sessionService.CreateSessionForUser(context.Background(), "alice")
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		method, path, body string
+		code               int
+	}{
+		{http.MethodGet, "/api/sessions", "", http.StatusBadRequest},
+		{http.MethodGet, "/api/sessions?user_id=alice&limit=0", "", http.StatusBadRequest},
+		{http.MethodGet, "/api/sessions?user_id=alice&limit=500", "", http.StatusBadRequest},
+		{http.MethodGet, "/api/sessions?user_id=alice&offset=-1", "", http.StatusBadRequest},
+		{http.MethodPost, "/api/sessions?user_id=alice", "", http.StatusMethodNotAllowed},
+		{http.MethodPatch, "/api/sessions/" + sess.ID + "?user_id=alice", "not json", http.StatusBadRequest},
+		{http.MethodPatch, "/api/sessions/" + sess.ID + "?user_id=alice", `{"title":"` + strings.Repeat("x", 101) + `"}`, http.StatusBadRequest},
+		{http.MethodPut, "/api/sessions/" + sess.ID + "?user_id=alice", "", http.StatusMethodNotAllowed},
+		{http.MethodOptions, "/api/sessions", "", http.StatusOK},
+	} {
+		rw := httptest.NewRecorder()
+		mux.ServeHTTP(rw, signedIn(sessionService, httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))))
+		assert.Equal(t, tc.code, rw.Code, "%s %s", tc.method, tc.path)
+	}
+}
+
+func TestSessionsAPI_Branches(t *testing.T) {
+	mux, sessionService := newTestMux(t)
+	ctx := context.Background()
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		mux.ServeHTTP(rw, signedIn(sessionService, httptest.NewRequest(method, path, strings.NewReader(body))))
+		return rw
+	}
+
+	sess, err := sessionService.CreateSessionForUser(ctx, "alice")
+	require.NoError(t, err)
+	question := chatmodel.NewMessage(chatmodel.RoleUser, "My printer jams")
+	require.NoError(t, sessionService.AddMessage(ctx, sess.ID, question))
+	require.NoError(t, sessionService.AddMessage(ctx, sess.ID, chatmodel.NewMessage(chatmodel.RoleAssistant, "Open the tray")))
+	edited, err := sessionService.EditMessage(ctx, sess.ID, question.ID, "My printer jams on duplex")
+	require.NoError(t, err)
+
+	// Only the active branch is shown, with the versions of each message
+	rw := do(http.MethodGet, "/api/sessions/"+sess.ID+"?user_id=alice", "")
+	require.Equal(t, http.StatusOK, rw.Code)
+	var full SessionResponse
+	require.NoError(t, json.NewDecoder(rw.Body).Decode(&full))
+	require.Len(t, full.Messages, 1)
+	assert.Equal(t, edited.ID, full.Messages[0].ID)
+	assert.Equal(t, []string{question.ID, edited.ID}, full.Messages[0].Versions)
+	assert.Equal(t, 1, full.MessageCount)
+
+	rw = do(http.MethodPost, "/api/sessions/"+sess.ID+"/branch?user_id=alice", `{"message_id":"`+question.ID+`"}`)
+	require.Equal(t, http.StatusOK, rw.Code)
+	require.NoError(t, json.NewDecoder(rw.Body).Decode(&full))
+	require.Len(t, full.Messages, 2)
+	assert.Equal(t, "My printer jams", full.Messages[0].Content)
+	assert.Equal(t, "Open the tray", full.Messages[1].Content)
+	assert.Empty(t, full.Messages[1].Versions)
+
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/api/sessions/"+sess.ID+"/branch?user_id=alice", `{"message_id":"msg_missing"}`).Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/api/sessions/"+sess.ID+"/branch?user_id=bob", `{"message_id":"`+question.ID+`"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/sessions/"+sess.ID+"/branch?user_id=alice", `{}`).Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, do(http.MethodGet, "/api/sessions/"+sess.ID+"/branch?user_id=alice", "").Code)
+}
+
+func TestSessionsAPI_Export(t *testing.T) {
+	mux, sessionService := newTestMux(t)
+	ctx := context.Background()
+	do := func(path string) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		mux.ServeHTTP(rw, signedIn(sessionService, httptest.NewRequest(http.MethodGet, path, nil)))
+		return rw
+	}
+
+	sess, err := sessionService.CreateSessionForUser(ctx, "alice")
+	require.NoError(t, err)
+	require.NoError(t, sessionService.AddMessage(ctx, sess.ID, session.Message{Role: "user", Content: "My printer jams"}))
+	_, err = sessionService.CreateSessionForUser(ctx, "alice")
+	require.NoError(t, err)
+	_, err = sessionService.CreateSessionForUser(ctx, "bob")
+	require.NoError(t, err)
+
+	rw := do("/api/sessions/" + sess.ID + "/export?user_id=alice")
+	require.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "text/markdown; charset=utf-8", rw.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename=`+sess.ID+`.md`, rw.Header().Get("Content-Disposition"))
+	assert.Contains(t, rw.Body.String(), "My printer jams")
+
+	rw = do("/api/sessions/" + sess.ID + "/export?user_id=alice&format=json")
+	require.Equal(t, http.StatusOK, rw.Code)
+	var doc export.Document
+	require.NoError(t, json.NewDecoder(rw.Body).Decode(&doc))
+	assert.Equal(t, sess.ID, doc.Session.ID)
+
+	rw = do("/api/sessions/export?user_id=alice&format=html")
+	require.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "application/zip", rw.Header().Get("Content-Type"))
+	zr, err := zip.NewReader(bytes.NewReader(rw.Body.Bytes()), int64(rw.Body.Len()))
+	require.NoError(t, err)
+	assert.Len(t, zr.File, 2, "only the user's own sessions")
+
+	assert.Equal(t, http.StatusNotFound, do("/api/sessions/"+sess.ID+"/export?user_id=bob").Code)
+	assert.Equal(t, http.StatusBadRequest, do("/api/sessions/"+sess.ID+"/export?user_id=alice&format=pdf").Code)
+	assert.Equal(t, http.StatusBadRequest, do("/api/sessions/export?format=md").Code)
+}
+
+// slowStore takes a while to load each session, as a large store does.
+type slowStore struct {
+	session.Store
+	delay time.Duration
+}
+
+func (s slowStore) Get(ctx context.Context, id string) (*session.Session, error) {
+	time.Sleep(s.delay)
+	return s.Store.Get(ctx, id)
+}
+
+func TestSessionsAPI_ExportAllStreams(t *testing.T) {
+	t.Setenv("USER_TOKEN_SECRET", "test-secret")
+	ctx := context.Background()
+	sessionService := session.NewServiceWithStore(slowStore{Store: session.NewMemoryStore(), delay: 50 * time.Millisecond})
+	for i := 0; i < 8; i++ {
+		sess, err := sessionService.CreateSessionForUser(ctx, "alice")
+		require.NoError(t, err)
+		require.NoError(t, sessionService.AddMessage(ctx, sess.ID, session.Message{Role: "user", Content: strings.Repeat("My printer jams. ", 2000)}))
+	}
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(NewHandler(sessionService).HandleExportAll))
+	// The export takes longer than the server's write timeout allows
+	ts.Config.WriteTimeout = 200 * time.Millisecond
+	ts.Start()
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/sessions/export?user_id=alice", nil)
+	require.NoError(t, err)
+	signedIn(sessionService, req)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Content-Length"), "the archive is streamed, not buffered")
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	require.NoError(t, err)
+	assert.Len(t, zr.File, 8)
+}
+
+func TestSessionsAPI_Import(t *testing.T) {
+	mux, sessionService := newTestMux(t)
+	ctx := context.Background()
+	do := func(contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/sessions/import?user_id=alice", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rw := httptest.NewRecorder()
+		mux.ServeHTTP(rw, signedIn(sessionService, req))
+		return rw
+	}
+
+	rw := do("application/json", `[
+		[{"role": "user", "content": "My printer jams"}, {"role": "assistant", "content": "Open the tray."}],
+		[{"role": "wizard", "content": "Hello"}]
+	]`)
+	require.Equal(t, http.StatusOK, rw.Code)
+	var resp ImportResponse
+	require.NoError(t, json.NewDecoder(rw.Body).Decode(&resp))
+	assert.Equal(t, "openai", resp.Format)
+	require.Len(t, resp.Imported, 1)
+	assert.Equal(t, 0, resp.Imported[0].Index)
+	assert.Equal(t, 2, resp.Imported[0].Session.MessageCount)
+	assert.NoError(t, sessionService.VerifyToken(resp.Imported[0].SessionToken, resp.Imported[0].Session.ID, "alice"), "imported sessions come with a token for continuing them")
+	require.Len(t, resp.Failed, 1)
+	assert.Equal(t, 1, resp.Failed[0].Index)
+	assert.Contains(t, resp.Failed[0].Error, "unknown role")
+
+	sess, err := sessionService.GetSessionForUser(ctx, "alice", resp.Imported[0].Session.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "My printer jams", sess.Messages[0].Content)
+
+	// A multipart upload of our own export
+	var exported bytes.Buffer
+	require.NoError(t, export.Write(&exported, sess, export.FormatJSON))
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", "session.json")
+	require.NoError(t, err)
+	_, err = fw.Write(exported.Bytes())
+	require.NoError(t, err)
+	require.NoError(t, mw.Close())
+
+	rw = do(mw.FormDataContentType(), body.String())
+	require.Equal(t, http.StatusOK, rw.Code)
+	resp = ImportResponse{}
+	require.NoError(t, json.NewDecoder(rw.Body).Decode(&resp))
+	assert.Equal(t, "csdeepseek", resp.Format)
+	require.Len(t, resp.Imported, 1)
+	assert.NotEqual(t, sess.ID, resp.Imported[0].Session.ID)
+	assert.Empty(t, resp.Failed)
+
+	_, total, err := sessionService.ListUserSessions(ctx, "alice", 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 2, total)
+
+	assert.Equal(t, http.StatusBadRequest, do("application/json", `not json`).Code)
+	assert.Equal(t, http.StatusBadRequest, do("application/json", `{"foo": 1}`).Code)
+	req := httptest.NewRequest(http.MethodPost, "/api/sessions/import", strings.NewReader(`[]`))
+	rw = httptest.NewRecorder()
+	mux.ServeHTTP(rw, req)
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+}
+
+func TestSessionsAPI_Auth(t *testing.T) {
+	mux, sessionService := newTestMux(t)
+	sess, err := sessionService.CreateSessionForUser(context.Background(), "alice")
+	require.NoError(t, err)
+	do := func(method, path string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rw := httptest.NewRecorder()
+		mux.ServeHTTP(rw, req)
+		return rw
+	}
+	sessionToken := sessionService.Token(sess.ID, "alice")
+	userToken := "Bearer " + sessionService.UserToken("alice")
+
+	// The user_id parameter alone proves nothing
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/api/sessions?user_id=alice").Code)
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/api/sessions/"+sess.ID+"?user_id=alice").Code)
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/api/sessions/export?user_id=alice").Code)
+
+	// A user token is only good for its own user
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/api/sessions?user_id=alice", "Authorization", userToken).Code)
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/api/sessions?user_id=bob", "Authorization", userToken).Code)
+
+	// A session token opens its own session, and nothing else
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/api/sessions/"+sess.ID+"?user_id=alice", "X-Session-Token", sessionToken).Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/api/sessions/"+sess.ID+"/export?user_id=alice", "X-Session-Token", sessionToken).Code)
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/api/sessions/"+sess.ID+"?user_id=bob", "X-Session-Token", sessionToken).Code)
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/api/sessions/other?user_id=alice", "X-Session-Token", sessionToken).Code)
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/api/sessions?user_id=alice", "Authorization", "Bearer "+sessionToken).Code)
+
+	// Summaries do not hand out session tokens
+	rw := do(http.MethodGet, "/api/sessions/"+sess.ID+"?user_id=alice", "Authorization", userToken)
+	require.Equal(t, http.StatusOK, rw.Code)
+	assert.NotContains(t, rw.Body.String(), "session_token")
+}
+
+func TestSessionsAPI_NoUserTokenSecret(t *testing.T) {
+	t.Setenv("USER_TOKEN_SECRET", "")
+	sessionService := session.NewServiceWithStore(session.NewMemoryStore())
+	h := NewHandler(sessionService)
+	sess, err := sessionService.CreateSessionForUser(context.Background(), "alice")
+	require.NoError(t, err)
+	assert.Empty(t, sessionService.UserToken("alice"))
+
+	for _, token := range []string{"", "Bearer "} {
+		req := httptest.NewRequest(http.MethodGet, "/api/sessions?user_id=alice", nil)
+		req.Header.Set("Authorization", token)
+		rw := httptest.NewRecorder()
+		h.HandleSessions(rw, req)
+		assert.Equal(t, http.StatusUnauthorized, rw.Code, "listing fails closed without a user token secret")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/sessions/"+sess.ID+"?user_id=alice", nil)
+	req.SetPathValue("id", sess.ID)
+	req.Header.Set("X-Session-Token", sessionService.Token(sess.ID, "alice"))
+	rw := httptest.NewRecorder()
+	h.HandleSession(rw, req)
+	assert.Equal(t, http.StatusOK, rw.Code, "session tokens still work")
+}
@@ -3,11 +3,19 @@ module csdeepseek/backend
 go 1.25.0
 
 require (
+	github.com/alicebob/miniredis/v2 v2.39.0
 	github.com/gorilla/websocket v1.5.1
+	github.com/redis/go-redis/v9 v9.22.0
 	github.com/stretchr/testify v1.8.4
 )
 
-require golang.org/x/net v0.55.0 // indirect
+require (
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
+	go.uber.org/atomic v1.11.0 // indirect
+	golang.org/x/net v0.55.0 // indirect
+	golang.org/x/sys v0.45.0 // indirect
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
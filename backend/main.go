@@ -13,8 +13,18 @@ import (
 
 	"csdeepseek/backend/api/chat"
 	"csdeepseek/backend/api/health"
+	"csdeepseek/backend/api/knowledge"
+	"csdeepseek/backend/api/memories"
+	"csdeepseek/backend/api/profiles"
+	searchapi "csdeepseek/backend/api/search"
+	"csdeepseek/backend/api/sessions"
 	"csdeepseek/backend/services/llm"
+	"csdeepseek/backend/services/memory"
+	"csdeepseek/backend/services/profile"
+	"csdeepseek/backend/services/retrieval"
+	"csdeepseek/backend/services/search"
 	"csdeepseek/backend/services/session"
+	"csdeepseek/backend/services/summary"
 	"csdeepseek/backend/services/vector"
 )
 
@@ -30,32 +40,64 @@ func main() {
 	llmService := llm.NewService()
 	vectorService := vector.NewService()
 	sessionService := session.NewService()
+	vectorService.SetReranker(llmService)
+	memoryService := memory.NewService(llmService, vectorService)
+	summaryService := summary.NewService(llmService, sessionService)
+	searchService := search.NewService(sessionService)
+	profileService := profile.NewService()
 
 	// Start session cleanup loop
-	timeout := 1 * time.Hour
 	interval := 10 * time.Minute
-	if v := os.Getenv("SESSION_TIMEOUT"); v != "" {
+	if v := os.Getenv("SESSION_CLEANUP_INTERVAL"); v != "" {
 		if secs, err := time.ParseDuration(v + "s"); err == nil {
-			timeout = secs
+			interval = secs
 		}
 	}
-	if v := os.Getenv("SESSION_CLEANUP_INTERVAL"); v != "" {
+	cleanupCtx, stopCleanup := context.WithCancel(context.Background())
+	cleanupDone := sessionService.StartCleanupLoop(cleanupCtx, interval)
+
+	// Start vector store snapshot loop
+	snapshotInterval := 5 * time.Minute
+	if v := os.Getenv("VECTOR_SNAPSHOT_INTERVAL"); v != "" {
 		if secs, err := time.ParseDuration(v + "s"); err == nil {
-			interval = secs
+			snapshotInterval = secs
 		}
 	}
-	sessionService.StartCleanupLoop(timeout, interval)
+	vectorService.StartSnapshotLoop(snapshotInterval)
 
 	// Initialize handlers
-	chatHandler := chat.NewHandler(llmService, vectorService, sessionService)
+	retriever := retrieval.NewService(llmService, vectorService)
+	chatHandler := chat.NewHandler(llmService, retriever, sessionService, memoryService, summaryService, profileService)
 	healthHandler := health.NewHandler(sessionService)
-	wsChatHandler := chat.NewWSHandler(llmService, sessionService)
+	wsChatHandler := chat.NewWSHandler(llmService, retriever, sessionService, memoryService, summaryService, profileService)
+	knowledgeHandler := knowledge.NewHandler(vectorService)
+	memoriesHandler := memories.NewHandler(memoryService, sessionService)
+	sessionsHandler := sessions.NewHandler(sessionService)
+	searchHandler := searchapi.NewHandler(searchService, sessionService)
+	profilesHandler := profiles.NewHandler(profileService)
 
 	// Setup routes
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/chat", chatHandler.HandleChat)
 	mux.HandleFunc("/api/health", healthHandler.HandleHealth)
 	mux.HandleFunc("/ws/chat", wsChatHandler.HandleWSChat)
+	mux.HandleFunc("/api/collections", knowledgeHandler.HandleCollections)
+	mux.HandleFunc("/api/collections/{name}", knowledgeHandler.HandleCollection)
+	mux.HandleFunc("/api/collections/{name}/search", knowledgeHandler.HandleSearch)
+	mux.HandleFunc("/api/documents", knowledgeHandler.HandleDocuments)
+	mux.HandleFunc("/api/documents/{id}", knowledgeHandler.HandleDocument)
+	mux.HandleFunc("/api/memories", memoriesHandler.HandleMemories)
+	mux.HandleFunc("/api/memories/{id}", memoriesHandler.HandleMemory)
+	mux.HandleFunc("/api/sessions", sessionsHandler.HandleSessions)
+	mux.HandleFunc("/api/sessions/{id}", sessionsHandler.HandleSession)
+	mux.HandleFunc("/api/sessions/{id}/branch", sessionsHandler.HandleBranch)
+	mux.HandleFunc("/api/sessions/{id}/restore", sessionsHandler.HandleRestore)
+	mux.HandleFunc("/api/sessions/{id}/export", sessionsHandler.HandleExport)
+	mux.HandleFunc("/api/sessions/export", sessionsHandler.HandleExportAll)
+	mux.HandleFunc("/api/sessions/import", sessionsHandler.HandleImport)
+	mux.HandleFunc("/api/sessions/trash", sessionsHandler.HandleTrash)
+	mux.HandleFunc("/api/search", searchHandler.HandleSearch)
+	mux.HandleFunc("/api/profiles", profilesHandler.HandleProfiles)
 
 	// Create server
 
@@ -93,5 +135,19 @@ func main() {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
 
+	// Stop the cleanup loop before closing the store it works on
+	stopCleanup()
+	<-cleanupDone
+
+	// Persist the session index
+	if err := sessionService.Close(); err != nil {
+		log.Printf("Failed to close session store: %v", err)
+	}
+
+	// Persist the vector store
+	if err := vectorService.Close(); err != nil {
+		log.Printf("Failed to close vector store: %v", err)
+	}
+
 	log.Println("Server exiting")
 }
@@ -1,13 +1,68 @@
 package chat
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"time"
 )
 
+// Message roles
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// Message statuses. Only complete messages are sent back to the model.
+const (
+	StatusComplete = "complete"
+	// StatusPartial marks a streamed reply that was cut off
+	StatusPartial = "partial"
+	// StatusFailed marks a reply the model failed to produce
+	StatusFailed = "failed"
+)
+
+// NoParent is the ParentID of a message that starts a branch at the top of
+// the conversation, such as an edited first question.
+const NoParent = "root"
+
+// Message is the domain model for one message of a conversation. Provider
+// wire formats are converted to and from it at the edges.
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	ID string `json:"id,omitempty"`
+	// ParentID is the message this one follows in the conversation tree.
+	// Empty means the message stored right before it, which keeps
+	// conversations stored before branching existed a single chain.
+	ParentID  string    `json:"parent_id,omitempty"`
+	Role      string    `json:"role"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at,omitempty"`
+	// Status is empty for messages stored before it existed, which count
+	// as complete
+	Status string `json:"status,omitempty"`
+
+	// Model, LatencyMillis and the token counts describe how an assistant
+	// reply was generated
+	Model            string `json:"model,omitempty"`
+	LatencyMillis    int64  `json:"latency_ms,omitempty"`
+	PromptTokens     int    `json:"prompt_tokens,omitempty"`
+	CompletionTokens int    `json:"completion_tokens,omitempty"`
+
+	// Citations are the knowledge base passages the reply was given,
+	// numbered as the model saw them
+	Citations []Citation `json:"citations,omitempty"`
+
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Citation is a knowledge base passage an assistant reply can refer to as
+// [Index].
+type Citation struct {
+	Index      int    `json:"index"`
+	DocumentID string `json:"document_id,omitempty"`
+	ChunkID    string `json:"chunk_id,omitempty"`
+	Text       string `json:"text"`
 }
 
 type Conversation struct {
@@ -17,12 +72,42 @@ type Conversation struct {
 	UpdatedAt string    `json:"updated_at"`
 }
 
+// NewMessage creates a complete message with a fresh ID and timestamp
+func NewMessage(role, content string) Message {
+	return Message{
+		ID:        NewMessageID(),
+		Role:      role,
+		Content:   content,
+		CreatedAt: time.Now(),
+		Status:    StatusComplete,
+	}
+}
+
+// NewMessageID generates a random message ID
+func NewMessageID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "msg_" + hex.EncodeToString(b)
+}
+
+// Complete reports whether the message was produced in full
+func (m *Message) Complete() bool {
+	return m.Status == "" || m.Status == StatusComplete
+}
+
 // Validate checks if the message is valid
 func (m *Message) Validate() error {
-	if m.Role != "user" && m.Role != "assistant" {
-		return errors.New("invalid role: must be 'user' or 'assistant'")
+	switch m.Role {
+	case RoleSystem, RoleUser, RoleAssistant:
+	default:
+		return errors.New("invalid role: must be 'system', 'user' or 'assistant'")
+	}
+	switch m.Status {
+	case "", StatusComplete, StatusPartial, StatusFailed:
+	default:
+		return errors.New("invalid status: must be 'complete', 'partial' or 'failed'")
 	}
-	if m.Content == "" {
+	if m.Content == "" && m.Complete() {
 		return errors.New("content cannot be empty")
 	}
 	return nil
@@ -49,7 +134,9 @@ func (c *Conversation) AddMessage(msg Message) error {
 	return nil
 }
 
-// generateID generates a unique ID for conversations
+// generateID generates a random, unguessable ID for conversations
 func generateID() string {
-	return time.Now().UTC().Format("20060102150405")
+	b := make([]byte, 16)
+	rand.Read(b)
+	return "conv_" + hex.EncodeToString(b)
 }
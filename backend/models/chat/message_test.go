@@ -0,0 +1,27 @@
+package chat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageValidate(t *testing.T) {
+	assert.NoError(t, (&Message{Role: RoleUser, Content: "hi"}).Validate())
+	assert.NoError(t, (&Message{Role: RoleSystem, Content: "Be brief."}).Validate())
+	assert.NoError(t, (&Message{Role: RoleAssistant, Status: StatusFailed}).Validate())
+	assert.NoError(t, (&Message{Role: RoleAssistant, Status: StatusPartial}).Validate())
+
+	assert.Error(t, (&Message{Role: "tool", Content: "hi"}).Validate())
+	assert.Error(t, (&Message{Role: RoleUser}).Validate())
+	assert.Error(t, (&Message{Role: RoleUser, Content: "hi", Status: "queued"}).Validate())
+}
+
+func TestNewMessage(t *testing.T) {
+	a, b := NewMessage(RoleUser, "hi"), NewMessage(RoleUser, "hi")
+	assert.NotEqual(t, a.ID, b.ID)
+	assert.Equal(t, StatusComplete, a.Status)
+	assert.False(t, a.CreatedAt.IsZero())
+	assert.True(t, a.Complete())
+	assert.True(t, (&Message{}).Complete(), "messages stored before statuses count as complete")
+}
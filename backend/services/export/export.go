@@ -0,0 +1,202 @@
+package export
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"strings"
+	"time"
+
+	"csdeepseek/backend/models/chat"
+	"csdeepseek/backend/services/session"
+)
+
+// Format is a transcript format.
+type Format string
+
+const (
+	FormatMarkdown Format = "md"
+	FormatJSON     Format = "json"
+	FormatHTML     Format = "html"
+)
+
+// DocumentKind and DocumentVersion identify the JSON format, so that
+// exported sessions can be recognized when imported again.
+const (
+	DocumentKind    = "csdeepseek.session"
+	DocumentVersion = 1
+)
+
+var ErrUnknownFormat = errors.New("unknown export format")
+
+// Document is the canonical JSON export: the whole session with every
+// branch and all metadata.
+type Document struct {
+	Kind       string           `json:"kind"`
+	Version    int              `json:"version"`
+	ExportedAt time.Time        `json:"exported_at"`
+	Session    *session.Session `json:"session"`
+}
+
+// ParseFormat reads a format name, defaulting to Markdown when empty.
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "", "md", "markdown":
+		return FormatMarkdown, nil
+	case "json":
+		return FormatJSON, nil
+	case "html":
+		return FormatHTML, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownFormat, name)
+}
+
+// ContentType returns the MIME type of the format.
+func (f Format) ContentType() string {
+	switch f {
+	case FormatJSON:
+		return "application/json"
+	case FormatHTML:
+		return "text/html; charset=utf-8"
+	}
+	return "text/markdown; charset=utf-8"
+}
+
+// Filename returns the name a session's transcript is saved under.
+func (f Format) Filename(sess *session.Session) string {
+	return sess.ID + "." + string(f)
+}
+
+// Write renders the session in format f. Markdown and HTML show the active
+// branch as the user sees it, without system messages; JSON holds
+// everything.
+func Write(w io.Writer, sess *session.Session, f Format) error {
+	switch f {
+	case FormatMarkdown:
+		return writeMarkdown(w, sess)
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(Document{
+			Kind:       DocumentKind,
+			Version:    DocumentVersion,
+			ExportedAt: time.Now().UTC(),
+			Session:    sess,
+		})
+	case FormatHTML:
+		return writeHTML(w, sess)
+	}
+	return fmt.Errorf("%w: %q", ErrUnknownFormat, f)
+}
+
+// WriteZip writes a zip archive holding each session's transcript in
+// format f. Sessions are written as they are yielded, so only one is held
+// at a time; the first error yielded stops the archive.
+func WriteZip(w io.Writer, sessions iter.Seq2[*session.Session, error], f Format) error {
+	zw := zip.NewWriter(w)
+	for sess, err := range sessions {
+		if err != nil {
+			return fmt.Errorf("failed to load session: %w", err)
+		}
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     f.Filename(sess),
+			Method:   zip.Deflate,
+			Modified: sess.UpdatedAt,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to add %s to archive: %w", sess.ID, err)
+		}
+		if err := Write(fw, sess, f); err != nil {
+			return fmt.Errorf("failed to export %s: %w", sess.ID, err)
+		}
+	}
+	return zw.Close()
+}
+
+// title returns the session's title, or a generic one.
+func title(sess *session.Session) string {
+	if sess.Title != "" {
+		return sess.Title
+	}
+	return "Conversation"
+}
+
+// transcript returns the messages a reader of the conversation sees.
+func transcript(sess *session.Session) []chat.Message {
+	var messages []chat.Message
+	for _, m := range sess.ActivePath() {
+		if m.Role != chat.RoleSystem {
+			messages = append(messages, m)
+		}
+	}
+	return messages
+}
+
+func speaker(role string) string {
+	if role == chat.RoleAssistant {
+		return "Assistant"
+	}
+	return "User"
+}
+
+// statusNote explains a reply that is not complete.
+func statusNote(m chat.Message) string {
+	switch m.Status {
+	case chat.StatusPartial:
+		return "This reply was cut off."
+	case chat.StatusFailed:
+		return "This reply failed."
+	}
+	return ""
+}
+
+func timestamp(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format("2006-01-02 15:04 UTC")
+}
+
+func writeMarkdown(w io.Writer, sess *session.Session) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", title(sess))
+	fmt.Fprintf(&b, "- Session: `%s`\n", sess.ID)
+	fmt.Fprintf(&b, "- Created: %s\n", timestamp(sess.CreatedAt))
+	fmt.Fprintf(&b, "- Updated: %s\n", timestamp(sess.UpdatedAt))
+	if sess.Summary != "" {
+		fmt.Fprintf(&b, "\n> %s\n", strings.ReplaceAll(sess.Summary, "\n", "\n> "))
+	}
+
+	for _, m := range transcript(sess) {
+		b.WriteString("\n---\n\n")
+		fmt.Fprintf(&b, "### %s", speaker(m.Role))
+		if ts := timestamp(m.CreatedAt); ts != "" {
+			fmt.Fprintf(&b, " · %s", ts)
+		}
+		b.WriteString("\n\n")
+		if m.Content != "" {
+			// Content is Markdown already, so code blocks come through as
+			// they were written
+			b.WriteString(strings.TrimRight(m.Content, "\n"))
+			b.WriteString("\n")
+		}
+		if note := statusNote(m); note != "" {
+			fmt.Fprintf(&b, "\n*%s*\n", note)
+		}
+		if len(m.Citations) > 0 {
+			b.WriteString("\n**Sources**\n\n")
+			for _, c := range m.Citations {
+				fmt.Fprintf(&b, "%d. ", c.Index)
+				if c.DocumentID != "" {
+					fmt.Fprintf(&b, "`%s`: ", c.DocumentID)
+				}
+				fmt.Fprintf(&b, "%s\n", strings.Join(strings.Fields(c.Text), " "))
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
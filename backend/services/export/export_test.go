@@ -0,0 +1,120 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"csdeepseek/backend/models/chat"
+	"csdeepseek/backend/services/session"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testSession() *session.Session {
+	at := time.Date(2026, 3, 1, 9, 30, 0, 0, time.UTC)
+	reply := "Run this:\n\n```bash\nlpstat -p <printer>\n```\n\nThen check `cups` [1]."
+	return &session.Session{
+		ID:        "sess_1",
+		Title:     "Printer <jams>",
+		Summary:   "The printer jams.",
+		CreatedAt: at,
+		UpdatedAt: at,
+		Messages: []chat.Message{
+			{ID: "m0", Role: chat.RoleSystem, Content: "secret memories", CreatedAt: at},
+			{ID: "m1", Role: chat.RoleUser, Content: "My printer jams", CreatedAt: at},
+			{ID: "m2", Role: chat.RoleAssistant, Content: "an older answer", CreatedAt: at},
+			{ID: "m3", ParentID: "m1", Role: chat.RoleAssistant, Content: reply, CreatedAt: at, Citations: []chat.Citation{
+				{Index: 1, DocumentID: "printers", ChunkID: "printers#2", Text: "CUPS manages   printers."},
+			}},
+			{ID: "m4", Role: chat.RoleUser, Content: "Still jams", CreatedAt: at},
+			{ID: "m5", Role: chat.RoleAssistant, Content: "Try", Status: chat.StatusPartial, CreatedAt: at},
+		},
+	}
+}
+
+func render(t *testing.T, sess *session.Session, f Format) string {
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, sess, f))
+	return buf.String()
+}
+
+func TestParseFormat(t *testing.T) {
+	for name, want := range map[string]Format{"": FormatMarkdown, "markdown": FormatMarkdown, "JSON": FormatJSON, "html": FormatHTML} {
+		got, err := ParseFormat(name)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+	_, err := ParseFormat("pdf")
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+}
+
+func TestWrite_Markdown(t *testing.T) {
+	md := render(t, testSession(), FormatMarkdown)
+	assert.True(t, strings.HasPrefix(md, "# Printer <jams>\n"))
+	assert.Contains(t, md, "> The printer jams.")
+	assert.Contains(t, md, "### User · 2026-03-01 09:30 UTC\n\nMy printer jams\n")
+	assert.Contains(t, md, "```bash\nlpstat -p <printer>\n```", "code blocks are kept verbatim")
+	assert.Contains(t, md, "**Sources**\n\n1. `printers`: CUPS manages printers.\n")
+	assert.Contains(t, md, "*This reply was cut off.*")
+	assert.NotContains(t, md, "secret memories", "system messages are left out")
+	assert.NotContains(t, md, "an older answer", "only the active branch is shown")
+}
+
+func TestWrite_JSON(t *testing.T) {
+	sess := testSession()
+	var doc Document
+	require.NoError(t, json.Unmarshal([]byte(render(t, sess, FormatJSON)), &doc))
+	assert.Equal(t, DocumentKind, doc.Kind)
+	assert.Equal(t, DocumentVersion, doc.Version)
+	assert.Equal(t, sess.Summary, doc.Session.Summary)
+	assert.Equal(t, sess.Messages, doc.Session.Messages, "every branch and all metadata are kept")
+}
+
+func TestWrite_HTML(t *testing.T) {
+	page := render(t, testSession(), FormatHTML)
+	assert.Contains(t, page, "<title>Printer &lt;jams&gt;</title>")
+	assert.Contains(t, page, `<pre><code class="language-bash">lpstat -p &lt;printer&gt;</code></pre>`)
+	assert.Contains(t, page, "<code>cups</code> [1]")
+	assert.Contains(t, page, `<li value="1"><code>printers</code>: CUPS manages   printers.</li>`)
+	assert.Contains(t, page, "This reply was cut off.")
+	assert.NotContains(t, page, "secret memories")
+	assert.NotContains(t, page, "<script")
+}
+
+func TestRenderContent_EscapesEverything(t *testing.T) {
+	got := string(renderContent("<script>alert(1)</script>\n\n```\n<b>\n```\nunterminated `code"))
+	assert.NotContains(t, got, "<script>")
+	assert.Contains(t, got, "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>")
+	assert.Contains(t, got, "<pre><code>&lt;b&gt;</code></pre>")
+	assert.Contains(t, got, "<p>unterminated `code</p>")
+}
+
+func TestWriteZip(t *testing.T) {
+	first := testSession()
+	second := testSession()
+	second.ID = "sess_2"
+
+	var buf bytes.Buffer
+	sessions := func(yield func(*session.Session, error) bool) {
+		_ = yield(first, nil) && yield(second, nil)
+	}
+	require.NoError(t, WriteZip(&buf, sessions, FormatMarkdown))
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	require.Len(t, zr.File, 2)
+	assert.Equal(t, "sess_1.md", zr.File[0].Name)
+	assert.Equal(t, "sess_2.md", zr.File[1].Name)
+
+	f, err := zr.File[1].Open()
+	require.NoError(t, err)
+	defer f.Close()
+	content, err := io.ReadAll(f)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "`sess_2`")
+}
@@ -0,0 +1,138 @@
+package export
+
+import (
+	"html/template"
+	"io"
+	"regexp"
+	"strings"
+
+	"csdeepseek/backend/models/chat"
+	"csdeepseek/backend/services/session"
+)
+
+// pageTemplate is a standalone page: styles are inline and nothing is
+// loaded from elsewhere, so the file can be opened offline.
+var pageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", "PingFang SC", "Microsoft YaHei", sans-serif; max-width: 760px; margin: 2em auto; padding: 0 1em; color: #222; line-height: 1.55; }
+header p { color: #666; margin: 0.2em 0; }
+.summary { border-left: 3px solid #ccc; padding-left: 1em; color: #444; }
+.message { border-top: 1px solid #eee; padding: 1em 0; }
+.role { font-weight: 600; }
+.time { color: #888; font-size: 0.85em; margin-left: 0.5em; }
+.assistant .role { color: #1a6fb5; }
+.note { color: #a33; font-style: italic; }
+pre { background: #f6f8fa; padding: 0.8em; overflow-x: auto; border-radius: 4px; }
+code { font-family: SFMono-Regular, Consolas, monospace; font-size: 0.92em; }
+p code { background: #f0f0f0; padding: 0 0.2em; border-radius: 3px; }
+.sources { font-size: 0.9em; color: #555; }
+</style>
+</head>
+<body>
+<header>
+<h1>{{.Title}}</h1>
+<p>Session <code>{{.ID}}</code></p>
+<p>Created {{.Created}} · Updated {{.Updated}}</p>
+{{if .Summary}}<p class="summary">{{.Summary}}</p>{{end}}
+</header>
+{{range .Messages}}<section class="message {{.Role}}">
+<div><span class="role">{{.Speaker}}</span>{{if .Time}}<span class="time">{{.Time}}</span>{{end}}</div>
+{{.Body}}
+{{if .Note}}<p class="note">{{.Note}}</p>{{end}}
+{{if .Citations}}<div class="sources"><strong>Sources</strong><ol>
+{{range .Citations}}<li value="{{.Index}}">{{if .DocumentID}}<code>{{.DocumentID}}</code>: {{end}}{{.Text}}</li>
+{{end}}</ol></div>{{end}}
+</section>
+{{end}}</body>
+</html>
+`))
+
+type htmlPage struct {
+	Title, ID, Created, Updated, Summary string
+	Messages                             []htmlMessage
+}
+
+type htmlMessage struct {
+	Role, Speaker, Time, Note string
+	Body                      template.HTML
+	Citations                 []chat.Citation
+}
+
+func writeHTML(w io.Writer, sess *session.Session) error {
+	page := htmlPage{
+		Title:   title(sess),
+		ID:      sess.ID,
+		Created: timestamp(sess.CreatedAt),
+		Updated: timestamp(sess.UpdatedAt),
+		Summary: sess.Summary,
+	}
+	for _, m := range transcript(sess) {
+		hm := htmlMessage{
+			Role:      m.Role,
+			Speaker:   speaker(m.Role),
+			Time:      timestamp(m.CreatedAt),
+			Note:      statusNote(m),
+			Body:      renderContent(m.Content),
+			Citations: m.Citations,
+		}
+		page.Messages = append(page.Messages, hm)
+	}
+	return pageTemplate.Execute(w, page)
+}
+
+var (
+	fencePattern      = regexp.MustCompile("^\\s*(```+|~~~+)\\s*([\\w+#.-]*)")
+	inlineCodePattern = regexp.MustCompile("`([^`\n]+)`")
+)
+
+// renderContent turns message text into HTML. Fenced code blocks become
+// <pre> blocks kept verbatim, other text becomes paragraphs with inline code
+// marked up. Everything is escaped; no other Markdown is interpreted.
+func renderContent(content string) template.HTML {
+	var out strings.Builder
+	var para []string
+	flush := func() {
+		if len(para) == 0 {
+			return
+		}
+		text := template.HTMLEscapeString(strings.Join(para, "\n"))
+		text = inlineCodePattern.ReplaceAllString(text, "<code>$1</code>")
+		out.WriteString("<p>" + strings.ReplaceAll(text, "\n", "<br>\n") + "</p>\n")
+		para = para[:0]
+	}
+
+	lines := strings.Split(content, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		fence := fencePattern.FindStringSubmatch(line)
+		if fence == nil {
+			if strings.TrimSpace(line) == "" {
+				flush()
+			} else {
+				para = append(para, line)
+			}
+			continue
+		}
+
+		flush()
+		var code []string
+		for i++; i < len(lines); i++ {
+			if strings.HasPrefix(strings.TrimSpace(lines[i]), fence[1]) {
+				break
+			}
+			code = append(code, lines[i])
+		}
+		out.WriteString("<pre><code")
+		if fence[2] != "" {
+			out.WriteString(` class="language-` + template.HTMLEscapeString(fence[2]) + `"`)
+		}
+		out.WriteString(">" + template.HTMLEscapeString(strings.Join(code, "\n")) + "</code></pre>\n")
+	}
+	flush()
+	return template.HTML(out.String())
+}
@@ -0,0 +1,254 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"csdeepseek/backend/models/chat"
+	"csdeepseek/backend/services/export"
+	"csdeepseek/backend/services/session"
+)
+
+// openAIMessage is a chat-completions message. Content is a string or an
+// array of content parts.
+type openAIMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+	Name    string          `json:"name,omitempty"`
+}
+
+type openAIPart struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// parseOpenAI reads a message array, or a request body holding one. The
+// messages form a single chain without timestamps. Tool messages and tool
+// calls without text are left out.
+func parseOpenAI(index int, raw json.RawMessage) Conversation {
+	var messages []openAIMessage
+	if err := json.Unmarshal(raw, &messages); err != nil {
+		var body struct {
+			Messages []openAIMessage `json:"messages"`
+		}
+		if err := json.Unmarshal(raw, &body); err != nil {
+			return failed(index, "", fmt.Errorf("invalid messages: %w", err))
+		}
+		messages = body.Messages
+	}
+
+	sess := &session.Session{}
+	for i, m := range messages {
+		role := m.Role
+		switch role {
+		case "developer":
+			role = chat.RoleSystem
+		case chat.RoleSystem, chat.RoleUser, chat.RoleAssistant:
+		case "tool", "function":
+			continue
+		default:
+			return failed(index, "", fmt.Errorf("message %d has unknown role %q", i+1, m.Role))
+		}
+		content, err := openAIContent(m.Content)
+		if err != nil {
+			return failed(index, "", fmt.Errorf("message %d: %w", i+1, err))
+		}
+		if content == "" {
+			continue
+		}
+		sess.Messages = append(sess.Messages, chat.Message{Role: role, Content: content})
+	}
+	return done(index, sess, finish(sess))
+}
+
+func openAIContent(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text, nil
+	}
+	var parts []openAIPart
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return "", fmt.Errorf("content must be a string or an array of parts")
+	}
+	var texts []string
+	for _, p := range parts {
+		if p.Type == "text" && p.Text != "" {
+			texts = append(texts, p.Text)
+		}
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+// chatGPTConversation is one entry of a ChatGPT conversations.json. Its
+// messages form a tree in Mapping; CurrentNode is the leaf shown last.
+type chatGPTConversation struct {
+	Title       string                 `json:"title"`
+	CreateTime  float64                `json:"create_time"`
+	Mapping     map[string]chatGPTNode `json:"mapping"`
+	CurrentNode string                 `json:"current_node"`
+}
+
+type chatGPTNode struct {
+	ID       string          `json:"id"`
+	Message  *chatGPTMessage `json:"message"`
+	Parent   string          `json:"parent"`
+	Children []string        `json:"children"`
+}
+
+type chatGPTMessage struct {
+	Author struct {
+		Role string `json:"role"`
+	} `json:"author"`
+	CreateTime float64 `json:"create_time"`
+	Content    struct {
+		ContentType string            `json:"content_type"`
+		Parts       []json.RawMessage `json:"parts"`
+	} `json:"content"`
+	Metadata struct {
+		ModelSlug string `json:"model_slug"`
+		Hidden    bool   `json:"is_visually_hidden_from_conversation"`
+	} `json:"metadata"`
+}
+
+// parseChatGPT reads one ChatGPT conversation, keeping its tree of edits
+// and regenerations. Hidden, empty, tool and non-text messages are left
+// out, and their children attached to the nearest message kept above them.
+func parseChatGPT(index int, raw json.RawMessage) Conversation {
+	var conv chatGPTConversation
+	if err := json.Unmarshal(raw, &conv); err != nil {
+		return failed(index, "", fmt.Errorf("invalid conversation: %w", err))
+	}
+	sess := &session.Session{
+		Title:     strings.TrimSpace(conv.Title),
+		CreatedAt: unixTime(conv.CreateTime),
+	}
+
+	var roots []string
+	for id, node := range conv.Mapping {
+		if _, ok := conv.Mapping[node.Parent]; !ok {
+			roots = append(roots, id)
+		}
+	}
+	if len(roots) != 1 {
+		// Map order is random; several roots are put in a stable order
+		sortByCreateTime(roots, conv.Mapping)
+	}
+
+	// kept maps each node to the nearest message kept at or above it
+	kept := make(map[string]string, len(conv.Mapping))
+	var visit func(id, parent string, depth int) error
+	visit = func(id, parent string, depth int) error {
+		if depth > len(conv.Mapping) {
+			return fmt.Errorf("message tree has a cycle")
+		}
+		node := conv.Mapping[id]
+		if msg, ok := chatGPTToMessage(node.Message); ok {
+			msg.ID = id
+			msg.ParentID = parent
+			if parent == "" {
+				msg.ParentID = chat.NoParent
+			}
+			sess.Messages = append(sess.Messages, msg)
+			parent = id
+		}
+		kept[id] = parent
+		for _, child := range node.Children {
+			if _, ok := conv.Mapping[child]; ok {
+				if err := visit(child, parent, depth+1); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	for _, root := range roots {
+		if err := visit(root, "", 0); err != nil {
+			return failed(index, sess.Title, err)
+		}
+	}
+	sess.ActiveLeaf = kept[conv.CurrentNode]
+
+	return done(index, sess, finish(sess))
+}
+
+func chatGPTToMessage(m *chatGPTMessage) (chat.Message, bool) {
+	if m == nil || m.Metadata.Hidden {
+		return chat.Message{}, false
+	}
+	role := m.Author.Role
+	if role != chat.RoleSystem && role != chat.RoleUser && role != chat.RoleAssistant {
+		return chat.Message{}, false
+	}
+	if m.Content.ContentType != "text" && m.Content.ContentType != "multimodal_text" {
+		return chat.Message{}, false
+	}
+	var texts []string
+	for _, part := range m.Content.Parts {
+		var text string
+		if json.Unmarshal(part, &text) == nil && strings.TrimSpace(text) != "" {
+			texts = append(texts, text)
+		}
+	}
+	if len(texts) == 0 {
+		return chat.Message{}, false
+	}
+	msg := chat.Message{
+		Role:      role,
+		Content:   strings.Join(texts, "\n"),
+		CreatedAt: unixTime(m.CreateTime),
+	}
+	if role == chat.RoleAssistant {
+		msg.Model = m.Metadata.ModelSlug
+	}
+	return msg, true
+}
+
+func sortByCreateTime(ids []string, mapping map[string]chatGPTNode) {
+	created := func(id string) float64 {
+		if m := mapping[id].Message; m != nil {
+			return m.CreateTime
+		}
+		return 0
+	}
+	for i := 1; i < len(ids); i++ {
+		for j := i; j > 0 && (created(ids[j]) < created(ids[j-1]) ||
+			(created(ids[j]) == created(ids[j-1]) && ids[j] < ids[j-1])); j-- {
+			ids[j], ids[j-1] = ids[j-1], ids[j]
+		}
+	}
+}
+
+// unixTime converts fractional Unix seconds; zero stays the zero time.
+func unixTime(secs float64) time.Time {
+	if secs <= 0 {
+		return time.Time{}
+	}
+	whole, frac := math.Modf(secs)
+	return time.Unix(int64(whole), int64(frac*1e9)).UTC()
+}
+
+// parseNative reads one of our own JSON export documents.
+func parseNative(index int, raw json.RawMessage) Conversation {
+	var doc export.Document
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return failed(index, "", fmt.Errorf("invalid document: %w", err))
+	}
+	if doc.Kind != export.DocumentKind {
+		return failed(index, "", fmt.Errorf("unknown document kind %q", doc.Kind))
+	}
+	if doc.Version > export.DocumentVersion {
+		return failed(index, "", fmt.Errorf("unsupported document version %d", doc.Version))
+	}
+	if doc.Session == nil {
+		return failed(index, "", fmt.Errorf("document has no session"))
+	}
+	sess := doc.Session
+	sess.ID, sess.UserID = "", ""
+	return done(index, sess, finish(sess))
+}
@@ -0,0 +1,202 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"csdeepseek/backend/models/chat"
+	"csdeepseek/backend/services/session"
+)
+
+// Input formats Parse recognizes.
+const (
+	// FormatOpenAI is an OpenAI chat-completions message array, alone, in a
+	// {"messages": [...]} request body, or as an array of such arrays
+	FormatOpenAI = "openai"
+	// FormatChatGPT is the conversations.json of a ChatGPT data export, or
+	// the export zip holding it
+	FormatChatGPT = "chatgpt"
+	// FormatNative is our own JSON export, one document or an array of them
+	FormatNative = "csdeepseek"
+)
+
+// maxZipEntrySize caps conversations.json when read from a zip.
+const maxZipEntrySize = 256 << 20
+
+var ErrUnknownFormat = errors.New("unrecognized import format")
+
+// Conversation is one conversation of an import file. Either Session holds
+// it, ready for session.Service.ImportSession, or Err says why it cannot be
+// imported.
+type Conversation struct {
+	// Index is the conversation's position in the file, from 0
+	Index   int
+	Title   string
+	Session *session.Session
+	Err     error
+}
+
+// Parse reads an import file and returns its format and conversations. It
+// fails only when the file as a whole cannot be read; a conversation that
+// is invalid is reported on its own and the others are still returned.
+func Parse(data []byte) (string, []Conversation, error) {
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		inner, err := readConversationsJSON(data)
+		if err != nil {
+			return "", nil, err
+		}
+		data = inner
+	}
+
+	var raw json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return "", nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	raw = bytes.TrimSpace(raw)
+
+	if raw[0] == '{' {
+		var probe probeObject
+		if err := json.Unmarshal(raw, &probe); err != nil {
+			return "", nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		switch {
+		case probe.Kind != nil:
+			return FormatNative, []Conversation{parseNative(0, raw)}, nil
+		case probe.Mapping != nil:
+			return FormatChatGPT, []Conversation{parseChatGPT(0, raw)}, nil
+		case probe.Messages != nil:
+			return FormatOpenAI, []Conversation{parseOpenAI(0, probe.Messages)}, nil
+		}
+		return "", nil, ErrUnknownFormat
+	}
+	if raw[0] != '[' {
+		return "", nil, ErrUnknownFormat
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return "", nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if len(items) == 0 {
+		return "", nil, fmt.Errorf("%w: empty array", ErrUnknownFormat)
+	}
+	first := bytes.TrimSpace(items[0])
+	if first[0] == '[' {
+		convs := make([]Conversation, len(items))
+		for i, item := range items {
+			convs[i] = parseOpenAI(i, item)
+		}
+		return FormatOpenAI, convs, nil
+	}
+	var probe probeObject
+	if first[0] != '{' || json.Unmarshal(first, &probe) != nil {
+		return "", nil, ErrUnknownFormat
+	}
+	switch {
+	case probe.Role != nil:
+		return FormatOpenAI, []Conversation{parseOpenAI(0, raw)}, nil
+	case probe.Mapping != nil:
+		convs := make([]Conversation, len(items))
+		for i, item := range items {
+			convs[i] = parseChatGPT(i, item)
+		}
+		return FormatChatGPT, convs, nil
+	case probe.Kind != nil:
+		convs := make([]Conversation, len(items))
+		for i, item := range items {
+			convs[i] = parseNative(i, item)
+		}
+		return FormatNative, convs, nil
+	}
+	return "", nil, ErrUnknownFormat
+}
+
+// probeObject holds the keys that tell the formats apart.
+type probeObject struct {
+	Kind     json.RawMessage `json:"kind"`
+	Mapping  json.RawMessage `json:"mapping"`
+	Messages json.RawMessage `json:"messages"`
+	Role     json.RawMessage `json:"role"`
+}
+
+// readConversationsJSON extracts conversations.json from a ChatGPT export
+// zip.
+func readConversationsJSON(data []byte) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid zip: %w", err)
+	}
+	for _, f := range zr.File {
+		if f.Name != "conversations.json" && !strings.HasSuffix(f.Name, "/conversations.json") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", f.Name, err)
+		}
+		defer rc.Close()
+		inner, err := io.ReadAll(io.LimitReader(rc, maxZipEntrySize+1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", f.Name, err)
+		}
+		if len(inner) > maxZipEntrySize {
+			return nil, fmt.Errorf("%s is too large", f.Name)
+		}
+		return inner, nil
+	}
+	return nil, fmt.Errorf("%w: zip has no conversations.json", ErrUnknownFormat)
+}
+
+// finish gives every message a fresh ID, rewriting parent links and the
+// pointers into the tree to match, and checks the messages.
+func finish(sess *session.Session) error {
+	if len(sess.Messages) == 0 {
+		return errors.New("conversation has no messages")
+	}
+	ids := make(map[string]string, len(sess.Messages))
+	for i := range sess.Messages {
+		msg := &sess.Messages[i]
+		fresh := chat.NewMessageID()
+		if msg.ID != "" {
+			ids[msg.ID] = fresh
+		}
+		msg.ID = fresh
+		switch msg.ParentID {
+		case "", chat.NoParent:
+		default:
+			parent, ok := ids[msg.ParentID]
+			if !ok {
+				return fmt.Errorf("message %d comes before its parent", i+1)
+			}
+			msg.ParentID = parent
+		}
+		if msg.Status == "" {
+			msg.Status = chat.StatusComplete
+		}
+		if err := msg.Validate(); err != nil {
+			return fmt.Errorf("invalid message %d: %w", i+1, err)
+		}
+	}
+	sess.ActiveLeaf = ids[sess.ActiveLeaf]
+	sess.SummaryThrough = ids[sess.SummaryThrough]
+	if sess.SummaryThrough == "" {
+		sess.Summary = ""
+	}
+	return nil
+}
+
+func failed(index int, title string, err error) Conversation {
+	return Conversation{Index: index, Title: title, Err: err}
+}
+
+func done(index int, sess *session.Session, err error) Conversation {
+	if err != nil {
+		return failed(index, sess.Title, err)
+	}
+	return Conversation{Index: index, Title: sess.Title, Session: sess}
+}
@@ -0,0 +1,177 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+	"time"
+
+	"csdeepseek/backend/models/chat"
+	"csdeepseek/backend/services/export"
+	"csdeepseek/backend/services/session"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func contents(messages []chat.Message) []string {
+	var out []string
+	for _, m := range messages {
+		out = append(out, m.Content)
+	}
+	return out
+}
+
+func TestParse_OpenAI(t *testing.T) {
+	format, convs, err := Parse([]byte(`[
+		{"role": "developer", "content": "Be brief."},
+		{"role": "user", "content": [{"type": "text", "text": "My printer jams"}, {"type": "image_url", "image_url": {"url": "x"}}]},
+		{"role": "assistant", "content": null, "tool_calls": [{"id": "c1"}]},
+		{"role": "tool", "content": "ok", "tool_call_id": "c1"},
+		{"role": "assistant", "content": "Open the rear tray."}
+	]`))
+	require.NoError(t, err)
+	assert.Equal(t, FormatOpenAI, format)
+	require.Len(t, convs, 1)
+	require.NoError(t, convs[0].Err)
+
+	sess := convs[0].Session
+	assert.Equal(t, []string{"Be brief.", "My printer jams", "Open the rear tray."}, contents(sess.ActivePath()))
+	assert.Equal(t, chat.RoleSystem, sess.Messages[0].Role)
+
+	format, convs, err = Parse([]byte(`[
+		[{"role": "user", "content": "Hello"}],
+		[{"role": "wizard", "content": "Hello"}],
+		[]
+	]`))
+	require.NoError(t, err)
+	assert.Equal(t, FormatOpenAI, format)
+	require.Len(t, convs, 3)
+	assert.NoError(t, convs[0].Err)
+	assert.ErrorContains(t, convs[1].Err, "unknown role")
+	assert.ErrorContains(t, convs[2].Err, "no messages")
+	assert.Equal(t, 2, convs[2].Index)
+
+	_, convs, err = Parse([]byte(`{"model": "gpt-4o", "messages": [{"role": "user", "content": "Hi"}]}`))
+	require.NoError(t, err)
+	require.NoError(t, convs[0].Err)
+	assert.Equal(t, "Hi", convs[0].Session.Messages[0].Content)
+}
+
+// chatGPTExport is a conversation where the first question was edited and
+// the edited version is current.
+const chatGPTExport = `[{
+	"title": "Printer trouble",
+	"create_time": 1767225600.5,
+	"update_time": 1767229200.0,
+	"current_node": "a2",
+	"mapping": {
+		"root": {"id": "root", "message": null, "parent": null, "children": ["sys"]},
+		"sys": {"id": "sys", "parent": "root", "children": ["u1", "u2"],
+			"message": {"author": {"role": "system"}, "content": {"content_type": "text", "parts": [""]}}},
+		"u1": {"id": "u1", "parent": "sys", "children": ["a1"],
+			"message": {"author": {"role": "user"}, "create_time": 1767225601, "content": {"content_type": "text", "parts": ["My printer jams"]}}},
+		"a1": {"id": "a1", "parent": "u1", "children": [],
+			"message": {"author": {"role": "assistant"}, "create_time": 1767225602, "content": {"content_type": "text", "parts": ["Open the tray."]}, "metadata": {"model_slug": "gpt-4o"}}},
+		"u2": {"id": "u2", "parent": "sys", "children": ["t2"],
+			"message": {"author": {"role": "user"}, "create_time": 1767225700, "content": {"content_type": "text", "parts": ["My HP printer jams"]}}},
+		"t2": {"id": "t2", "parent": "u2", "children": ["a2"],
+			"message": {"author": {"role": "tool"}, "content": {"content_type": "text", "parts": ["search results"]}}},
+		"a2": {"id": "a2", "parent": "t2", "children": [],
+			"message": {"author": {"role": "assistant"}, "create_time": 1767225702, "content": {"content_type": "text", "parts": ["Open the rear tray."]}, "metadata": {"model_slug": "gpt-4o"}}}
+	}
+}, {
+	"title": "Broken",
+	"mapping": {"root": {"id": "root", "message": null, "children": []}}
+}]`
+
+func TestParse_ChatGPT(t *testing.T) {
+	format, convs, err := Parse([]byte(chatGPTExport))
+	require.NoError(t, err)
+	assert.Equal(t, FormatChatGPT, format)
+	require.Len(t, convs, 2)
+	require.NoError(t, convs[0].Err)
+
+	sess := convs[0].Session
+	assert.Equal(t, "Printer trouble", sess.Title)
+	assert.Equal(t, time.Date(2026, 1, 1, 0, 0, 0, 5e8, time.UTC), sess.CreatedAt)
+	require.Len(t, sess.Messages, 4, "empty, hidden and tool messages are left out")
+
+	path := sess.ActivePath()
+	assert.Equal(t, []string{"My HP printer jams", "Open the rear tray."}, contents(path))
+	assert.Equal(t, "gpt-4o", path[1].Model)
+	assert.Equal(t, time.Unix(1767225700, 0).UTC(), path[0].CreatedAt)
+	assert.Len(t, sess.Versions(path[0].ID), 2, "the edit is kept as a branch")
+
+	assert.Equal(t, "Broken", convs[1].Title)
+	assert.ErrorContains(t, convs[1].Err, "no messages")
+
+	// The export zip holds the same file
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	fw, err := zw.Create("conversations.json")
+	require.NoError(t, err)
+	_, err = fw.Write([]byte(chatGPTExport))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	format, convs, err = Parse(buf.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, FormatChatGPT, format)
+	assert.Len(t, convs, 2)
+}
+
+func TestParse_Native(t *testing.T) {
+	at := time.Date(2026, 3, 1, 9, 30, 0, 0, time.UTC)
+	original := &session.Session{
+		ID:        "sess_1",
+		UserID:    "alice",
+		Title:     "Printer",
+		CreatedAt: at,
+		UpdatedAt: at,
+		Messages: []chat.Message{
+			{ID: "m1", Role: chat.RoleUser, Content: "My printer jams", CreatedAt: at},
+			{ID: "m2", Role: chat.RoleAssistant, Content: "an older answer", CreatedAt: at},
+			{ID: "m3", ParentID: "m1", Role: chat.RoleAssistant, Content: "Open the tray.", CreatedAt: at},
+		},
+		ActiveLeaf:     "m2",
+		Summary:        "The printer jams.",
+		SummaryThrough: "m2",
+	}
+	var buf bytes.Buffer
+	require.NoError(t, export.Write(&buf, original, export.FormatJSON))
+
+	format, convs, err := Parse(buf.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, FormatNative, format)
+	require.Len(t, convs, 1)
+	require.NoError(t, convs[0].Err)
+
+	sess := convs[0].Session
+	assert.Empty(t, sess.ID)
+	assert.Empty(t, sess.UserID)
+	assert.Equal(t, at, sess.CreatedAt)
+	assert.NotEqual(t, "m1", sess.Messages[0].ID, "message IDs are fresh")
+	assert.Equal(t, sess.Messages[0].ID, sess.Messages[2].ParentID)
+	assert.Equal(t, []string{"My printer jams", "an older answer"}, contents(sess.ActivePath()))
+	assert.Equal(t, sess.Messages[1].ID, sess.SummaryThrough)
+	assert.Equal(t, "The printer jams.", sess.Summary)
+
+	_, convs, err = Parse([]byte(`[
+		{"kind": "csdeepseek.session", "version": 99, "session": {}},
+		{"kind": "csdeepseek.session", "version": 1, "session": {"messages": [{"id": "a", "parent_id": "b", "role": "user", "content": "x"}]}}
+	]`))
+	require.NoError(t, err)
+	require.Len(t, convs, 2)
+	assert.ErrorContains(t, convs[0].Err, "version")
+	assert.ErrorContains(t, convs[1].Err, "before its parent")
+}
+
+func TestParse_Unrecognized(t *testing.T) {
+	for _, data := range []string{`not json`, `{"foo": 1}`, `[]`, `[1, 2]`, `"text"`} {
+		_, _, err := Parse([]byte(data))
+		assert.Error(t, err, data)
+	}
+	_, _, err := Parse([]byte(`{"foo": 1}`))
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+}
@@ -8,6 +8,8 @@ import (
 	"net/http"
 	"os"
 	"time"
+
+	"csdeepseek/backend/models/chat"
 )
 
 type Service struct {
@@ -16,29 +18,107 @@ type Service struct {
 	httpClient *http.Client
 }
 
+// defaultModel is the model requests are sent to unless Options name
+// another
+const defaultModel = "deepseek-chat"
+
+// Options tune how a reply is generated. Zero values leave the provider's
+// defaults in place.
+type Options struct {
+	Model       string
+	Temperature *float64
+	TopP        *float64
+	MaxTokens   int
+}
+
 type CompletionRequest struct {
-	Model    string    `json:"model"`
-	Messages []Message `json:"messages"`
+	Model         string          `json:"model"`
+	Messages      []Message       `json:"messages"`
+	Temperature   *float64        `json:"temperature,omitempty"`
+	TopP          *float64        `json:"top_p,omitempty"`
+	MaxTokens     int             `json:"max_tokens,omitempty"`
+	Stream        bool            `json:"stream,omitempty"`
+	StreamOptions map[string]bool `json:"stream_options,omitempty"`
+}
+
+// newRequest builds the request for a completion of messages with opts.
+func newRequest(messages []Message, opts Options) CompletionRequest {
+	req := CompletionRequest{
+		Model:       opts.Model,
+		Messages:    messages,
+		Temperature: opts.Temperature,
+		TopP:        opts.TopP,
+		MaxTokens:   opts.MaxTokens,
+	}
+	if req.Model == "" {
+		req.Model = defaultModel
+	}
+	return req
 }
 
+// Message is the provider wire format of a chat message. The rest of the
+// backend works with chat.Message and converts at this boundary.
 type Message struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
 }
 
+// Usage is the token accounting the provider reports for a completion
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
 type CompletionResponse struct {
+	Model   string `json:"model"`
 	Choices []struct {
 		Message Message `json:"message"`
 	} `json:"choices"`
+	Usage *Usage `json:"usage"`
+}
+
+// Completion is a generated reply with what is known about how it was made
+type Completion struct {
+	Content string
+	Model   string
+	Usage   Usage
+	Latency time.Duration
 }
 
 type LlmStreamToken struct {
 	Type    string // "token", "done", "error"
 	Content string
+	// Model and Usage are set on "done" when the provider reports them
+	Model string
+	Usage *Usage
+}
+
+// WireMessages converts domain messages to the provider wire format. Replies
+// that did not complete are left out, since the model never said them in
+// full.
+func WireMessages(messages []chat.Message) []Message {
+	wire := make([]Message, 0, len(messages))
+	for _, m := range messages {
+		if !m.Complete() {
+			continue
+		}
+		wire = append(wire, Message{Role: m.Role, Content: m.Content})
+	}
+	return wire
+}
+
+// Message converts a completion to a domain assistant message
+func (c *Completion) Message() chat.Message {
+	msg := chat.NewMessage(chat.RoleAssistant, c.Content)
+	msg.Model = c.Model
+	msg.LatencyMillis = c.Latency.Milliseconds()
+	msg.PromptTokens = c.Usage.PromptTokens
+	msg.CompletionTokens = c.Usage.CompletionTokens
+	return msg
 }
 
 type LLMStreamer interface {
-	StreamResponse(ctx context.Context, messages []Message) (<-chan LlmStreamToken, error)
+	StreamResponse(ctx context.Context, messages []Message, opts Options) (<-chan LlmStreamToken, error)
 }
 
 func NewService() *Service {
@@ -51,24 +131,33 @@ func NewService() *Service {
 	}
 }
 
+// GenerateResponse returns the model's reply to messages
 func (s *Service) GenerateResponse(ctx context.Context, messages []Message) (string, error) {
-	if s.apiKey == "" {
-		return "", fmt.Errorf("API key not configured")
+	completion, err := s.Complete(ctx, messages, Options{})
+	if err != nil {
+		return "", err
 	}
+	return completion.Content, nil
+}
 
-	req := CompletionRequest{
-		Model:    "deepseek-chat",
-		Messages: messages,
+// Complete returns the model's reply to messages, generated with opts, along
+// with the model name, token usage and latency
+func (s *Service) Complete(ctx context.Context, messages []Message, opts Options) (*Completion, error) {
+	if s.apiKey == "" {
+		return nil, fmt.Errorf("API key not configured")
 	}
 
+	start := time.Now()
+	req := newRequest(messages, opts)
+
 	reqBody, err := json.Marshal(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
 	httpReq, err := http.NewRequestWithContext(ctx, "POST", s.apiURL, bytes.NewBuffer(reqBody))
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	httpReq.Header.Set("Content-Type", "application/json")
@@ -76,28 +165,36 @@ func (s *Service) GenerateResponse(ctx context.Context, messages []Message) (str
 
 	resp, err := s.httpClient.Do(httpReq)
 	if err != nil {
-		return "", fmt.Errorf("failed to send request: %w", err)
+		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API request failed with status %d", resp.StatusCode)
+		return nil, fmt.Errorf("API request failed with status %d", resp.StatusCode)
 	}
 
 	var completionResp CompletionResponse
 	if err := json.NewDecoder(resp.Body).Decode(&completionResp); err != nil {
-		return "", fmt.Errorf("failed to decode response: %w", err)
+		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 
 	if len(completionResp.Choices) == 0 {
-		return "", fmt.Errorf("no response from model")
+		return nil, fmt.Errorf("no response from model")
 	}
 
-	return completionResp.Choices[0].Message.Content, nil
+	completion := &Completion{
+		Content: completionResp.Choices[0].Message.Content,
+		Model:   completionResp.Model,
+		Latency: time.Since(start),
+	}
+	if completionResp.Usage != nil {
+		completion.Usage = *completionResp.Usage
+	}
+	return completion, nil
 }
 
 // StreamResponse streams tokens from DeepSeek API
-func (s *Service) StreamResponse(ctx context.Context, messages []Message) (<-chan LlmStreamToken, error) {
+func (s *Service) StreamResponse(ctx context.Context, messages []Message, opts Options) (<-chan LlmStreamToken, error) {
 	ch := make(chan LlmStreamToken)
 
 	go func() {
@@ -107,12 +204,10 @@ func (s *Service) StreamResponse(ctx context.Context, messages []Message) (<-cha
 			return
 		}
 
-		reqBodyMap := map[string]interface{}{
-			"model":    "deepseek-chat",
-			"messages": messages,
-			"stream":   true,
-		}
-		reqBody, err := json.Marshal(reqBodyMap)
+		req := newRequest(messages, opts)
+		req.Stream = true
+		req.StreamOptions = map[string]bool{"include_usage": true}
+		reqBody, err := json.Marshal(req)
 		if err != nil {
 			ch <- LlmStreamToken{Type: "error", Content: "failed to marshal request"}
 			return
@@ -141,6 +236,8 @@ func (s *Service) StreamResponse(ctx context.Context, messages []Message) (<-cha
 		// Read the response line by line (SSE or chunked JSON)
 		buf := make([]byte, 4096)
 		var partial string
+		var model string
+		var usage *Usage
 		for {
 			n, err := resp.Body.Read(buf)
 			if n > 0 {
@@ -160,17 +257,25 @@ func (s *Service) StreamResponse(ctx context.Context, messages []Message) (<-cha
 						continue
 					}
 					if line == "[DONE]" {
-						ch <- LlmStreamToken{Type: "done", Content: ""}
+						ch <- LlmStreamToken{Type: "done", Content: "", Model: model, Usage: usage}
 						return
 					}
 					var sse struct {
+						Model   string `json:"model"`
 						Choices []struct {
 							Delta struct {
 								Content string `json:"content"`
 							} `json:"delta"`
 						} `json:"choices"`
+						Usage *Usage `json:"usage"`
 					}
 					if err := json.Unmarshal([]byte(line), &sse); err == nil {
+						if sse.Model != "" {
+							model = sse.Model
+						}
+						if sse.Usage != nil {
+							usage = sse.Usage
+						}
 						for _, choice := range sse.Choices {
 							if choice.Delta.Content != "" {
 								ch <- LlmStreamToken{Type: "token", Content: choice.Delta.Content}
@@ -183,7 +288,7 @@ func (s *Service) StreamResponse(ctx context.Context, messages []Message) (<-cha
 				break
 			}
 		}
-		ch <- LlmStreamToken{Type: "done", Content: ""}
+		ch <- LlmStreamToken{Type: "done", Content: "", Model: model, Usage: usage}
 	}()
 
 	return ch, nil
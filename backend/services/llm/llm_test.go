@@ -0,0 +1,118 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"csdeepseek/backend/models/chat"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWireMessages(t *testing.T) {
+	partial := chat.NewMessage(chat.RoleAssistant, "Try resett")
+	partial.Status = chat.StatusPartial
+	failed := chat.NewMessage(chat.RoleAssistant, "")
+	failed.Status = chat.StatusFailed
+
+	wire := WireMessages([]chat.Message{
+		chat.NewMessage(chat.RoleSystem, "Be brief."),
+		chat.NewMessage(chat.RoleUser, "My router blinks red"),
+		partial,
+		failed,
+		{Role: chat.RoleAssistant, Content: "stored before statuses existed"},
+		chat.NewMessage(chat.RoleUser, "Still blinking"),
+	})
+	assert.Equal(t, []Message{
+		{Role: "system", Content: "Be brief."},
+		{Role: "user", Content: "My router blinks red"},
+		{Role: "assistant", Content: "stored before statuses existed"},
+		{Role: "user", Content: "Still blinking"},
+	}, wire)
+}
+
+func TestComplete_ReportsModelAndUsage(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req CompletionRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, defaultModel, req.Model)
+		assert.Nil(t, req.Temperature, "unset options are left to the provider")
+		fmt.Fprint(w, `{"model":"deepseek-chat-v3","choices":[{"message":{"role":"assistant","content":"Hello"}}],"usage":{"prompt_tokens":12,"completion_tokens":3}}`)
+	}))
+	defer ts.Close()
+	s := &Service{apiKey: "test", apiURL: ts.URL, httpClient: ts.Client()}
+
+	completion, err := s.Complete(context.Background(), []Message{{Role: "user", Content: "Hi"}}, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, "Hello", completion.Content)
+	assert.Equal(t, "deepseek-chat-v3", completion.Model)
+	assert.Equal(t, Usage{PromptTokens: 12, CompletionTokens: 3}, completion.Usage)
+
+	completion.Latency = 1500 * time.Millisecond
+	msg := completion.Message()
+	assert.Equal(t, chat.RoleAssistant, msg.Role)
+	assert.Equal(t, chat.StatusComplete, msg.Status)
+	assert.NotEmpty(t, msg.ID)
+	assert.Equal(t, int64(1500), msg.LatencyMillis)
+	assert.Equal(t, 12, msg.PromptTokens)
+	assert.Equal(t, 3, msg.CompletionTokens)
+}
+
+func TestStreamResponse_ReportsUsageOnDone(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, map[string]interface{}{"include_usage": true}, req["stream_options"])
+		fmt.Fprint(w, "data: {\"model\":\"deepseek-chat\",\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"model\":\"deepseek-chat\",\"choices\":[{\"delta\":{\"content\":\"lo\"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"model\":\"deepseek-chat\",\"choices\":[],\"usage\":{\"prompt_tokens\":7,\"completion_tokens\":2}}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer ts.Close()
+	s := &Service{apiKey: "test", apiURL: ts.URL, httpClient: ts.Client()}
+
+	stream, err := s.StreamResponse(context.Background(), []Message{{Role: "user", Content: "Hi"}}, Options{})
+	require.NoError(t, err)
+	var content string
+	var done LlmStreamToken
+	for token := range stream {
+		switch token.Type {
+		case "token":
+			content += token.Content
+		case "done":
+			done = token
+		}
+	}
+	assert.Equal(t, "Hello", content)
+	assert.Equal(t, "deepseek-chat", done.Model)
+	require.NotNil(t, done.Usage)
+	assert.Equal(t, Usage{PromptTokens: 7, CompletionTokens: 2}, *done.Usage)
+}
+
+func TestStreamResponse_SendsOptions(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "deepseek-reasoner", req["model"])
+		assert.Equal(t, 0.2, req["temperature"])
+		assert.Equal(t, float64(256), req["max_tokens"])
+		assert.NotContains(t, req, "top_p")
+		assert.Equal(t, true, req["stream"])
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer ts.Close()
+	s := &Service{apiKey: "test", apiURL: ts.URL, httpClient: ts.Client()}
+
+	temperature := 0.2
+	stream, err := s.StreamResponse(context.Background(), []Message{{Role: "user", Content: "Hi"}}, Options{Model: "deepseek-reasoner", Temperature: &temperature, MaxTokens: 256})
+	require.NoError(t, err)
+	for token := range stream {
+		assert.Equal(t, "done", token.Type)
+	}
+}
@@ -0,0 +1,139 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"csdeepseek/backend/services/llm"
+)
+
+const extractInstructions = "You maintain long-term memory about a customer for a support assistant. " +
+	"From the conversation, extract durable facts and preferences about the user that will help in " +
+	"future conversations: devices and products they own with model numbers, problems they had and " +
+	"how they were resolved, account details they mention, and how they like to be helped. " +
+	"Ignore small talk, questions, and anything only relevant to this conversation. " +
+	"Do not repeat known memories unless they changed. Write each memory as a short third-person " +
+	`sentence. Reply with only a JSON array of objects {"text": "...", "kind": "fact" or "preference"}, ` +
+	"or [] if there is nothing new."
+
+type extracted struct {
+	Text string `json:"text"`
+	Kind string `json:"kind"`
+}
+
+// Extract asks the LLM for new memories in messages and stores them for
+// the user. A memory that closely matches an existing one replaces it, so
+// updated facts ("now uses the X200") do not pile up next to stale ones. It
+// returns the memories that were stored.
+func (s *Service) Extract(ctx context.Context, userID, sessionID string, messages []llm.Message) ([]Memory, error) {
+	if err := ValidateUserID(userID); err != nil {
+		return nil, err
+	}
+
+	known, err := s.List(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var prompt strings.Builder
+	if len(known) > 0 {
+		prompt.WriteString("Known memories:\n")
+		for _, m := range known {
+			fmt.Fprintf(&prompt, "- %s\n", m.Text)
+		}
+		prompt.WriteString("\n")
+	}
+	prompt.WriteString("Conversation:\n")
+	for _, m := range messages {
+		if m.Role == "system" {
+			continue
+		}
+		fmt.Fprintf(&prompt, "%s: %s\n", m.Role, m.Content)
+	}
+
+	reply, err := s.completer.GenerateResponse(ctx, []llm.Message{
+		{Role: "system", Content: extractInstructions},
+		{Role: "user", Content: prompt.String()},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract memories: %w", err)
+	}
+	candidates, err := parseExtracted(reply)
+	if err != nil {
+		return nil, err
+	}
+
+	var stored []Memory
+	for _, c := range candidates {
+		m := Memory{UserID: userID, Text: c.Text, Kind: c.Kind, SessionID: sessionID}
+		if len(known) > 0 {
+			if id, ok := s.findDuplicate(ctx, userID, c.Text); ok {
+				m.ID = id
+			}
+		}
+		m, err := s.Add(ctx, m)
+		if err != nil {
+			return stored, err
+		}
+		stored = append(stored, m)
+	}
+
+	if err := s.prune(ctx, userID); err != nil {
+		return stored, err
+	}
+	return stored, nil
+}
+
+// findDuplicate returns the ID of an existing memory whose embedding is
+// nearly identical to text.
+func (s *Service) findDuplicate(ctx context.Context, userID, text string) (string, bool) {
+	embedding, err := s.vectorService.GetEmbedding(ctx, text)
+	if err != nil {
+		return "", false
+	}
+	results := s.vectorService.Store().Search(namespace(userID), embedding, 1, nil)
+	if len(results) == 0 || results[0].Score < duplicateThreshold {
+		return "", false
+	}
+	return results[0].ID, true
+}
+
+// prune drops the oldest memories beyond the per-user limit.
+func (s *Service) prune(ctx context.Context, userID string) error {
+	memories, err := s.List(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, m := range memories[min(len(memories), s.maxPerUser):] {
+		if err := s.vectorService.Delete(ctx, namespace(userID), m.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseExtracted reads the JSON array of memories from a model reply,
+// tolerating surrounding prose or code fences.
+func parseExtracted(reply string) ([]extracted, error) {
+	start := strings.IndexByte(reply, '[')
+	end := strings.LastIndexByte(reply, ']')
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("memory reply contains no array")
+	}
+
+	var items []extracted
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &items); err != nil {
+		return nil, fmt.Errorf("failed to parse memories: %w", err)
+	}
+
+	valid := items[:0]
+	for _, item := range items {
+		item.Text = strings.TrimSpace(item.Text)
+		if item.Text != "" {
+			valid = append(valid, item)
+		}
+	}
+	return valid, nil
+}
@@ -0,0 +1,229 @@
+package memory
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"csdeepseek/backend/services/llm"
+	"csdeepseek/backend/services/vector"
+)
+
+const (
+	KindFact       = "fact"
+	KindPreference = "preference"
+
+	// namespacePrefix keeps memories apart from knowledge base collections,
+	// whose names cannot contain ':'.
+	namespacePrefix = "memory:"
+	// duplicateThreshold is the embedding similarity above which a newly
+	// extracted memory replaces an existing one instead of being added.
+	duplicateThreshold = 0.9
+)
+
+var ErrNotFound = errors.New("memory not found")
+
+// Completer generates a chat completion. *llm.Service satisfies it.
+type Completer interface {
+	GenerateResponse(ctx context.Context, messages []llm.Message) (string, error)
+}
+
+// Memory is a durable fact or preference about a user, carried across
+// sessions.
+type Memory struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	Text      string    `json:"text"`
+	Kind      string    `json:"kind"`
+	SessionID string    `json:"session_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Service extracts memories from conversations with the LLM and stores them
+// per user in the vector service, so they can be recalled by similarity.
+type Service struct {
+	vectorService *vector.Service
+	completer     Completer
+	enabled       bool
+	topK          int
+	maxPerUser    int
+}
+
+// NewService creates a memory service configured from MEMORY_ENABLED,
+// MEMORY_TOP_K and MEMORY_MAX_PER_USER.
+func NewService(completer Completer, vectorService *vector.Service) *Service {
+	s := &Service{
+		vectorService: vectorService,
+		completer:     completer,
+		enabled:       true,
+		topK:          5,
+		maxPerUser:    200,
+	}
+	if v, err := strconv.ParseBool(os.Getenv("MEMORY_ENABLED")); err == nil {
+		s.enabled = v
+	}
+	if n, err := strconv.Atoi(os.Getenv("MEMORY_TOP_K")); err == nil && n > 0 {
+		s.topK = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("MEMORY_MAX_PER_USER")); err == nil && n > 0 {
+		s.maxPerUser = n
+	}
+	return s
+}
+
+// Enabled reports whether memories are recalled and extracted in chat.
+func (s *Service) Enabled() bool {
+	return s.enabled
+}
+
+// ValidateUserID checks that a user ID can be used to store memories.
+func ValidateUserID(userID string) error {
+	if userID == "" || len(userID) > 128 {
+		return fmt.Errorf("user ID must be 1-128 characters")
+	}
+	if strings.ContainsRune(userID, 0) {
+		return fmt.Errorf("user ID contains invalid characters")
+	}
+	return nil
+}
+
+func namespace(userID string) string {
+	return namespacePrefix + userID
+}
+
+// Add stores a memory for a user. An empty ID is generated.
+func (s *Service) Add(ctx context.Context, m Memory) (Memory, error) {
+	if err := ValidateUserID(m.UserID); err != nil {
+		return Memory{}, err
+	}
+	m.Text = strings.TrimSpace(m.Text)
+	if m.Text == "" {
+		return Memory{}, fmt.Errorf("memory text cannot be empty")
+	}
+	if m.Kind != KindPreference {
+		m.Kind = KindFact
+	}
+	if m.ID == "" {
+		m.ID = generateID()
+	}
+	if m.CreatedAt.IsZero() {
+		m.CreatedAt = time.Now()
+	}
+
+	metadata := map[string]string{
+		"kind":       m.Kind,
+		"created_at": m.CreatedAt.UTC().Format(time.RFC3339Nano),
+	}
+	if m.SessionID != "" {
+		metadata["session_id"] = m.SessionID
+	}
+	if err := s.vectorService.Upsert(ctx, namespace(m.UserID), m.ID, m.Text, metadata); err != nil {
+		return Memory{}, fmt.Errorf("failed to store memory: %w", err)
+	}
+	return m, nil
+}
+
+// List returns a user's memories, newest first.
+func (s *Service) List(ctx context.Context, userID string) ([]Memory, error) {
+	if err := ValidateUserID(userID); err != nil {
+		return nil, err
+	}
+	records := s.vectorService.Store().List(namespace(userID))
+	memories := make([]Memory, len(records))
+	for i, rec := range records {
+		memories[i] = fromRecord(userID, rec.ID, rec.Text, rec.Metadata)
+	}
+	sort.Slice(memories, func(i, j int) bool {
+		if !memories[i].CreatedAt.Equal(memories[j].CreatedAt) {
+			return memories[i].CreatedAt.After(memories[j].CreatedAt)
+		}
+		return memories[i].ID < memories[j].ID
+	})
+	return memories, nil
+}
+
+// Delete removes one memory.
+func (s *Service) Delete(ctx context.Context, userID, id string) error {
+	if err := ValidateUserID(userID); err != nil {
+		return err
+	}
+	if _, ok := s.vectorService.Store().Get(namespace(userID), id); !ok {
+		return ErrNotFound
+	}
+	return s.vectorService.Delete(ctx, namespace(userID), id)
+}
+
+// DeleteAll removes every memory of a user and returns how many there were.
+func (s *Service) DeleteAll(ctx context.Context, userID string) (int, error) {
+	memories, err := s.List(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	for _, m := range memories {
+		if err := s.vectorService.Delete(ctx, namespace(userID), m.ID); err != nil {
+			return 0, err
+		}
+	}
+	return len(memories), nil
+}
+
+// Recall returns the user's memories most relevant to query.
+func (s *Service) Recall(ctx context.Context, userID, query string) ([]Memory, error) {
+	if err := ValidateUserID(userID); err != nil {
+		return nil, err
+	}
+	results, err := s.vectorService.Search(ctx, vector.SearchRequest{
+		Namespace: namespace(userID),
+		Query:     query,
+		K:         s.topK,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to recall memories: %w", err)
+	}
+
+	memories := make([]Memory, len(results))
+	for i, r := range results {
+		memories[i] = fromRecord(userID, r.ID, r.Text, r.Metadata)
+	}
+	return memories, nil
+}
+
+// SystemPrompt renders memories as a system message for a new session.
+func SystemPrompt(memories []Memory) string {
+	var b strings.Builder
+	b.WriteString("You are talking to a returning user. From earlier conversations you remember:\n")
+	for _, m := range memories {
+		fmt.Fprintf(&b, "- %s\n", m.Text)
+	}
+	b.WriteString("Use this when it helps, but do not recite it unprompted. It may be outdated; " +
+		"trust what the user says now.")
+	return b.String()
+}
+
+func fromRecord(userID, id, text string, metadata map[string]string) Memory {
+	m := Memory{
+		ID:        id,
+		UserID:    userID,
+		Text:      text,
+		Kind:      metadata["kind"],
+		SessionID: metadata["session_id"],
+	}
+	if t, err := time.Parse(time.RFC3339Nano, metadata["created_at"]); err == nil {
+		m.CreatedAt = t
+	}
+	return m
+}
+
+// generateID returns a random memory ID.
+func generateID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "mem_" + hex.EncodeToString(b)
+}
@@ -0,0 +1,112 @@
+package memory
+
+import (
+	"context"
+	"testing"
+
+	"csdeepseek/backend/services/llm"
+	"csdeepseek/backend/services/vector"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeCompleter struct {
+	reply    string
+	messages []llm.Message
+}
+
+func (f *fakeCompleter) GenerateResponse(ctx context.Context, messages []llm.Message) (string, error) {
+	f.messages = messages
+	return f.reply, nil
+}
+
+func newTestService(t *testing.T, completer Completer) *Service {
+	t.Setenv("VECTOR_STORE_DIR", "")
+	return NewService(completer, vector.NewServiceWithEmbedder(vector.NewHashEmbedder(256)))
+}
+
+func TestExtract_StoresAndDeduplicates(t *testing.T) {
+	completer := &fakeCompleter{reply: "Sure:\n" + `[{"text": "The user owns a Pixel 8 phone.", "kind": "fact"},
+		{"text": "Prefers step-by-step instructions.", "kind": "preference"}, {"text": " "}]`}
+	s := newTestService(t, completer)
+	ctx := context.Background()
+	conversation := []llm.Message{
+		{Role: "system", Content: "internal context"},
+		{Role: "user", Content: "My Pixel 8 will not charge. Please go step by step."},
+		{Role: "assistant", Content: "Let's check the cable first."},
+	}
+
+	stored, err := s.Extract(ctx, "alice", "sess_1", conversation)
+	require.NoError(t, err)
+	require.Len(t, stored, 2)
+	assert.Equal(t, KindPreference, stored[1].Kind)
+	assert.Equal(t, "sess_1", stored[0].SessionID)
+	assert.NotContains(t, completer.messages[1].Content, "internal context")
+
+	// Extracting the same facts again replaces them instead of adding copies
+	stored, err = s.Extract(ctx, "alice", "sess_2", conversation)
+	require.NoError(t, err)
+	require.Len(t, stored, 2)
+	assert.Contains(t, completer.messages[1].Content, "Known memories:\n")
+	memories, err := s.List(ctx, "alice")
+	require.NoError(t, err)
+	assert.Len(t, memories, 2)
+
+	// Memories are per user
+	other, err := s.List(ctx, "bob")
+	require.NoError(t, err)
+	assert.Empty(t, other)
+
+	_, err = s.Extract(ctx, "", "sess_3", conversation)
+	assert.Error(t, err)
+}
+
+func TestRecallAndDelete(t *testing.T) {
+	s := newTestService(t, &fakeCompleter{})
+	ctx := context.Background()
+
+	device, err := s.Add(ctx, Memory{UserID: "alice", Text: "Owns a Galaxy S23 phone."})
+	require.NoError(t, err)
+	_, err = s.Add(ctx, Memory{UserID: "alice", Text: "Had a billing issue in March that was refunded."})
+	require.NoError(t, err)
+
+	recalled, err := s.Recall(ctx, "alice", "my galaxy phone is slow")
+	require.NoError(t, err)
+	require.NotEmpty(t, recalled)
+	assert.Equal(t, device.ID, recalled[0].ID)
+	assert.Equal(t, KindFact, recalled[0].Kind)
+	assert.Contains(t, SystemPrompt(recalled), "- Owns a Galaxy S23 phone.\n")
+
+	require.NoError(t, s.Delete(ctx, "alice", device.ID))
+	assert.ErrorIs(t, s.Delete(ctx, "alice", device.ID), ErrNotFound)
+	assert.ErrorIs(t, s.Delete(ctx, "bob", "mem_x"), ErrNotFound)
+
+	deleted, err := s.DeleteAll(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	recalled, err = s.Recall(ctx, "alice", "galaxy")
+	require.NoError(t, err)
+	assert.Empty(t, recalled)
+}
+
+func TestExtract_PrunesOldestBeyondLimit(t *testing.T) {
+	completer := &fakeCompleter{reply: `[{"text": "Uses the router in the basement office."}]`}
+	s := newTestService(t, completer)
+	s.maxPerUser = 2
+	ctx := context.Background()
+
+	_, err := s.Add(ctx, Memory{UserID: "alice", Text: "Lives in Lisbon."})
+	require.NoError(t, err)
+	_, err = s.Add(ctx, Memory{UserID: "alice", Text: "Has two cats."})
+	require.NoError(t, err)
+
+	_, err = s.Extract(ctx, "alice", "sess_1", []llm.Message{{Role: "user", Content: "The router is in my basement office."}})
+	require.NoError(t, err)
+
+	memories, err := s.List(ctx, "alice")
+	require.NoError(t, err)
+	require.Len(t, memories, 2)
+	assert.Equal(t, "Uses the router in the basement office.", memories[0].Text)
+	assert.NotContains(t, []string{memories[0].Text, memories[1].Text}, "Lives in Lisbon.")
+}
@@ -0,0 +1,214 @@
+package profile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+
+	"csdeepseek/backend/services/llm"
+)
+
+// Tools a profile can give the assistant.
+const (
+	// ToolMemory recalls what is remembered about a returning user and
+	// remembers more after each turn
+	ToolMemory = "memory"
+	// ToolKnowledge answers from the passages of a knowledge base
+	// collection
+	ToolKnowledge = "knowledge"
+)
+
+var allTools = []string{ToolMemory, ToolKnowledge}
+
+// DefaultName is the name of the built-in profile, which leaves the model's
+// persona alone and uses every tool. It is used when no default profile is
+// configured.
+const DefaultName = "default"
+
+var ErrNotFound = errors.New("profile not found")
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Profile is a named assistant configuration a session is created with.
+type Profile struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// SystemPrompt is sent to the model ahead of every conversation
+	SystemPrompt string `json:"system_prompt,omitempty"`
+	// Model and the generation parameters override the provider's
+	// defaults when set
+	Model       string   `json:"model,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	MaxTokens   int      `json:"max_tokens,omitempty"`
+	// Tools lists the tools the assistant uses; omitted in the config
+	// means all of them, an empty list none
+	Tools []string `json:"tools"`
+	// Collection is the knowledge base collection searched by the
+	// knowledge tool; empty means the one set with RAG_COLLECTION
+	Collection string `json:"collection,omitempty"`
+}
+
+// validate checks a configured profile, filling in its tools when they
+// are omitted.
+func (p *Profile) validate() error {
+	if !validName.MatchString(p.Name) {
+		return fmt.Errorf("invalid profile name %q", p.Name)
+	}
+	if p.Temperature != nil && (*p.Temperature < 0 || *p.Temperature > 2) {
+		return fmt.Errorf("profile %s: temperature must be between 0 and 2", p.Name)
+	}
+	if p.TopP != nil && (*p.TopP <= 0 || *p.TopP > 1) {
+		return fmt.Errorf("profile %s: top_p must be above 0 and at most 1", p.Name)
+	}
+	if p.MaxTokens < 0 {
+		return fmt.Errorf("profile %s: max_tokens cannot be negative", p.Name)
+	}
+	if p.Tools == nil {
+		p.Tools = slices.Clone(allTools)
+	}
+	for _, tool := range p.Tools {
+		if !slices.Contains(allTools, tool) {
+			return fmt.Errorf("profile %s: unknown tool %q", p.Name, tool)
+		}
+	}
+	return nil
+}
+
+// Uses reports whether the profile gives the assistant tool.
+func (p *Profile) Uses(tool string) bool {
+	return slices.Contains(p.Tools, tool)
+}
+
+// Options returns the generation options of the profile.
+func (p *Profile) Options() llm.Options {
+	return llm.Options{
+		Model:       p.Model,
+		Temperature: p.Temperature,
+		TopP:        p.TopP,
+		MaxTokens:   p.MaxTokens,
+	}
+}
+
+// Messages returns the conversation to send to the model, starting with the
+// profile's system prompt when it has one. The prompt is not stored with
+// the session, so changes to it apply to existing sessions too.
+func (p *Profile) Messages(messages []llm.Message) []llm.Message {
+	if p.SystemPrompt == "" {
+		return messages
+	}
+	return append([]llm.Message{{Role: "system", Content: p.SystemPrompt}}, messages...)
+}
+
+// Service holds the configured profiles.
+type Service struct {
+	profiles    map[string]*Profile
+	order       []string
+	defaultName string
+}
+
+// NewService loads the profiles from the JSON file named by PROFILES_FILE,
+// with DEFAULT_PROFILE naming the one used for sessions created without
+// choosing one. Without a file, or when it cannot be loaded, only the
+// built-in default profile exists.
+func NewService() *Service {
+	if path := os.Getenv("PROFILES_FILE"); path != "" {
+		s, err := Load(path, os.Getenv("DEFAULT_PROFILE"))
+		if err == nil {
+			log.Printf("Loaded %d assistant profiles from %s", len(s.order), path)
+			return s
+		}
+		log.Printf("Failed to load assistant profiles, using the default: %v", err)
+	}
+	s, _ := New(nil, "")
+	return s
+}
+
+// Load reads a JSON array of profiles from path and calls New with them.
+func Load(path, defaultName string) (*Service, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profiles: %w", err)
+	}
+	var profiles []Profile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("failed to parse profiles: %w", err)
+	}
+	return New(profiles, defaultName)
+}
+
+// New creates a service holding profiles. defaultName names the profile of
+// sessions created without choosing one; when empty, a profile named
+// DefaultName is used, or the built-in one if there is none.
+func New(profiles []Profile, defaultName string) (*Service, error) {
+	s := &Service{profiles: make(map[string]*Profile), defaultName: defaultName}
+	for _, p := range profiles {
+		if err := p.validate(); err != nil {
+			return nil, err
+		}
+		if s.profiles[p.Name] != nil {
+			return nil, fmt.Errorf("duplicate profile %q", p.Name)
+		}
+		s.profiles[p.Name] = &p
+		s.order = append(s.order, p.Name)
+	}
+	if s.defaultName == "" {
+		s.defaultName = DefaultName
+		if s.profiles[DefaultName] == nil {
+			s.profiles[DefaultName] = &Profile{Name: DefaultName, Tools: slices.Clone(allTools)}
+			s.order = append([]string{DefaultName}, s.order...)
+		}
+	}
+	if s.profiles[s.defaultName] == nil {
+		return nil, fmt.Errorf("default profile %q is not configured", s.defaultName)
+	}
+	return s, nil
+}
+
+// Default returns the profile of sessions created without choosing one.
+func (s *Service) Default() *Profile {
+	return s.profiles[s.defaultName]
+}
+
+// Get returns the profile with the given name, or the default profile when
+// name is empty. The profile must not be changed.
+func (s *Service) Get(name string) (*Profile, error) {
+	if name == "" {
+		return s.Default(), nil
+	}
+	p, ok := s.profiles[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return p, nil
+}
+
+// ForSession returns the profile a session was created with. Sessions whose
+// profile has since been removed from the config, and those created before
+// profiles existed, get the default profile.
+func (s *Service) ForSession(name string) *Profile {
+	p, err := s.Get(name)
+	if err != nil {
+		log.Printf("Profile %q is no longer configured, using the default", name)
+		return s.Default()
+	}
+	return p
+}
+
+// List returns the profiles in the order they were configured.
+func (s *Service) List() []Profile {
+	profiles := make([]Profile, 0, len(s.order))
+	for _, name := range s.order {
+		profiles = append(profiles, *s.profiles[name])
+	}
+	return profiles
+}
+
+// DefaultName returns the name of the default profile.
+func (s *Service) DefaultName() string {
+	return s.defaultName
+}
@@ -0,0 +1,113 @@
+package profile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"csdeepseek/backend/services/llm"
+)
+
+func writeProfiles(t *testing.T, data string) string {
+	path := filepath.Join(t.TempDir(), "profiles.json")
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+	return path
+}
+
+func TestNewService(t *testing.T) {
+	t.Setenv("PROFILES_FILE", writeProfiles(t, `[
+		{"name": "support", "system_prompt": "You are a support agent.", "temperature": 0.3, "collection": "manuals"},
+		{"name": "casual", "model": "deepseek-reasoner", "max_tokens": 512, "tools": []}
+	]`))
+	t.Setenv("DEFAULT_PROFILE", "support")
+	s := NewService()
+
+	assert.Equal(t, "support", s.DefaultName())
+	assert.Equal(t, []string{"support", "casual"}, []string{s.List()[0].Name, s.List()[1].Name})
+	support, err := s.Get("")
+	require.NoError(t, err)
+	assert.Equal(t, "support", support.Name)
+	assert.True(t, support.Uses(ToolMemory), "omitted tools mean all of them")
+	assert.True(t, support.Uses(ToolKnowledge))
+
+	casual, err := s.Get("casual")
+	require.NoError(t, err)
+	assert.False(t, casual.Uses(ToolMemory), "an empty list means none")
+	assert.Equal(t, llm.Options{Model: "deepseek-reasoner", MaxTokens: 512}, casual.Options())
+
+	_, err = s.Get("pirate")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, support, s.ForSession("pirate"), "sessions of removed profiles fall back to the default")
+}
+
+func TestNewService_FallsBackToBuiltIn(t *testing.T) {
+	t.Setenv("PROFILES_FILE", writeProfiles(t, `[{"name": "support", "tools": ["shell"]}]`))
+	s := NewService()
+
+	assert.Equal(t, DefaultName, s.DefaultName())
+	require.Len(t, s.List(), 1)
+	p := s.Default()
+	assert.Empty(t, p.SystemPrompt)
+	assert.Equal(t, llm.Options{}, p.Options())
+	assert.True(t, p.Uses(ToolMemory))
+	assert.True(t, p.Uses(ToolKnowledge))
+}
+
+func TestNew(t *testing.T) {
+	hot := 3.0
+	tests := []struct {
+		name        string
+		profiles    []Profile
+		defaultName string
+		wantErr     string
+		wantNames   []string
+	}{
+		{name: "built-in default added", profiles: []Profile{{Name: "support"}}, wantNames: []string{DefaultName, "support"}},
+		{name: "configured default kept", profiles: []Profile{{Name: "support"}, {Name: DefaultName, SystemPrompt: "Be brief."}}, wantNames: []string{"support", DefaultName}},
+		{name: "default named", profiles: []Profile{{Name: "support"}}, defaultName: "support", wantNames: []string{"support"}},
+		{name: "missing default", profiles: []Profile{{Name: "support"}}, defaultName: "sales", wantErr: `default profile "sales" is not configured`},
+		{name: "duplicate", profiles: []Profile{{Name: "support"}, {Name: "support"}}, wantErr: `duplicate profile "support"`},
+		{name: "invalid name", profiles: []Profile{{Name: "tech support"}}, wantErr: "invalid profile name"},
+		{name: "invalid temperature", profiles: []Profile{{Name: "support", Temperature: &hot}}, wantErr: "temperature must be between 0 and 2"},
+		{name: "unknown tool", profiles: []Profile{{Name: "support", Tools: []string{"shell"}}}, wantErr: `unknown tool "shell"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(tt.profiles, tt.defaultName)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			var names []string
+			for _, p := range s.List() {
+				names = append(names, p.Name)
+			}
+			assert.Equal(t, tt.wantNames, names)
+		})
+	}
+}
+
+func TestProfile_Messages(t *testing.T) {
+	history := []llm.Message{{Role: "user", Content: "Hi"}}
+
+	assert.Equal(t, history, (&Profile{}).Messages(history))
+	p := &Profile{SystemPrompt: "You are a support agent."}
+	assert.Equal(t, []llm.Message{
+		{Role: "system", Content: "You are a support agent."},
+		{Role: "user", Content: "Hi"},
+	}, p.Messages(history))
+	assert.Len(t, history, 1, "the history is not changed")
+}
+
+func TestLoad_Example(t *testing.T) {
+	s, err := Load("../../profiles.example.json", "")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultName, s.DefaultName())
+	assert.NotEmpty(t, s.Default().SystemPrompt, "the example's default profile replaces the built-in one")
+	assert.Len(t, s.List(), 3)
+}
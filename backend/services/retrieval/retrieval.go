@@ -0,0 +1,216 @@
+package retrieval
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"csdeepseek/backend/models/chat"
+	"csdeepseek/backend/services/llm"
+	"csdeepseek/backend/services/vector"
+)
+
+// rrfK is the reciprocal rank fusion constant used to merge the results of
+// query variants.
+const rrfK = 60
+
+// Config controls retrieval for chat turns.
+type Config struct {
+	// Collection is searched for every turn; empty disables retrieval.
+	Collection string
+	K          int
+	// Rewrite condenses follow-up questions into standalone queries.
+	Rewrite bool
+	// Variants is how many extra phrasings of the query are searched.
+	Variants int
+	// HistoryMessages is how many earlier messages the rewriter sees.
+	HistoryMessages int
+}
+
+// configFromEnv reads RAG_COLLECTION, RAG_TOP_K, QUERY_REWRITE,
+// QUERY_VARIANTS and QUERY_REWRITE_HISTORY.
+func configFromEnv() Config {
+	cfg := Config{
+		Collection:      os.Getenv("RAG_COLLECTION"),
+		K:               4,
+		Rewrite:         true,
+		HistoryMessages: 6,
+	}
+	if n, err := strconv.Atoi(os.Getenv("RAG_TOP_K")); err == nil && n > 0 {
+		cfg.K = n
+	}
+	if v, err := strconv.ParseBool(os.Getenv("QUERY_REWRITE")); err == nil {
+		cfg.Rewrite = v
+	}
+	if n, err := strconv.Atoi(os.Getenv("QUERY_VARIANTS")); err == nil && n >= 0 {
+		cfg.Variants = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("QUERY_REWRITE_HISTORY")); err == nil && n >= 0 {
+		cfg.HistoryMessages = n
+	}
+	return cfg
+}
+
+// Service retrieves knowledge base passages for a chat turn.
+type Service struct {
+	vectorService *vector.Service
+	rewriter      *Rewriter
+	cfg           Config
+}
+
+// Trace records how the passages of a turn were retrieved, for debugging.
+type Trace struct {
+	Collection     string                `json:"collection"`
+	Query          string                `json:"query"`
+	RewrittenQuery string                `json:"rewritten_query,omitempty"`
+	Variants       []string              `json:"variants,omitempty"`
+	RewriteError   string                `json:"rewrite_error,omitempty"`
+	RewriteMillis  int64                 `json:"rewrite_ms"`
+	SearchMillis   int64                 `json:"search_ms"`
+	Results        []vector.SearchResult `json:"results"`
+}
+
+// NewService creates a retrieval service configured from the environment.
+func NewService(completer Completer, vectorService *vector.Service) *Service {
+	return &Service{
+		vectorService: vectorService,
+		rewriter:      NewRewriter(completer),
+		cfg:           configFromEnv(),
+	}
+}
+
+// SetConfig replaces the retrieval configuration.
+func (s *Service) SetConfig(cfg Config) {
+	s.cfg = cfg
+}
+
+// Enabled reports whether chat turns retrieve passages.
+func (s *Service) Enabled() bool {
+	return s.cfg.Collection != ""
+}
+
+// Collection returns the collection searched for chat turns by default.
+func (s *Service) Collection() string {
+	return s.cfg.Collection
+}
+
+// Retrieve finds the passages relevant to latest, the newest user message,
+// given the messages before it. Follow-ups are first rewritten into a
+// standalone query; if rewriting fails the latest message is searched as is
+// and the failure is recorded in the trace.
+func (s *Service) Retrieve(ctx context.Context, history []llm.Message, latest string) ([]vector.SearchResult, *Trace, error) {
+	return s.RetrieveFrom(ctx, s.cfg.Collection, history, latest)
+}
+
+// RetrieveFrom is Retrieve searching collection instead of the configured
+// one.
+func (s *Service) RetrieveFrom(ctx context.Context, collection string, history []llm.Message, latest string) ([]vector.SearchResult, *Trace, error) {
+	trace := &Trace{Collection: collection, Query: latest}
+
+	queries := []string{latest}
+	if s.cfg.Rewrite {
+		if len(history) > s.cfg.HistoryMessages {
+			history = history[len(history)-s.cfg.HistoryMessages:]
+		}
+
+		start := time.Now()
+		query, variants, err := s.rewriter.Rewrite(ctx, history, latest, s.cfg.Variants)
+		trace.RewriteMillis = time.Since(start).Milliseconds()
+		if err != nil {
+			log.Printf("Query rewrite failed, searching the original message: %v", err)
+			trace.RewriteError = err.Error()
+		} else {
+			if query != latest {
+				trace.RewrittenQuery = query
+			}
+			trace.Variants = variants
+			queries = append([]string{query}, variants...)
+		}
+	}
+
+	start := time.Now()
+	lists := make([][]vector.SearchResult, 0, len(queries))
+	for _, q := range queries {
+		results, err := s.vectorService.Search(ctx, vector.SearchRequest{
+			Namespace: collection,
+			Query:     q,
+			K:         s.cfg.K,
+		})
+		if err != nil {
+			return nil, trace, fmt.Errorf("failed to search %q: %w", q, err)
+		}
+		lists = append(lists, results)
+	}
+	results := lists[0]
+	if len(lists) > 1 {
+		results = fuseQueries(lists, s.cfg.K)
+	}
+	trace.SearchMillis = time.Since(start).Milliseconds()
+	trace.Results = results
+	return results, trace, nil
+}
+
+// fuseQueries merges the results of several queries with reciprocal rank
+// fusion, so passages found by more phrasings rank higher. Each result keeps
+// the fields from its best ranking; Score becomes the fused score.
+func fuseQueries(lists [][]vector.SearchResult, k int) []vector.SearchResult {
+	fused := make(map[string]*vector.SearchResult)
+	for _, list := range lists {
+		for rank, r := range list {
+			f, ok := fused[r.ID]
+			if !ok {
+				r.Score = 0
+				f = &r
+				fused[r.ID] = f
+			}
+			f.Score += 1 / float64(rrfK+rank+1)
+		}
+	}
+
+	results := make([]vector.SearchResult, 0, len(fused))
+	for _, f := range fused {
+		results = append(results, *f)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID < results[j].ID
+	})
+	if len(results) > k {
+		results = results[:k]
+	}
+	return results
+}
+
+// Citations describes the passages of ContextMessage with the numbers the
+// model sees them under, for storing with the reply.
+func Citations(results []vector.SearchResult) []chat.Citation {
+	citations := make([]chat.Citation, 0, len(results))
+	for i, r := range results {
+		citations = append(citations, chat.Citation{
+			Index:      i + 1,
+			DocumentID: r.Metadata[vector.MetadataDocumentID],
+			ChunkID:    r.ID,
+			Text:       r.Text,
+		})
+	}
+	return citations
+}
+
+// ContextMessage builds the system message that gives the model the
+// retrieved passages.
+func ContextMessage(results []vector.SearchResult) llm.Message {
+	var b strings.Builder
+	b.WriteString("Use the following knowledge base excerpts to answer the user when they are relevant. ")
+	b.WriteString("If they do not contain the answer, say so instead of guessing.\n")
+	for i, r := range results {
+		fmt.Fprintf(&b, "\n[%d] %s\n", i+1, r.Text)
+	}
+	return llm.Message{Role: "system", Content: b.String()}
+}
@@ -0,0 +1,149 @@
+package retrieval
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"csdeepseek/backend/services/llm"
+	"csdeepseek/backend/services/vector"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeCompleter struct {
+	reply    string
+	err      error
+	calls    int
+	messages []llm.Message
+}
+
+func (f *fakeCompleter) GenerateResponse(ctx context.Context, messages []llm.Message) (string, error) {
+	f.calls++
+	f.messages = messages
+	return f.reply, f.err
+}
+
+func newTestService(t *testing.T, completer Completer) *Service {
+	t.Setenv("VECTOR_STORE_DIR", "")
+	vectorService := vector.NewServiceWithEmbedder(vector.NewHashEmbedder(128))
+	ctx := context.Background()
+
+	_, err := vectorService.CreateCollection(vector.Collection{Name: "catalog"})
+	require.NoError(t, err)
+	docs := map[string]string{
+		"kettle-red":  "The red kettle holds 1.5 litres and costs 30 euros.",
+		"kettle-blue": "The blue kettle holds 1.7 litres, has a temperature dial and costs 45 euros.",
+		"toaster":     "The toaster has four slots and a defrost setting.",
+	}
+	for id, text := range docs {
+		_, err := vectorService.IngestDocument(ctx, "catalog", id, text, nil)
+		require.NoError(t, err)
+	}
+
+	s := NewService(completer, vectorService)
+	s.SetConfig(Config{Collection: "catalog", K: 2, Rewrite: true, HistoryMessages: 4})
+	return s
+}
+
+var conversation = []llm.Message{
+	{Role: "user", Content: "Which kettles do you sell?"},
+	{Role: "assistant", Content: "We have a red kettle and a blue kettle."},
+}
+
+func TestRetrieve_RewritesFollowUps(t *testing.T) {
+	completer := &fakeCompleter{reply: `{"query": "blue kettle capacity and price"}`}
+	s := newTestService(t, completer)
+
+	results, trace, err := s.Retrieve(context.Background(), conversation, "what about the blue one?")
+	require.NoError(t, err)
+	require.NotEmpty(t, results)
+	assert.Equal(t, "kettle-blue", results[0].Metadata[vector.MetadataDocumentID])
+
+	assert.Equal(t, "what about the blue one?", trace.Query)
+	assert.Equal(t, "blue kettle capacity and price", trace.RewrittenQuery)
+	assert.Equal(t, "catalog", trace.Collection)
+	assert.Equal(t, results, trace.Results)
+
+	require.Len(t, completer.messages, 2)
+	assert.Contains(t, completer.messages[1].Content, "assistant: We have a red kettle and a blue kettle.")
+	assert.Contains(t, completer.messages[1].Content, "Latest message: what about the blue one?")
+}
+
+func TestRetrieve_FirstTurnSkipsRewrite(t *testing.T) {
+	completer := &fakeCompleter{}
+	s := newTestService(t, completer)
+
+	_, trace, err := s.Retrieve(context.Background(), nil, "toaster slots")
+	require.NoError(t, err)
+	assert.Zero(t, completer.calls)
+	assert.Empty(t, trace.RewrittenQuery)
+}
+
+func TestRetrieve_FallsBackWhenRewriteFails(t *testing.T) {
+	completer := &fakeCompleter{err: errors.New("model unavailable")}
+	s := newTestService(t, completer)
+
+	results, trace, err := s.Retrieve(context.Background(), conversation, "blue kettle price")
+	require.NoError(t, err)
+	require.NotEmpty(t, results)
+	assert.Contains(t, trace.RewriteError, "model unavailable")
+	assert.Empty(t, trace.RewrittenQuery)
+}
+
+func TestRetrieveFrom_SearchesOtherCollection(t *testing.T) {
+	s := newTestService(t, &fakeCompleter{})
+	ctx := context.Background()
+	_, err := s.vectorService.CreateCollection(vector.Collection{Name: "returns"})
+	require.NoError(t, err)
+	_, err = s.vectorService.IngestDocument(ctx, "returns", "policy", "Kettles can be returned within 30 days.", nil)
+	require.NoError(t, err)
+
+	results, trace, err := s.RetrieveFrom(ctx, "returns", nil, "kettle returns")
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "policy", results[0].Metadata[vector.MetadataDocumentID])
+	assert.Equal(t, "returns", trace.Collection)
+	assert.Equal(t, "catalog", s.Collection(), "the configured collection is unchanged")
+}
+
+func TestRetrieve_QueryVariants(t *testing.T) {
+	completer := &fakeCompleter{reply: "```json\n" +
+		`{"query": "blue kettle", "variants": ["Blue Kettle", "kettle with temperature dial", "", "toaster defrost"]}` +
+		"\n```"}
+	s := newTestService(t, completer)
+	s.cfg.Variants = 2
+
+	results, trace, err := s.Retrieve(context.Background(), conversation, "and the blue one?")
+	require.NoError(t, err)
+	// Duplicates and empty variants are dropped, the rest capped at two
+	assert.Equal(t, []string{"kettle with temperature dial", "toaster defrost"}, trace.Variants)
+	assert.Contains(t, completer.messages[0].Content, "2 differently worded variants")
+	require.Len(t, results, 2)
+	ids := []string{results[0].Metadata[vector.MetadataDocumentID], results[1].Metadata[vector.MetadataDocumentID]}
+	assert.Contains(t, ids, "kettle-blue")
+	// Fused scores sum over the queries that found a passage
+	assert.Greater(t, results[0].Score, 1.0/61)
+}
+
+func TestParseRewriteReply_PlainText(t *testing.T) {
+	query, variants := parseRewriteReply(`  "blue kettle price"  `)
+	assert.Equal(t, "blue kettle price", query)
+	assert.Empty(t, variants)
+}
+
+func TestCitations(t *testing.T) {
+	results := []vector.SearchResult{
+		{ID: "refunds#0", Text: "Refunds take 5 days.", Metadata: map[string]string{vector.MetadataDocumentID: "refunds"}},
+		{ID: "loose", Text: "Call support."},
+	}
+	citations := Citations(results)
+	require.Len(t, citations, 2)
+	assert.Equal(t, 1, citations[0].Index)
+	assert.Equal(t, "refunds", citations[0].DocumentID)
+	assert.Equal(t, "refunds#0", citations[0].ChunkID)
+	assert.Equal(t, 2, citations[1].Index)
+	assert.Empty(t, citations[1].DocumentID)
+	assert.Contains(t, ContextMessage(results).Content, "[2] Call support.")
+}
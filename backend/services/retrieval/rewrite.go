@@ -0,0 +1,112 @@
+package retrieval
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"csdeepseek/backend/services/llm"
+)
+
+// maxRewriteMessageLength caps how much of each history message is shown to
+// the rewriter, in bytes.
+const maxRewriteMessageLength = 500
+
+// Completer generates a chat completion. *llm.Service satisfies it.
+type Completer interface {
+	GenerateResponse(ctx context.Context, messages []llm.Message) (string, error)
+}
+
+// Rewriter condenses the latest user turn of a conversation into standalone
+// search queries, resolving references like "the blue one" against the
+// preceding messages.
+type Rewriter struct {
+	completer Completer
+}
+
+// NewRewriter creates a rewriter that uses completer.
+func NewRewriter(completer Completer) *Rewriter {
+	return &Rewriter{completer: completer}
+}
+
+type rewriteReply struct {
+	Query    string   `json:"query"`
+	Variants []string `json:"variants"`
+}
+
+// Rewrite returns a standalone version of latest given the earlier messages
+// in history, plus up to variants alternative phrasings. Without history
+// the latest message already stands alone and is returned as is.
+func (rw *Rewriter) Rewrite(ctx context.Context, history []llm.Message, latest string, variants int) (string, []string, error) {
+	if len(history) == 0 && variants == 0 {
+		return latest, nil, nil
+	}
+
+	var prompt strings.Builder
+	if len(history) > 0 {
+		prompt.WriteString("Conversation:\n")
+		for _, m := range history {
+			if m.Role == "system" {
+				continue
+			}
+			content := m.Content
+			if len(content) > maxRewriteMessageLength {
+				content = strings.ToValidUTF8(content[:maxRewriteMessageLength], "") + "..."
+			}
+			fmt.Fprintf(&prompt, "%s: %s\n", m.Role, strings.Join(strings.Fields(content), " "))
+		}
+		prompt.WriteString("\n")
+	}
+	fmt.Fprintf(&prompt, "Latest message: %s", latest)
+
+	instructions := "Rewrite the latest message of the conversation into a standalone search query " +
+		"for a knowledge base. Resolve pronouns and references using the conversation, keep " +
+		"product names, codes and other specific terms, and do not answer the question. " +
+		"Keep the language of the latest message."
+	if variants > 0 {
+		instructions += fmt.Sprintf(" Also give %d differently worded variants of the query.", variants)
+	}
+	instructions += ` Reply with only JSON: {"query": "...", "variants": ["..."]}.`
+
+	reply, err := rw.completer.GenerateResponse(ctx, []llm.Message{
+		{Role: "system", Content: instructions},
+		{Role: "user", Content: prompt.String()},
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to rewrite query: %w", err)
+	}
+
+	query, alternatives := parseRewriteReply(reply)
+	if query == "" {
+		return "", nil, fmt.Errorf("rewriter returned an empty query")
+	}
+	if len(alternatives) > variants {
+		alternatives = alternatives[:variants]
+	}
+	return query, alternatives, nil
+}
+
+// parseRewriteReply extracts the query and variants from a model reply. A
+// reply that is not JSON is taken as the query itself.
+func parseRewriteReply(reply string) (string, []string) {
+	start := strings.IndexByte(reply, '{')
+	end := strings.LastIndexByte(reply, '}')
+	if start >= 0 && end > start {
+		var parsed rewriteReply
+		if err := json.Unmarshal([]byte(reply[start:end+1]), &parsed); err == nil {
+			query := strings.TrimSpace(parsed.Query)
+			var variants []string
+			seen := map[string]bool{strings.ToLower(query): true}
+			for _, v := range parsed.Variants {
+				v = strings.TrimSpace(v)
+				if v != "" && !seen[strings.ToLower(v)] {
+					seen[strings.ToLower(v)] = true
+					variants = append(variants, v)
+				}
+			}
+			return query, variants
+		}
+	}
+	return strings.Trim(strings.TrimSpace(reply), `"`), nil
+}
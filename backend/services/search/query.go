@@ -0,0 +1,200 @@
+package search
+
+import (
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"csdeepseek/backend/services/tokenizer"
+)
+
+const (
+	// snippetLength is the length of a snippet in runes, and snippetLead
+	// how much of it comes before the first match
+	snippetLength = 160
+	snippetLead   = 40
+)
+
+// phrase is a sequence of terms that must occur together. Offsets are
+// relative to the first term; identifier parts share their identifier's
+// offset, as in the index.
+type phrase []phraseTerm
+
+type phraseTerm struct {
+	term   string
+	offset int
+}
+
+// parseQuery splits query text into phrases: each "quoted phrase", and
+// each remaining word. A word is a phrase too, so that a CJK word, indexed
+// as overlapping bigrams, matches only where its characters are adjacent.
+func parseQuery(text string) []phrase {
+	var phrases []phrase
+	add := func(part string) {
+		tokens := tokenizer.Tokenize(part)
+		if len(tokens) == 0 {
+			return
+		}
+		p := make(phrase, len(tokens))
+		for i, t := range tokens {
+			p[i] = phraseTerm{term: t.Term, offset: t.Position - tokens[0].Position}
+		}
+		phrases = append(phrases, p)
+	}
+
+	for text != "" {
+		if text[0] == '"' {
+			text = text[1:]
+			end := strings.IndexByte(text, '"')
+			if end < 0 {
+				end = len(text)
+			}
+			add(text[:end])
+			text = text[min(end+1, len(text)):]
+			continue
+		}
+		end := strings.IndexFunc(text, func(r rune) bool { return unicode.IsSpace(r) || r == '"' })
+		if end < 0 {
+			end = len(text)
+		}
+		add(text[:end])
+		text = strings.TrimLeftFunc(text[end:], unicode.IsSpace)
+	}
+	return phrases
+}
+
+// starts returns the positions in document id where the phrase begins.
+func (p phrase) starts(postings map[string]map[int][]int, id int) []int {
+	var starts []int
+	for _, pos := range postings[p[0].term][id] {
+		if p.matchesAt(pos, func(term string, at int) bool {
+			positions := postings[term][id]
+			i := sort.SearchInts(positions, at)
+			return i < len(positions) && positions[i] == at
+		}) {
+			starts = append(starts, pos)
+		}
+	}
+	return starts
+}
+
+func (p phrase) matchesAt(pos int, has func(term string, at int) bool) bool {
+	for _, t := range p[1:] {
+		if !has(t.term, pos+t.offset) {
+			return false
+		}
+	}
+	return true
+}
+
+// df estimates how many documents contain the phrase by its rarest term.
+func (p phrase) df(postings map[string]map[int][]int) int {
+	df := len(postings[p[0].term])
+	for _, t := range p[1:] {
+		df = min(df, len(postings[t.term]))
+	}
+	return df
+}
+
+type span struct{ start, end int }
+
+// snippet returns an excerpt of content around its first match, HTML
+// escaped, with every match wrapped in <mark> tags.
+func snippet(content string, phrases []phrase) string {
+	content = strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\r' || r == '\t' {
+			return ' '
+		}
+		return r
+	}, content)
+
+	tokens := tokenizer.Tokenize(content)
+	at := make(map[int][]tokenizer.Token)
+	for _, t := range tokens {
+		at[t.Position] = append(at[t.Position], t)
+	}
+	find := func(term string, pos int) (tokenizer.Token, bool) {
+		for _, t := range at[pos] {
+			if t.Term == term {
+				return t, true
+			}
+		}
+		return tokenizer.Token{}, false
+	}
+
+	var spans []span
+	for _, p := range phrases {
+		for _, t := range tokens {
+			if t.Term != p[0].term || !p.matchesAt(t.Position, func(term string, pos int) bool {
+				_, ok := find(term, pos)
+				return ok
+			}) {
+				continue
+			}
+			for _, pt := range p {
+				m, _ := find(pt.term, t.Position+pt.offset)
+				spans = append(spans, span{m.Start, m.End})
+			}
+		}
+	}
+	spans = mergeSpans(spans)
+
+	from := 0
+	if len(spans) > 0 {
+		from = backRunes(content, spans[0].start, snippetLead)
+	}
+	to := forwardRunes(content, from, snippetLength)
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	pos := from
+	for _, sp := range spans {
+		if sp.start >= to {
+			break
+		}
+		b.WriteString(html.EscapeString(content[pos:sp.start]))
+		end := min(sp.end, to)
+		b.WriteString("<mark>" + html.EscapeString(content[sp.start:end]) + "</mark>")
+		pos = end
+	}
+	b.WriteString(html.EscapeString(content[pos:to]))
+	if to < len(content) {
+		b.WriteString("…")
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// mergeSpans sorts spans and joins those that overlap or touch, such as
+// the overlapping bigrams of a CJK match.
+func mergeSpans(spans []span) []span {
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	var merged []span
+	for _, sp := range spans {
+		if n := len(merged); n > 0 && sp.start <= merged[n-1].end {
+			merged[n-1].end = max(merged[n-1].end, sp.end)
+			continue
+		}
+		merged = append(merged, sp)
+	}
+	return merged
+}
+
+func backRunes(s string, i, n int) int {
+	for ; n > 0 && i > 0; n-- {
+		_, size := utf8.DecodeLastRuneInString(s[:i])
+		i -= size
+	}
+	return i
+}
+
+func forwardRunes(s string, i, n int) int {
+	for ; n > 0 && i < len(s); n-- {
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+	}
+	return i
+}
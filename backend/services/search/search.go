@@ -0,0 +1,446 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"csdeepseek/backend/models/chat"
+	"csdeepseek/backend/services/session"
+	"csdeepseek/backend/services/tokenizer"
+)
+
+// BM25 parameters, as in the knowledge base keyword index.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+var (
+	ErrDisabled   = errors.New("history search is disabled")
+	ErrEmptyQuery = errors.New("query has no searchable terms")
+)
+
+// Service is a full-text index over the messages of every session. It
+// observes the session service and follows its event bus, so messages are
+// indexed as they are added and dropped when their session is deleted, on
+// this replica or any other sharing the bus. Sessions the store expires on
+// its own are dropped when a search finds them gone.
+//
+// The index lives in memory, one per replica, and is rebuilt from the
+// session store at startup. Events a replica misses while disconnected
+// from the bus are only made up for at its next restart.
+type Service struct {
+	sessions *session.Service
+	enabled  bool
+
+	mu       sync.RWMutex
+	postings map[string]map[int][]int // term -> document -> positions
+	docs     map[int]*document
+	byID     map[string]*indexedSession
+	nextDoc  int
+	totalLen int
+}
+
+// document is one indexed message.
+type document struct {
+	sessionID string
+	messageID string
+	role      string
+	createdAt time.Time
+	content   string
+	terms     []string
+	length    int
+}
+
+type indexedSession struct {
+	userID string
+	docs   []int
+	// indexed holds the IDs of the messages seen, as a message may be
+	// reported both by the observer and by the bus
+	indexed map[string]bool
+}
+
+// Query describes a search. Text holds words, all of which must occur;
+// "quoted phrases" must occur as written. The other fields narrow the
+// search and are ignored when zero.
+type Query struct {
+	Text string
+	// UserID limits the search to one user's sessions
+	UserID    string
+	SessionID string
+	// Role is chat.RoleUser or chat.RoleAssistant
+	Role string
+	// From and To bound the message time; From is inclusive, To exclusive
+	From time.Time
+	To   time.Time
+
+	Offset int
+	Limit  int
+}
+
+// Hit is a matching message. Snippet is an HTML-escaped excerpt of it with
+// the matches wrapped in <mark> tags.
+type Hit struct {
+	SessionID    string    `json:"session_id"`
+	SessionTitle string    `json:"session_title,omitempty"`
+	UserID       string    `json:"user_id,omitempty"`
+	MessageID    string    `json:"message_id"`
+	Role         string    `json:"role"`
+	CreatedAt    time.Time `json:"created_at"`
+	Snippet      string    `json:"snippet"`
+	Score        float64   `json:"score"`
+}
+
+// NewService creates the history index, configured from
+// HISTORY_SEARCH_ENABLED, indexes the sessions already stored and starts
+// following changes to them.
+func NewService(sessions *session.Service) *Service {
+	s := &Service{
+		sessions: sessions,
+		enabled:  true,
+		postings: make(map[string]map[int][]int),
+		docs:     make(map[int]*document),
+		byID:     make(map[string]*indexedSession),
+	}
+	if v, err := strconv.ParseBool(os.Getenv("HISTORY_SEARCH_ENABLED")); err == nil {
+		s.enabled = v
+	}
+	if !s.enabled {
+		return s
+	}
+
+	all, err := sessions.ListSessions(context.Background())
+	if err != nil {
+		log.Printf("Failed to index session history: %v", err)
+	}
+	for _, sess := range all {
+		s.SessionCreated(sess)
+	}
+	sessions.Observe(s)
+	sessions.Subscribe(session.AllSessions, s.follow)
+	return s
+}
+
+// Enabled reports whether history is indexed.
+func (s *Service) Enabled() bool {
+	return s.enabled
+}
+
+// Len returns the number of indexed messages.
+func (s *Service) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.docs)
+}
+
+// SessionCreated indexes a new session's messages.
+func (s *Service) SessionCreated(sess *session.Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeSession(sess.ID)
+	s.byID[sess.ID] = &indexedSession{userID: sess.UserID}
+	for _, msg := range sess.Messages {
+		s.add(sess.ID, msg)
+	}
+}
+
+// MessageAdded indexes a new message. A session not seen before, such as
+// one created on another replica, is indexed whole.
+func (s *Service) MessageAdded(sessionID string, msg session.Message) {
+	s.mu.Lock()
+	_, known := s.byID[sessionID]
+	if known {
+		s.add(sessionID, msg)
+	}
+	s.mu.Unlock()
+	if !known {
+		s.indexStored(sessionID)
+	}
+}
+
+// indexStored indexes a session as the store holds it.
+func (s *Service) indexStored(sessionID string) {
+	sess, err := s.sessions.GetSession(context.Background(), sessionID)
+	if err != nil {
+		log.Printf("Failed to index session %s: %v", sessionID, err)
+		return
+	}
+	s.SessionCreated(sess)
+}
+
+// follow applies an event from the session bus, which carries the changes
+// made on other replicas too. Those made here are also reported to the
+// observer, so each change may arrive twice.
+func (s *Service) follow(ev session.Event) {
+	switch ev.Type {
+	case session.EventSessionCreated:
+		s.mu.RLock()
+		_, known := s.byID[ev.SessionID]
+		s.mu.RUnlock()
+		if !known {
+			s.indexStored(ev.SessionID)
+		}
+	case session.EventMessageAdded:
+		if ev.Message != nil {
+			s.MessageAdded(ev.SessionID, *ev.Message)
+		}
+	case session.EventSessionDeleted:
+		s.SessionsDeleted([]string{ev.SessionID})
+	}
+}
+
+// SessionsDeleted drops the sessions' messages from the index.
+func (s *Service) SessionsDeleted(ids []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		s.removeSession(id)
+	}
+}
+
+// add indexes a message, unless it already is. The caller must hold the
+// write lock.
+func (s *Service) add(sessionID string, msg session.Message) {
+	entry := s.byID[sessionID]
+	if entry.indexed[msg.ID] {
+		return
+	}
+	if entry.indexed == nil {
+		entry.indexed = make(map[string]bool)
+	}
+	entry.indexed[msg.ID] = true
+	if msg.Role != chat.RoleUser && msg.Role != chat.RoleAssistant {
+		// System messages hold recalled memories and instructions, not
+		// conversation
+		return
+	}
+	tokens := tokenizer.Tokenize(msg.Content)
+	if len(tokens) == 0 {
+		return
+	}
+
+	id := s.nextDoc
+	s.nextDoc++
+	doc := &document{
+		sessionID: sessionID,
+		messageID: msg.ID,
+		role:      msg.Role,
+		createdAt: msg.CreatedAt,
+		content:   msg.Content,
+		length:    tokens[len(tokens)-1].Position + 1,
+	}
+	for _, t := range tokens {
+		docs, ok := s.postings[t.Term]
+		if !ok {
+			docs = make(map[int][]int)
+			s.postings[t.Term] = docs
+		}
+		if _, ok := docs[id]; !ok {
+			doc.terms = append(doc.terms, t.Term)
+		}
+		docs[id] = append(docs[id], t.Position)
+	}
+	s.docs[id] = doc
+	s.totalLen += doc.length
+	entry.docs = append(entry.docs, id)
+}
+
+// removeSession drops a session from the index. The caller must hold the
+// write lock.
+func (s *Service) removeSession(sessionID string) {
+	entry, ok := s.byID[sessionID]
+	if !ok {
+		return
+	}
+	for _, id := range entry.docs {
+		doc := s.docs[id]
+		for _, term := range doc.terms {
+			docs := s.postings[term]
+			delete(docs, id)
+			if len(docs) == 0 {
+				delete(s.postings, term)
+			}
+		}
+		s.totalLen -= doc.length
+		delete(s.docs, id)
+	}
+	delete(s.byID, sessionID)
+}
+
+// scoredDoc is a matching document before it is turned into a hit.
+type scoredDoc struct {
+	id    int
+	score float64
+}
+
+// Search returns a page of the messages matching q, best matches first,
+// and the total number of matches.
+func (s *Service) Search(ctx context.Context, q Query) ([]Hit, int, error) {
+	if !s.enabled {
+		return nil, 0, ErrDisabled
+	}
+	phrases := parseQuery(q.Text)
+	if len(phrases) == 0 {
+		return nil, 0, ErrEmptyQuery
+	}
+
+	// Every match is resolved before it is counted, so sessions the store
+	// expired on its own, which are only noticed here, do not inflate the
+	// total. Each session is looked up once.
+	matches := s.match(q, phrases)
+	hits := make([]Hit, 0, min(q.Limit, len(matches)))
+	titles := make(map[string]string)
+	missing := make(map[string]bool)
+	total := 0
+	for _, m := range matches {
+		sessionID, ok := s.sessionOf(m.id)
+		if !ok || missing[sessionID] {
+			continue
+		}
+		if _, seen := titles[sessionID]; !seen {
+			sess, err := s.sessions.GetSession(ctx, sessionID)
+			switch {
+			case errors.Is(err, session.ErrNotFound):
+				missing[sessionID] = true
+				s.SessionsDeleted([]string{sessionID})
+				continue
+			case err != nil:
+				return nil, 0, err
+			}
+			titles[sessionID] = sess.Title
+		}
+		total++
+		if total <= q.Offset || len(hits) >= q.Limit {
+			continue
+		}
+		hit, ok := s.hit(m, phrases)
+		if !ok {
+			// Removed since it was counted
+			total--
+			continue
+		}
+		hit.SessionTitle = titles[sessionID]
+		hits = append(hits, hit)
+	}
+	return hits, total, nil
+}
+
+// match finds and ranks the documents matching every phrase of the query.
+func (s *Service) match(q Query, phrases []phrase) []scoredDoc {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Start from the rarest term; every match contains it
+	var rarest map[int][]int
+	for _, p := range phrases {
+		for _, t := range p {
+			docs := s.postings[t.term]
+			if len(docs) == 0 {
+				return nil
+			}
+			if rarest == nil || len(docs) < len(rarest) {
+				rarest = docs
+			}
+		}
+	}
+
+	n := float64(len(s.docs))
+	avgLen := float64(s.totalLen) / n
+	var matches []scoredDoc
+	for id := range rarest {
+		doc := s.docs[id]
+		if !s.filter(q, doc) {
+			continue
+		}
+		score := 0.0
+		for _, p := range phrases {
+			tf := len(p.starts(s.postings, id))
+			if tf == 0 {
+				score = -1
+				break
+			}
+			df := float64(p.df(s.postings))
+			idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+			norm := bm25K1 * (1 - bm25B + bm25B*float64(doc.length)/avgLen)
+			score += idf * float64(tf) * (bm25K1 + 1) / (float64(tf) + norm)
+		}
+		if score >= 0 {
+			matches = append(matches, scoredDoc{id: id, score: score})
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.score != b.score {
+			return a.score > b.score
+		}
+		da, db := s.docs[a.id], s.docs[b.id]
+		if !da.createdAt.Equal(db.createdAt) {
+			return da.createdAt.After(db.createdAt)
+		}
+		return a.id > b.id
+	})
+	return matches
+}
+
+func (s *Service) filter(q Query, doc *document) bool {
+	if q.SessionID != "" && doc.sessionID != q.SessionID {
+		return false
+	}
+	if q.UserID != "" && s.byID[doc.sessionID].userID != q.UserID {
+		return false
+	}
+	if q.Role != "" && doc.role != q.Role {
+		return false
+	}
+	if !q.From.IsZero() && doc.createdAt.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !doc.createdAt.Before(q.To) {
+		return false
+	}
+	return true
+}
+
+// sessionOf returns the session of a matching document, reporting false if
+// the document has been removed since it matched.
+func (s *Service) sessionOf(id int) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	doc, ok := s.docs[id]
+	if !ok {
+		return "", false
+	}
+	return doc.sessionID, true
+}
+
+// hit describes a matching document, reporting false if it has been
+// removed since it matched.
+func (s *Service) hit(m scoredDoc, phrases []phrase) (Hit, bool) {
+	s.mu.RLock()
+	doc, ok := s.docs[m.id]
+	var userID string
+	if ok {
+		userID = s.byID[doc.sessionID].userID
+	}
+	s.mu.RUnlock()
+	if !ok {
+		return Hit{}, false
+	}
+	return Hit{
+		SessionID: doc.sessionID,
+		UserID:    userID,
+		MessageID: doc.messageID,
+		Role:      doc.role,
+		CreatedAt: doc.createdAt,
+		Snippet:   snippet(doc.content, phrases),
+		Score:     m.score,
+	}, true
+}
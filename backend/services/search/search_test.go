@@ -0,0 +1,228 @@
+package search
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"csdeepseek/backend/models/chat"
+	"csdeepseek/backend/services/session"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestService(t *testing.T) (*Service, *session.Service) {
+	t.Setenv("HISTORY_SEARCH_ENABLED", "")
+	sessions := session.NewServiceWithStore(session.NewMemoryStore())
+	return NewService(sessions), sessions
+}
+
+func addMessages(t *testing.T, sessions *session.Service, userID string, messages ...session.Message) *session.Session {
+	ctx := context.Background()
+	sess, err := sessions.CreateSessionForUser(ctx, userID)
+	require.NoError(t, err)
+	for _, m := range messages {
+		require.NoError(t, sessions.AddMessage(ctx, sess.ID, m))
+	}
+	return sess
+}
+
+func search(t *testing.T, svc *Service, q Query) []Hit {
+	if q.Limit == 0 {
+		q.Limit = 10
+	}
+	hits, total, err := svc.Search(context.Background(), q)
+	require.NoError(t, err)
+	assert.Len(t, hits, min(total, q.Limit))
+	return hits
+}
+
+func TestSearch(t *testing.T) {
+	svc, sessions := newTestService(t)
+	ctx := context.Background()
+
+	printer := addMessages(t, sessions, "alice",
+		session.Message{Role: chat.RoleSystem, Content: "Known about the user: owns a printer"},
+		session.Message{Role: chat.RoleUser, Content: "My printer shows error E-1234 and jams"},
+		session.Message{Role: chat.RoleAssistant, Content: "Open the rear tray of the printer and remove the paper."},
+	)
+	addMessages(t, sessions, "alice",
+		session.Message{Role: chat.RoleUser, Content: "我的打印机卡纸了，怎么办？"},
+		session.Message{Role: chat.RoleAssistant, Content: "请打开后盖，取出卡住的纸。"},
+	)
+	addMessages(t, sessions, "bob",
+		session.Message{Role: chat.RoleUser, Content: "The printer at the office is offline"},
+	)
+	require.NoError(t, sessions.UpdateSession(ctx, printer.ID, session.SessionUpdate{Title: ptr("Printer jam")}))
+
+	hits := search(t, svc, Query{Text: "printer", UserID: "alice"})
+	require.Len(t, hits, 2, "system messages are not indexed, other users are not searched")
+	assert.Equal(t, printer.ID, hits[0].SessionID)
+	assert.Equal(t, "Printer jam", hits[0].SessionTitle)
+
+	assert.Len(t, search(t, svc, Query{Text: "printer"}), 3)
+	assert.Len(t, search(t, svc, Query{Text: "printer", UserID: "alice", Role: chat.RoleUser}), 1)
+
+	hits = search(t, svc, Query{Text: "e-1234", UserID: "alice"})
+	require.Len(t, hits, 1)
+	assert.Contains(t, hits[0].Snippet, "<mark>E-1234</mark>")
+	assert.Len(t, search(t, svc, Query{Text: "1234", UserID: "alice"}), 1, "identifier parts match")
+
+	// Phrases must occur as written, words anywhere
+	assert.Len(t, search(t, svc, Query{Text: `"rear tray"`, UserID: "alice"}), 1)
+	assert.Empty(t, search(t, svc, Query{Text: `"tray rear"`, UserID: "alice"}))
+	assert.Len(t, search(t, svc, Query{Text: "tray rear", UserID: "alice"}), 1)
+	assert.Empty(t, search(t, svc, Query{Text: "printer modem", UserID: "alice"}))
+
+	hits = search(t, svc, Query{Text: "打印机", UserID: "alice"})
+	require.Len(t, hits, 1)
+	assert.Equal(t, "我的<mark>打印机</mark>卡纸了，怎么办？", hits[0].Snippet)
+	assert.Empty(t, search(t, svc, Query{Text: "印卡", UserID: "alice"}), "CJK characters must be adjacent")
+
+	_, _, err := svc.Search(ctx, Query{Text: "  ?! ", Limit: 10})
+	assert.ErrorIs(t, err, ErrEmptyQuery)
+}
+
+func TestSearch_Filters(t *testing.T) {
+	svc, sessions := newTestService(t)
+	day := func(d int) time.Time { return time.Date(2026, 5, d, 12, 0, 0, 0, time.UTC) }
+
+	sess := addMessages(t, sessions, "alice",
+		session.Message{Role: chat.RoleUser, Content: "router reboot", CreatedAt: day(1)},
+		session.Message{Role: chat.RoleAssistant, Content: "router reset", CreatedAt: day(2)},
+		session.Message{Role: chat.RoleUser, Content: "router again", CreatedAt: day(3)},
+	)
+	other := addMessages(t, sessions, "alice",
+		session.Message{Role: chat.RoleUser, Content: "router lights", CreatedAt: day(3)},
+	)
+
+	hits := search(t, svc, Query{Text: "router", UserID: "alice", From: day(2), To: day(3)})
+	require.Len(t, hits, 1)
+	assert.Equal(t, "<mark>router</mark> reset", hits[0].Snippet)
+	assert.Len(t, search(t, svc, Query{Text: "router", SessionID: other.ID}), 1)
+
+	// Pages, newest first among equal scores
+	page, total, err := svc.Search(context.Background(), Query{Text: "router", UserID: "alice", Offset: 1, Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, 4, total)
+	require.Len(t, page, 2)
+	assert.Equal(t, day(3), page[0].CreatedAt)
+	assert.Equal(t, sess.ID, page[1].SessionID)
+	assert.Equal(t, day(2), page[1].CreatedAt)
+}
+
+func TestSearch_FollowsSessions(t *testing.T) {
+	sessions := session.NewServiceWithStore(session.NewMemoryStore())
+	ctx := context.Background()
+	existing := addMessages(t, sessions, "alice", session.Message{Role: chat.RoleUser, Content: "modem blinking"})
+
+	t.Setenv("HISTORY_SEARCH_ENABLED", "")
+	svc := NewService(sessions)
+	assert.Len(t, search(t, svc, Query{Text: "modem"}), 1, "sessions stored before start are indexed")
+
+	imported, err := sessions.ImportSession(ctx, "alice", &session.Session{Messages: []session.Message{
+		{Role: chat.RoleUser, Content: "modem firmware"},
+	}})
+	require.NoError(t, err)
+	assert.Len(t, search(t, svc, Query{Text: "modem"}), 2)
+
+	require.NoError(t, sessions.DeleteSession(ctx, existing.ID))
+	hits := search(t, svc, Query{Text: "modem"})
+	require.Len(t, hits, 1)
+	assert.Equal(t, imported.ID, hits[0].SessionID)
+	assert.Equal(t, 1, svc.Len())
+
+	// A session removed behind the service's back is dropped when found
+	svc.mu.Lock()
+	svc.byID["sess_gone"] = &indexedSession{userID: "alice"}
+	svc.add("sess_gone", session.Message{ID: "m1", Role: chat.RoleUser, Content: "modem"})
+	svc.mu.Unlock()
+	assert.Len(t, search(t, svc, Query{Text: "modem"}), 1)
+	assert.Equal(t, 1, svc.Len())
+}
+
+func TestSearch_TotalCountsOnlyLiveSessions(t *testing.T) {
+	svc, sessions := newTestService(t)
+	addMessages(t, sessions, "alice", session.Message{Role: chat.RoleUser, Content: "modem blinking"})
+
+	// Sessions removed behind the service's back, beyond the page asked for
+	svc.mu.Lock()
+	for _, id := range []string{"sess_gone1", "sess_gone2"} {
+		svc.byID[id] = &indexedSession{userID: "alice"}
+		svc.add(id, session.Message{ID: "m1", Role: chat.RoleUser, Content: "modem modem modem"})
+	}
+	svc.mu.Unlock()
+
+	hits, total, err := svc.Search(context.Background(), Query{Text: "modem", Offset: 1, Limit: 1})
+	require.NoError(t, err)
+	assert.Empty(t, hits)
+	assert.Equal(t, 1, total)
+	assert.Equal(t, 1, svc.Len())
+}
+
+func TestSearch_FollowsOtherReplicas(t *testing.T) {
+	t.Setenv("HISTORY_SEARCH_ENABLED", "")
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	newReplica := func() (*Service, *session.Service) {
+		client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		sessions := session.NewServiceWithStore(session.NewRedisStore(client, "", 0))
+		bus, err := session.NewRedisBus(ctx, client, "")
+		require.NoError(t, err)
+		sessions.SetBus(bus)
+		t.Cleanup(func() { sessions.Close() })
+		return NewService(sessions), sessions
+	}
+	first, firstSessions := newReplica()
+	second, _ := newReplica()
+
+	sess := addMessages(t, firstSessions, "alice", session.Message{Role: chat.RoleUser, Content: "modem blinking"})
+	assert.Eventually(t, func() bool { return second.Len() == 1 }, time.Second, 10*time.Millisecond, "messages added elsewhere are indexed")
+	assert.Equal(t, 1, first.Len(), "the replica's own messages are indexed once")
+	assert.Equal(t, sess.ID, search(t, second, Query{Text: "modem"})[0].SessionID)
+
+	imported, err := firstSessions.ImportSession(ctx, "alice", &session.Session{Messages: []session.Message{
+		{Role: chat.RoleUser, Content: "router firmware"},
+	}})
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return second.Len() == 2 }, time.Second, 10*time.Millisecond, "sessions created elsewhere are indexed whole")
+
+	require.NoError(t, firstSessions.DeleteSession(ctx, imported.ID))
+	assert.Eventually(t, func() bool { return second.Len() == 1 }, time.Second, 10*time.Millisecond, "sessions deleted elsewhere are dropped")
+}
+
+func TestSearch_Disabled(t *testing.T) {
+	t.Setenv("HISTORY_SEARCH_ENABLED", "false")
+	sessions := session.NewServiceWithStore(session.NewMemoryStore())
+	svc := NewService(sessions)
+	addMessages(t, sessions, "alice", session.Message{Role: chat.RoleUser, Content: "modem"})
+
+	_, _, err := svc.Search(context.Background(), Query{Text: "modem", Limit: 10})
+	assert.ErrorIs(t, err, ErrDisabled)
+	assert.Zero(t, svc.Len())
+}
+
+func TestParseQuery(t *testing.T) {
+	phrases := parseQuery(`  printer "paper jam" 卡纸 "unterminated`)
+	require.Len(t, phrases, 4)
+	assert.Equal(t, phrase{{"printer", 0}}, phrases[0])
+	assert.Equal(t, phrase{{"paper", 0}, {"jam", 1}}, phrases[1])
+	assert.Equal(t, phrase{{"卡纸", 0}}, phrases[2])
+	assert.Equal(t, phrase{{"unterminated", 0}}, phrases[3])
+}
+
+func TestSnippet(t *testing.T) {
+	long := "Intro. " + strings.Repeat("filler ", 40) + "the key word is here. " + strings.Repeat("tail ", 40)
+	got := snippet(long, parseQuery("key"))
+	assert.True(t, len(got) < len(long))
+	assert.Contains(t, got, "<mark>key</mark>")
+	assert.Regexp(t, `^….*…$`, got)
+
+	assert.Equal(t, "a &lt;b&gt; <mark>c</mark>", snippet("a <b>\nc", parseQuery("c")))
+}
+
+func ptr(s string) *string { return &s }
@@ -0,0 +1,208 @@
+package session
+
+import (
+	"context"
+	"errors"
+
+	"csdeepseek/backend/models/chat"
+)
+
+var (
+	// ErrMessageNotFound is returned when a message ID is not part of the
+	// session.
+	ErrMessageNotFound = errors.New("message not found")
+	// ErrWrongRole is returned when editing a message that is not the
+	// user's or regenerating one that is not a reply.
+	ErrWrongRole = errors.New("message has the wrong role for this operation")
+)
+
+// A session's messages form a tree: editing a question or regenerating an
+// answer adds a sibling of the original instead of replacing it. The branch
+// shown to the user and sent to the model is the path from the top of the
+// tree to the active leaf.
+
+// tree resolves message parents. parent[i] is the index of message i's
+// parent, or -1 at the top of the tree.
+type tree struct {
+	messages []Message
+	index    map[string]int
+	parent   []int
+}
+
+func newTree(messages []Message) *tree {
+	t := &tree{
+		messages: messages,
+		index:    make(map[string]int, len(messages)),
+		parent:   make([]int, len(messages)),
+	}
+	for i, msg := range messages {
+		if msg.ID != "" {
+			t.index[msg.ID] = i
+		}
+	}
+	for i, msg := range messages {
+		switch msg.ParentID {
+		case chat.NoParent:
+			t.parent[i] = -1
+		case "":
+			t.parent[i] = i - 1
+		default:
+			p, ok := t.index[msg.ParentID]
+			if !ok || p >= i {
+				// Parents are always added first; anything else is
+				// treated as the top of the tree so paths end
+				p = -1
+			}
+			t.parent[i] = p
+		}
+	}
+	return t
+}
+
+// path returns the messages from the top of the tree down to message i.
+func (t *tree) path(i int) []Message {
+	var rev []int
+	for ; i >= 0; i = t.parent[i] {
+		rev = append(rev, i)
+	}
+	path := make([]Message, 0, len(rev))
+	for j := len(rev) - 1; j >= 0; j-- {
+		path = append(path, t.messages[rev[j]])
+	}
+	return path
+}
+
+// children returns the indexes of message i's children in the order they
+// were added; -1 asks for the messages at the top of the tree.
+func (t *tree) children(i int) []int {
+	var children []int
+	for j, p := range t.parent {
+		if p == i {
+			children = append(children, j)
+		}
+	}
+	return children
+}
+
+// latestLeaf follows the most recently added child down from message i.
+func (t *tree) latestLeaf(i int) int {
+	for {
+		children := t.children(i)
+		if len(children) == 0 {
+			return i
+		}
+		i = children[len(children)-1]
+	}
+}
+
+// ActivePath returns the messages of the branch being shown, from the first
+// message to the active leaf.
+func (s *Session) ActivePath() []Message {
+	t := newTree(s.Messages)
+	leaf, ok := t.index[s.ActiveLeaf]
+	if !ok {
+		leaf = len(s.Messages) - 1
+	}
+	return t.path(leaf)
+}
+
+// PathTo returns the messages from the first message down to the one with
+// the given ID, or nil if there is no such message.
+func (s *Session) PathTo(id string) []Message {
+	t := newTree(s.Messages)
+	i, ok := t.index[id]
+	if !ok {
+		return nil
+	}
+	return t.path(i)
+}
+
+// Versions returns the IDs of the alternatives of a message, itself
+// included, in the order they were added: the edits of a question or the
+// regenerations of an answer.
+func (s *Session) Versions(id string) []string {
+	t := newTree(s.Messages)
+	i, ok := t.index[id]
+	if !ok {
+		return nil
+	}
+	var ids []string
+	for _, j := range t.children(t.parent[i]) {
+		if t.messages[j].Role == t.messages[i].Role && t.messages[j].ID != "" {
+			ids = append(ids, t.messages[j].ID)
+		}
+	}
+	return ids
+}
+
+// parentOf returns the ParentID a sibling of message i needs.
+func (t *tree) parentOf(i int) string {
+	if p := t.parent[i]; p >= 0 && t.messages[p].ID != "" {
+		return t.messages[p].ID
+	}
+	return chat.NoParent
+}
+
+// findMessage loads a session and locates one of its messages.
+func (s *Service) findMessage(ctx context.Context, sessionID, messageID string) (*tree, int, error) {
+	sess, err := s.GetSession(ctx, sessionID)
+	if err != nil {
+		return nil, 0, err
+	}
+	t := newTree(sess.Messages)
+	i, ok := t.index[messageID]
+	if !ok {
+		return nil, 0, ErrMessageNotFound
+	}
+	return t, i, nil
+}
+
+// EditMessage adds content as a new version of the user message messageID,
+// on a new branch that becomes active. The original and everything after it
+// stay available through SwitchBranch.
+func (s *Service) EditMessage(ctx context.Context, sessionID, messageID, content string) (Message, error) {
+	t, i, err := s.findMessage(ctx, sessionID, messageID)
+	if err != nil {
+		return Message{}, err
+	}
+	if t.messages[i].Role != chat.RoleUser {
+		return Message{}, ErrWrongRole
+	}
+	msg := chat.NewMessage(chat.RoleUser, content)
+	msg.ParentID = t.parentOf(i)
+	if err := s.AddMessage(ctx, sessionID, msg); err != nil {
+		return Message{}, err
+	}
+	return msg, nil
+}
+
+// ReplyTo returns the ID of the message the assistant reply messageID
+// answers. A regenerated reply is added under it.
+func (s *Service) ReplyTo(ctx context.Context, sessionID, messageID string) (string, error) {
+	t, i, err := s.findMessage(ctx, sessionID, messageID)
+	if err != nil {
+		return "", err
+	}
+	if t.messages[i].Role != chat.RoleAssistant {
+		return "", ErrWrongRole
+	}
+	parent := t.parentOf(i)
+	if parent == chat.NoParent {
+		return "", ErrWrongRole
+	}
+	return parent, nil
+}
+
+// SwitchBranch makes the branch through messageID active, continuing down
+// to its most recent leaf, and returns that leaf's ID.
+func (s *Service) SwitchBranch(ctx context.Context, sessionID, messageID string) (string, error) {
+	t, i, err := s.findMessage(ctx, sessionID, messageID)
+	if err != nil {
+		return "", err
+	}
+	leaf := t.messages[t.latestLeaf(i)].ID
+	if err := s.store.Update(ctx, sessionID, SessionUpdate{ActiveLeaf: &leaf}); err != nil {
+		return "", err
+	}
+	return leaf, nil
+}
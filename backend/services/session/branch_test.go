@@ -0,0 +1,137 @@
+package session
+
+import (
+	"context"
+	"testing"
+
+	"csdeepseek/backend/models/chat"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func contents(messages []Message) []string {
+	var out []string
+	for _, m := range messages {
+		out = append(out, m.Content)
+	}
+	return out
+}
+
+func TestActivePath_LegacyChain(t *testing.T) {
+	sess := &Session{Messages: []Message{
+		{Role: "user", Content: "q1"},
+		{Role: "assistant", Content: "a1"},
+		{ID: "m3", Role: "user", Content: "q2"},
+	}}
+	assert.Equal(t, []string{"q1", "a1", "q2"}, contents(sess.ActivePath()), "messages without parents form a chain")
+	assert.Empty(t, (&Session{}).ActivePath())
+}
+
+func TestBranching(t *testing.T) {
+	svc := NewServiceWithStore(NewMemoryStore())
+	ctx := context.Background()
+	sess, err := svc.CreateSession(ctx)
+	require.NoError(t, err)
+
+	add := func(role, content string) Message {
+		msg := chat.NewMessage(role, content)
+		require.NoError(t, svc.AddMessage(ctx, sess.ID, msg))
+		return msg
+	}
+	path := func() []string {
+		got, err := svc.GetSession(ctx, sess.ID)
+		require.NoError(t, err)
+		return contents(got.ActivePath())
+	}
+
+	q1 := add("user", "q1")
+	a1 := add("assistant", "a1")
+	q2 := add("user", "q2")
+	add("assistant", "a2")
+	assert.Equal(t, []string{"q1", "a1", "q2", "a2"}, path())
+
+	// Editing the second question branches off after the first answer
+	edited, err := svc.EditMessage(ctx, sess.ID, q2.ID, "q2 edited")
+	require.NoError(t, err)
+	assert.Equal(t, a1.ID, edited.ParentID)
+	assert.Equal(t, []string{"q1", "a1", "q2 edited"}, path())
+	add("assistant", "a2 for edit")
+
+	// Regenerating the first answer adds a reply under the first question
+	parent, err := svc.ReplyTo(ctx, sess.ID, a1.ID)
+	require.NoError(t, err)
+	assert.Equal(t, q1.ID, parent)
+	regenerated := chat.NewMessage("assistant", "a1 again")
+	regenerated.ParentID = parent
+	require.NoError(t, svc.AddMessage(ctx, sess.ID, regenerated))
+	assert.Equal(t, []string{"q1", "a1 again"}, path())
+
+	got, err := svc.GetSession(ctx, sess.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{a1.ID, regenerated.ID}, got.Versions(a1.ID))
+	assert.Equal(t, []string{q2.ID, edited.ID}, got.Versions(edited.ID))
+	assert.Equal(t, []string{"q1", "a1", "q2 edited"}, contents(got.PathTo(edited.ID)))
+
+	// Switching back follows the most recent continuation of the branch
+	leaf, err := svc.SwitchBranch(ctx, sess.ID, a1.ID)
+	require.NoError(t, err)
+	got, err = svc.GetSession(ctx, sess.ID)
+	require.NoError(t, err)
+	assert.Equal(t, leaf, got.ActiveLeaf)
+	assert.Equal(t, []string{"q1", "a1", "q2 edited", "a2 for edit"}, path())
+	_, err = svc.SwitchBranch(ctx, sess.ID, q2.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"q1", "a1", "q2", "a2"}, path())
+
+	// New messages continue the active branch
+	add("user", "q3")
+	assert.Equal(t, []string{"q1", "a1", "q2", "a2", "q3"}, path())
+	assert.Len(t, getSession(t, svc, sess.ID).Messages, 8, "no message is ever lost")
+}
+
+func TestBranching_EditFirstMessage(t *testing.T) {
+	svc := NewServiceWithStore(NewMemoryStore())
+	ctx := context.Background()
+	sess, err := svc.CreateSession(ctx)
+	require.NoError(t, err)
+	q1 := chat.NewMessage("user", "q1")
+	require.NoError(t, svc.AddMessage(ctx, sess.ID, q1))
+	require.NoError(t, svc.AddMessage(ctx, sess.ID, chat.NewMessage("assistant", "a1")))
+
+	edited, err := svc.EditMessage(ctx, sess.ID, q1.ID, "q1 edited")
+	require.NoError(t, err)
+	assert.Equal(t, chat.NoParent, edited.ParentID)
+	got := getSession(t, svc, sess.ID)
+	assert.Equal(t, []string{"q1 edited"}, contents(got.ActivePath()))
+	assert.Equal(t, []string{q1.ID, edited.ID}, got.Versions(q1.ID))
+}
+
+func TestBranching_Errors(t *testing.T) {
+	svc := NewServiceWithStore(NewMemoryStore())
+	ctx := context.Background()
+	sess, err := svc.CreateSession(ctx)
+	require.NoError(t, err)
+	q := chat.NewMessage("user", "q")
+	a := chat.NewMessage("assistant", "a")
+	require.NoError(t, svc.AddMessage(ctx, sess.ID, q))
+	require.NoError(t, svc.AddMessage(ctx, sess.ID, a))
+
+	_, err = svc.EditMessage(ctx, sess.ID, a.ID, "x")
+	assert.ErrorIs(t, err, ErrWrongRole)
+	_, err = svc.ReplyTo(ctx, sess.ID, q.ID)
+	assert.ErrorIs(t, err, ErrWrongRole)
+	_, err = svc.EditMessage(ctx, sess.ID, "msg_missing", "x")
+	assert.ErrorIs(t, err, ErrMessageNotFound)
+	_, err = svc.SwitchBranch(ctx, sess.ID, "msg_missing")
+	assert.ErrorIs(t, err, ErrMessageNotFound)
+	_, err = svc.SwitchBranch(ctx, "missing", q.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func getSession(t *testing.T, svc *Service, id string) *Session {
+	t.Helper()
+	sess, err := svc.GetSession(context.Background(), id)
+	require.NoError(t, err)
+	return sess
+}
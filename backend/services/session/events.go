@@ -0,0 +1,161 @@
+package session
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// Event types published on the service's bus.
+const (
+	// EventSessionCreated reports a new session, or one restored from the
+	// trash
+	EventSessionCreated = "session_created"
+	// EventMessageAdded carries a message added to the session
+	EventMessageAdded = "message_added"
+	// EventTitleChanged carries the session's new title
+	EventTitleChanged = "title_changed"
+	// EventSessionDeleted reports the session deleted, evicted or moved to
+	// the trash
+	EventSessionDeleted = "session_deleted"
+	// EventStreamToken carries the next piece of a reply being streamed
+	EventStreamToken = "stream_token"
+)
+
+// AllSessions subscribes to the events of every session, for keeping data
+// derived from sessions in step across replicas.
+const AllSessions = "*"
+
+// Event is a change to a session that clients showing it should see live.
+type Event struct {
+	Type      string `json:"type"`
+	SessionID string `json:"session_id"`
+	// Message is set for message_added
+	Message *Message `json:"message,omitempty"`
+	// Title is set for title_changed
+	Title string `json:"title,omitempty"`
+	// MessageID is the reply a stream_token belongs to, Token its text
+	MessageID string `json:"message_id,omitempty"`
+	Token     string `json:"token,omitempty"`
+	// Source names whoever caused the event, as set with WithSource, so a
+	// subscriber can skip the events it caused itself
+	Source string `json:"source,omitempty"`
+}
+
+// Bus delivers session events to the subscribers of each session. The
+// default LocalBus reaches subscribers in this process; a bus backed by a
+// broker, such as RedisBus, carries events between replicas as well.
+type Bus interface {
+	// Publish delivers ev to the subscribers of ev.SessionID.
+	Publish(ctx context.Context, ev Event) error
+	// Subscribe calls fn with every event of the session, or of all
+	// sessions for AllSessions, published from now on, until the returned
+	// function is called. fn may be called from several goroutines at once
+	// and should not block for long.
+	Subscribe(sessionID string, fn func(Event)) (unsubscribe func())
+	// Close stops delivering events.
+	Close() error
+}
+
+// LocalBus delivers events to subscribers in this process, synchronously
+// from Publish.
+type LocalBus struct {
+	mu   sync.RWMutex
+	subs map[string]map[*subscriber]bool
+}
+
+type subscriber struct {
+	fn func(Event)
+}
+
+func NewLocalBus() *LocalBus {
+	return &LocalBus{subs: make(map[string]map[*subscriber]bool)}
+}
+
+func (b *LocalBus) Publish(ctx context.Context, ev Event) error {
+	b.deliver(ev)
+	return nil
+}
+
+// deliver calls the subscribers of the event's session and those of all
+// sessions. They are called without the lock held, so they may subscribe
+// and unsubscribe.
+func (b *LocalBus) deliver(ev Event) {
+	b.mu.RLock()
+	subs := make([]*subscriber, 0, len(b.subs[ev.SessionID])+len(b.subs[AllSessions]))
+	for sub := range b.subs[ev.SessionID] {
+		subs = append(subs, sub)
+	}
+	for sub := range b.subs[AllSessions] {
+		subs = append(subs, sub)
+	}
+	b.mu.RUnlock()
+
+	for _, sub := range subs {
+		sub.fn(ev)
+	}
+}
+
+func (b *LocalBus) Subscribe(sessionID string, fn func(Event)) func() {
+	sub := &subscriber{fn: fn}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs[sessionID] == nil {
+		b.subs[sessionID] = make(map[*subscriber]bool)
+	}
+	b.subs[sessionID][sub] = true
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.subs[sessionID], sub)
+			if len(b.subs[sessionID]) == 0 {
+				delete(b.subs, sessionID)
+			}
+		})
+	}
+}
+
+func (b *LocalBus) Close() error {
+	return nil
+}
+
+type sourceKey struct{}
+
+// WithSource marks the events published with ctx, including those the
+// service publishes for changes made with it, as caused by source.
+func WithSource(ctx context.Context, source string) context.Context {
+	return context.WithValue(ctx, sourceKey{}, source)
+}
+
+// SetBus replaces the in-process bus, such as with one shared between
+// replicas. It must be called before the service is used; the service
+// closes the bus when it is closed.
+func (s *Service) SetBus(bus Bus) {
+	s.bus = bus
+}
+
+// Publish sends an event to the session's subscribers. An empty Source is
+// taken from ctx.
+func (s *Service) Publish(ctx context.Context, ev Event) error {
+	if ev.Source == "" {
+		ev.Source, _ = ctx.Value(sourceKey{}).(string)
+	}
+	return s.bus.Publish(ctx, ev)
+}
+
+// Subscribe calls fn with the session's events until the returned function
+// is called. It does not check who may see the session; callers must.
+func (s *Service) Subscribe(sessionID string, fn func(Event)) (unsubscribe func()) {
+	return s.bus.Subscribe(sessionID, fn)
+}
+
+// publish is Publish for events that are a side effect of a change already
+// made, where failing to deliver them must not fail the change.
+func (s *Service) publish(ctx context.Context, ev Event) {
+	if err := s.Publish(ctx, ev); err != nil {
+		log.Printf("Failed to publish %s event for session %s: %v", ev.Type, ev.SessionID, err)
+	}
+}
@@ -0,0 +1,85 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisBus carries session events between replicas through Redis pub/sub.
+// Events are published on <prefix>events:<session ID>, and every replica
+// receives all of them through one pattern subscription, handing each to
+// its local subscribers. Events published while a replica is disconnected
+// are lost to it.
+type RedisBus struct {
+	client *redis.Client
+	prefix string
+	pubsub *redis.PubSub
+	local  *LocalBus
+	done   chan struct{}
+}
+
+// NewRedisBus subscribes to the events under prefix and starts delivering
+// them. The client is not closed with the bus.
+func NewRedisBus(ctx context.Context, client *redis.Client, prefix string) (*RedisBus, error) {
+	if prefix == "" {
+		prefix = defaultRedisPrefix
+	}
+	pubsub := client.PSubscribe(ctx, prefix+"events:*")
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, fmt.Errorf("failed to subscribe to session events: %w", err)
+	}
+	b := &RedisBus{
+		client: client,
+		prefix: prefix,
+		pubsub: pubsub,
+		local:  NewLocalBus(),
+		done:   make(chan struct{}),
+	}
+	go b.run()
+	return b, nil
+}
+
+func (b *RedisBus) channel(sessionID string) string {
+	return b.prefix + "events:" + sessionID
+}
+
+func (b *RedisBus) run() {
+	defer close(b.done)
+	for msg := range b.pubsub.Channel() {
+		var ev Event
+		if err := json.Unmarshal([]byte(msg.Payload), &ev); err != nil {
+			log.Printf("Ignoring unreadable session event on %s: %v", msg.Channel, err)
+			continue
+		}
+		b.local.deliver(ev)
+	}
+}
+
+// Publish sends ev through Redis, which hands it back to this replica's
+// subscribers along with everyone else's.
+func (b *RedisBus) Publish(ctx context.Context, ev Event) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("failed to encode session event: %w", err)
+	}
+	if err := b.client.Publish(ctx, b.channel(ev.SessionID), data).Err(); err != nil {
+		return fmt.Errorf("failed to publish session event: %w", err)
+	}
+	return nil
+}
+
+func (b *RedisBus) Subscribe(sessionID string, fn func(Event)) func() {
+	return b.local.Subscribe(sessionID, fn)
+}
+
+// Close ends the subscription and waits for delivery to stop.
+func (b *RedisBus) Close() error {
+	err := b.pubsub.Close()
+	<-b.done
+	return err
+}
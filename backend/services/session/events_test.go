@@ -0,0 +1,105 @@
+package session
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"csdeepseek/backend/models/chat"
+)
+
+// eventLog collects the events of a subscription.
+type eventLog struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (l *eventLog) add(ev Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, ev)
+}
+
+func (l *eventLog) types() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var types []string
+	for _, ev := range l.events {
+		types = append(types, ev.Type)
+	}
+	return types
+}
+
+func TestServiceEvents(t *testing.T) {
+	svc := NewServiceWithStore(NewMemoryStore())
+	ctx := context.Background()
+	sess, err := svc.CreateSessionForUser(ctx, "alice")
+	require.NoError(t, err)
+	other, err := svc.CreateSessionForUser(ctx, "alice")
+	require.NoError(t, err)
+
+	events := &eventLog{}
+	unsubscribe := svc.Subscribe(sess.ID, events.add)
+	svc.Subscribe(other.ID, func(ev Event) { t.Errorf("unexpected event for another session: %+v", ev) })
+
+	require.NoError(t, svc.AddMessage(WithSource(ctx, "phone"), sess.ID, Message{Role: chat.RoleUser, Content: "Hello"}))
+	require.NoError(t, svc.Publish(ctx, Event{Type: EventStreamToken, SessionID: sess.ID, MessageID: "m2", Token: "Hi"}))
+	require.NoError(t, svc.UpdateSession(ctx, sess.ID, SessionUpdate{Title: ptr("Greeting")}))
+	require.NoError(t, svc.UpdateSession(ctx, sess.ID, SessionUpdate{Pinned: ptr(true)}))
+	require.NoError(t, svc.DeleteSession(ctx, sess.ID))
+
+	assert.Equal(t, []string{EventMessageAdded, EventStreamToken, EventTitleChanged, EventSessionDeleted}, events.types())
+	assert.Equal(t, "Hello", events.events[0].Message.Content)
+	assert.Equal(t, "phone", events.events[0].Source, "the source comes from the context")
+	assert.Equal(t, "Greeting", events.events[2].Title)
+
+	unsubscribe()
+	unsubscribe()
+	require.NoError(t, svc.Publish(ctx, Event{Type: EventStreamToken, SessionID: sess.ID}))
+	assert.Len(t, events.types(), 4)
+}
+
+func TestRedisBus_CarriesEventsBetweenReplicas(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	newReplica := func() *Service {
+		store := NewRedisStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "", 0)
+		svc := NewServiceWithStore(store)
+		bus, err := NewRedisBus(ctx, store.client, store.prefix)
+		require.NoError(t, err)
+		svc.SetBus(bus)
+		t.Cleanup(func() { svc.Close() })
+		return svc
+	}
+	first, second := newReplica(), newReplica()
+	created := []*eventLog{{}, {}}
+	first.Subscribe(AllSessions, created[0].add)
+	second.Subscribe(AllSessions, created[1].add)
+
+	// The creation reaches every replica before the session is followed
+	sess, err := first.CreateSessionForUser(ctx, "alice")
+	require.NoError(t, err)
+	for _, events := range created {
+		assert.Eventually(t, func() bool { return len(events.types()) == 1 }, time.Second, 10*time.Millisecond)
+		assert.Equal(t, []string{EventSessionCreated}, events.types())
+	}
+	local, remote := &eventLog{}, &eventLog{}
+	first.Subscribe(sess.ID, local.add)
+	second.Subscribe(sess.ID, remote.add)
+
+	require.NoError(t, first.AddMessage(ctx, sess.ID, Message{Role: chat.RoleUser, Content: "Hello"}))
+	require.NoError(t, second.UpdateSession(ctx, sess.ID, SessionUpdate{Title: ptr("Greeting")}))
+
+	want := []string{EventMessageAdded, EventTitleChanged}
+	for _, events := range []*eventLog{local, remote} {
+		assert.Eventually(t, func() bool { return len(events.types()) == 2 }, time.Second, 10*time.Millisecond)
+		assert.Equal(t, want, events.types())
+	}
+	assert.Equal(t, "Hello", remote.events[0].Message.Content)
+}
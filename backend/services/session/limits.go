@@ -0,0 +1,311 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Limit policies decide what happens when a session cap is reached.
+const (
+	// PolicyEvict moves least recently used sessions to the trash to make
+	// room: the creating user's own at the per-user cap, and at the total
+	// cap unpinned sessions of anyone, anonymous ones first. Sessions in the
+	// middle of a turn are never evicted.
+	PolicyEvict = "evict"
+	// PolicyReject refuses the new session
+	PolicyReject = "reject"
+)
+
+var (
+	ErrLimitExceeded   = errors.New("session limit reached")
+	ErrMessageTooLarge = errors.New("message too large")
+)
+
+// Limits caps how much the service stores. Zero means no cap. Sessions in
+// the trash do not count toward the session caps, as the trash is emptied
+// by retention. When a cap on sessions is reached, Policy decides between
+// evicting and rejecting; messages over their caps are always rejected.
+type Limits struct {
+	MaxSessions        int    `json:"max_sessions"`
+	MaxSessionsPerUser int    `json:"max_sessions_per_user"`
+	MaxMessages        int    `json:"max_messages_per_session"`
+	MaxMessageBytes    int    `json:"max_message_bytes"`
+	Policy             string `json:"policy"`
+}
+
+// LimitsFromEnv reads MAX_SESSIONS, MAX_SESSIONS_PER_USER,
+// MAX_MESSAGES_PER_SESSION, MAX_MESSAGE_BYTES and SESSION_LIMIT_POLICY.
+func LimitsFromEnv() Limits {
+	limits := Limits{
+		MaxSessions:        1000,
+		MaxSessionsPerUser: 100,
+		MaxMessages:        1000,
+		MaxMessageBytes:    64 << 10,
+		Policy:             PolicyEvict,
+	}
+	for name, limit := range map[string]*int{
+		"MAX_SESSIONS":             &limits.MaxSessions,
+		"MAX_SESSIONS_PER_USER":    &limits.MaxSessionsPerUser,
+		"MAX_MESSAGES_PER_SESSION": &limits.MaxMessages,
+		"MAX_MESSAGE_BYTES":        &limits.MaxMessageBytes,
+	} {
+		if n, err := strconv.Atoi(os.Getenv(name)); err == nil && n >= 0 {
+			*limit = n
+		}
+	}
+	switch policy := strings.ToLower(os.Getenv("SESSION_LIMIT_POLICY")); policy {
+	case PolicyEvict, PolicyReject:
+		limits.Policy = policy
+	case "":
+	default:
+		log.Printf("Unknown SESSION_LIMIT_POLICY %q, using %s", policy, limits.Policy)
+	}
+	return limits
+}
+
+// LimitStats counts what the limits did since the service started.
+type LimitStats struct {
+	Evictions        uint64 `json:"evictions"`
+	RejectedSessions uint64 `json:"rejected_sessions"`
+	RejectedMessages uint64 `json:"rejected_messages"`
+}
+
+// limiter enforces Limits and counts its interventions.
+type limiter struct {
+	limits Limits
+	// mu serializes session creation, so that concurrent creates cannot
+	// overshoot a cap. Replicas sharing a store may still overshoot briefly.
+	mu sync.Mutex
+
+	// countMu guards the counts of sessions outside the trash, loaded from
+	// the store on the first create and kept up to date as sessions come
+	// and go. Sessions other replicas create or remove, or that the store
+	// expires, are noticed when the cleanup pass counts again.
+	countMu sync.Mutex
+	counted bool
+	total   int
+	perUser map[string]int
+
+	evictions        atomic.Uint64
+	rejectedSessions atomic.Uint64
+	rejectedMessages atomic.Uint64
+}
+
+// Limits returns the caps the service enforces.
+func (s *Service) Limits() Limits {
+	return s.limiter.limits
+}
+
+// LimitStats returns the limit counters.
+func (s *Service) LimitStats() LimitStats {
+	return LimitStats{
+		Evictions:        s.limiter.evictions.Load(),
+		RejectedSessions: s.limiter.rejectedSessions.Load(),
+		RejectedMessages: s.limiter.rejectedMessages.Load(),
+	}
+}
+
+// enabled reports whether any session cap is set.
+func (l *limiter) enabled() bool {
+	return l.limits.MaxSessions > 0 || l.limits.MaxSessionsPerUser > 0
+}
+
+// recount replaces the session counts with those of infos.
+func (l *limiter) recount(infos []SessionInfo) {
+	l.countMu.Lock()
+	defer l.countMu.Unlock()
+
+	l.total, l.perUser = 0, make(map[string]int)
+	for _, info := range infos {
+		if info.DeletedAt.IsZero() {
+			l.total++
+			l.perUser[info.UserID]++
+		}
+	}
+	l.counted = true
+}
+
+// count adds n sessions of userID to the counts, once they are loaded.
+func (l *limiter) count(userID string, n int) {
+	l.countMu.Lock()
+	defer l.countMu.Unlock()
+
+	if !l.counted {
+		return
+	}
+	l.total = max(l.total+n, 0)
+	if owned := l.perUser[userID] + n; owned > 0 {
+		l.perUser[userID] = owned
+	} else {
+		delete(l.perUser, userID)
+	}
+}
+
+// counts returns the number of sessions outside the trash, in total and of
+// userID, and whether they are loaded.
+func (l *limiter) counts(userID string) (total, owned int, ok bool) {
+	l.countMu.Lock()
+	defer l.countMu.Unlock()
+	return l.total, l.perUser[userID], l.counted
+}
+
+// createWithin stores a new session once there is room for it under the
+// session caps, evicting or rejecting as the policy says.
+func (s *Service) createWithin(ctx context.Context, sess *Session) error {
+	l := s.limiter
+	if !l.enabled() {
+		return s.store.Create(ctx, sess)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	total, owned, ok := l.counts(sess.UserID)
+	if !ok {
+		infos, err := s.store.Infos(ctx)
+		if err != nil {
+			return err
+		}
+		l.recount(infos)
+		total, owned, _ = l.counts(sess.UserID)
+	}
+	// Anonymous sessions belong to nobody in particular, so only the total
+	// cap applies to them
+	var userExcess, totalExcess int
+	if l.limits.MaxSessionsPerUser > 0 && sess.UserID != "" {
+		userExcess = owned - l.limits.MaxSessionsPerUser + 1
+	}
+	if l.limits.MaxSessions > 0 {
+		totalExcess = total - l.limits.MaxSessions + 1
+	}
+	if userExcess > 0 || totalExcess > 0 {
+		if err := s.makeRoom(ctx, sess.UserID, userExcess, totalExcess); err != nil {
+			return err
+		}
+	}
+	if err := s.store.Create(ctx, sess); err != nil {
+		return err
+	}
+	l.count(sess.UserID, 1)
+	return nil
+}
+
+// makeRoom moves sessions to the trash so a new session of userID fits
+// under the caps: userExcess of the user's own, pinned ones last, then as
+// many unpinned sessions of anyone as are still over the total cap, with
+// anonymous ones going before users' and the least recently used first.
+// Sessions in the middle of a turn are never evicted.
+func (s *Service) makeRoom(ctx context.Context, userID string, userExcess, totalExcess int) error {
+	limits := s.limiter.limits
+	if limits.Policy == PolicyReject {
+		if userExcess > 0 {
+			return s.rejectSession(limits.MaxSessionsPerUser, "sessions per user", "")
+		}
+		return s.rejectSession(limits.MaxSessions, "sessions", "")
+	}
+
+	infos, err := s.store.Infos(ctx)
+	if err != nil {
+		return err
+	}
+	evicted := make(map[string]bool)
+	if userExcess > 0 {
+		var own []SessionInfo
+		for _, info := range infos {
+			if info.UserID == userID && s.evictable(info) {
+				own = append(own, info)
+			}
+		}
+		sort.Slice(own, func(i, j int) bool {
+			if own[i].Pinned != own[j].Pinned {
+				return !own[i].Pinned
+			}
+			return own[i].UpdatedAt.Before(own[j].UpdatedAt)
+		})
+		if len(own) < userExcess {
+			return s.rejectSession(limits.MaxSessionsPerUser, "sessions per user", ", none of the user's to spare")
+		}
+		if err := s.evict(ctx, own[:userExcess], limits.MaxSessionsPerUser, "sessions per user", evicted); err != nil {
+			return err
+		}
+		totalExcess -= userExcess
+	}
+	if totalExcess <= 0 {
+		return nil
+	}
+
+	var idle []SessionInfo
+	for _, info := range infos {
+		if !info.Pinned && !evicted[info.ID] && s.evictable(info) {
+			idle = append(idle, info)
+		}
+	}
+	sort.Slice(idle, func(i, j int) bool {
+		if anon := idle[i].UserID == ""; anon != (idle[j].UserID == "") {
+			return anon
+		}
+		return idle[i].UpdatedAt.Before(idle[j].UpdatedAt)
+	})
+	if len(idle) < totalExcess {
+		return s.rejectSession(limits.MaxSessions, "sessions", ", all pinned or in use")
+	}
+	return s.evict(ctx, idle[:totalExcess], limits.MaxSessions, "sessions", evicted)
+}
+
+// evictable reports whether a session may be evicted at all: it is not in
+// the trash already, and not in the middle of a turn.
+func (s *Service) evictable(info SessionInfo) bool {
+	return info.DeletedAt.IsZero() && !s.turns.busy(info.ID)
+}
+
+// evict moves sessions to the trash to stay within limit, the cap named
+// name, recording their IDs in evicted.
+func (s *Service) evict(ctx context.Context, sessions []SessionInfo, limit int, name string, evicted map[string]bool) error {
+	now := time.Now()
+	for _, info := range sessions {
+		err := s.trash(ctx, info.ID, info.UserID, now)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("failed to evict session %s: %w", info.ID, err)
+		}
+		evicted[info.ID] = true
+		s.limiter.evictions.Add(1)
+		log.Printf("Evicted session %s, idle since %v, to stay within %d %s", info.ID, info.UpdatedAt, limit, name)
+	}
+	return nil
+}
+
+// rejectSession counts and returns the error refusing a new session at
+// limit, the cap named name, with an optional reason.
+func (s *Service) rejectSession(limit int, name, reason string) error {
+	s.limiter.rejectedSessions.Add(1)
+	return fmt.Errorf("%w: %d %s%s", ErrLimitExceeded, limit, name, reason)
+}
+
+// checkMessageSize applies the cap on message size.
+func (s *Service) checkMessageSize(msg Message) error {
+	limits := s.limiter.limits
+	if limits.MaxMessageBytes > 0 && len(msg.Content) > limits.MaxMessageBytes {
+		s.limiter.rejectedMessages.Add(1)
+		return fmt.Errorf("%w: over %d bytes", ErrMessageTooLarge, limits.MaxMessageBytes)
+	}
+	return nil
+}
+
+// checkMessageCount applies the cap on messages per session to a message
+// joining a session that holds count messages.
+func (s *Service) checkMessageCount(count int) error {
+	limits := s.limiter.limits
+	if limits.MaxMessages > 0 && count >= limits.MaxMessages {
+		s.limiter.rejectedMessages.Add(1)
+		return fmt.Errorf("%w: %d messages per session", ErrLimitExceeded, limits.MaxMessages)
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package session
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"csdeepseek/backend/models/chat"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newLimitedService(limits Limits) *Service {
+	svc := NewServiceWithStore(NewMemoryStore())
+	svc.limiter = &limiter{limits: limits}
+	return svc
+}
+
+// age makes a stored session look last used d ago.
+func age(svc *Service, id string, d time.Duration) {
+	store := svc.store.(*MemoryStore)
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.sessions[id].UpdatedAt = time.Now().Add(-d)
+}
+
+func TestLimitsFromEnv(t *testing.T) {
+	t.Setenv("MAX_SESSIONS", "50")
+	t.Setenv("MAX_SESSIONS_PER_USER", "0")
+	t.Setenv("MAX_MESSAGES_PER_SESSION", "bad")
+	t.Setenv("MAX_MESSAGE_BYTES", "")
+	t.Setenv("SESSION_LIMIT_POLICY", "Reject")
+
+	limits := LimitsFromEnv()
+	assert.Equal(t, Limits{
+		MaxSessions:        50,
+		MaxSessionsPerUser: 0,
+		MaxMessages:        1000,
+		MaxMessageBytes:    64 << 10,
+		Policy:             PolicyReject,
+	}, limits)
+}
+
+func TestLimits_EvictLeastRecentlyUsed(t *testing.T) {
+	svc := newLimitedService(Limits{MaxSessions: 4, MaxSessionsPerUser: 3, Policy: PolicyEvict})
+	observer := &recordingObserver{}
+	svc.Observe(observer)
+	ctx := context.Background()
+
+	oldest, err := svc.CreateSessionForUser(ctx, "alice")
+	require.NoError(t, err)
+	age(svc, oldest.ID, 3*time.Hour)
+	pinned, err := svc.CreateSessionForUser(ctx, "alice")
+	require.NoError(t, err)
+	require.NoError(t, svc.UpdateSession(ctx, pinned.ID, SessionUpdate{Pinned: ptr(true)}))
+	age(svc, pinned.ID, 4*time.Hour)
+	older, err := svc.CreateSessionForUser(ctx, "alice")
+	require.NoError(t, err)
+	age(svc, older.ID, 2*time.Hour)
+	anonymous, err := svc.CreateSession(ctx)
+	require.NoError(t, err)
+
+	// A user at their cap makes room with their own oldest unpinned
+	// session, which goes to the trash
+	_, err = svc.CreateSessionForUser(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, []string{oldest.ID}, observer.deleted)
+
+	// At the total cap, anonymous sessions go first, then the least
+	// recently used of anyone's; pinned sessions stay
+	_, err = svc.CreateSessionForUser(ctx, "bob")
+	require.NoError(t, err)
+	_, err = svc.CreateSessionForUser(ctx, "carol")
+	require.NoError(t, err)
+	assert.Equal(t, []string{oldest.ID, anonymous.ID, older.ID}, observer.deleted)
+	_, err = svc.GetSession(ctx, pinned.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, LimitStats{Evictions: 3}, svc.LimitStats())
+
+	restored, err := svc.RestoreSession(ctx, "alice", oldest.ID)
+	require.NoError(t, err, "evicted sessions can be restored")
+	assert.Equal(t, oldest.ID, restored.ID)
+}
+
+func TestLimits_NewUsersGetRoomAtTotalCap(t *testing.T) {
+	svc := newLimitedService(Limits{MaxSessions: 3, Policy: PolicyEvict})
+	ctx := context.Background()
+
+	// A bot fills the store with anonymous sessions
+	for i := 0; i < 3; i++ {
+		_, err := svc.CreateSession(ctx)
+		require.NoError(t, err)
+	}
+
+	alice, err := svc.CreateSessionForUser(ctx, "alice")
+	require.NoError(t, err, "a new user can still start a session")
+
+	// The bot keeps going, evicting only its own sessions
+	for i := 0; i < 5; i++ {
+		_, err := svc.CreateSession(ctx)
+		require.NoError(t, err)
+	}
+	_, err = svc.GetSession(ctx, alice.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, LimitStats{Evictions: 6}, svc.LimitStats())
+}
+
+func TestLimits_SkipsSessionsInUse(t *testing.T) {
+	svc := newLimitedService(Limits{MaxSessionsPerUser: 1, Policy: PolicyEvict})
+	ctx := context.Background()
+
+	busy, err := svc.CreateSessionForUser(ctx, "alice")
+	require.NoError(t, err)
+	unlock, err := svc.LockTurn(ctx, busy.ID)
+	require.NoError(t, err)
+
+	_, err = svc.CreateSessionForUser(ctx, "alice")
+	assert.ErrorIs(t, err, ErrLimitExceeded)
+	unlock()
+
+	_, err = svc.CreateSessionForUser(ctx, "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, LimitStats{Evictions: 1, RejectedSessions: 1}, svc.LimitStats())
+}
+
+func TestLimits_CountsWithoutRescanning(t *testing.T) {
+	store := &readCountingStore{Store: NewMemoryStore()}
+	svc := NewServiceWithStore(store)
+	svc.limiter = &limiter{limits: Limits{MaxSessionsPerUser: 2, Policy: PolicyReject}}
+	ctx := context.Background()
+
+	first, err := svc.CreateSessionForUser(ctx, "alice")
+	require.NoError(t, err)
+	_, err = svc.CreateSessionForUser(ctx, "alice")
+	require.NoError(t, err)
+	_, err = svc.CreateSessionForUser(ctx, "alice")
+	assert.ErrorIs(t, err, ErrLimitExceeded)
+
+	// Sessions in the trash make room, and restoring them takes it again
+	require.NoError(t, svc.DeleteSession(ctx, first.ID))
+	third, err := svc.CreateSessionForUser(ctx, "alice")
+	require.NoError(t, err)
+	require.NoError(t, svc.DeleteSession(ctx, third.ID))
+	_, err = svc.RestoreSession(ctx, "alice", first.ID)
+	require.NoError(t, err)
+	_, err = svc.CreateSessionForUser(ctx, "alice")
+	assert.ErrorIs(t, err, ErrLimitExceeded)
+	assert.EqualValues(t, 1, store.infos.Load(), "the store is scanned once, on the first create")
+
+	// The cleanup pass counts again, catching up with other replicas
+	require.NoError(t, store.Store.Delete(ctx, first.ID))
+	_, err = svc.Cleanup(ctx)
+	require.NoError(t, err)
+	_, err = svc.CreateSessionForUser(ctx, "alice")
+	assert.NoError(t, err)
+}
+
+func TestLimits_Reject(t *testing.T) {
+	svc := newLimitedService(Limits{MaxSessionsPerUser: 1, MaxMessages: 2, MaxMessageBytes: 10, Policy: PolicyReject})
+	ctx := context.Background()
+
+	sess, err := svc.CreateSessionForUser(ctx, "alice")
+	require.NoError(t, err)
+	_, err = svc.CreateSessionForUser(ctx, "alice")
+	assert.ErrorIs(t, err, ErrLimitExceeded)
+	_, err = svc.CreateSessionForUser(ctx, "bob")
+	assert.NoError(t, err)
+	_, err = svc.CreateSession(ctx)
+	assert.NoError(t, err, "anonymous sessions have no per-user cap")
+
+	assert.ErrorIs(t, svc.AddMessage(ctx, sess.ID, Message{Role: chat.RoleUser, Content: strings.Repeat("x", 11)}), ErrMessageTooLarge)
+	require.NoError(t, svc.AddMessage(ctx, sess.ID, Message{Role: chat.RoleUser, Content: "Hello"}))
+	require.NoError(t, svc.AddMessage(ctx, sess.ID, Message{Role: chat.RoleAssistant, Content: "Hi"}))
+	assert.ErrorIs(t, svc.AddMessage(ctx, sess.ID, Message{Role: chat.RoleUser, Content: "More"}), ErrLimitExceeded)
+
+	_, err = svc.ImportSession(ctx, "carol", &Session{Messages: []Message{
+		{Role: chat.RoleUser, Content: "a"}, {Role: chat.RoleAssistant, Content: "b"}, {Role: chat.RoleUser, Content: "c"},
+	}})
+	assert.ErrorIs(t, err, ErrLimitExceeded)
+
+	assert.Equal(t, LimitStats{RejectedSessions: 1, RejectedMessages: 3}, svc.LimitStats())
+}
+
+func ptr[T any](v T) *T { return &v }
@@ -0,0 +1,59 @@
+package session
+
+import "context"
+
+// Observer is told about changes to sessions made through the Service, so
+// that data derived from them, such as a search index, can be kept in step.
+// Calls are made after the store has accepted the change, synchronously, and
+// may come from several goroutines at once.
+//
+// Sessions expired by the store on its own, as Redis does with a TTL, are
+// not reported.
+type Observer interface {
+	// SessionCreated reports a new session, or one restored from the
+	// trash, with any messages it already holds.
+	SessionCreated(sess *Session)
+	// MessageAdded reports a message added to a session.
+	MessageAdded(sessionID string, msg Message)
+	// SessionsDeleted reports sessions deleted, evicted or moved to the
+	// trash. Sessions later purged from the trash are not reported again.
+	SessionsDeleted(ids []string)
+}
+
+// Observe registers o to be told about changes. It must be called before
+// the service is used.
+func (s *Service) Observe(o Observer) {
+	s.observers = append(s.observers, o)
+}
+
+// notifyCreated tells observers and the subscribers of all sessions about a
+// new or restored session.
+func (s *Service) notifyCreated(ctx context.Context, sess *Session) {
+	for _, o := range s.observers {
+		o.SessionCreated(sess.clone())
+	}
+	s.publish(ctx, Event{Type: EventSessionCreated, SessionID: sess.ID})
+}
+
+// notifyMessage tells observers and the session's subscribers about a new
+// message.
+func (s *Service) notifyMessage(ctx context.Context, sessionID string, msg Message) {
+	for _, o := range s.observers {
+		o.MessageAdded(sessionID, msg)
+	}
+	s.publish(ctx, Event{Type: EventMessageAdded, SessionID: sessionID, Message: &msg})
+}
+
+// notifyDeleted tells observers and the sessions' subscribers that the
+// sessions are gone.
+func (s *Service) notifyDeleted(ctx context.Context, ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+	for _, o := range s.observers {
+		o.SessionsDeleted(ids)
+	}
+	for _, id := range ids {
+		s.publish(ctx, Event{Type: EventSessionDeleted, SessionID: id})
+	}
+}
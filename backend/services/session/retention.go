@@ -0,0 +1,202 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Retention decides how long sessions are kept. Sessions idle for IdleTTL
+// or created more than MaxAge ago are moved to the trash unless pinned, and
+// sessions in the trash for TrashTTL are deleted for good. A zero IdleTTL or
+// MaxAge disables that rule; a zero TrashTTL disables the trash, so sessions
+// are deleted right away.
+type Retention struct {
+	IdleTTL  time.Duration
+	MaxAge   time.Duration
+	TrashTTL time.Duration
+}
+
+// RetentionFromEnv reads SESSION_TIMEOUT and SESSION_MAX_AGE in seconds and
+// SESSION_TRASH_DAYS. By default sessions are kept until idle for 30 days,
+// and deleted ones can be restored for 30 days.
+func RetentionFromEnv() Retention {
+	r := Retention{
+		IdleTTL:  30 * 24 * time.Hour,
+		TrashTTL: 30 * 24 * time.Hour,
+	}
+	for name, setting := range map[string]struct {
+		d    *time.Duration
+		unit time.Duration
+	}{
+		"SESSION_TIMEOUT":    {&r.IdleTTL, time.Second},
+		"SESSION_MAX_AGE":    {&r.MaxAge, time.Second},
+		"SESSION_TRASH_DAYS": {&r.TrashTTL, 24 * time.Hour},
+	} {
+		if n, err := strconv.Atoi(os.Getenv(name)); err == nil && n >= 0 {
+			*setting.d = time.Duration(n) * setting.unit
+		}
+	}
+	return r
+}
+
+// CleanupReport describes what a cleanup pass did.
+type CleanupReport struct {
+	At time.Time `json:"at"`
+	// Idle and Aged count sessions moved to the trash, or deleted when
+	// there is none, for idleness and for age
+	Idle int `json:"idle"`
+	Aged int `json:"aged"`
+	// Purged counts sessions deleted from the trash
+	Purged int    `json:"purged"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Retention returns the rules the cleanup loop applies.
+func (s *Service) Retention() Retention {
+	return s.retention
+}
+
+// LastCleanup returns the report of the latest cleanup pass, or nil before
+// the first one.
+func (s *Service) LastCleanup() *CleanupReport {
+	return s.lastCleanup.Load()
+}
+
+// trash moves a session of userID to the trash, or deletes it when the
+// trash is disabled.
+func (s *Service) trash(ctx context.Context, id, userID string, now time.Time) error {
+	var err error
+	if s.retention.TrashTTL > 0 {
+		err = s.store.Update(ctx, id, SessionUpdate{DeletedAt: &now})
+	} else {
+		err = s.store.Delete(ctx, id)
+	}
+	if err != nil {
+		return err
+	}
+	s.limiter.count(userID, -1)
+	s.notifyDeleted(ctx, []string{id})
+	return nil
+}
+
+// ListTrash returns the sessions of userID in the trash, most recently
+// deleted first.
+func (s *Service) ListTrash(ctx context.Context, userID string) ([]*Session, error) {
+	trashed, err := s.userInfos(ctx, userID, true)
+	if err != nil {
+		return nil, err
+	}
+	return s.getSessions(ctx, trashed)
+}
+
+// RestoreSession takes a session of userID out of the trash. Restoring
+// counts as use, so the session is not trashed again for idleness at the
+// next cleanup. Sessions not in the trash are reported as not found.
+func (s *Service) RestoreSession(ctx context.Context, userID, id string) (*Session, error) {
+	sess, err := s.store.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if sess.UserID != userID || sess.DeletedAt.IsZero() {
+		return nil, ErrNotFound
+	}
+	var restored time.Time
+	now := time.Now()
+	if err := s.store.Update(ctx, id, SessionUpdate{DeletedAt: &restored, UpdatedAt: &now}); err != nil {
+		return nil, err
+	}
+	sess.DeletedAt, sess.UpdatedAt = restored, now
+	s.limiter.count(sess.UserID, 1)
+	s.notifyCreated(ctx, sess)
+	return sess, nil
+}
+
+// Cleanup applies the retention rules once. Sessions in the middle of a
+// turn are left for a later pass. On error, the report covers what was done
+// before it.
+func (s *Service) Cleanup(ctx context.Context) (CleanupReport, error) {
+	now := time.Now()
+	report := CleanupReport{At: now}
+	// Counting while no session is being created keeps the limits' counts
+	// exact, correcting any drift since the last pass
+	s.limiter.mu.Lock()
+	infos, err := s.store.Infos(ctx)
+	if err == nil && s.limiter.enabled() {
+		s.limiter.recount(infos)
+	}
+	s.limiter.mu.Unlock()
+	if err != nil {
+		return report, err
+	}
+
+	r := s.retention
+	for _, info := range infos {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		var err error
+		switch {
+		case !info.DeletedAt.IsZero():
+			if now.Sub(info.DeletedAt) < r.TrashTTL {
+				continue
+			}
+			if err = s.store.Delete(ctx, info.ID); err == nil {
+				report.Purged++
+			}
+		case info.Pinned || s.turns.busy(info.ID):
+			continue
+		case r.IdleTTL > 0 && now.Sub(info.UpdatedAt) >= r.IdleTTL:
+			if err = s.trash(ctx, info.ID, info.UserID, now); err == nil {
+				report.Idle++
+			}
+		case r.MaxAge > 0 && now.Sub(info.CreatedAt) >= r.MaxAge:
+			if err = s.trash(ctx, info.ID, info.UserID, now); err == nil {
+				report.Aged++
+			}
+		}
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return report, fmt.Errorf("failed to clean up session %s: %w", info.ID, err)
+		}
+	}
+	return report, nil
+}
+
+// StartCleanupLoop runs Cleanup every interval in a background goroutine
+// until ctx is done. The returned channel is closed once the loop has
+// stopped, so the store can be closed safely after it.
+func (s *Service) StartCleanupLoop(ctx context.Context, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer fmt.Printf("[SessionCleanup] Stopped cleanup loop\n")
+		r := s.retention
+		fmt.Printf("[SessionCleanup] Starting cleanup loop: idle TTL %v, max age %v, trash %v\n", r.IdleTTL, r.MaxAge, r.TrashTTL)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			report, err := s.Cleanup(ctx)
+			if err != nil && ctx.Err() == nil {
+				report.Error = err.Error()
+				fmt.Printf("[SessionCleanup] Failed to clean up sessions: %v\n", err)
+			}
+			s.lastCleanup.Store(&report)
+			if report.Idle+report.Aged+report.Purged > 0 {
+				fmt.Printf("[SessionCleanup] Retired %d idle and %d old sessions, purged %d from the trash\n", report.Idle, report.Aged, report.Purged)
+			}
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}()
+	return done
+}
@@ -0,0 +1,114 @@
+package session
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetentionFromEnv(t *testing.T) {
+	t.Setenv("SESSION_TIMEOUT", "3600")
+	t.Setenv("SESSION_MAX_AGE", "bad")
+	t.Setenv("SESSION_TRASH_DAYS", "0")
+
+	assert.Equal(t, Retention{IdleTTL: time.Hour}, RetentionFromEnv())
+}
+
+func TestCleanup(t *testing.T) {
+	svc := NewServiceWithStore(NewMemoryStore())
+	svc.retention = Retention{IdleTTL: 24 * time.Hour, MaxAge: 72 * time.Hour, TrashTTL: 48 * time.Hour}
+	observer := &recordingObserver{}
+	svc.Observe(observer)
+	ctx := context.Background()
+	create := func() *Session {
+		sess, err := svc.CreateSessionForUser(ctx, "alice")
+		require.NoError(t, err)
+		return sess
+	}
+	store := svc.store.(*MemoryStore)
+
+	idle, fresh, pinned, old, busy := create(), create(), create(), create(), create()
+	age(svc, idle.ID, 25*time.Hour)
+	age(svc, pinned.ID, 100*time.Hour)
+	require.NoError(t, svc.UpdateSession(ctx, pinned.ID, SessionUpdate{Pinned: ptr(true)}))
+	store.mu.Lock()
+	store.sessions[old.ID].CreatedAt = time.Now().Add(-73 * time.Hour)
+	store.mu.Unlock()
+	age(svc, busy.ID, 25*time.Hour)
+	unlock, err := svc.LockTurn(ctx, busy.ID)
+	require.NoError(t, err)
+	defer unlock()
+
+	report, err := svc.Cleanup(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Idle)
+	assert.Equal(t, 1, report.Aged)
+	assert.Zero(t, report.Purged)
+	assert.ElementsMatch(t, []string{idle.ID, old.ID}, observer.deleted)
+
+	for _, id := range []string{fresh.ID, pinned.ID, busy.ID} {
+		_, err := svc.GetSession(ctx, id)
+		assert.NoError(t, err, "fresh, pinned and busy sessions are kept")
+	}
+	trash, err := svc.ListTrash(ctx, "alice")
+	require.NoError(t, err)
+	assert.Len(t, trash, 2)
+
+	// The trash is purged once its retention has passed
+	store.mu.Lock()
+	store.sessions[idle.ID].DeletedAt = time.Now().Add(-49 * time.Hour)
+	store.mu.Unlock()
+	report, err = svc.Cleanup(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, CleanupReport{At: report.At, Purged: 1}, report)
+	_, err = svc.RestoreSession(ctx, "alice", idle.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Len(t, observer.deleted, 2, "purging is not reported again")
+}
+
+func TestDeleteAndRestore(t *testing.T) {
+	svc := NewServiceWithStore(NewMemoryStore())
+	svc.retention = Retention{IdleTTL: time.Hour, TrashTTL: time.Hour}
+	observer := &recordingObserver{}
+	svc.Observe(observer)
+	ctx := context.Background()
+
+	sess, err := svc.CreateSessionForUser(ctx, "alice")
+	require.NoError(t, err)
+	require.NoError(t, svc.AddMessage(ctx, sess.ID, Message{Role: "user", Content: "Hello"}))
+	age(svc, sess.ID, 2*time.Hour)
+	require.NoError(t, svc.DeleteSession(ctx, sess.ID))
+
+	_, err = svc.GetSessionForUser(ctx, "alice", sess.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, svc.AddMessage(ctx, sess.ID, Message{Role: "user", Content: "Still there?"}), ErrNotFound)
+	listed, total, err := svc.ListUserSessions(ctx, "alice", 0, 10)
+	require.NoError(t, err)
+	assert.Empty(t, listed)
+	assert.Zero(t, total)
+
+	_, err = svc.RestoreSession(ctx, "bob", sess.ID)
+	assert.ErrorIs(t, err, ErrNotFound, "only the owner restores a session")
+	restored, err := svc.RestoreSession(ctx, "alice", sess.ID)
+	require.NoError(t, err)
+	assert.True(t, restored.DeletedAt.IsZero())
+	assert.Len(t, restored.Messages, 1)
+	assert.Equal(t, []string{sess.ID, sess.ID}, observer.created, "restoring reports the session again")
+
+	report, err := svc.Cleanup(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, report.Idle, "restoring counts as use")
+	_, err = svc.GetSessionForUser(ctx, "alice", sess.ID)
+	assert.NoError(t, err)
+
+	// Without a trash, deleting is final
+	svc.retention.TrashTTL = 0
+	require.NoError(t, svc.DeleteSession(ctx, sess.ID))
+	_, err = svc.RestoreSession(ctx, "alice", sess.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = svc.store.Get(ctx, sess.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -2,130 +2,470 @@ package session
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"sync"
+	"iter"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"sync/atomic"
 	"time"
+
+	"csdeepseek/backend/models/chat"
 )
 
 type Service struct {
-	sessions map[string]*Session
-	mu       sync.RWMutex
+	store       Store
+	turns       *turnLocks
+	tokens      *tokenSigner
+	users       *tokenSigner
+	limiter     *limiter
+	retention   Retention
+	lastCleanup atomic.Pointer[CleanupReport]
+	observers   []Observer
+	bus         Bus
 }
 
 type Session struct {
-	ID        string    `json:"id"`
+	ID string `json:"id"`
+	// UserID is the user the session belongs to; empty for anonymous sessions
+	UserID string `json:"user_id,omitempty"`
+	// Profile names the assistant profile the session was created with;
+	// empty for the default profile
+	Profile string `json:"profile,omitempty"`
+	// Title is a user-chosen name for the session, or one generated from
+	// the conversation when AutoTitle is set
+	Title     string    `json:"title,omitempty"`
+	AutoTitle bool      `json:"auto_title,omitempty"`
+	Pinned    bool      `json:"pinned"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
-	Messages  []Message `json:"messages"`
+	// Messages holds every message of the conversation tree in the order
+	// they were added
+	Messages []Message `json:"messages"`
+	// ActiveLeaf is the ID of the last message on the branch being shown.
+	// Adding a message makes it the active leaf; empty means the last
+	// message added.
+	ActiveLeaf string `json:"active_leaf,omitempty"`
+	// Summary condenses the conversation up to and including the message
+	// SummaryThrough, so long histories can be sent to the model shorter
+	Summary        string `json:"summary,omitempty"`
+	SummaryThrough string `json:"summary_through,omitempty"`
+	// DeletedAt is when the session was moved to the trash; zero for
+	// sessions that are not in it
+	DeletedAt time.Time `json:"deleted_at,omitzero"`
+	// Version changes with every write to the session. Its values mean
+	// nothing beyond that and differ between stores.
+	Version int64 `json:"-"`
+}
+
+// clone returns a copy of the session that shares no memory with it.
+func (s *Session) clone() *Session {
+	c := *s
+	c.Messages = append(make([]Message, 0, len(s.Messages)), s.Messages...)
+	return &c
 }
 
-type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+// SessionUpdate changes session metadata. Nil fields are left unchanged.
+type SessionUpdate struct {
+	Title          *string `json:"title,omitempty"`
+	AutoTitle      *bool   `json:"auto_title,omitempty"`
+	Pinned         *bool   `json:"pinned,omitempty"`
+	ActiveLeaf     *string `json:"active_leaf,omitempty"`
+	Summary        *string `json:"summary,omitempty"`
+	SummaryThrough *string `json:"summary_through,omitempty"`
+	// DeletedAt moves the session to the trash, or out of it when zero
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// UpdatedAt marks the session as used at that time
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// apply changes sess as described by u.
+func (u SessionUpdate) apply(sess *Session) {
+	if u.Title != nil {
+		sess.Title = *u.Title
+	}
+	if u.AutoTitle != nil {
+		sess.AutoTitle = *u.AutoTitle
+	}
+	if u.Pinned != nil {
+		sess.Pinned = *u.Pinned
+	}
+	if u.ActiveLeaf != nil {
+		sess.ActiveLeaf = *u.ActiveLeaf
+	}
+	if u.Summary != nil {
+		sess.Summary = *u.Summary
+	}
+	if u.SummaryThrough != nil {
+		sess.SummaryThrough = *u.SummaryThrough
+	}
+	if u.DeletedAt != nil {
+		sess.DeletedAt = *u.DeletedAt
+	}
+	if u.UpdatedAt != nil {
+		sess.UpdatedAt = *u.UpdatedAt
+	}
 }
 
+// Message is the domain message model. Sessions store it as is.
+type Message = chat.Message
+
+// NewService creates a session service. SESSION_REDIS_URL shares sessions
+// between replicas through Redis, SESSION_STORE_DIR keeps them on local disk,
+// otherwise they live in memory only. Sessions in Redis expire after
+// SESSION_REDIS_TTL seconds without use, regardless of pinning and the trash;
+// by default they get no TTL, so that the cleanup loop alone applies the
+// retention rules. Session events travel between replicas through Redis as
+// well.
 func NewService() *Service {
+	if url := os.Getenv("SESSION_REDIS_URL"); url != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		var ttl time.Duration
+		if secs, err := strconv.Atoi(os.Getenv("SESSION_REDIS_TTL")); err == nil && secs > 0 {
+			ttl = time.Duration(secs) * time.Second
+		}
+		store, err := OpenRedisStore(ctx, url, os.Getenv("SESSION_REDIS_PREFIX"), ttl)
+		if err != nil {
+			log.Printf("Failed to open redis session store, falling back to memory: %v", err)
+		} else {
+			log.Printf("Using redis session store")
+			svc := NewServiceWithStore(store)
+			bus, err := NewRedisBus(ctx, store.client, store.prefix)
+			if err != nil {
+				log.Printf("Failed to subscribe to redis session events, keeping them in this replica: %v", err)
+			} else {
+				svc.SetBus(bus)
+			}
+			return svc
+		}
+	}
+	if dir := os.Getenv("SESSION_STORE_DIR"); dir != "" {
+		start := time.Now()
+		store, err := OpenFileStore(dir)
+		if err != nil {
+			log.Printf("Failed to open session store, falling back to memory: %v", err)
+		} else {
+			log.Printf("Loaded %d sessions from %s in %v", store.Len(), dir, time.Since(start))
+			return NewServiceWithStore(store)
+		}
+	}
+	return NewServiceWithStore(NewMemoryStore())
+}
+
+// NewServiceWithStore creates a session service backed by store, with
+// limits from LimitsFromEnv and retention from RetentionFromEnv.
+func NewServiceWithStore(store Store) *Service {
 	return &Service{
-		sessions: make(map[string]*Session),
+		store:     store,
+		turns:     newTurnLocks(),
+		tokens:    tokenSignerFromEnv(),
+		users:     userSignerFromEnv(),
+		limiter:   &limiter{limits: LimitsFromEnv()},
+		retention: RetentionFromEnv(),
+		bus:       NewLocalBus(),
 	}
 }
 
-// CreateSession creates a new session
+// CreateSession creates a new anonymous session
 func (s *Service) CreateSession(ctx context.Context) (*Session, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	return s.CreateSessionForUser(ctx, "")
+}
+
+// CreateSessionForUser creates a new session owned by userID
+func (s *Service) CreateSessionForUser(ctx context.Context, userID string) (*Session, error) {
+	return s.CreateSessionWithProfile(ctx, userID, "")
+}
 
+// CreateSessionWithProfile creates a new session owned by userID that uses
+// the named assistant profile. The name is stored as is; callers check that
+// the profile exists.
+func (s *Service) CreateSessionWithProfile(ctx context.Context, userID, profile string) (*Session, error) {
 	now := time.Now()
 	session := &Session{
 		ID:        generateID(),
+		UserID:    userID,
+		Profile:   profile,
 		CreatedAt: now,
 		UpdatedAt: now,
 		Messages:  make([]Message, 0),
 	}
 
-	s.sessions[session.ID] = session
+	if err := s.createWithin(ctx, session); err != nil {
+		return nil, err
+	}
+	s.notifyCreated(ctx, session)
 	return session, nil
 }
 
-// GetSession retrieves a session by ID
-func (s *Service) GetSession(ctx context.Context, id string) (*Session, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// ImportSession stores a conversation brought in from elsewhere as a new
+// session owned by userID. It gets a fresh ID; its messages, their tree and
+// timestamps and the creation time are kept. UpdatedAt is set to now, as the
+// import counts as activity and old conversations would otherwise expire
+// right away.
+func (s *Service) ImportSession(ctx context.Context, userID string, sess *Session) (*Session, error) {
+	if len(sess.Messages) == 0 {
+		return nil, fmt.Errorf("invalid session: no messages")
+	}
+	if err := s.checkMessageCount(len(sess.Messages) - 1); err != nil {
+		return nil, err
+	}
+	imported := sess.clone()
+	imported.ID = generateID()
+	imported.UserID = userID
+	imported.UpdatedAt = time.Now()
+	if imported.CreatedAt.IsZero() {
+		imported.CreatedAt = imported.UpdatedAt
+	}
+	for i := range imported.Messages {
+		msg := &imported.Messages[i]
+		if msg.ID == "" {
+			msg.ID = chat.NewMessageID()
+		}
+		if msg.CreatedAt.IsZero() {
+			msg.CreatedAt = imported.CreatedAt
+		}
+		if msg.Status == "" {
+			msg.Status = chat.StatusComplete
+		}
+		if err := msg.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid message %d: %w", i+1, err)
+		}
+		if err := s.checkMessageSize(*msg); err != nil {
+			return nil, fmt.Errorf("message %d: %w", i+1, err)
+		}
+	}
 
-	session, exists := s.sessions[id]
-	if !exists {
-		return nil, fmt.Errorf("session not found")
+	if err := s.createWithin(ctx, imported); err != nil {
+		return nil, err
 	}
+	s.notifyCreated(ctx, imported)
+	return imported.clone(), nil
+}
 
-	return session, nil
+// GetSession retrieves a snapshot of a session. Later changes to the
+// session are not reflected in it, and changing it does not change the
+// session. Sessions in the trash are reported as not found.
+func (s *Service) GetSession(ctx context.Context, id string) (*Session, error) {
+	sess, err := s.store.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !sess.DeletedAt.IsZero() {
+		return nil, ErrNotFound
+	}
+	return sess, nil
 }
 
-// AddMessage adds a message to a session
-func (s *Service) AddMessage(ctx context.Context, sessionID string, msg Message) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// maxAppendRetries bounds how often AddMessage works a message out again
+// after losing a race with another write to the session.
+const maxAppendRetries = 20
 
-	session, exists := s.sessions[sessionID]
-	if !exists {
-		return fmt.Errorf("session not found")
+// AddMessage validates a message and adds it to a session, where it becomes
+// the active leaf. A missing ID, timestamp or status is filled in, and a
+// message without a parent continues the active branch. Messages over the
+// size or count limits are rejected, as are messages to sessions in the
+// trash.
+//
+// The parent and the count are worked out from a snapshot, so the message is
+// appended only if the session has not changed since, and otherwise worked
+// out again. This holds across replicas, where the turn lock does not.
+func (s *Service) AddMessage(ctx context.Context, sessionID string, msg Message) error {
+	if err := s.checkMessageSize(msg); err != nil {
+		return err
+	}
+	if msg.ID == "" {
+		msg.ID = chat.NewMessageID()
+	}
+	if msg.CreatedAt.IsZero() {
+		msg.CreatedAt = time.Now()
+	}
+	if msg.Status == "" {
+		msg.Status = chat.StatusComplete
+	}
+	if err := msg.Validate(); err != nil {
+		return fmt.Errorf("invalid message: %w", err)
 	}
 
-	session.Messages = append(session.Messages, msg)
-	session.UpdatedAt = time.Now()
+	parentID := msg.ParentID
+	for attempt := 0; ; attempt++ {
+		sess, err := s.GetSession(ctx, sessionID)
+		if err != nil {
+			return err
+		}
+		if err := s.checkMessageCount(len(sess.Messages)); err != nil {
+			return err
+		}
+		msg.ParentID = parentID
+		if path := sess.ActivePath(); msg.ParentID == "" && len(path) > 0 {
+			msg.ParentID = path[len(path)-1].ID
+		}
+		err = s.store.Append(ctx, sessionID, msg, sess.Version)
+		if errors.Is(err, ErrConflict) && attempt < maxAppendRetries {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		break
+	}
+	s.notifyMessage(ctx, sessionID, msg)
 	return nil
 }
 
-// ListSessions returns all sessions
-func (s *Service) ListSessions(ctx context.Context) ([]*Session, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// GetSessionForUser retrieves a snapshot of a session owned by userID.
+// Sessions of other users are reported as not found, so that their IDs
+// cannot be probed.
+func (s *Service) GetSessionForUser(ctx context.Context, userID, id string) (*Session, error) {
+	sess, err := s.GetSession(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if sess.UserID != userID {
+		return nil, ErrNotFound
+	}
+	return sess, nil
+}
 
-	sessions := make([]*Session, 0, len(s.sessions))
-	for _, session := range s.sessions {
-		sessions = append(sessions, session)
+// ListUserSessions returns a page of the sessions owned by userID, pinned
+// ones first and otherwise most recently updated first, along with the
+// total number of sessions the user has. Sessions in the trash are left
+// out.
+func (s *Service) ListUserSessions(ctx context.Context, userID string, offset, limit int) ([]*Session, int, error) {
+	owned, err := s.userInfos(ctx, userID, false)
+	if err != nil {
+		return nil, 0, err
 	}
+	total := len(owned)
+	start := min(offset, total)
+	end := start + min(limit, total-start)
+	page, err := s.getSessions(ctx, owned[start:end])
+	if err != nil {
+		return nil, 0, err
+	}
+	return page, total, nil
+}
 
-	return sessions, nil
+// UserSessions yields the sessions owned by userID in the order of
+// ListUserSessions, loading each only when it is reached.
+func (s *Service) UserSessions(ctx context.Context, userID string) iter.Seq2[*Session, error] {
+	return func(yield func(*Session, error) bool) {
+		owned, err := s.userInfos(ctx, userID, false)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		for _, info := range owned {
+			sess, err := s.store.Get(ctx, info.ID)
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			if !yield(sess, err) || err != nil {
+				return
+			}
+		}
+	}
 }
 
-// DeleteSession deletes a session
-func (s *Service) DeleteSession(ctx context.Context, id string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// userInfos describes the sessions of userID, either those in the trash or
+// the others, in the order they are listed in.
+func (s *Service) userInfos(ctx context.Context, userID string, trashed bool) ([]SessionInfo, error) {
+	infos, err := s.store.Infos(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var owned []SessionInfo
+	for _, info := range infos {
+		if info.UserID == userID && info.DeletedAt.IsZero() != trashed {
+			owned = append(owned, info)
+		}
+	}
+	sort.Slice(owned, func(i, j int) bool {
+		a, b := owned[i], owned[j]
+		if trashed && !a.DeletedAt.Equal(b.DeletedAt) {
+			return a.DeletedAt.After(b.DeletedAt)
+		}
+		if a.Pinned != b.Pinned {
+			return a.Pinned
+		}
+		if !a.UpdatedAt.Equal(b.UpdatedAt) {
+			return a.UpdatedAt.After(b.UpdatedAt)
+		}
+		return a.ID < b.ID
+	})
+	return owned, nil
+}
 
-	if _, exists := s.sessions[id]; !exists {
-		return fmt.Errorf("session not found")
+// getSessions loads the described sessions, leaving out any deleted since
+// they were described.
+func (s *Service) getSessions(ctx context.Context, infos []SessionInfo) ([]*Session, error) {
+	sessions := make([]*Session, 0, len(infos))
+	for _, info := range infos {
+		sess, err := s.store.Get(ctx, info.ID)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, sess)
 	}
+	return sessions, nil
+}
 
-	delete(s.sessions, id)
+// UpdateSession changes a session's metadata. A new title is published to
+// the session's subscribers.
+func (s *Service) UpdateSession(ctx context.Context, id string, update SessionUpdate) error {
+	if err := s.store.Update(ctx, id, update); err != nil {
+		return err
+	}
+	if update.Title != nil {
+		s.publish(ctx, Event{Type: EventTitleChanged, SessionID: id, Title: *update.Title})
+	}
 	return nil
 }
 
-// generateID generates a unique session ID
-func generateID() string {
-	return fmt.Sprintf("sess_%d", time.Now().UnixNano())
-}
-
-// StartCleanupLoop starts a background goroutine to delete old sessions.
-func (s *Service) StartCleanupLoop(timeout, interval time.Duration) {
-	go func() {
-		fmt.Printf("[SessionCleanup] Starting cleanup loop\n")
-		for {
-			time.Sleep(interval)
-			fmt.Printf("[SessionCleanup] Checking for sessions older than %v\n", timeout)
-			s.mu.Lock()
-			now := time.Now()
-			deleted := 0
-			for id, sess := range s.sessions {
-				if now.Sub(sess.UpdatedAt) > timeout {
-					delete(s.sessions, id)
-					deleted++
-				}
-			}
-			s.mu.Unlock()
-			if deleted > 0 {
-				fmt.Printf("[SessionCleanup] Deleted %d sessions older than %v\n", deleted, timeout)
-			}
+// ListSessions returns all sessions not in the trash
+func (s *Service) ListSessions(ctx context.Context) ([]*Session, error) {
+	all, err := s.store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sessions := all[:0]
+	for _, sess := range all {
+		if sess.DeletedAt.IsZero() {
+			sessions = append(sessions, sess)
 		}
-	}()
+	}
+	return sessions, nil
+}
+
+// DeleteSession moves a session to the trash, from which RestoreSession
+// brings it back until the cleanup loop purges it. Without a trash the
+// session is deleted right away.
+func (s *Service) DeleteSession(ctx context.Context, id string) error {
+	sess, err := s.GetSession(ctx, id)
+	if err != nil {
+		return err
+	}
+	return s.trash(ctx, id, sess.UserID, time.Now())
+}
+
+// Close stops the event bus and releases the session store.
+func (s *Service) Close() error {
+	if err := s.bus.Close(); err != nil {
+		log.Printf("Failed to close session event bus: %v", err)
+	}
+	return s.store.Close()
+}
+
+// generateID generates a random, unguessable session ID
+func generateID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return "sess_" + hex.EncodeToString(b)
 }
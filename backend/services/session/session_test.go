@@ -2,18 +2,47 @@ package session
 
 import (
 	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"csdeepseek/backend/models/chat"
+
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 func TestNewService(t *testing.T) {
+	t.Setenv("SESSION_STORE_DIR", "")
 	service := NewService()
 	assert.NotNil(t, service)
-	assert.NotNil(t, service.sessions)
-	assert.Empty(t, service.sessions)
+	store, ok := service.store.(*MemoryStore)
+	require.True(t, ok)
+	assert.Empty(t, store.sessions)
+}
+
+func TestNewService_FileStore(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("SESSION_STORE_DIR", dir)
+	ctx := context.Background()
+
+	service := NewService()
+	require.IsType(t, &FileStore{}, service.store)
+	created, err := service.CreateSession(ctx)
+	require.NoError(t, err)
+	require.NoError(t, service.AddMessage(ctx, created.ID, Message{Role: "user", Content: "hi"}))
+	require.NoError(t, service.Close())
+
+	reopened := NewService()
+	defer reopened.Close()
+	sess, err := reopened.GetSession(ctx, created.ID)
+	require.NoError(t, err)
+	require.Len(t, sess.Messages, 1)
+	assert.Equal(t, "hi", sess.Messages[0].Content)
+	assert.NotEmpty(t, sess.Messages[0].ID)
+	assert.False(t, sess.Messages[0].CreatedAt.IsZero(), "message timestamps survive a restart")
 }
 
 func TestCreateSession(t *testing.T) {
@@ -28,6 +57,19 @@ func TestCreateSession(t *testing.T) {
 	assert.Empty(t, session.Messages)
 }
 
+func TestCreateSessionForUser(t *testing.T) {
+	service := NewService()
+	ctx := context.Background()
+
+	owned, err := service.CreateSessionForUser(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", owned.UserID)
+
+	anonymous, err := service.CreateSession(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, anonymous.UserID)
+}
+
 func TestGetSession(t *testing.T) {
 	service := NewService()
 	ctx := context.Background()
@@ -73,8 +115,51 @@ func TestAddMessage(t *testing.T) {
 	retrieved, err := service.GetSession(ctx, session.ID)
 	require.NoError(t, err)
 	require.Len(t, retrieved.Messages, 1)
-	assert.Equal(t, msg, retrieved.Messages[0])
+	got := retrieved.Messages[0]
+	assert.Equal(t, msg.Role, got.Role)
+	assert.Equal(t, msg.Content, got.Content)
 	assert.True(t, retrieved.UpdatedAt.After(retrieved.CreatedAt))
+
+	// Missing fields are filled in
+	assert.True(t, strings.HasPrefix(got.ID, "msg_"))
+	assert.False(t, got.CreatedAt.IsZero())
+	assert.Equal(t, chat.StatusComplete, got.Status)
+}
+
+func TestAddMessage_KeepsGivenFields(t *testing.T) {
+	service := NewService()
+	ctx := context.Background()
+	sess, err := service.CreateSession(ctx)
+	require.NoError(t, err)
+
+	msg := chat.NewMessage(chat.RoleAssistant, "Try restarting the router.")
+	msg.Model = "deepseek-chat"
+	msg.LatencyMillis = 1200
+	msg.PromptTokens = 50
+	msg.CompletionTokens = 8
+	msg.Metadata = map[string]string{"source": "test"}
+	require.NoError(t, service.AddMessage(ctx, sess.ID, msg))
+
+	retrieved, err := service.GetSession(ctx, sess.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []Message{msg}, retrieved.Messages)
+}
+
+func TestAddMessage_Invalid(t *testing.T) {
+	service := NewService()
+	ctx := context.Background()
+	sess, err := service.CreateSession(ctx)
+	require.NoError(t, err)
+
+	assert.Error(t, service.AddMessage(ctx, sess.ID, Message{Role: "robot", Content: "beep"}))
+	assert.Error(t, service.AddMessage(ctx, sess.ID, Message{Role: "user"}))
+	assert.Error(t, service.AddMessage(ctx, sess.ID, Message{Role: "user", Content: "hi", Status: "pending"}))
+
+	// A failed reply may be empty
+	require.NoError(t, service.AddMessage(ctx, sess.ID, Message{Role: "assistant", Status: chat.StatusFailed}))
+	retrieved, err := service.GetSession(ctx, sess.ID)
+	require.NoError(t, err)
+	assert.Len(t, retrieved.Messages, 1)
 }
 
 func TestAddMessage_NotFound(t *testing.T) {
@@ -174,37 +259,195 @@ func TestConcurrentOperations(t *testing.T) {
 }
 
 func TestSessionCleanupLoop(t *testing.T) {
-	svc := NewService()
+	svc := NewServiceWithStore(NewMemoryStore())
+	svc.retention = Retention{IdleTTL: time.Hour, TrashTTL: time.Hour}
 	ctx := context.Background()
 
-	// Create 2 sessions: one old, one recent
 	oldSess, _ := svc.CreateSession(ctx)
 	recentSess, _ := svc.CreateSession(ctx)
+	age(svc, oldSess.ID, 2*time.Hour)
+
+	loopCtx, stop := context.WithCancel(ctx)
+	done := svc.StartCleanupLoop(loopCtx, 10*time.Millisecond)
+	assert.Eventually(t, func() bool { return svc.LastCleanup() != nil }, time.Second, 10*time.Millisecond)
+	stop()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("cleanup loop did not stop")
+	}
 
-	// Manually set old session's UpdatedAt to 2 hours ago
-	svc.mu.Lock()
-	oldSess.UpdatedAt = time.Now().Add(-2 * time.Hour)
-	svc.mu.Unlock()
-
-	// Run cleanup with 1 hour timeout, 10ms interval (run only once for test)
-	done := make(chan struct{})
-	go func() {
-		svc.StartCleanupLoop(1*time.Hour, 10*time.Millisecond)
-		time.Sleep(50 * time.Millisecond)
-		close(done)
-	}()
-	<-done
-
-	// Check that old session is deleted, recent one remains
-	svc.mu.RLock()
-	_, oldExists := svc.sessions[oldSess.ID]
-	_, recentExists := svc.sessions[recentSess.ID]
-	svc.mu.RUnlock()
-
-	if oldExists {
-		t.Errorf("Old session was not cleaned up")
+	_, err := svc.GetSession(ctx, oldSess.ID)
+	assert.ErrorIs(t, err, ErrNotFound, "idle sessions are moved to the trash")
+	_, err = svc.GetSession(ctx, recentSess.ID)
+	assert.NoError(t, err)
+}
+
+func TestListUserSessions(t *testing.T) {
+	service := NewServiceWithStore(NewMemoryStore())
+	ctx := context.Background()
+
+	var ids []string
+	for i := 0; i < 5; i++ {
+		sess, err := service.CreateSessionForUser(ctx, "alice")
+		require.NoError(t, err)
+		ids = append(ids, sess.ID)
+		time.Sleep(time.Millisecond)
 	}
-	if !recentExists {
-		t.Errorf("Recent session was incorrectly deleted")
+	_, err := service.CreateSessionForUser(ctx, "bob")
+	require.NoError(t, err)
+
+	// Activity moves a session up; pinning moves it to the top
+	require.NoError(t, service.AddMessage(ctx, ids[1], Message{Role: "user", Content: "hello"}))
+	pinned := true
+	require.NoError(t, service.UpdateSession(ctx, ids[0], SessionUpdate{Pinned: &pinned}))
+
+	page, total, err := service.ListUserSessions(ctx, "alice", 0, 3)
+	require.NoError(t, err)
+	assert.Equal(t, 5, total)
+	require.Len(t, page, 3)
+	assert.Equal(t, []string{ids[0], ids[1], ids[4]}, []string{page[0].ID, page[1].ID, page[2].ID})
+
+	page, _, err = service.ListUserSessions(ctx, "alice", 3, 3)
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	assert.Equal(t, []string{ids[3], ids[2]}, []string{page[0].ID, page[1].ID})
+
+	page, total, err = service.ListUserSessions(ctx, "alice", 10, 3)
+	require.NoError(t, err)
+	assert.Empty(t, page)
+	assert.Equal(t, 5, total)
+}
+
+// readCountingStore counts how often sessions are read or scanned.
+type readCountingStore struct {
+	Store
+	gets, lists, infos atomic.Int32
+}
+
+func (s *readCountingStore) Get(ctx context.Context, id string) (*Session, error) {
+	s.gets.Add(1)
+	return s.Store.Get(ctx, id)
+}
+
+func (s *readCountingStore) Infos(ctx context.Context) ([]SessionInfo, error) {
+	s.infos.Add(1)
+	return s.Store.Infos(ctx)
+}
+
+func (s *readCountingStore) List(ctx context.Context) ([]*Session, error) {
+	s.lists.Add(1)
+	return s.Store.List(ctx)
+}
+
+func TestListUserSessions_LoadsOnlyThePage(t *testing.T) {
+	t.Setenv("SESSION_TRASH_DAYS", "7")
+	store := &readCountingStore{Store: NewMemoryStore()}
+	service := NewServiceWithStore(store)
+	ctx := context.Background()
+	var ids []string
+	for i := 0; i < 10; i++ {
+		sess, err := service.CreateSessionForUser(ctx, "alice")
+		require.NoError(t, err)
+		ids = append(ids, sess.ID)
+		time.Sleep(time.Millisecond)
+	}
+	require.NoError(t, service.DeleteSession(ctx, ids[0]))
+	store.gets.Store(0)
+
+	page, total, err := service.ListUserSessions(ctx, "alice", 2, 3)
+	require.NoError(t, err)
+	assert.Equal(t, 9, total)
+	assert.Len(t, page, 3)
+	assert.EqualValues(t, 3, store.gets.Load())
+
+	trash, err := service.ListTrash(ctx, "alice")
+	require.NoError(t, err)
+	require.Len(t, trash, 1)
+	assert.Equal(t, ids[0], trash[0].ID)
+	assert.EqualValues(t, 4, store.gets.Load())
+
+	var exported []string
+	for sess, err := range service.UserSessions(ctx, "alice") {
+		require.NoError(t, err)
+		exported = append(exported, sess.ID)
+	}
+	assert.Equal(t, ids[9], exported[0], "in the order of the list")
+	assert.Len(t, exported, 9)
+	assert.Zero(t, store.lists.Load(), "no listing reads every session")
+}
+
+func TestGetSessionForUser(t *testing.T) {
+	service := NewService()
+	ctx := context.Background()
+
+	sess, err := service.CreateSessionForUser(ctx, "alice")
+	require.NoError(t, err)
+
+	got, err := service.GetSessionForUser(ctx, "alice", sess.ID)
+	require.NoError(t, err)
+	assert.Equal(t, sess.ID, got.ID)
+
+	_, err = service.GetSessionForUser(ctx, "bob", sess.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = service.GetSessionForUser(ctx, "", sess.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+// recordingObserver records the changes it is told about.
+type recordingObserver struct {
+	mu      sync.Mutex
+	created []string
+	added   []string
+	deleted []string
+}
+
+func (o *recordingObserver) SessionCreated(sess *Session) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.created = append(o.created, sess.ID)
+	for _, msg := range sess.Messages {
+		o.added = append(o.added, msg.Content)
 	}
 }
+
+func (o *recordingObserver) MessageAdded(sessionID string, msg Message) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.added = append(o.added, msg.Content)
+}
+
+func (o *recordingObserver) SessionsDeleted(ids []string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.deleted = append(o.deleted, ids...)
+}
+
+func TestObserver(t *testing.T) {
+	svc := NewServiceWithStore(NewMemoryStore())
+	observer := &recordingObserver{}
+	svc.Observe(observer)
+	ctx := context.Background()
+
+	sess, err := svc.CreateSessionForUser(ctx, "alice")
+	require.NoError(t, err)
+	require.NoError(t, svc.AddMessage(ctx, sess.ID, Message{Role: chat.RoleUser, Content: "Hello"}))
+	assert.Error(t, svc.AddMessage(ctx, "sess_missing", Message{Role: chat.RoleUser, Content: "Lost"}))
+
+	imported, err := svc.ImportSession(ctx, "alice", &Session{Messages: []Message{{Role: chat.RoleUser, Content: "Imported"}}})
+	require.NoError(t, err)
+	require.NoError(t, svc.DeleteSession(ctx, sess.ID))
+	assert.Error(t, svc.DeleteSession(ctx, sess.ID))
+
+	// Sessions retired by the cleanup are reported too
+	svc.retention.IdleTTL = time.Hour
+	age(svc, imported.ID, 2*time.Hour)
+	_, err = svc.Cleanup(ctx)
+	require.NoError(t, err)
+
+	observer.mu.Lock()
+	defer observer.mu.Unlock()
+	assert.Equal(t, []string{sess.ID, imported.ID}, observer.created)
+	assert.Equal(t, []string{"Hello", "Imported"}, observer.added)
+	assert.Equal(t, []string{sess.ID, imported.ID}, observer.deleted)
+}
@@ -0,0 +1,53 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned for operations on a session that does not exist.
+var ErrNotFound = errors.New("session not found")
+
+// ErrConflict is returned by Append when the session changed since the
+// version the caller read.
+var ErrConflict = errors.New("session changed concurrently")
+
+// SessionInfo is what a store can tell about a session cheaply.
+type SessionInfo struct {
+	ID        string
+	UserID    string
+	Pinned    bool
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt time.Time
+	Messages  int
+}
+
+// Store persists sessions and their messages. Implementations must be safe
+// for concurrent use, and sessions passed in or returned must not be shared
+// with the store: callers may read and change them without locking.
+type Store interface {
+	// Create stores a new session. Its ID must not be in use.
+	Create(ctx context.Context, sess *Session) error
+	// Get returns a snapshot of the session with its messages and its
+	// current Version.
+	Get(ctx context.Context, id string) (*Session, error)
+	// Append adds a message to the end of a session, makes it the active
+	// leaf and sets UpdatedAt to now. It fails with ErrConflict, adding
+	// nothing, unless the session is still at version, so that a message
+	// built from a snapshot cannot land after changes the snapshot missed.
+	Append(ctx context.Context, id string, msg Message, version int64) error
+	// Update changes a session's metadata without touching its messages.
+	// UpdatedAt changes only when the update sets it. The session's version
+	// changes as well.
+	Update(ctx context.Context, id string, update SessionUpdate) error
+	// List returns every session.
+	List(ctx context.Context) ([]*Session, error)
+	// Infos describes every session without reading its messages.
+	Infos(ctx context.Context) ([]SessionInfo, error)
+	// Delete removes a session and its messages.
+	Delete(ctx context.Context, id string) error
+	// Close releases the store's resources.
+	Close() error
+}
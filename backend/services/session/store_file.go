@@ -0,0 +1,474 @@
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// On-disk layout of a session store directory:
+//
+//	<id>.jsonl  one JSON event per line: a create event with the session's
+//	            metadata, then one message event per appended message and
+//	            one update event per metadata change
+//	index.json  metadata and file size of every session as of the last
+//	            clean shutdown
+//
+// The session files are the source of truth. On open, an index entry is
+// trusted only if its recorded size matches the file; any other file is
+// replayed, and a torn last line left by a crash is truncated away.
+const (
+	sessionFileExt = ".jsonl"
+	indexFile      = "index.json"
+	eventCreate    = "create"
+	eventMessage   = "message"
+	eventUpdate    = "update"
+)
+
+var validSessionID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+
+type fileEvent struct {
+	Type      string         `json:"type"`
+	At        time.Time      `json:"at"`
+	ID        string         `json:"id,omitempty"`
+	UserID    string         `json:"user_id,omitempty"`
+	Profile   string         `json:"profile,omitempty"`
+	CreatedAt time.Time      `json:"created_at,omitempty"`
+	Message   *Message       `json:"message,omitempty"`
+	Update    *SessionUpdate `json:"update,omitempty"`
+}
+
+// indexEntry is what the store keeps in memory about each session, so that
+// listing and retention do not read every session file.
+type indexEntry struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id,omitempty"`
+	Pinned    bool      `json:"pinned,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	DeletedAt time.Time `json:"deleted_at,omitzero"`
+	Messages  int       `json:"messages"`
+	Size      int64     `json:"size"`
+}
+
+// FileStore keeps each session in an append-only JSONL file, so sessions
+// survive restarts.
+type FileStore struct {
+	dir   string
+	index map[string]*indexEntry
+	mu    sync.RWMutex
+}
+
+// OpenFileStore opens or creates a session store in dir, recovering any
+// sessions written since the index was last saved.
+func OpenFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create session store directory: %w", err)
+	}
+	s := &FileStore{
+		dir:   dir,
+		index: make(map[string]*indexEntry),
+	}
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+	if err := s.saveIndex(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileStore) sessionPath(id string) string {
+	return filepath.Join(s.dir, id+sessionFileExt)
+}
+
+// recover rebuilds the in-memory index from index.json and the session
+// files.
+func (s *FileStore) recover() error {
+	saved := make(map[string]*indexEntry)
+	data, err := os.ReadFile(filepath.Join(s.dir, indexFile))
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &saved); err != nil {
+			log.Printf("[SessionStore] Ignoring unreadable index: %v", err)
+			saved = make(map[string]*indexEntry)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("failed to read session index: %w", err)
+	}
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read session store directory: %w", err)
+	}
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), sessionFileExt)
+		if !ok || e.IsDir() || !validSessionID.MatchString(id) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return fmt.Errorf("failed to stat session %s: %w", id, err)
+		}
+		if entry, ok := saved[id]; ok && entry.Size == info.Size() {
+			s.index[id] = entry
+			continue
+		}
+
+		sess, size, err := s.replay(id, true)
+		if err != nil {
+			log.Printf("[SessionStore] Skipping unreadable session %s: %v", id, err)
+			continue
+		}
+		s.index[id] = &indexEntry{
+			ID:        sess.ID,
+			UserID:    sess.UserID,
+			Pinned:    sess.Pinned,
+			CreatedAt: sess.CreatedAt,
+			UpdatedAt: sess.UpdatedAt,
+			DeletedAt: sess.DeletedAt,
+			Messages:  len(sess.Messages),
+			Size:      size,
+		}
+	}
+	return nil
+}
+
+// replay reads a session file. With repair set, a torn or corrupt tail is
+// truncated so later appends start on a clean line. It returns the session
+// and the size of the valid part of the file.
+func (s *FileStore) replay(id string, repair bool) (*Session, int64, error) {
+	path := s.sessionPath(id)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var sess *Session
+	var offset int64
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, '\n')
+		var ev fileEvent
+		if end < 0 || json.Unmarshal(data[:end], &ev) != nil || (sess == nil) != (ev.Type == eventCreate) {
+			if !repair {
+				return nil, 0, fmt.Errorf("corrupt session file at offset %d", offset)
+			}
+			log.Printf("[SessionStore] Discarding torn tail of %s at offset %d", filepath.Base(path), offset)
+			if err := os.Truncate(path, offset); err != nil {
+				return nil, 0, fmt.Errorf("failed to truncate session file: %w", err)
+			}
+			break
+		}
+
+		switch ev.Type {
+		case eventCreate:
+			sess = &Session{
+				ID:        ev.ID,
+				UserID:    ev.UserID,
+				Profile:   ev.Profile,
+				CreatedAt: ev.CreatedAt,
+				UpdatedAt: ev.At,
+				Messages:  make([]Message, 0),
+			}
+			if ev.Update != nil {
+				ev.Update.apply(sess)
+			}
+		case eventUpdate:
+			if ev.Update != nil {
+				ev.Update.apply(sess)
+			}
+		case eventMessage:
+			if ev.Message != nil {
+				sess.Messages = append(sess.Messages, *ev.Message)
+				sess.ActiveLeaf = ev.Message.ID
+			}
+			sess.UpdatedAt = ev.At
+		}
+		offset += int64(end + 1)
+		data = data[end+1:]
+	}
+
+	if sess == nil {
+		if repair {
+			os.Remove(path)
+		}
+		return nil, 0, fmt.Errorf("session file has no create event")
+	}
+	return sess, offset, nil
+}
+
+// appendEvents writes event lines to a session file, whose valid part is
+// size bytes long, and syncs them to disk with a single fsync. If the write
+// fails, the file is truncated back to size so no torn line is left in
+// front of later appends. It returns the number of bytes written.
+func (s *FileStore) appendEvents(id string, size int64, flag int, evs ...fileEvent) (int64, error) {
+	var lines []byte
+	for _, ev := range evs {
+		line, err := json.Marshal(ev)
+		if err != nil {
+			return 0, fmt.Errorf("failed to encode session event: %w", err)
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+
+	f, err := os.OpenFile(s.sessionPath(id), flag|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open session file: %w", err)
+	}
+	if _, err := f.Write(lines); err != nil {
+		discardTail(f, size)
+		return 0, fmt.Errorf("failed to write session file: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		discardTail(f, size)
+		return 0, fmt.Errorf("failed to sync session file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return 0, fmt.Errorf("failed to close session file: %w", err)
+	}
+	return int64(len(lines)), nil
+}
+
+// discardTail cuts a session file back to size after a failed write and
+// closes it.
+func discardTail(f *os.File, size int64) {
+	if err := f.Truncate(size); err != nil {
+		log.Printf("[SessionStore] Failed to truncate %s after a failed write: %v", filepath.Base(f.Name()), err)
+	}
+	f.Close()
+}
+
+func (s *FileStore) Create(ctx context.Context, sess *Session) error {
+	if !validSessionID.MatchString(sess.ID) {
+		return fmt.Errorf("invalid session ID %q", sess.ID)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.index[sess.ID]; exists {
+		return fmt.Errorf("session %s already exists", sess.ID)
+	}
+	meta := &SessionUpdate{
+		Title:          &sess.Title,
+		AutoTitle:      &sess.AutoTitle,
+		Pinned:         &sess.Pinned,
+		Summary:        &sess.Summary,
+		SummaryThrough: &sess.SummaryThrough,
+	}
+	if !sess.DeletedAt.IsZero() {
+		meta.DeletedAt = &sess.DeletedAt
+	}
+	evs := []fileEvent{{
+		Type:      eventCreate,
+		At:        sess.UpdatedAt,
+		ID:        sess.ID,
+		UserID:    sess.UserID,
+		Profile:   sess.Profile,
+		CreatedAt: sess.CreatedAt,
+		Update:    meta,
+	}}
+	for i := range sess.Messages {
+		evs = append(evs, fileEvent{Type: eventMessage, At: sess.UpdatedAt, Message: &sess.Messages[i]})
+	}
+	if n := len(sess.Messages); n > 0 && sess.ActiveLeaf != sess.Messages[n-1].ID {
+		evs = append(evs, fileEvent{Type: eventUpdate, At: sess.UpdatedAt, Update: &SessionUpdate{ActiveLeaf: &sess.ActiveLeaf}})
+	}
+	size, err := s.appendEvents(sess.ID, 0, os.O_CREATE|os.O_EXCL, evs...)
+	if err != nil {
+		if !errors.Is(err, os.ErrExist) {
+			os.Remove(s.sessionPath(sess.ID))
+		}
+		return err
+	}
+
+	s.index[sess.ID] = &indexEntry{
+		ID:        sess.ID,
+		UserID:    sess.UserID,
+		Pinned:    sess.Pinned,
+		CreatedAt: sess.CreatedAt,
+		UpdatedAt: sess.UpdatedAt,
+		DeletedAt: sess.DeletedAt,
+		Messages:  len(sess.Messages),
+		Size:      size,
+	}
+	return nil
+}
+
+func (s *FileStore) Get(ctx context.Context, id string) (*Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, exists := s.index[id]; !exists {
+		return nil, ErrNotFound
+	}
+	sess, size, err := s.replay(id, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session %s: %w", id, err)
+	}
+	sess.Version = size
+	return sess, nil
+}
+
+// Append uses the size of the session file as its version, since every
+// write appends to it.
+func (s *FileStore) Append(ctx context.Context, id string, msg Message, version int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, exists := s.index[id]
+	if !exists {
+		return ErrNotFound
+	}
+	if entry.Size != version {
+		return ErrConflict
+	}
+	now := time.Now()
+	n, err := s.appendEvents(id, entry.Size, 0, fileEvent{Type: eventMessage, At: now, Message: &msg})
+	if err != nil {
+		return err
+	}
+	entry.UpdatedAt = now
+	entry.Messages++
+	entry.Size += n
+	return nil
+}
+
+func (s *FileStore) Update(ctx context.Context, id string, update SessionUpdate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, exists := s.index[id]
+	if !exists {
+		return ErrNotFound
+	}
+	n, err := s.appendEvents(id, entry.Size, 0, fileEvent{Type: eventUpdate, At: time.Now(), Update: &update})
+	if err != nil {
+		return err
+	}
+	entry.Size += n
+	if update.Pinned != nil {
+		entry.Pinned = *update.Pinned
+	}
+	if update.DeletedAt != nil {
+		entry.DeletedAt = *update.DeletedAt
+	}
+	if update.UpdatedAt != nil {
+		entry.UpdatedAt = *update.UpdatedAt
+	}
+	return nil
+}
+
+func (s *FileStore) List(ctx context.Context) ([]*Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sessions := make([]*Session, 0, len(s.index))
+	for id := range s.index {
+		sess, size, err := s.replay(id, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read session %s: %w", id, err)
+		}
+		sess.Version = size
+		sessions = append(sessions, sess)
+	}
+	return sessions, nil
+}
+
+func (s *FileStore) Infos(ctx context.Context) ([]SessionInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	infos := make([]SessionInfo, 0, len(s.index))
+	for _, entry := range s.index {
+		infos = append(infos, SessionInfo{
+			ID:        entry.ID,
+			UserID:    entry.UserID,
+			Pinned:    entry.Pinned,
+			CreatedAt: entry.CreatedAt,
+			UpdatedAt: entry.UpdatedAt,
+			DeletedAt: entry.DeletedAt,
+			Messages:  entry.Messages,
+		})
+	}
+	return infos, nil
+}
+
+func (s *FileStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.index[id]; !exists {
+		return ErrNotFound
+	}
+	return s.remove(id)
+}
+
+// remove deletes a session file and its index entry. The caller must hold
+// the write lock.
+func (s *FileStore) remove(id string) error {
+	if err := os.Remove(s.sessionPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete session file: %w", err)
+	}
+	delete(s.index, id)
+	return nil
+}
+
+// Close saves the index so the next open does not replay every session.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.saveIndex()
+}
+
+// saveIndex atomically replaces index.json. The caller must hold the lock
+// or have exclusive access to the store.
+func (s *FileStore) saveIndex() error {
+	tmp, err := os.CreateTemp(s.dir, indexFile+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create session index: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	if err := json.NewEncoder(w).Encode(s.index); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to encode session index: %w", err)
+	}
+	if err := flushAndSync(w, tmp); err != nil {
+		return fmt.Errorf("failed to write session index: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, indexFile)); err != nil {
+		return fmt.Errorf("failed to replace session index: %w", err)
+	}
+	return nil
+}
+
+func flushAndSync(w *bufio.Writer, f *os.File) error {
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Len returns the number of sessions in the store.
+func (s *FileStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.index)
+}
@@ -0,0 +1,122 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps sessions in a map. Everything is lost on restart. It
+// stores and returns copies, so callers never share a session with it.
+type MemoryStore struct {
+	sessions map[string]*Session
+	mu       sync.RWMutex
+}
+
+// NewMemoryStore creates an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions: make(map[string]*Session),
+	}
+}
+
+func (s *MemoryStore) Create(ctx context.Context, sess *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.sessions[sess.ID]; exists {
+		return fmt.Errorf("session %s already exists", sess.ID)
+	}
+	stored := sess.clone()
+	stored.Version = 0
+	s.sessions[sess.ID] = stored
+	return nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, id string) (*Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sess, exists := s.sessions[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return sess.clone(), nil
+}
+
+func (s *MemoryStore) Append(ctx context.Context, id string, msg Message, version int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, exists := s.sessions[id]
+	if !exists {
+		return ErrNotFound
+	}
+	if sess.Version != version {
+		return ErrConflict
+	}
+	sess.Messages = append(sess.Messages, msg)
+	sess.ActiveLeaf = msg.ID
+	sess.UpdatedAt = time.Now()
+	sess.Version++
+	return nil
+}
+
+func (s *MemoryStore) Update(ctx context.Context, id string, update SessionUpdate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, exists := s.sessions[id]
+	if !exists {
+		return ErrNotFound
+	}
+	update.apply(sess)
+	sess.Version++
+	return nil
+}
+
+func (s *MemoryStore) List(ctx context.Context) ([]*Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		sessions = append(sessions, sess.clone())
+	}
+	return sessions, nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.sessions[id]; !exists {
+		return ErrNotFound
+	}
+	delete(s.sessions, id)
+	return nil
+}
+
+func (s *MemoryStore) Infos(ctx context.Context) ([]SessionInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	infos := make([]SessionInfo, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		infos = append(infos, SessionInfo{
+			ID:        sess.ID,
+			UserID:    sess.UserID,
+			Pinned:    sess.Pinned,
+			CreatedAt: sess.CreatedAt,
+			UpdatedAt: sess.UpdatedAt,
+			DeletedAt: sess.DeletedAt,
+			Messages:  len(sess.Messages),
+		})
+	}
+	return infos, nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,422 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis layout, all keys under a configurable prefix:
+//
+//	<prefix><id>           hash of id, user_id, profile, title, auto_title,
+//	                       pinned, active_leaf, summary, summary_through,
+//	                       created_at, updated_at, deleted_at, and version,
+//	                       which every write increments
+//	<prefix><id>:messages  list of JSON-encoded messages
+//	<prefix>index          sorted set of session IDs scored by updated_at in
+//	                       microseconds, used for listing
+//
+// Given a TTL, both per-session keys carry it and every append renews it,
+// so Redis expires idle sessions on its own. Such expiry ignores pinning and
+// the trash, so NewService sets a TTL only when SESSION_REDIS_TTL asks for
+// one and otherwise leaves retention to the service's cleanup loop. Index
+// members whose session has expired are pruned lazily.
+const (
+	defaultRedisPrefix = "session:"
+	maxTxRetries       = 100
+)
+
+// RedisStore keeps sessions in Redis so every backend replica sees the same
+// history.
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+}
+
+// NewRedisStore creates a store using client. Sessions expire ttl after their
+// last update; zero keeps them until deleted.
+func NewRedisStore(client *redis.Client, prefix string, ttl time.Duration) *RedisStore {
+	if prefix == "" {
+		prefix = defaultRedisPrefix
+	}
+	return &RedisStore{
+		client: client,
+		prefix: prefix,
+		ttl:    ttl,
+	}
+}
+
+// OpenRedisStore connects to the Redis server at url (redis://...) and
+// checks that it answers.
+func OpenRedisStore(ctx context.Context, url, prefix string, ttl time.Duration) (*RedisStore, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis URL: %w", err)
+	}
+	client := redis.NewClient(opts)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+	return NewRedisStore(client, prefix, ttl), nil
+}
+
+// TTL reports how long an idle session lives before Redis expires it.
+func (s *RedisStore) TTL() time.Duration {
+	return s.ttl
+}
+
+func (s *RedisStore) metaKey(id string) string     { return s.prefix + id }
+func (s *RedisStore) messagesKey(id string) string { return s.prefix + id + ":messages" }
+func (s *RedisStore) indexKey() string             { return s.prefix + "index" }
+
+// touch queues the writes that mark a session updated at t: the index score
+// and, with a TTL, renewed expiry of both keys.
+func (s *RedisStore) touch(pipe redis.Pipeliner, ctx context.Context, id string, t time.Time) {
+	pipe.ZAdd(ctx, s.indexKey(), redis.Z{Score: float64(t.UnixMicro()), Member: id})
+	if s.ttl > 0 {
+		pipe.Expire(ctx, s.metaKey(id), s.ttl)
+		pipe.Expire(ctx, s.messagesKey(id), s.ttl)
+	}
+}
+
+func (s *RedisStore) Create(ctx context.Context, sess *Session) error {
+	messages := make([]interface{}, 0, len(sess.Messages))
+	for _, msg := range sess.Messages {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return fmt.Errorf("failed to encode message: %w", err)
+		}
+		messages = append(messages, data)
+	}
+
+	key := s.metaKey(sess.ID)
+	err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+		n, err := tx.Exists(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return fmt.Errorf("session %s already exists", sess.ID)
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, key,
+				"id", sess.ID,
+				"user_id", sess.UserID,
+				"profile", sess.Profile,
+				"title", sess.Title,
+				"auto_title", sess.AutoTitle,
+				"pinned", sess.Pinned,
+				"active_leaf", sess.ActiveLeaf,
+				"summary", sess.Summary,
+				"summary_through", sess.SummaryThrough,
+				"created_at", sess.CreatedAt.Format(time.RFC3339Nano),
+				"updated_at", sess.UpdatedAt.Format(time.RFC3339Nano),
+				"deleted_at", formatRedisTime(sess.DeletedAt),
+				"version", 0,
+			)
+			if len(messages) > 0 {
+				pipe.RPush(ctx, s.messagesKey(sess.ID), messages...)
+			}
+			s.touch(pipe, ctx, sess.ID, sess.UpdatedAt)
+			return nil
+		})
+		return err
+	}, key)
+	if errors.Is(err, redis.TxFailedErr) {
+		return fmt.Errorf("session %s already exists", sess.ID)
+	}
+	return err
+}
+
+func (s *RedisStore) Get(ctx context.Context, id string) (*Session, error) {
+	var meta *redis.MapStringStringCmd
+	var messages *redis.StringSliceCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		meta = pipe.HGetAll(ctx, s.metaKey(id))
+		messages = pipe.LRange(ctx, s.messagesKey(id), 0, -1)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session %s: %w", id, err)
+	}
+	return decodeRedisSession(meta.Val(), messages.Val())
+}
+
+func decodeRedisSession(meta map[string]string, messages []string) (*Session, error) {
+	if len(meta) == 0 {
+		return nil, ErrNotFound
+	}
+	sess := &Session{
+		ID:             meta["id"],
+		UserID:         meta["user_id"],
+		Profile:        meta["profile"],
+		Title:          meta["title"],
+		AutoTitle:      meta["auto_title"] == "1",
+		Pinned:         meta["pinned"] == "1",
+		ActiveLeaf:     meta["active_leaf"],
+		Summary:        meta["summary"],
+		SummaryThrough: meta["summary_through"],
+		Messages:       make([]Message, 0, len(messages)),
+	}
+	var err error
+	if sess.CreatedAt, err = time.Parse(time.RFC3339Nano, meta["created_at"]); err != nil {
+		return nil, fmt.Errorf("invalid created_at in session %s: %w", sess.ID, err)
+	}
+	if sess.UpdatedAt, err = time.Parse(time.RFC3339Nano, meta["updated_at"]); err != nil {
+		return nil, fmt.Errorf("invalid updated_at in session %s: %w", sess.ID, err)
+	}
+	if sess.DeletedAt, err = parseRedisTime(meta["deleted_at"]); err != nil {
+		return nil, fmt.Errorf("invalid deleted_at in session %s: %w", sess.ID, err)
+	}
+	if sess.Version, err = parseRedisVersion(meta["version"]); err != nil {
+		return nil, fmt.Errorf("invalid version in session %s: %w", sess.ID, err)
+	}
+	for _, data := range messages {
+		var msg Message
+		if err := json.Unmarshal([]byte(data), &msg); err != nil {
+			return nil, fmt.Errorf("invalid message in session %s: %w", sess.ID, err)
+		}
+		sess.Messages = append(sess.Messages, msg)
+	}
+	return sess, nil
+}
+
+// formatRedisTime stores t, a zero time as the empty string.
+func formatRedisTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+func parseRedisTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, v)
+}
+
+// parseRedisVersion reads the version field, which sessions written before
+// it existed lack.
+func parseRedisVersion(v string) (int64, error) {
+	if v == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(v, 10, 64)
+}
+
+// Append adds a message under WATCH on the session's hash once it has
+// checked the session's version, so a write from any replica that commits
+// in between fails the transaction. A failed transaction is retried, and
+// then reports ErrConflict if the version moved on. This also keeps a
+// message from landing in a session that was deleted or expired in the
+// meantime.
+func (s *RedisStore) Append(ctx context.Context, id string, msg Message, version int64) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	key := s.metaKey(id)
+	for i := 0; i < maxTxRetries; i++ {
+		err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+			meta, err := tx.HMGet(ctx, key, "id", "version").Result()
+			if err != nil {
+				return err
+			}
+			if meta[0] == nil {
+				return ErrNotFound
+			}
+			current, _ := meta[1].(string)
+			if v, err := parseRedisVersion(current); err != nil {
+				return fmt.Errorf("invalid version in session %s: %w", id, err)
+			} else if v != version {
+				return ErrConflict
+			}
+			now := time.Now()
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.RPush(ctx, s.messagesKey(id), data)
+				pipe.HSet(ctx, key, "updated_at", now.Format(time.RFC3339Nano), "active_leaf", msg.ID)
+				pipe.HIncrBy(ctx, key, "version", 1)
+				s.touch(pipe, ctx, id, now)
+				return nil
+			})
+			return err
+		}, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return fmt.Errorf("failed to append to session %s: too much contention", id)
+}
+
+func (s *RedisStore) Update(ctx context.Context, id string, update SessionUpdate) error {
+	var fields []interface{}
+	if update.Title != nil {
+		fields = append(fields, "title", *update.Title)
+	}
+	if update.AutoTitle != nil {
+		fields = append(fields, "auto_title", *update.AutoTitle)
+	}
+	if update.Pinned != nil {
+		fields = append(fields, "pinned", *update.Pinned)
+	}
+	if update.ActiveLeaf != nil {
+		fields = append(fields, "active_leaf", *update.ActiveLeaf)
+	}
+	if update.Summary != nil {
+		fields = append(fields, "summary", *update.Summary)
+	}
+	if update.SummaryThrough != nil {
+		fields = append(fields, "summary_through", *update.SummaryThrough)
+	}
+	if update.DeletedAt != nil {
+		fields = append(fields, "deleted_at", formatRedisTime(*update.DeletedAt))
+	}
+	if update.UpdatedAt != nil {
+		fields = append(fields, "updated_at", update.UpdatedAt.Format(time.RFC3339Nano))
+	}
+
+	key := s.metaKey(id)
+	for i := 0; i < maxTxRetries; i++ {
+		err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+			n, err := tx.Exists(ctx, key).Result()
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				return ErrNotFound
+			}
+			if len(fields) == 0 {
+				return nil
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.HSet(ctx, key, fields...)
+				pipe.HIncrBy(ctx, key, "version", 1)
+				if update.UpdatedAt != nil {
+					s.touch(pipe, ctx, id, *update.UpdatedAt)
+				}
+				return nil
+			})
+			return err
+		}, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return fmt.Errorf("failed to update session %s: too much contention", id)
+}
+
+func (s *RedisStore) List(ctx context.Context) ([]*Session, error) {
+	ids, err := s.client.ZRange(ctx, s.indexKey(), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	metas := make([]*redis.MapStringStringCmd, len(ids))
+	messages := make([]*redis.StringSliceCmd, len(ids))
+	_, err = s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			metas[i] = pipe.HGetAll(ctx, s.metaKey(id))
+			messages[i] = pipe.LRange(ctx, s.messagesKey(id), 0, -1)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	sessions := make([]*Session, 0, len(ids))
+	var expired []interface{}
+	for i, id := range ids {
+		sess, err := decodeRedisSession(metas[i].Val(), messages[i].Val())
+		if errors.Is(err, ErrNotFound) {
+			expired = append(expired, id)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, sess)
+	}
+	if len(expired) > 0 {
+		if err := s.client.ZRem(ctx, s.indexKey(), expired...).Err(); err != nil {
+			return nil, fmt.Errorf("failed to prune session index: %w", err)
+		}
+	}
+	return sessions, nil
+}
+
+func (s *RedisStore) Infos(ctx context.Context) ([]SessionInfo, error) {
+	ids, err := s.client.ZRange(ctx, s.indexKey(), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	metas := make([]*redis.SliceCmd, len(ids))
+	counts := make([]*redis.IntCmd, len(ids))
+	_, err = s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			metas[i] = pipe.HMGet(ctx, s.metaKey(id), "user_id", "pinned", "created_at", "updated_at", "deleted_at")
+			counts[i] = pipe.LLen(ctx, s.messagesKey(id))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	infos := make([]SessionInfo, 0, len(ids))
+	for i, id := range ids {
+		fields := metas[i].Val()
+		updated, ok := fields[3].(string)
+		if !ok {
+			// Expired since the index was read
+			continue
+		}
+		info := SessionInfo{ID: id, Messages: int(counts[i].Val())}
+		info.UserID, _ = fields[0].(string)
+		pinned, _ := fields[1].(string)
+		info.Pinned = pinned == "1"
+		created, _ := fields[2].(string)
+		if info.CreatedAt, err = time.Parse(time.RFC3339Nano, created); err != nil {
+			return nil, fmt.Errorf("invalid created_at in session %s: %w", id, err)
+		}
+		if info.UpdatedAt, err = time.Parse(time.RFC3339Nano, updated); err != nil {
+			return nil, fmt.Errorf("invalid updated_at in session %s: %w", id, err)
+		}
+		deleted, _ := fields[4].(string)
+		if info.DeletedAt, err = parseRedisTime(deleted); err != nil {
+			return nil, fmt.Errorf("invalid deleted_at in session %s: %w", id, err)
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (s *RedisStore) Delete(ctx context.Context, id string) error {
+	var deleted *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		deleted = pipe.Del(ctx, s.metaKey(id), s.messagesKey(id))
+		pipe.ZRem(ctx, s.indexKey(), id)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete session %s: %w", id, err)
+	}
+	if deleted.Val() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,185 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRedisStore(t *testing.T, ttl time.Duration) (*RedisStore, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	store := NewRedisStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "", ttl)
+	t.Cleanup(func() { store.Close() })
+	return store, mr
+}
+
+func TestRedisStoreConformance(t *testing.T) {
+	testStoreConformance(t, func(t *testing.T) Store {
+		store, _ := newTestRedisStore(t, time.Hour)
+		return store
+	})
+}
+
+func TestRedisStore_TTLExpiresIdleSessions(t *testing.T) {
+	store, mr := newTestRedisStore(t, time.Hour)
+	ctx := context.Background()
+	now := time.Now()
+	require.NoError(t, store.Create(ctx, &Session{ID: "sess_idle", CreatedAt: now, UpdatedAt: now}))
+	require.NoError(t, store.Create(ctx, &Session{ID: "sess_active", CreatedAt: now, UpdatedAt: now}))
+	require.NoError(t, appendMessage(store, "sess_idle", Message{Role: "user", Content: "hello"}))
+
+	mr.FastForward(40 * time.Minute)
+	require.NoError(t, appendMessage(store, "sess_active", Message{Role: "user", Content: "still here"}))
+	mr.FastForward(30 * time.Minute)
+
+	_, err := store.Get(ctx, "sess_idle")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.False(t, mr.Exists("session:sess_idle:messages"))
+	got, err := store.Get(ctx, "sess_active")
+	require.NoError(t, err)
+	assert.Len(t, got.Messages, 1)
+
+	// Listing prunes the expired session from the index
+	sessions, err := store.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, "sess_active", sessions[0].ID)
+	members, err := mr.ZMembers("session:index")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sess_active"}, members)
+}
+
+func TestRedisStore_AppendToDeletedSession(t *testing.T) {
+	store, mr := newTestRedisStore(t, time.Hour)
+	ctx := context.Background()
+	now := time.Now()
+	require.NoError(t, store.Create(ctx, &Session{ID: "sess_a", CreatedAt: now, UpdatedAt: now}))
+	require.NoError(t, store.Delete(ctx, "sess_a"))
+
+	err := store.Append(ctx, "sess_a", Message{Role: "user", Content: "hello"}, 0)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.False(t, mr.Exists("session:sess_a:messages"), "append must not recreate a deleted session")
+}
+
+func TestRedisStore_ConcurrentAppendsAcrossClients(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+
+	// Each store stands in for a separate backend replica
+	stores := make([]*RedisStore, 3)
+	for i := range stores {
+		stores[i] = NewRedisStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "", time.Hour)
+		defer stores[i].Close()
+	}
+	now := time.Now()
+	require.NoError(t, stores[0].Create(ctx, &Session{ID: "sess_a", CreatedAt: now, UpdatedAt: now}))
+
+	var wg sync.WaitGroup
+	for _, store := range stores {
+		for j := 0; j < 10; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, appendMessage(store, "sess_a", Message{Role: "user", Content: "hello"}))
+			}()
+		}
+	}
+	wg.Wait()
+
+	got, err := stores[1].Get(ctx, "sess_a")
+	require.NoError(t, err)
+	assert.Len(t, got.Messages, 30)
+	assert.Equal(t, "30", mr.HGet("session:sess_a", "version"))
+}
+
+func TestAddMessage_ConcurrentReplicas(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+
+	// Each service stands in for a separate backend replica, whose turn
+	// locks the others do not see
+	replicas := make([]*Service, 3)
+	for i := range replicas {
+		replicas[i] = NewServiceWithStore(NewRedisStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "", 0))
+		replicas[i].limiter = &limiter{limits: Limits{MaxMessages: 20}}
+		defer replicas[i].Close()
+	}
+	sess, err := replicas[0].CreateSession(ctx)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var added, rejected int
+	for _, svc := range replicas {
+		for j := 0; j < 10; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := svc.AddMessage(ctx, sess.ID, Message{Role: "user", Content: "hello"})
+				mu.Lock()
+				defer mu.Unlock()
+				if errors.Is(err, ErrLimitExceeded) {
+					rejected++
+					return
+				}
+				assert.NoError(t, err)
+				added++
+			}()
+		}
+	}
+	wg.Wait()
+
+	assert.Equal(t, 20, added)
+	assert.Equal(t, 10, rejected, "the message limit holds across replicas")
+	got, err := replicas[1].GetSession(ctx, sess.ID)
+	require.NoError(t, err)
+	require.Len(t, got.Messages, 20)
+	for i := 1; i < len(got.Messages); i++ {
+		assert.Equal(t, got.Messages[i-1].ID, got.Messages[i].ParentID, "the conversation stays one chain")
+	}
+}
+
+func TestRedisStore_CreateDuplicateAcrossClients(t *testing.T) {
+	store, mr := newTestRedisStore(t, 0)
+	other := NewRedisStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "", 0)
+	defer other.Close()
+	ctx := context.Background()
+	now := time.Now()
+
+	require.NoError(t, store.Create(ctx, &Session{ID: "sess_a", CreatedAt: now, UpdatedAt: now}))
+	assert.Error(t, other.Create(ctx, &Session{ID: "sess_a", CreatedAt: now, UpdatedAt: now}))
+	assert.Equal(t, time.Duration(0), mr.TTL("session:sess_a"), "zero TTL keeps sessions until deleted")
+}
+
+func TestNewService_RedisStore(t *testing.T) {
+	mr := miniredis.RunT(t)
+	t.Setenv("SESSION_REDIS_URL", "redis://"+mr.Addr())
+	t.Setenv("SESSION_REDIS_PREFIX", "test:")
+
+	svc := NewService()
+	defer svc.Close()
+	require.IsType(t, &RedisStore{}, svc.store)
+
+	sess, err := svc.CreateSession(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, mr.TTL("test:"+sess.ID), "retention is left to the cleanup loop")
+}
+
+func TestNewService_RedisTTL(t *testing.T) {
+	mr := miniredis.RunT(t)
+	t.Setenv("SESSION_REDIS_URL", "redis://"+mr.Addr())
+	t.Setenv("SESSION_REDIS_TTL", "3600")
+
+	svc := NewService()
+	defer svc.Close()
+	sess, err := svc.CreateSession(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, mr.TTL("session:"+sess.ID))
+}
@@ -0,0 +1,416 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testStoreConformance checks the behaviour every Store must share. newStore
+// returns an empty store.
+func testStoreConformance(t *testing.T, newStore func(t *testing.T) Store) {
+	ctx := context.Background()
+	newSession := func(id, userID string) *Session {
+		now := time.Now()
+		return &Session{ID: id, UserID: userID, CreatedAt: now, UpdatedAt: now, Messages: make([]Message, 0)}
+	}
+
+	t.Run("CreateAndGet", func(t *testing.T) {
+		store := newStore(t)
+		created := newSession("sess_a", "alice")
+		created.Title, created.AutoTitle, created.Summary = "Printer", true, "The printer jams."
+		created.Profile = "support"
+		require.NoError(t, store.Create(ctx, created))
+
+		got, err := store.Get(ctx, "sess_a")
+		require.NoError(t, err)
+		assert.Equal(t, "sess_a", got.ID)
+		assert.Equal(t, "alice", got.UserID)
+		assert.Equal(t, "support", got.Profile)
+		assert.Equal(t, "Printer", got.Title)
+		assert.True(t, got.AutoTitle)
+		assert.Equal(t, "The printer jams.", got.Summary)
+		assert.True(t, got.CreatedAt.Equal(created.CreatedAt))
+		assert.True(t, got.UpdatedAt.Equal(created.UpdatedAt))
+		assert.Empty(t, got.Messages)
+	})
+
+	t.Run("CreateDuplicate", func(t *testing.T) {
+		store := newStore(t)
+		require.NoError(t, store.Create(ctx, newSession("sess_a", "")))
+		assert.Error(t, store.Create(ctx, newSession("sess_a", "")))
+	})
+
+	t.Run("GetNotFound", func(t *testing.T) {
+		store := newStore(t)
+		_, err := store.Get(ctx, "missing")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("AppendKeepsOrder", func(t *testing.T) {
+		store := newStore(t)
+		created := newSession("sess_a", "")
+		require.NoError(t, store.Create(ctx, created))
+
+		messages := []Message{
+			{Role: "user", Content: "hello"},
+			{Role: "assistant", Content: "hi, how can I help?"},
+			{Role: "user", Content: "line one\nline \"two\""},
+		}
+		for _, m := range messages {
+			require.NoError(t, appendMessage(store, "sess_a", m))
+		}
+
+		got, err := store.Get(ctx, "sess_a")
+		require.NoError(t, err)
+		assert.Equal(t, messages, got.Messages)
+		assert.True(t, got.UpdatedAt.After(created.CreatedAt))
+	})
+
+	t.Run("Snapshots", func(t *testing.T) {
+		store := newStore(t)
+		created := newSession("sess_a", "alice")
+		require.NoError(t, store.Create(ctx, created))
+		created.UserID = "mallory"
+		require.NoError(t, appendMessage(store, "sess_a", Message{Role: "user", Content: "hello"}))
+
+		snapshot, err := store.Get(ctx, "sess_a")
+		require.NoError(t, err)
+		require.NoError(t, appendMessage(store, "sess_a", Message{Role: "assistant", Content: "hi"}))
+		assert.Len(t, snapshot.Messages, 1, "a snapshot does not see later appends")
+
+		snapshot.Messages[0].Content = "changed"
+		snapshot.Messages = append(snapshot.Messages, Message{Role: "user", Content: "injected"})
+		got, err := store.Get(ctx, "sess_a")
+		require.NoError(t, err)
+		assert.Equal(t, "alice", got.UserID)
+		assert.Equal(t, []Message{{Role: "user", Content: "hello"}, {Role: "assistant", Content: "hi"}}, got.Messages)
+	})
+
+	t.Run("AppendConflict", func(t *testing.T) {
+		store := newStore(t)
+		require.NoError(t, store.Create(ctx, newSession("sess_a", "")))
+		stale, err := store.Get(ctx, "sess_a")
+		require.NoError(t, err)
+
+		require.NoError(t, store.Append(ctx, "sess_a", Message{Role: "user", Content: "hello"}, stale.Version))
+		err = store.Append(ctx, "sess_a", Message{Role: "user", Content: "hello again"}, stale.Version)
+		assert.ErrorIs(t, err, ErrConflict, "another append got in first")
+
+		current, err := store.Get(ctx, "sess_a")
+		require.NoError(t, err)
+		title := "Greeting"
+		require.NoError(t, store.Update(ctx, "sess_a", SessionUpdate{Title: &title}))
+		err = store.Append(ctx, "sess_a", Message{Role: "assistant", Content: "hi"}, current.Version)
+		assert.ErrorIs(t, err, ErrConflict, "updates change the version too")
+
+		got, err := store.Get(ctx, "sess_a")
+		require.NoError(t, err)
+		assert.Equal(t, []Message{{Role: "user", Content: "hello"}}, got.Messages)
+		require.NoError(t, store.Append(ctx, "sess_a", Message{Role: "assistant", Content: "hi"}, got.Version))
+	})
+
+	t.Run("AppendNotFound", func(t *testing.T) {
+		store := newStore(t)
+		err := store.Append(ctx, "missing", Message{Role: "user", Content: "hello"}, 0)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		store := newStore(t)
+		created := newSession("sess_a", "alice")
+		created.Title = "Printer"
+		require.NoError(t, store.Create(ctx, created))
+		require.NoError(t, appendMessage(store, "sess_a", Message{Role: "user", Content: "hello"}))
+		before, err := store.Get(ctx, "sess_a")
+		require.NoError(t, err)
+		assert.Equal(t, "Printer", before.Title)
+		assert.False(t, before.Pinned)
+
+		pinned := true
+		require.NoError(t, store.Update(ctx, "sess_a", SessionUpdate{Pinned: &pinned}))
+		title := "Printer jams"
+		require.NoError(t, store.Update(ctx, "sess_a", SessionUpdate{Title: &title}))
+		auto, summary, through := true, "The printer jams on duplex.", "m1"
+		require.NoError(t, store.Update(ctx, "sess_a", SessionUpdate{AutoTitle: &auto, Summary: &summary, SummaryThrough: &through}))
+
+		got, err := store.Get(ctx, "sess_a")
+		require.NoError(t, err)
+		assert.Equal(t, "Printer jams", got.Title)
+		assert.True(t, got.AutoTitle)
+		assert.Equal(t, summary, got.Summary)
+		assert.Equal(t, "m1", got.SummaryThrough)
+		assert.True(t, got.Pinned)
+		assert.Len(t, got.Messages, 1)
+		assert.True(t, got.UpdatedAt.Equal(before.UpdatedAt), "metadata changes are not activity")
+
+		assert.ErrorIs(t, store.Update(ctx, "missing", SessionUpdate{Title: &title}), ErrNotFound)
+	})
+
+	t.Run("ActiveLeaf", func(t *testing.T) {
+		store := newStore(t)
+		created := newSession("sess_a", "")
+		created.Messages = []Message{{ID: "m1", Role: "user", Content: "hello"}, {ID: "m2", Role: "assistant", Content: "hi"}}
+		created.ActiveLeaf = "m1"
+		require.NoError(t, store.Create(ctx, created))
+		got, err := store.Get(ctx, "sess_a")
+		require.NoError(t, err)
+		assert.Equal(t, "m1", got.ActiveLeaf)
+
+		require.NoError(t, appendMessage(store, "sess_a", Message{ID: "m3", ParentID: "m1", Role: "assistant", Content: "hey"}))
+		got, err = store.Get(ctx, "sess_a")
+		require.NoError(t, err)
+		assert.Equal(t, "m3", got.ActiveLeaf, "appending moves the active leaf")
+		assert.Equal(t, "m1", got.Messages[2].ParentID)
+
+		leaf := "m2"
+		require.NoError(t, store.Update(ctx, "sess_a", SessionUpdate{ActiveLeaf: &leaf}))
+		got, err = store.Get(ctx, "sess_a")
+		require.NoError(t, err)
+		assert.Equal(t, "m2", got.ActiveLeaf)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		store := newStore(t)
+		require.NoError(t, store.Create(ctx, newSession("sess_a", "")))
+		require.NoError(t, store.Create(ctx, newSession("sess_b", "")))
+		require.NoError(t, appendMessage(store, "sess_b", Message{Role: "user", Content: "hello"}))
+
+		sessions, err := store.List(ctx)
+		require.NoError(t, err)
+		counts := make(map[string]int)
+		for _, s := range sessions {
+			counts[s.ID] = len(s.Messages)
+		}
+		assert.Equal(t, map[string]int{"sess_a": 0, "sess_b": 1}, counts)
+	})
+
+	t.Run("Infos", func(t *testing.T) {
+		store := newStore(t)
+		require.NoError(t, store.Create(ctx, newSession("sess_a", "alice")))
+		require.NoError(t, store.Create(ctx, newSession("sess_b", "")))
+		require.NoError(t, appendMessage(store, "sess_b", Message{Role: "user", Content: "hello"}))
+		pinned := true
+		require.NoError(t, store.Update(ctx, "sess_a", SessionUpdate{Pinned: &pinned}))
+
+		infos, err := store.Infos(ctx)
+		require.NoError(t, err)
+		require.Len(t, infos, 2)
+		byID := make(map[string]SessionInfo)
+		for _, info := range infos {
+			byID[info.ID] = info
+		}
+		assert.Equal(t, "alice", byID["sess_a"].UserID)
+		assert.True(t, byID["sess_a"].Pinned)
+		assert.Equal(t, 0, byID["sess_a"].Messages)
+		assert.False(t, byID["sess_b"].Pinned)
+		assert.Equal(t, 1, byID["sess_b"].Messages)
+		assert.False(t, byID["sess_b"].UpdatedAt.Before(byID["sess_a"].UpdatedAt))
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		store := newStore(t)
+		require.NoError(t, store.Create(ctx, newSession("sess_a", "")))
+		require.NoError(t, store.Delete(ctx, "sess_a"))
+
+		_, err := store.Get(ctx, "sess_a")
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.ErrorIs(t, store.Delete(ctx, "sess_a"), ErrNotFound)
+	})
+
+	t.Run("Trash", func(t *testing.T) {
+		store := newStore(t)
+		created := newSession("sess_a", "alice")
+		created.CreatedAt = time.Now().Add(-2 * time.Hour)
+		require.NoError(t, store.Create(ctx, created))
+
+		deleted := time.Now().Add(-time.Minute)
+		require.NoError(t, store.Update(ctx, "sess_a", SessionUpdate{DeletedAt: &deleted}))
+		got, err := store.Get(ctx, "sess_a")
+		require.NoError(t, err)
+		assert.True(t, got.DeletedAt.Equal(deleted))
+		infos, err := store.Infos(ctx)
+		require.NoError(t, err)
+		require.Len(t, infos, 1)
+		assert.True(t, infos[0].DeletedAt.Equal(deleted))
+		assert.True(t, infos[0].CreatedAt.Equal(created.CreatedAt))
+
+		var restored time.Time
+		used := time.Now().Add(time.Minute)
+		require.NoError(t, store.Update(ctx, "sess_a", SessionUpdate{DeletedAt: &restored, UpdatedAt: &used}))
+		got, err = store.Get(ctx, "sess_a")
+		require.NoError(t, err)
+		assert.True(t, got.DeletedAt.IsZero())
+		assert.True(t, got.UpdatedAt.Equal(used))
+		infos, err = store.Infos(ctx)
+		require.NoError(t, err)
+		assert.True(t, infos[0].DeletedAt.IsZero())
+		assert.True(t, infos[0].UpdatedAt.Equal(used))
+	})
+
+	t.Run("ConcurrentAppends", func(t *testing.T) {
+		store := newStore(t)
+		for i := 0; i < 4; i++ {
+			require.NoError(t, store.Create(ctx, newSession(fmt.Sprintf("sess_%d", i), "")))
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			for j := 0; j < 10; j++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					assert.NoError(t, appendMessage(store, fmt.Sprintf("sess_%d", i), Message{Role: "user", Content: "hello"}))
+					_, err := store.List(ctx)
+					assert.NoError(t, err)
+				}()
+			}
+		}
+		wg.Wait()
+
+		for i := 0; i < 4; i++ {
+			got, err := store.Get(ctx, fmt.Sprintf("sess_%d", i))
+			require.NoError(t, err)
+			assert.Len(t, got.Messages, 10)
+		}
+	})
+}
+
+// appendMessage appends msg at the session's current version, retrying when
+// another write gets in first, as the service does.
+func appendMessage(store Store, id string, msg Message) error {
+	ctx := context.Background()
+	for {
+		sess, err := store.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		err = store.Append(ctx, id, msg, sess.Version)
+		if !errors.Is(err, ErrConflict) {
+			return err
+		}
+	}
+}
+
+func TestMemoryStoreConformance(t *testing.T) {
+	testStoreConformance(t, func(t *testing.T) Store {
+		return NewMemoryStore()
+	})
+}
+
+func TestFileStoreConformance(t *testing.T) {
+	testStoreConformance(t, func(t *testing.T) Store {
+		store, err := OpenFileStore(t.TempDir())
+		require.NoError(t, err)
+		t.Cleanup(func() { store.Close() })
+		return store
+	})
+}
+
+func fileStoreWithSession(t *testing.T, dir string) {
+	ctx := context.Background()
+	store, err := OpenFileStore(dir)
+	require.NoError(t, err)
+	now := time.Now()
+	require.NoError(t, store.Create(ctx, &Session{ID: "sess_a", UserID: "alice", CreatedAt: now, UpdatedAt: now}))
+	require.NoError(t, appendMessage(store, "sess_a", Message{Role: "user", Content: "first"}))
+	require.NoError(t, appendMessage(store, "sess_a", Message{Role: "assistant", Content: "second"}))
+	require.NoError(t, store.Close())
+}
+
+func TestFileStore_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	fileStoreWithSession(t, dir)
+
+	store, err := OpenFileStore(dir)
+	require.NoError(t, err)
+	defer store.Close()
+
+	got, err := store.Get(context.Background(), "sess_a")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", got.UserID)
+	assert.Equal(t, []Message{{Role: "user", Content: "first"}, {Role: "assistant", Content: "second"}}, got.Messages)
+}
+
+func TestFileStore_RecoversTornTail(t *testing.T) {
+	dir := t.TempDir()
+	fileStoreWithSession(t, dir)
+
+	// Simulate a crash halfway through writing a third message
+	path := filepath.Join(dir, "sess_a.jsonl")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"type":"message","at":"2024-01-01T00:00:00Z","message":{"role":"us`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	ctx := context.Background()
+	store, err := OpenFileStore(dir)
+	require.NoError(t, err)
+	got, err := store.Get(ctx, "sess_a")
+	require.NoError(t, err)
+	assert.Len(t, got.Messages, 2)
+
+	// Appends after recovery start on a clean line
+	require.NoError(t, appendMessage(store, "sess_a", Message{Role: "user", Content: "third"}))
+	require.NoError(t, store.Close())
+
+	store, err = OpenFileStore(dir)
+	require.NoError(t, err)
+	defer store.Close()
+	got, err = store.Get(ctx, "sess_a")
+	require.NoError(t, err)
+	require.Len(t, got.Messages, 3)
+	assert.Equal(t, "third", got.Messages[2].Content)
+}
+
+func TestFileStore_RecoversWithoutIndex(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	// A crash before Close leaves sessions the index has never seen
+	store, err := OpenFileStore(dir)
+	require.NoError(t, err)
+	now := time.Now()
+	require.NoError(t, store.Create(ctx, &Session{ID: "sess_a", CreatedAt: now, UpdatedAt: now}))
+	require.NoError(t, appendMessage(store, "sess_a", Message{Role: "user", Content: "hello"}))
+
+	reopened, err := OpenFileStore(dir)
+	require.NoError(t, err)
+	defer reopened.Close()
+	assert.Equal(t, 1, reopened.Len())
+	got, err := reopened.Get(ctx, "sess_a")
+	require.NoError(t, err)
+	assert.Len(t, got.Messages, 1)
+}
+
+func TestFileStore_DropsIndexEntriesWithoutFile(t *testing.T) {
+	dir := t.TempDir()
+	fileStoreWithSession(t, dir)
+	require.NoError(t, os.Remove(filepath.Join(dir, "sess_a.jsonl")))
+
+	store, err := OpenFileStore(dir)
+	require.NoError(t, err)
+	defer store.Close()
+	assert.Equal(t, 0, store.Len())
+	_, err = store.Get(context.Background(), "sess_a")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestFileStore_RejectsUnsafeIDs(t *testing.T) {
+	store, err := OpenFileStore(t.TempDir())
+	require.NoError(t, err)
+	defer store.Close()
+
+	now := time.Now()
+	err = store.Create(context.Background(), &Session{ID: "../escape", CreatedAt: now, UpdatedAt: now})
+	assert.Error(t, err)
+}
@@ -0,0 +1,103 @@
+package session
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log"
+	"os"
+)
+
+// ErrInvalidToken is returned when a session token does not match the
+// session and user it is presented with.
+var ErrInvalidToken = errors.New("invalid session token")
+
+// tokenSigner issues and checks session tokens: an HMAC-SHA256 over the
+// session ID and the owning user's ID. Knowing a session ID is therefore not
+// enough to continue the conversation, and a token cannot be replayed for a
+// different user.
+type tokenSigner struct {
+	key []byte
+}
+
+// tokenSignerFromEnv uses SESSION_TOKEN_SECRET as the signing key. Without
+// it a random key is generated, so tokens stop working on restart and are
+// not accepted by other replicas.
+func tokenSignerFromEnv() *tokenSigner {
+	if secret := os.Getenv("SESSION_TOKEN_SECRET"); secret != "" {
+		return &tokenSigner{key: []byte(secret)}
+	}
+	log.Printf("SESSION_TOKEN_SECRET not set, session tokens will not survive a restart")
+	key := make([]byte, 32)
+	rand.Read(key)
+	return &tokenSigner{key: key}
+}
+
+func (t *tokenSigner) mac(sessionID, userID string) []byte {
+	h := hmac.New(sha256.New, t.key)
+	h.Write([]byte(sessionID))
+	h.Write([]byte{0})
+	h.Write([]byte(userID))
+	return h.Sum(nil)
+}
+
+func (t *tokenSigner) sign(sessionID, userID string) string {
+	return base64.RawURLEncoding.EncodeToString(t.mac(sessionID, userID))
+}
+
+func (t *tokenSigner) verify(token, sessionID, userID string) error {
+	got, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || !hmac.Equal(got, t.mac(sessionID, userID)) {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// userSignerFromEnv uses USER_TOKEN_SECRET as the key of user tokens. They
+// are issued by the sign-in service sharing the secret, never by this
+// backend, and prove who a user is across all of their sessions. Without
+// the secret no user token is accepted.
+func userSignerFromEnv() *tokenSigner {
+	if secret := os.Getenv("USER_TOKEN_SECRET"); secret != "" {
+		return &tokenSigner{key: []byte(secret)}
+	}
+	return nil
+}
+
+// Token returns the token that grants userID access to the session.
+func (s *Service) Token(sessionID, userID string) string {
+	return s.tokens.sign(sessionID, userID)
+}
+
+// VerifyToken checks that token was issued for the session and user. The
+// user's token is accepted too, as it grants access to all their sessions.
+func (s *Service) VerifyToken(token, sessionID, userID string) error {
+	if s.tokens.verify(token, sessionID, userID) == nil {
+		return nil
+	}
+	if userID != "" && s.VerifyUserToken(token, userID) == nil {
+		return nil
+	}
+	return ErrInvalidToken
+}
+
+// UserToken returns the user token for userID, as the sign-in service
+// issues it: the HMAC-SHA256 of the user ID keyed with USER_TOKEN_SECRET,
+// URL-safe base64 encoded. It is empty when no secret is configured.
+func (s *Service) UserToken(userID string) string {
+	if s.users == nil {
+		return ""
+	}
+	return s.users.sign("", userID)
+}
+
+// VerifyUserToken checks that token was issued for userID by the sign-in
+// service. It always fails when USER_TOKEN_SECRET is not set.
+func (s *Service) VerifyUserToken(token, userID string) error {
+	if s.users == nil {
+		return ErrInvalidToken
+	}
+	return s.users.verify(token, "", userID)
+}
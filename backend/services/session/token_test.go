@@ -0,0 +1,74 @@
+package session
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToken_BoundToSessionAndUser(t *testing.T) {
+	t.Setenv("SESSION_TOKEN_SECRET", "test-secret")
+	svc := NewServiceWithStore(NewMemoryStore())
+
+	token := svc.Token("sess_a", "alice")
+	assert.NoError(t, svc.VerifyToken(token, "sess_a", "alice"))
+
+	assert.ErrorIs(t, svc.VerifyToken(token, "sess_b", "alice"), ErrInvalidToken)
+	assert.ErrorIs(t, svc.VerifyToken(token, "sess_a", "bob"), ErrInvalidToken)
+	assert.ErrorIs(t, svc.VerifyToken(token, "sess_a", ""), ErrInvalidToken)
+	assert.ErrorIs(t, svc.VerifyToken("", "sess_a", "alice"), ErrInvalidToken)
+	assert.ErrorIs(t, svc.VerifyToken("not base64!", "sess_a", "alice"), ErrInvalidToken)
+
+	// The separator keeps the ID and user from being shifted into each other
+	assert.ErrorIs(t, svc.VerifyToken(svc.Token("sess_a", "lice"), "sess_aa", "lice"), ErrInvalidToken)
+	assert.NotEqual(t, svc.Token("sess_a", "alice"), svc.Token("sess_aa", "lice"))
+}
+
+func TestToken_SharedSecret(t *testing.T) {
+	t.Setenv("SESSION_TOKEN_SECRET", "test-secret")
+	token := NewServiceWithStore(NewMemoryStore()).Token("sess_a", "alice")
+
+	// Another replica with the same secret accepts the token
+	assert.NoError(t, NewServiceWithStore(NewMemoryStore()).VerifyToken(token, "sess_a", "alice"))
+
+	t.Setenv("SESSION_TOKEN_SECRET", "other-secret")
+	assert.ErrorIs(t, NewServiceWithStore(NewMemoryStore()).VerifyToken(token, "sess_a", "alice"), ErrInvalidToken)
+
+	// Without a secret every service signs with its own random key
+	t.Setenv("SESSION_TOKEN_SECRET", "")
+	a, b := NewServiceWithStore(NewMemoryStore()), NewServiceWithStore(NewMemoryStore())
+	assert.ErrorIs(t, b.VerifyToken(a.Token("sess_a", "alice"), "sess_a", "alice"), ErrInvalidToken)
+}
+
+func TestUserToken(t *testing.T) {
+	t.Setenv("SESSION_TOKEN_SECRET", "test-secret")
+	t.Setenv("USER_TOKEN_SECRET", "user-secret")
+	svc := NewServiceWithStore(NewMemoryStore())
+
+	token := svc.UserToken("alice")
+	assert.NoError(t, svc.VerifyUserToken(token, "alice"))
+	assert.ErrorIs(t, svc.VerifyUserToken(token, "bob"), ErrInvalidToken)
+	assert.ErrorIs(t, svc.VerifyUserToken(svc.Token("sess_a", "alice"), "alice"), ErrInvalidToken, "a session token is not a user token")
+
+	// The user's token opens any of their sessions
+	assert.NoError(t, svc.VerifyToken(token, "sess_a", "alice"))
+	assert.ErrorIs(t, svc.VerifyToken(token, "sess_a", "bob"), ErrInvalidToken)
+
+	// Without the secret no user token is accepted
+	t.Setenv("USER_TOKEN_SECRET", "")
+	svc = NewServiceWithStore(NewMemoryStore())
+	assert.Empty(t, svc.UserToken("alice"))
+	assert.ErrorIs(t, svc.VerifyUserToken(token, "alice"), ErrInvalidToken)
+	assert.ErrorIs(t, svc.VerifyUserToken("", "alice"), ErrInvalidToken)
+}
+
+func TestGenerateID_Unguessable(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		id := generateID()
+		assert.Regexp(t, regexp.MustCompile(`^sess_[0-9a-f]{32}$`), id)
+		assert.False(t, seen[id], "duplicate ID %s", id)
+		seen[id] = true
+	}
+}
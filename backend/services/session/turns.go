@@ -0,0 +1,75 @@
+package session
+
+import (
+	"context"
+	"sync"
+)
+
+// turnLocks hands out one lock per session ID so that a session's chat turns
+// run one at a time. Locks are dropped once nobody holds or waits for them.
+type turnLocks struct {
+	mu    sync.Mutex
+	locks map[string]*turnLock
+}
+
+type turnLock struct {
+	held chan struct{}
+	refs int
+}
+
+func newTurnLocks() *turnLocks {
+	return &turnLocks{
+		locks: make(map[string]*turnLock),
+	}
+}
+
+func (t *turnLocks) acquire(ctx context.Context, id string) (func(), error) {
+	t.mu.Lock()
+	l, ok := t.locks[id]
+	if !ok {
+		l = &turnLock{held: make(chan struct{}, 1)}
+		t.locks[id] = l
+	}
+	l.refs++
+	t.mu.Unlock()
+
+	select {
+	case l.held <- struct{}{}:
+	case <-ctx.Done():
+		t.release(id, l)
+		return nil, ctx.Err()
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			<-l.held
+			t.release(id, l)
+		})
+	}, nil
+}
+
+// busy reports whether a turn of the session is running or waiting.
+func (t *turnLocks) busy(id string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.locks[id]
+	return ok
+}
+
+func (t *turnLocks) release(id string, l *turnLock) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l.refs--
+	if l.refs == 0 {
+		delete(t.locks, id)
+	}
+}
+
+// LockTurn waits until no other turn is running on the session and returns
+// the function that ends this one. Reads of the history and the messages a
+// turn adds should happen while it holds the lock. The lock is local to this
+// process.
+func (s *Service) LockTurn(ctx context.Context, id string) (func(), error) {
+	return s.turns.acquire(ctx, id)
+}
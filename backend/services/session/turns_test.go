@@ -0,0 +1,160 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockTurn_SerializesTurns(t *testing.T) {
+	svc := NewServiceWithStore(NewMemoryStore())
+	ctx := context.Background()
+	sess, err := svc.CreateSession(ctx)
+	require.NoError(t, err)
+
+	// Each turn reads the history, then adds a question and its answer. The
+	// answer refers to the history it saw, as a model reply would.
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := svc.LockTurn(ctx, sess.ID)
+			require.NoError(t, err)
+			defer unlock()
+
+			history, err := svc.GetSession(ctx, sess.ID)
+			require.NoError(t, err)
+			seen := len(history.Messages)
+			require.NoError(t, svc.AddMessage(ctx, sess.ID, Message{Role: "user", Content: fmt.Sprint(i)}))
+			time.Sleep(time.Millisecond)
+			require.NoError(t, svc.AddMessage(ctx, sess.ID, Message{Role: "assistant", Content: fmt.Sprint(seen)}))
+		}()
+	}
+	wg.Wait()
+
+	got, err := svc.GetSession(ctx, sess.ID)
+	require.NoError(t, err)
+	require.Len(t, got.Messages, 40)
+	for i := 0; i < 40; i += 2 {
+		assert.Equal(t, "user", got.Messages[i].Role)
+		assert.Equal(t, "assistant", got.Messages[i+1].Role)
+		assert.Equal(t, fmt.Sprint(i), got.Messages[i+1].Content, "turn saw another turn's partial history")
+	}
+	assert.Empty(t, svc.turns.locks, "locks are dropped once released")
+}
+
+func TestLockTurn_OtherSessionsDoNotWait(t *testing.T) {
+	svc := NewServiceWithStore(NewMemoryStore())
+	ctx := context.Background()
+
+	unlock, err := svc.LockTurn(ctx, "sess_a")
+	require.NoError(t, err)
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	unlockB, err := svc.LockTurn(ctx, "sess_b")
+	require.NoError(t, err)
+	unlockB()
+}
+
+func TestLockTurn_ContextCancelled(t *testing.T) {
+	svc := NewServiceWithStore(NewMemoryStore())
+	unlock, err := svc.LockTurn(context.Background(), "sess_a")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = svc.LockTurn(ctx, "sess_a")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Unlocking twice is harmless, and the lock is free again afterwards
+	unlock()
+	unlock()
+	unlock, err = svc.LockTurn(context.Background(), "sess_a")
+	require.NoError(t, err)
+	unlock()
+	assert.Empty(t, svc.turns.locks)
+}
+
+// TestService_ConcurrentStress mixes every operation on shared sessions. It
+// is meant to be run with -race: readers walk snapshots while writers append.
+func TestService_ConcurrentStress(t *testing.T) {
+	svc := NewServiceWithStore(NewMemoryStore())
+	ctx := context.Background()
+
+	ids := make([]string, 4)
+	for i := range ids {
+		sess, err := svc.CreateSessionForUser(ctx, fmt.Sprintf("user%d", i))
+		require.NoError(t, err)
+		ids[i] = sess.ID
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 16; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 50; n++ {
+				id := ids[(w+n)%len(ids)]
+				switch n % 4 {
+				case 0:
+					assert.NoError(t, svc.AddMessage(ctx, id, Message{Role: "user", Content: "hello"}))
+				case 1:
+					sess, err := svc.GetSession(ctx, id)
+					if assert.NoError(t, err) {
+						for _, m := range sess.Messages {
+							_ = m.Content
+						}
+						sess.Messages = append(sess.Messages, Message{Role: "user", Content: "local only"})
+						sess.UpdatedAt = time.Time{}
+					}
+				case 2:
+					sessions, err := svc.ListSessions(ctx)
+					if assert.NoError(t, err) {
+						for _, s := range sessions {
+							_ = len(s.Messages)
+							_ = s.UpdatedAt
+						}
+					}
+				case 3:
+					unlock, err := svc.LockTurn(ctx, id)
+					if assert.NoError(t, err) {
+						_, err = svc.GetSession(ctx, id)
+						assert.NoError(t, err)
+						assert.NoError(t, svc.AddMessage(ctx, id, Message{Role: "assistant", Content: "hi"}))
+						unlock()
+					}
+				}
+			}
+		}()
+	}
+
+	// A throwaway session is created and deleted alongside the traffic
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for n := 0; n < 50; n++ {
+			sess, err := svc.CreateSession(ctx)
+			if assert.NoError(t, err) {
+				assert.NoError(t, svc.DeleteSession(ctx, sess.ID))
+			}
+		}
+	}()
+	wg.Wait()
+
+	total := 0
+	for _, id := range ids {
+		sess, err := svc.GetSession(ctx, id)
+		require.NoError(t, err)
+		total += len(sess.Messages)
+		assert.False(t, sess.UpdatedAt.IsZero())
+	}
+	assert.Equal(t, 16*25, total)
+}
@@ -0,0 +1,272 @@
+package summary
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"csdeepseek/backend/models/chat"
+	"csdeepseek/backend/services/llm"
+	"csdeepseek/backend/services/session"
+)
+
+const (
+	// maxTitleLength caps generated titles, in runes
+	maxTitleLength = 60
+	// maxPromptMessages and maxPromptMessageLength bound how much of the
+	// conversation one summary request shows the model
+	maxPromptMessages      = 40
+	maxPromptMessageLength = 1000
+)
+
+const instructions = "You name and summarize customer support conversations. " +
+	"Write the title as a few words naming the user's issue, at most six words, in the language the user writes in, " +
+	"without quotes or trailing punctuation. Write the summary as a few sentences on the user's problem, their " +
+	"devices and details, what was tried and what is still open, in the same language. Update the previous summary " +
+	"with the new messages rather than starting over. Set topic_changed when the new messages moved to a " +
+	`different issue than the current title. Reply with only JSON: {"title": "...", "summary": "...", "topic_changed": false}.`
+
+// Completer generates a chat completion. *llm.Service satisfies it.
+type Completer interface {
+	GenerateResponse(ctx context.Context, messages []llm.Message) (string, error)
+}
+
+// Service gives sessions a generated title after the first exchange and
+// keeps a running summary of the conversation, which stands in for older
+// messages when the history is sent to the model.
+type Service struct {
+	completer Completer
+	sessions  *session.Service
+	enabled   bool
+	every     int
+	keep      int
+
+	mu      sync.Mutex
+	running map[string]bool
+}
+
+type summaryReply struct {
+	Title        string `json:"title"`
+	Summary      string `json:"summary"`
+	TopicChanged bool   `json:"topic_changed"`
+}
+
+// NewService creates a summary service configured from SUMMARY_ENABLED,
+// SUMMARY_EVERY and SUMMARY_KEEP_MESSAGES.
+func NewService(completer Completer, sessions *session.Service) *Service {
+	s := &Service{
+		completer: completer,
+		sessions:  sessions,
+		enabled:   true,
+		every:     6,
+		keep:      10,
+		running:   make(map[string]bool),
+	}
+	if v, err := strconv.ParseBool(os.Getenv("SUMMARY_ENABLED")); err == nil {
+		s.enabled = v
+	}
+	if n, err := strconv.Atoi(os.Getenv("SUMMARY_EVERY")); err == nil && n > 0 {
+		s.every = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("SUMMARY_KEEP_MESSAGES")); err == nil && n > 0 {
+		s.keep = n
+	}
+	return s
+}
+
+// Enabled reports whether sessions are titled and summarized.
+func (s *Service) Enabled() bool {
+	return s.enabled
+}
+
+// Refresh titles and summarizes the session's active branch when it is due:
+// once the first exchange is complete, then every SUMMARY_EVERY messages. A
+// generated title is replaced when the topic drifts, a title the user chose
+// never is. It returns the new title, or "" if the title did not change.
+func (s *Service) Refresh(ctx context.Context, sessionID string) (string, error) {
+	if !s.enabled || !s.start(sessionID) {
+		return "", nil
+	}
+	defer s.finish(sessionID)
+
+	sess, err := s.sessions.GetSession(ctx, sessionID)
+	if err != nil {
+		return "", err
+	}
+	branch := sess.ActivePath()
+	if !hasExchange(branch) {
+		return "", nil
+	}
+	through := indexOf(branch, sess.SummaryThrough)
+	previous := sess.Summary
+	if through < 0 {
+		// The summary was made on another branch
+		previous = ""
+	}
+	fresh := branch[through+1:]
+	if len(fresh) == 0 || (sess.Title != "" && len(fresh) < s.every) {
+		return "", nil
+	}
+
+	reply, err := s.summarize(ctx, sess.Title, previous, fresh)
+	if err != nil {
+		return "", err
+	}
+
+	// The user may have renamed the session while the model was busy
+	sess, err = s.sessions.GetSession(ctx, sessionID)
+	if err != nil {
+		return "", err
+	}
+	last := branch[len(branch)-1].ID
+	update := session.SessionUpdate{Summary: &reply.Summary, SummaryThrough: &last}
+	newTitle := ""
+	if reply.Title != "" && reply.Title != sess.Title &&
+		(sess.Title == "" || (sess.AutoTitle && reply.TopicChanged)) {
+		auto := true
+		newTitle = reply.Title
+		update.Title, update.AutoTitle = &newTitle, &auto
+	}
+	if err := s.sessions.UpdateSession(ctx, sessionID, update); err != nil {
+		return "", err
+	}
+	return newTitle, nil
+}
+
+// start marks a refresh of the session as running, reporting false if one
+// already is.
+func (s *Service) start(sessionID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running[sessionID] {
+		return false
+	}
+	s.running[sessionID] = true
+	return true
+}
+
+func (s *Service) finish(sessionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.running, sessionID)
+}
+
+func (s *Service) summarize(ctx context.Context, title, previous string, messages []chat.Message) (*summaryReply, error) {
+	if len(messages) > maxPromptMessages {
+		messages = messages[len(messages)-maxPromptMessages:]
+	}
+
+	var prompt strings.Builder
+	if title != "" {
+		fmt.Fprintf(&prompt, "Current title: %s\n", title)
+	}
+	if previous != "" {
+		fmt.Fprintf(&prompt, "Previous summary: %s\n", previous)
+	}
+	prompt.WriteString("New messages:\n")
+	for _, m := range messages {
+		if m.Role == chat.RoleSystem || !m.Complete() {
+			continue
+		}
+		content := m.Content
+		if len(content) > maxPromptMessageLength {
+			content = strings.ToValidUTF8(content[:maxPromptMessageLength], "") + "..."
+		}
+		fmt.Fprintf(&prompt, "%s: %s\n", m.Role, strings.Join(strings.Fields(content), " "))
+	}
+
+	reply, err := s.completer.GenerateResponse(ctx, []llm.Message{
+		{Role: "system", Content: instructions},
+		{Role: "user", Content: prompt.String()},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to summarize session: %w", err)
+	}
+	return parseSummaryReply(reply)
+}
+
+// parseSummaryReply reads the JSON object from a model reply, tolerating
+// surrounding prose or code fences, and tidies the title.
+func parseSummaryReply(reply string) (*summaryReply, error) {
+	start := strings.IndexByte(reply, '{')
+	end := strings.LastIndexByte(reply, '}')
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("summary reply contains no object")
+	}
+	var parsed summaryReply
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse summary: %w", err)
+	}
+
+	parsed.Summary = strings.TrimSpace(parsed.Summary)
+	if parsed.Summary == "" {
+		return nil, fmt.Errorf("summary reply has an empty summary")
+	}
+	title := strings.Join(strings.Fields(parsed.Title), " ")
+	title = strings.Trim(title, "\"'“”「」《》.。!！?？,，:：;； ")
+	if utf8.RuneCountInString(title) > maxTitleLength {
+		title = strings.TrimSpace(string([]rune(title)[:maxTitleLength]))
+	}
+	parsed.Title = title
+	return &parsed, nil
+}
+
+// History returns the messages to send to the model for a branch of sess.
+// When the session's summary covers the start of the branch, the messages
+// it covers are replaced by it, except for the last SUMMARY_KEEP_MESSAGES
+// and system messages such as recalled memories.
+func (s *Service) History(sess *session.Session, branch []chat.Message) []llm.Message {
+	if !s.enabled || sess.Summary == "" {
+		return llm.WireMessages(branch)
+	}
+	cut := min(indexOf(branch, sess.SummaryThrough)+1, len(branch)-s.keep)
+	if cut <= 0 {
+		return llm.WireMessages(branch)
+	}
+
+	var messages []llm.Message
+	for _, m := range branch[:cut] {
+		if m.Role == chat.RoleSystem && m.Complete() {
+			messages = append(messages, llm.Message{Role: m.Role, Content: m.Content})
+		}
+	}
+	messages = append(messages, llm.Message{Role: chat.RoleSystem, Content: "Summary of the earlier conversation: " + sess.Summary})
+	return append(messages, llm.WireMessages(branch[cut:])...)
+}
+
+// hasExchange reports whether the branch holds a complete question and
+// answer.
+func hasExchange(branch []chat.Message) bool {
+	var asked bool
+	for _, m := range branch {
+		if !m.Complete() {
+			continue
+		}
+		switch m.Role {
+		case chat.RoleUser:
+			asked = true
+		case chat.RoleAssistant:
+			if asked {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func indexOf(branch []chat.Message, id string) int {
+	if id == "" {
+		return -1
+	}
+	for i, m := range branch {
+		if m.ID == id {
+			return i
+		}
+	}
+	return -1
+}
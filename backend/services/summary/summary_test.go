@@ -0,0 +1,188 @@
+package summary
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"csdeepseek/backend/models/chat"
+	"csdeepseek/backend/services/llm"
+	"csdeepseek/backend/services/session"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCompleter answers every request with reply and records the prompts.
+type fakeCompleter struct {
+	mu      sync.Mutex
+	reply   string
+	prompts []string
+}
+
+func (f *fakeCompleter) GenerateResponse(ctx context.Context, messages []llm.Message) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.prompts = append(f.prompts, messages[len(messages)-1].Content)
+	return f.reply, nil
+}
+
+func (f *fakeCompleter) calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.prompts)
+}
+
+func newTestService(t *testing.T, reply string) (*Service, *fakeCompleter, *session.Service) {
+	t.Setenv("SUMMARY_EVERY", "4")
+	t.Setenv("SUMMARY_KEEP_MESSAGES", "2")
+	sessions := session.NewServiceWithStore(session.NewMemoryStore())
+	completer := &fakeCompleter{reply: reply}
+	return NewService(completer, sessions), completer, sessions
+}
+
+func addExchange(t *testing.T, sessions *session.Service, id, question, answer string) {
+	ctx := context.Background()
+	require.NoError(t, sessions.AddMessage(ctx, id, chat.NewMessage(chat.RoleUser, question)))
+	require.NoError(t, sessions.AddMessage(ctx, id, chat.NewMessage(chat.RoleAssistant, answer)))
+}
+
+func TestRefresh_TitlesAfterFirstExchange(t *testing.T) {
+	svc, completer, sessions := newTestService(t, "```json\n{\"title\": \"「打印机卡纸」。\", \"summary\": \"用户的打印机卡纸。\"}\n```")
+	ctx := context.Background()
+	sess, err := sessions.CreateSession(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, sessions.AddMessage(ctx, sess.ID, chat.NewMessage(chat.RoleUser, "我的打印机卡纸了")))
+	title, err := svc.Refresh(ctx, sess.ID)
+	require.NoError(t, err)
+	assert.Empty(t, title, "no title before the first answer")
+	assert.Zero(t, completer.calls())
+
+	require.NoError(t, sessions.AddMessage(ctx, sess.ID, chat.NewMessage(chat.RoleAssistant, "请打开纸盒检查。")))
+	title, err = svc.Refresh(ctx, sess.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "打印机卡纸", title)
+
+	got, err := sessions.GetSession(ctx, sess.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "打印机卡纸", got.Title)
+	assert.True(t, got.AutoTitle)
+	assert.Equal(t, "用户的打印机卡纸。", got.Summary)
+	assert.Equal(t, got.Messages[1].ID, got.SummaryThrough)
+
+	// Nothing new to summarize yet
+	title, err = svc.Refresh(ctx, sess.ID)
+	require.NoError(t, err)
+	assert.Empty(t, title)
+	assert.Equal(t, 1, completer.calls())
+}
+
+func TestRefresh_RetitlesOnDrift(t *testing.T) {
+	svc, completer, sessions := newTestService(t, `{"title": "Printer jams", "summary": "Printer jams."}`)
+	ctx := context.Background()
+	sess, err := sessions.CreateSession(ctx)
+	require.NoError(t, err)
+	addExchange(t, sessions, sess.ID, "My printer jams", "Open the tray")
+	_, err = svc.Refresh(ctx, sess.ID)
+	require.NoError(t, err)
+
+	completer.reply = `{"title": "Router keeps rebooting", "summary": "Printer fixed, now the router reboots.", "topic_changed": true}`
+	addExchange(t, sessions, sess.ID, "Thanks, fixed", "Glad to help")
+	title, err := svc.Refresh(ctx, sess.ID)
+	require.NoError(t, err)
+	assert.Empty(t, title, "not due before SUMMARY_EVERY new messages")
+	assert.Equal(t, 1, completer.calls())
+
+	addExchange(t, sessions, sess.ID, "Now my router reboots", "Check the power supply")
+	title, err = svc.Refresh(ctx, sess.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Router keeps rebooting", title)
+	assert.Contains(t, completer.prompts[1], "Previous summary: Printer jams.")
+	assert.Contains(t, completer.prompts[1], "Current title: Printer jams")
+	assert.NotContains(t, completer.prompts[1], "My printer jams", "only new messages are sent")
+}
+
+func TestRefresh_KeepsUserTitles(t *testing.T) {
+	svc, _, sessions := newTestService(t, `{"title": "Router keeps rebooting", "summary": "Router reboots.", "topic_changed": true}`)
+	ctx := context.Background()
+	sess, err := sessions.CreateSession(ctx)
+	require.NoError(t, err)
+	mine := "Home network"
+	require.NoError(t, sessions.UpdateSession(ctx, sess.ID, session.SessionUpdate{Title: &mine}))
+	for i := 0; i < 2; i++ {
+		addExchange(t, sessions, sess.ID, "My router reboots", "Check the power supply")
+	}
+
+	title, err := svc.Refresh(ctx, sess.ID)
+	require.NoError(t, err)
+	assert.Empty(t, title)
+	got, err := sessions.GetSession(ctx, sess.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Home network", got.Title)
+	assert.Equal(t, "Router reboots.", got.Summary, "sessions with user titles are still summarized")
+}
+
+func TestRefresh_Disabled(t *testing.T) {
+	t.Setenv("SUMMARY_ENABLED", "false")
+	sessions := session.NewServiceWithStore(session.NewMemoryStore())
+	completer := &fakeCompleter{}
+	svc := NewService(completer, sessions)
+	ctx := context.Background()
+	sess, err := sessions.CreateSession(ctx)
+	require.NoError(t, err)
+	addExchange(t, sessions, sess.ID, "hi", "hello")
+
+	_, err = svc.Refresh(ctx, sess.ID)
+	require.NoError(t, err)
+	assert.Zero(t, completer.calls())
+}
+
+func TestParseSummaryReply(t *testing.T) {
+	_, err := parseSummaryReply("no json here")
+	assert.Error(t, err)
+	_, err = parseSummaryReply(`{"title": "x", "summary": " "}`)
+	assert.Error(t, err)
+
+	parsed, err := parseSummaryReply(`Sure: {"title": "  \"Printer   jams on duplex.\" ", "summary": "s"}`)
+	require.NoError(t, err)
+	assert.Equal(t, "Printer jams on duplex", parsed.Title)
+
+	parsed, err = parseSummaryReply(`{"title": "` + strings.Repeat("long ", 30) + `", "summary": "s"}`)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len([]rune(parsed.Title)), maxTitleLength)
+}
+
+func TestHistory(t *testing.T) {
+	svc, _, _ := newTestService(t, "")
+	branch := []chat.Message{
+		{ID: "m0", Role: chat.RoleSystem, Content: "memories"},
+		{ID: "m1", Role: chat.RoleUser, Content: "q1"},
+		{ID: "m2", Role: chat.RoleAssistant, Content: "a1"},
+		{ID: "m3", Role: chat.RoleUser, Content: "q2"},
+		{ID: "m4", Role: chat.RoleAssistant, Content: "a2"},
+		{ID: "m5", Role: chat.RoleUser, Content: "q3"},
+	}
+	contents := func(messages []llm.Message) []string {
+		var out []string
+		for _, m := range messages {
+			out = append(out, m.Content)
+		}
+		return out
+	}
+
+	sess := &session.Session{}
+	assert.Len(t, svc.History(sess, branch), 6, "no summary, full history")
+
+	sess.Summary, sess.SummaryThrough = "talked about q1 and q2", "m4"
+	assert.Equal(t, []string{"memories", "Summary of the earlier conversation: talked about q1 and q2", "a2", "q3"},
+		contents(svc.History(sess, branch)), "the last SUMMARY_KEEP_MESSAGES stay verbatim")
+
+	sess.SummaryThrough = "m2"
+	assert.Equal(t, []string{"memories", "Summary of the earlier conversation: talked about q1 and q2", "q2", "a2", "q3"},
+		contents(svc.History(sess, branch)))
+
+	sess.SummaryThrough = "other-branch"
+	assert.Len(t, svc.History(sess, branch), 6, "a summary of another branch is not used")
+}
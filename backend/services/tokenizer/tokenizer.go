@@ -0,0 +1,127 @@
+package tokenizer
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Token is a normalized term with its position in the token stream and its
+// byte offsets in the original text. Tokens that are alternatives for the
+// same text share a position.
+type Token struct {
+	Term     string
+	Position int
+	Start    int
+	End      int
+}
+
+// Tokenize splits text into lowercase search terms.
+//
+// Latin-script text is split on whitespace and punctuation. Identifiers such
+// as SKUs, order numbers and error codes ("AB-1234", "ERR_TIMEOUT",
+// "v2.3.1") are kept whole and additionally split into their parts, so both
+// an exact paste and a partial query match.
+//
+// Chinese, Japanese and Korean text has no spaces, so each run of CJK
+// characters is segmented into overlapping character bigrams; a run of a
+// single character yields that character. Bigram segmentation needs no
+// dictionary and gives good recall for search.
+func Tokenize(text string) []Token {
+	var tokens []Token
+	position := 0
+	emit := func(term string, start, end int) {
+		tokens = append(tokens, Token{Term: term, Position: position, Start: start, End: end})
+		position++
+	}
+	// emitPart adds an identifier part at the same position as the whole
+	// identifier, so phrase matching treats them as alternatives.
+	emitPart := func(term string, start, end int) {
+		tokens = append(tokens, Token{Term: term, Position: position - 1, Start: start, End: end})
+	}
+
+	i := 0
+	for i < len(text) {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		switch {
+		case IsCJK(r):
+			start := i
+			var offsets []int
+			for i < len(text) {
+				r, size := utf8.DecodeRuneInString(text[i:])
+				if !IsCJK(r) {
+					break
+				}
+				offsets = append(offsets, i)
+				i += size
+			}
+			offsets = append(offsets, i)
+			if len(offsets) == 2 {
+				emit(text[start:i], start, i)
+				continue
+			}
+			for j := 0; j+2 < len(offsets); j++ {
+				emit(text[offsets[j]:offsets[j+2]], offsets[j], offsets[j+2])
+			}
+		case isWordRune(r):
+			start := i
+			for i < len(text) {
+				r, size := utf8.DecodeRuneInString(text[i:])
+				if isWordRune(r) || (isJoiner(r) && i+size < len(text) && nextIsWord(text[i+size:])) {
+					i += size
+					continue
+				}
+				break
+			}
+			word := strings.ToLower(text[start:i])
+			emit(word, start, i)
+			if strings.ContainsAny(word, "-_./") {
+				// Joiners are ASCII, so byte-wise scanning is safe
+				partStart := start
+				for j := start; j <= i; j++ {
+					if j == i || isJoiner(rune(text[j])) {
+						if j > partStart {
+							emitPart(strings.ToLower(text[partStart:j]), partStart, j)
+						}
+						partStart = j + 1
+					}
+				}
+			}
+		default:
+			i += size
+		}
+	}
+	return tokens
+}
+
+// Terms returns just the terms of Tokenize(text).
+func Terms(text string) []string {
+	tokens := Tokenize(text)
+	terms := make([]string, len(tokens))
+	for i, t := range tokens {
+		terms[i] = t.Term
+	}
+	return terms
+}
+
+// IsCJK reports whether r is a Han, Hiragana, Katakana or Hangul character.
+func IsCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) ||
+		unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) ||
+		unicode.Is(unicode.Hangul, r)
+}
+
+func isWordRune(r rune) bool {
+	return (unicode.IsLetter(r) || unicode.IsNumber(r)) && !IsCJK(r)
+}
+
+// isJoiner reports whether r may join the parts of an identifier.
+func isJoiner(r rune) bool {
+	return r == '-' || r == '_' || r == '.' || r == '/'
+}
+
+func nextIsWord(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return isWordRune(r)
+}
@@ -0,0 +1,34 @@
+package tokenizer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenize_English(t *testing.T) {
+	assert.Equal(t, []string{"my", "router", "won", "t", "connect"}, Terms("My router won't connect"))
+	assert.Equal(t, []string{"hello", "world"}, Terms("  Hello, WORLD!  "))
+}
+
+func TestTokenize_Identifiers(t *testing.T) {
+	terms := Terms("Order SKU-4411B failed with ERR_TIMEOUT.")
+	assert.Equal(t, []string{"order", "sku-4411b", "sku", "4411b", "failed", "with", "err_timeout", "err", "timeout"}, terms)
+
+	tokens := Tokenize("see AB-12 now")
+	assert.Equal(t, Token{Term: "ab-12", Position: 1, Start: 4, End: 9}, tokens[1])
+	assert.Equal(t, Token{Term: "ab", Position: 1, Start: 4, End: 6}, tokens[2])
+	assert.Equal(t, Token{Term: "12", Position: 1, Start: 7, End: 9}, tokens[3])
+	assert.Equal(t, 2, tokens[4].Position)
+}
+
+func TestTokenize_Chinese(t *testing.T) {
+	assert.Equal(t, []string{"申请", "请退", "退款"}, Terms("申请退款"))
+	assert.Equal(t, []string{"订", "iphone", "15", "退货"}, Terms("订 iPhone 15 退货"))
+
+	tokens := Tokenize("我的订单")
+	assert.Equal(t, "的订", tokens[1].Term)
+	assert.Equal(t, "我的订单"[3:9], tokens[1].Term)
+	assert.Equal(t, 3, tokens[1].Start)
+	assert.Equal(t, 9, tokens[1].End)
+}
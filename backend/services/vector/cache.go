@@ -0,0 +1,214 @@
+package vector
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// EmbeddingCache is a content-addressed cache of embeddings. Entries are keyed
+// by the embedding model and a hash of the normalized input text, so identical
+// text is only embedded once per model. It keeps an in-memory LRU tier and an
+// optional on-disk tier that survives restarts.
+type EmbeddingCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List
+	dir      string
+
+	memoryHits atomic.Uint64
+	diskHits   atomic.Uint64
+	misses     atomic.Uint64
+	evictions  atomic.Uint64
+}
+
+type cacheEntry struct {
+	key       string
+	embedding []float32
+}
+
+// CacheStats reports hit/miss counters for an EmbeddingCache.
+type CacheStats struct {
+	Entries    int    `json:"entries"`
+	Capacity   int    `json:"capacity"`
+	MemoryHits uint64 `json:"memory_hits"`
+	DiskHits   uint64 `json:"disk_hits"`
+	Misses     uint64 `json:"misses"`
+	Evictions  uint64 `json:"evictions"`
+}
+
+// NewEmbeddingCache creates a cache holding up to capacity embeddings in memory.
+// If dir is not empty, embeddings are also written to and read from that
+// directory.
+func NewEmbeddingCache(capacity int, dir string) (*EmbeddingCache, error) {
+	if capacity <= 0 {
+		return nil, fmt.Errorf("cache capacity must be positive")
+	}
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create cache directory: %w", err)
+		}
+	}
+	return &EmbeddingCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		dir:      dir,
+	}, nil
+}
+
+// CacheKey returns the content address for text embedded with model.
+func CacheKey(model, text string) string {
+	h := sha256.New()
+	h.Write([]byte(model))
+	h.Write([]byte{0})
+	h.Write([]byte(normalizeText(text)))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// normalizeText collapses runs of whitespace and trims the text so that
+// formatting-only differences map to the same cache entry.
+func normalizeText(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// Get returns the cached embedding for text under model, if any.
+func (c *EmbeddingCache) Get(model, text string) ([]float32, bool) {
+	key := CacheKey(model, text)
+
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok {
+		c.order.MoveToFront(el)
+		embedding := el.Value.(*cacheEntry).embedding
+		c.mu.Unlock()
+		c.memoryHits.Add(1)
+		return cloneEmbedding(embedding), true
+	}
+	c.mu.Unlock()
+
+	if c.dir != "" {
+		if embedding, err := c.readDisk(key); err == nil {
+			c.diskHits.Add(1)
+			c.add(key, embedding)
+			return cloneEmbedding(embedding), true
+		}
+	}
+
+	c.misses.Add(1)
+	return nil, false
+}
+
+// Put stores the embedding for text under model in every tier.
+func (c *EmbeddingCache) Put(model, text string, embedding []float32) error {
+	key := CacheKey(model, text)
+	embedding = cloneEmbedding(embedding)
+	c.add(key, embedding)
+
+	if c.dir != "" {
+		if err := c.writeDisk(key, embedding); err != nil {
+			return fmt.Errorf("failed to write cache entry: %w", err)
+		}
+	}
+	return nil
+}
+
+// Stats returns a snapshot of the cache counters.
+func (c *EmbeddingCache) Stats() CacheStats {
+	c.mu.Lock()
+	entries := c.order.Len()
+	c.mu.Unlock()
+
+	return CacheStats{
+		Entries:    entries,
+		Capacity:   c.capacity,
+		MemoryHits: c.memoryHits.Load(),
+		DiskHits:   c.diskHits.Load(),
+		Misses:     c.misses.Load(),
+		Evictions:  c.evictions.Load(),
+	}
+}
+
+// add inserts an entry into the memory tier, evicting the least recently used
+// entry when the cache is full.
+func (c *EmbeddingCache) add(key string, embedding []float32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		el.Value.(*cacheEntry).embedding = embedding
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, embedding: embedding})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+		c.evictions.Add(1)
+	}
+}
+
+// diskPath shards entries by the first byte of the key to keep directories
+// small. Entries hold little-endian float32 values.
+func (c *EmbeddingCache) diskPath(key string) string {
+	return filepath.Join(c.dir, key[:2], key+".f32")
+}
+
+func (c *EmbeddingCache) readDisk(key string) ([]float32, error) {
+	data, err := os.ReadFile(c.diskPath(key))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 || len(data)%4 != 0 {
+		return nil, fmt.Errorf("corrupt cache entry")
+	}
+
+	embedding := make([]float32, len(data)/4)
+	for i := range embedding {
+		embedding[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:]))
+	}
+	return embedding, nil
+}
+
+// writeDisk writes the entry to a temporary file and renames it into place so
+// readers never observe a partially written entry.
+func (c *EmbeddingCache) writeDisk(key string, embedding []float32) error {
+	path := c.diskPath(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	data := make([]byte, len(embedding)*4)
+	for i, v := range embedding {
+		binary.LittleEndian.PutUint32(data[i*4:], math.Float32bits(v))
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func cloneEmbedding(embedding []float32) []float32 {
+	return append([]float32(nil), embedding...)
+}
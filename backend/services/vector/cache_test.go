@@ -0,0 +1,101 @@
+package vector
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheKey_Normalization(t *testing.T) {
+	assert.Equal(t, CacheKey("m", "hello   world"), CacheKey("m", "  hello world\n"))
+	assert.NotEqual(t, CacheKey("m", "hello world"), CacheKey("m", "Hello world"))
+	assert.NotEqual(t, CacheKey("a", "hello"), CacheKey("b", "hello"))
+}
+
+func TestEmbeddingCache_LRU(t *testing.T) {
+	cache, err := NewEmbeddingCache(2, "")
+	require.NoError(t, err)
+
+	require.NoError(t, cache.Put("m", "a", []float32{1}))
+	require.NoError(t, cache.Put("m", "b", []float32{2}))
+
+	// Touch "a" so "b" becomes the least recently used entry
+	_, ok := cache.Get("m", "a")
+	require.True(t, ok)
+	require.NoError(t, cache.Put("m", "c", []float32{3}))
+
+	_, ok = cache.Get("m", "b")
+	assert.False(t, ok)
+	got, ok := cache.Get("m", "c")
+	assert.True(t, ok)
+	assert.Equal(t, []float32{3}, got)
+
+	stats := cache.Stats()
+	assert.Equal(t, 2, stats.Entries)
+	assert.Equal(t, uint64(2), stats.MemoryHits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, uint64(1), stats.Evictions)
+}
+
+func TestEmbeddingCache_DiskTierSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	cache, err := NewEmbeddingCache(10, dir)
+	require.NoError(t, err)
+	require.NoError(t, cache.Put("m", "persisted", []float32{0.25, -1.5}))
+
+	reopened, err := NewEmbeddingCache(10, dir)
+	require.NoError(t, err)
+	got, ok := reopened.Get("m", "persisted")
+	require.True(t, ok)
+	assert.Equal(t, []float32{0.25, -1.5}, got)
+	assert.Equal(t, uint64(1), reopened.Stats().DiskHits)
+
+	// The disk hit is promoted to the memory tier
+	_, ok = reopened.Get("m", "persisted")
+	require.True(t, ok)
+	assert.Equal(t, uint64(1), reopened.Stats().MemoryHits)
+}
+
+func TestGetEmbedding_UsesCache(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var req EmbeddingRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		resp := EmbeddingResponse{}
+		resp.Data = append(resp.Data, struct {
+			Embedding []float32 `json:"embedding"`
+			Index     int       `json:"index"`
+		}{Embedding: []float32{1, 2, 3}})
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	defer ts.Close()
+
+	cache, err := NewEmbeddingCache(10, "")
+	require.NoError(t, err)
+	svc := &Service{
+		embedder: &DeepSeekEmbedder{
+			apiKey:     "test",
+			apiURL:     ts.URL,
+			model:      "deepseek-embed",
+			httpClient: ts.Client(),
+		},
+		cache: cache,
+	}
+
+	ctx := context.Background()
+	first, err := svc.GetEmbedding(ctx, "same text")
+	require.NoError(t, err)
+	second, err := svc.GetEmbedding(ctx, "same  text ")
+	require.NoError(t, err)
+
+	assert.Equal(t, first, second)
+	assert.Equal(t, int32(1), calls.Load())
+}
@@ -0,0 +1,109 @@
+package vector
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	defaultChunkSize    = 1000
+	defaultChunkOverlap = 100
+)
+
+// ChunkText splits text into chunks of at most size runes for embedding.
+// Paragraphs (separated by blank lines) are packed together whole where they
+// fit; a paragraph longer than size is cut at word boundaries into windows
+// that repeat up to overlap runes of the previous window, so a sentence
+// straddling a cut is still retrievable from either side.
+func ChunkText(text string, size, overlap int) []string {
+	if size <= 0 {
+		size = defaultChunkSize
+	}
+	overlap = max(0, min(overlap, size/2))
+
+	var chunks []string
+	var current strings.Builder
+	currentLen := 0
+	flush := func() {
+		if currentLen > 0 {
+			chunks = append(chunks, current.String())
+			current.Reset()
+			currentLen = 0
+		}
+	}
+
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	for _, para := range strings.Split(text, "\n\n") {
+		para = strings.TrimSpace(para)
+		if para == "" {
+			continue
+		}
+		n := utf8.RuneCountInString(para)
+
+		if n > size {
+			flush()
+			chunks = append(chunks, splitWords(para, size, overlap)...)
+			continue
+		}
+		if currentLen > 0 && currentLen+2+n > size {
+			flush()
+		}
+		if currentLen > 0 {
+			current.WriteString("\n\n")
+			currentLen += 2
+		}
+		current.WriteString(para)
+		currentLen += n
+	}
+	flush()
+	return chunks
+}
+
+// splitWords cuts text into windows of at most size runes at word
+// boundaries. Words longer than size are cut at rune boundaries.
+func splitWords(text string, size, overlap int) []string {
+	var words []string
+	for _, w := range strings.Fields(text) {
+		for utf8.RuneCountInString(w) > size {
+			r := []rune(w)
+			words = append(words, string(r[:size]))
+			w = string(r[size:])
+		}
+		words = append(words, w)
+	}
+
+	var chunks []string
+	start := 0
+	for start < len(words) {
+		end, length := start, 0
+		for end < len(words) {
+			n := utf8.RuneCountInString(words[end])
+			if end > start {
+				n++
+			}
+			if length+n > size {
+				break
+			}
+			length += n
+			end++
+		}
+		chunks = append(chunks, strings.Join(words[start:end], " "))
+		if end == len(words) {
+			break
+		}
+
+		// Step back over up to overlap runes of trailing words, but always
+		// make progress
+		next, back := end, 0
+		for next-1 > start {
+			n := utf8.RuneCountInString(words[next-1]) + 1
+			if back+n > overlap {
+				break
+			}
+			back += n
+			next--
+		}
+		start = next
+	}
+	return chunks
+}
@@ -0,0 +1,52 @@
+package vector
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkText_PacksParagraphs(t *testing.T) {
+	text := "First paragraph.\r\n\r\nSecond paragraph.\n\n\n\nThird paragraph that is a bit longer."
+
+	chunks := ChunkText(text, 40, 0)
+	require.Len(t, chunks, 2)
+	assert.Equal(t, "First paragraph.\n\nSecond paragraph.", chunks[0])
+	assert.Equal(t, "Third paragraph that is a bit longer.", chunks[1])
+
+	assert.Equal(t, []string{"First paragraph.\n\nSecond paragraph.\n\nThird paragraph that is a bit longer."}, ChunkText(text, 1000, 0))
+	assert.Empty(t, ChunkText(" \n\n \n", 100, 10))
+}
+
+func TestChunkText_SplitsLongParagraphsWithOverlap(t *testing.T) {
+	words := make([]string, 100)
+	for i := range words {
+		words[i] = "wörd"
+	}
+	words[50] = "marker"
+	text := strings.Join(words, " ")
+
+	chunks := ChunkText(text, 60, 15)
+	require.Greater(t, len(chunks), 1)
+	withMarker := 0
+	for _, c := range chunks {
+		assert.LessOrEqual(t, utf8.RuneCountInString(c), 60)
+		if strings.Contains(c, "marker") {
+			withMarker++
+		}
+	}
+	// Overlap repeats the words around each cut
+	total := 0
+	for _, c := range chunks {
+		total += len(strings.Fields(c))
+	}
+	assert.Greater(t, total, len(words))
+	assert.GreaterOrEqual(t, withMarker, 1)
+
+	// A single word longer than the chunk size is cut
+	chunks = ChunkText(strings.Repeat("x", 25), 10, 0)
+	assert.Equal(t, []string{"xxxxxxxxxx", "xxxxxxxxxx", "xxxxx"}, chunks)
+}
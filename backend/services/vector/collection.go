@@ -0,0 +1,416 @@
+package vector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const collectionsFile = "collections.json"
+
+// Metadata keys set on every chunk of an ingested document.
+const (
+	MetadataDocumentID = "doc_id"
+	MetadataChunk      = "chunk"
+)
+
+// Collection is a named namespace of documents with its own search settings.
+type Collection struct {
+	Name        string       `json:"name"`
+	Description string       `json:"description,omitempty"`
+	Rerank      RerankConfig `json:"rerank"`
+	CreatedAt   time.Time    `json:"created_at"`
+}
+
+// CollectionInfo is a collection with its current size.
+type CollectionInfo struct {
+	Collection
+	Documents int `json:"documents"`
+	Chunks    int `json:"chunks"`
+}
+
+// Document describes an ingested document. Chunks is only filled in when a
+// single document is fetched.
+type Document struct {
+	ID         string            `json:"id"`
+	Collection string            `json:"collection"`
+	ChunkCount int               `json:"chunk_count"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	Chunks     []string          `json:"chunks,omitempty"`
+}
+
+// ValidateCollectionName checks that name is usable as a collection name,
+// which also appears in URLs: 1-64 letters, digits, '-' or '_'.
+func ValidateCollectionName(name string) error {
+	if name == "" || len(name) > 64 {
+		return invalidf("collection name must be 1-64 characters")
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return invalidf("collection name may only contain letters, digits, '-' and '_'")
+		}
+	}
+	return nil
+}
+
+// CreateCollection registers a new, empty collection.
+func (s *Service) CreateCollection(c Collection) (CollectionInfo, error) {
+	if err := ValidateCollectionName(c.Name); err != nil {
+		return CollectionInfo{}, err
+	}
+	if err := c.Rerank.Validate(); err != nil {
+		return CollectionInfo{}, err
+	}
+
+	s.collectionsMu.Lock()
+	defer s.collectionsMu.Unlock()
+
+	if _, exists := s.collections[c.Name]; exists {
+		return CollectionInfo{}, ErrCollectionExists
+	}
+	c.CreatedAt = time.Now()
+	s.collections[c.Name] = &c
+	if err := s.saveCollections(); err != nil {
+		delete(s.collections, c.Name)
+		return CollectionInfo{}, err
+	}
+	s.setRerankConfig(c.Name, c.Rerank)
+	return CollectionInfo{Collection: c}, nil
+}
+
+// UpdateCollection replaces the description and search settings of a
+// collection.
+func (s *Service) UpdateCollection(c Collection) (CollectionInfo, error) {
+	if err := c.Rerank.Validate(); err != nil {
+		return CollectionInfo{}, err
+	}
+
+	s.collectionsMu.Lock()
+	existing, ok := s.collections[c.Name]
+	if !ok {
+		s.collectionsMu.Unlock()
+		return CollectionInfo{}, ErrCollectionNotFound
+	}
+	previous := *existing
+	existing.Description = c.Description
+	existing.Rerank = c.Rerank
+	if err := s.saveCollections(); err != nil {
+		*existing = previous
+		s.collectionsMu.Unlock()
+		return CollectionInfo{}, err
+	}
+	updated := *existing
+	s.collectionsMu.Unlock()
+
+	s.setRerankConfig(c.Name, c.Rerank)
+	return s.collectionInfo(updated), nil
+}
+
+// GetCollection returns a collection and its size.
+func (s *Service) GetCollection(name string) (CollectionInfo, error) {
+	s.collectionsMu.RLock()
+	c, ok := s.collections[name]
+	s.collectionsMu.RUnlock()
+	if !ok {
+		return CollectionInfo{}, ErrCollectionNotFound
+	}
+	return s.collectionInfo(*c), nil
+}
+
+func (s *Service) hasCollection(name string) bool {
+	s.collectionsMu.RLock()
+	defer s.collectionsMu.RUnlock()
+	_, ok := s.collections[name]
+	return ok
+}
+
+// ListCollections returns all collections sorted by name.
+func (s *Service) ListCollections() []CollectionInfo {
+	s.collectionsMu.RLock()
+	collections := make([]Collection, 0, len(s.collections))
+	for _, c := range s.collections {
+		collections = append(collections, *c)
+	}
+	s.collectionsMu.RUnlock()
+
+	sort.Slice(collections, func(i, j int) bool {
+		return collections[i].Name < collections[j].Name
+	})
+	infos := make([]CollectionInfo, len(collections))
+	for i, c := range collections {
+		infos[i] = s.collectionInfo(c)
+	}
+	return infos
+}
+
+// DeleteCollection removes a collection and every document in it.
+func (s *Service) DeleteCollection(ctx context.Context, name string) error {
+	s.documentsMu.Lock()
+	defer s.documentsMu.Unlock()
+	s.collectionsMu.Lock()
+	defer s.collectionsMu.Unlock()
+
+	c, ok := s.collections[name]
+	if !ok {
+		return ErrCollectionNotFound
+	}
+	for _, rec := range s.store.List(name) {
+		if _, err := s.store.Delete(name, rec.ID); err != nil {
+			return err
+		}
+		s.keywords.Remove(name, rec.ID)
+	}
+
+	delete(s.collections, name)
+	if err := s.saveCollections(); err != nil {
+		s.collections[name] = c
+		return err
+	}
+	s.rerankMu.Lock()
+	delete(s.rerankConfigs, name)
+	s.rerankMu.Unlock()
+	return nil
+}
+
+// IngestDocument chunks text and stores the chunks in collection under
+// docID, replacing any previous version of the document. Every chunk gets
+// metadata plus doc_id and chunk, so searches can be filtered to a document.
+// It returns the number of chunks stored.
+func (s *Service) IngestDocument(ctx context.Context, collection, docID, text string, metadata map[string]string) (int, error) {
+	if !s.hasCollection(collection) {
+		return 0, ErrCollectionNotFound
+	}
+	if docID == "" || len(docID) > 255 {
+		return 0, invalidf("document ID must be 1-255 characters")
+	}
+
+	chunks := ChunkText(text, s.chunkSize, s.chunkOverlap)
+	if len(chunks) == 0 {
+		return 0, invalidf("document is empty")
+	}
+	// Embed before touching the store so a failing embedder leaves the
+	// previous version intact
+	embeddings, err := s.GetEmbeddings(ctx, chunks)
+	if err != nil {
+		return 0, err
+	}
+
+	s.documentsMu.Lock()
+	defer s.documentsMu.Unlock()
+	// The collection may have been deleted while embedding
+	if !s.hasCollection(collection) {
+		return 0, ErrCollectionNotFound
+	}
+
+	previous := s.documentChunks(collection, docID)
+	for i, chunk := range chunks {
+		md := make(map[string]string, len(metadata)+2)
+		for k, v := range metadata {
+			md[k] = v
+		}
+		md[MetadataDocumentID] = docID
+		md[MetadataChunk] = strconv.Itoa(i)
+
+		id := chunkID(docID, i)
+		if err := s.store.Put(Record{
+			Namespace: collection,
+			ID:        id,
+			Vector:    embeddings[i],
+			Text:      chunk,
+			Metadata:  md,
+		}); err != nil {
+			return 0, err
+		}
+		s.keywords.Add(collection, id, chunk, md)
+		delete(previous, id)
+	}
+
+	// Drop chunks left over from a longer previous version
+	for id := range previous {
+		if _, err := s.store.Delete(collection, id); err != nil {
+			return 0, err
+		}
+		s.keywords.Remove(collection, id)
+	}
+	return len(chunks), nil
+}
+
+// DeleteDocument removes every chunk of a document.
+func (s *Service) DeleteDocument(ctx context.Context, collection, docID string) error {
+	s.documentsMu.Lock()
+	defer s.documentsMu.Unlock()
+
+	if !s.hasCollection(collection) {
+		return ErrCollectionNotFound
+	}
+	chunks := s.documentChunks(collection, docID)
+	if len(chunks) == 0 {
+		return ErrDocumentNotFound
+	}
+	for id := range chunks {
+		if _, err := s.store.Delete(collection, id); err != nil {
+			return err
+		}
+		s.keywords.Remove(collection, id)
+	}
+	return nil
+}
+
+// ListDocuments returns the documents in a collection sorted by ID.
+func (s *Service) ListDocuments(collection string) ([]Document, error) {
+	if !s.hasCollection(collection) {
+		return nil, ErrCollectionNotFound
+	}
+
+	docs := make(map[string]*Document)
+	for _, rec := range s.store.List(collection) {
+		id, ok := rec.Metadata[MetadataDocumentID]
+		if !ok {
+			continue
+		}
+		doc, ok := docs[id]
+		if !ok {
+			doc = &Document{ID: id, Collection: collection, Metadata: documentMetadata(rec.Metadata)}
+			docs[id] = doc
+		}
+		doc.ChunkCount++
+	}
+
+	list := make([]Document, 0, len(docs))
+	for _, doc := range docs {
+		list = append(list, *doc)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+// GetDocument returns a document with its chunks in order.
+func (s *Service) GetDocument(collection, docID string) (Document, error) {
+	if !s.hasCollection(collection) {
+		return Document{}, ErrCollectionNotFound
+	}
+	chunks := s.documentChunks(collection, docID)
+	if len(chunks) == 0 {
+		return Document{}, ErrDocumentNotFound
+	}
+
+	doc := Document{ID: docID, Collection: collection, ChunkCount: len(chunks), Chunks: make([]string, len(chunks))}
+	for _, rec := range chunks {
+		i, err := strconv.Atoi(rec.Metadata[MetadataChunk])
+		if err != nil || i < 0 || i >= len(chunks) {
+			return Document{}, fmt.Errorf("document %s has inconsistent chunks", docID)
+		}
+		doc.Chunks[i] = rec.Text
+		if i == 0 {
+			doc.Metadata = documentMetadata(rec.Metadata)
+		}
+	}
+	return doc, nil
+}
+
+// documentChunks returns the records of a document keyed by record ID.
+func (s *Service) documentChunks(collection, docID string) map[string]Record {
+	chunks := make(map[string]Record)
+	for _, rec := range s.store.List(collection) {
+		if rec.Metadata[MetadataDocumentID] == docID {
+			chunks[rec.ID] = rec
+		}
+	}
+	return chunks
+}
+
+func (s *Service) collectionInfo(c Collection) CollectionInfo {
+	info := CollectionInfo{Collection: c}
+	docs := make(map[string]bool)
+	for _, rec := range s.store.List(c.Name) {
+		info.Chunks++
+		if id, ok := rec.Metadata[MetadataDocumentID]; ok {
+			docs[id] = true
+		}
+	}
+	info.Documents = len(docs)
+	return info
+}
+
+func chunkID(docID string, i int) string {
+	return docID + "#" + strconv.Itoa(i)
+}
+
+// documentMetadata strips the per-chunk keys from chunk metadata.
+func documentMetadata(md map[string]string) map[string]string {
+	doc := make(map[string]string, len(md))
+	for k, v := range md {
+		if k != MetadataDocumentID && k != MetadataChunk {
+			doc[k] = v
+		}
+	}
+	if len(doc) == 0 {
+		return nil
+	}
+	return doc
+}
+
+// loadCollections reads the collection registry of a persistent store.
+func (s *Service) loadCollections() error {
+	if s.store.dir == "" {
+		return nil
+	}
+	data, err := os.ReadFile(filepath.Join(s.store.dir, collectionsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read collections: %w", err)
+	}
+
+	var collections []Collection
+	if err := json.Unmarshal(data, &collections); err != nil {
+		return fmt.Errorf("failed to parse collections: %w", err)
+	}
+	for i := range collections {
+		c := collections[i]
+		if err := s.SetRerankConfig(c.Name, c.Rerank); err != nil {
+			return fmt.Errorf("invalid settings for collection %s: %w", c.Name, err)
+		}
+		s.collections[c.Name] = &c
+	}
+	return nil
+}
+
+// saveCollections writes the collection registry next to the store's
+// snapshot. Callers must hold collectionsMu.
+func (s *Service) saveCollections() error {
+	if s.store.dir == "" {
+		return nil
+	}
+
+	collections := make([]Collection, 0, len(s.collections))
+	for _, c := range s.collections {
+		collections = append(collections, *c)
+	}
+	sort.Slice(collections, func(i, j int) bool {
+		return collections[i].Name < collections[j].Name
+	})
+	data, err := json.MarshalIndent(collections, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal collections: %w", err)
+	}
+
+	path := filepath.Join(s.store.dir, collectionsFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write collections: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write collections: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package vector
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollections_IngestReplaceDelete(t *testing.T) {
+	t.Setenv("VECTOR_STORE_DIR", "")
+	t.Setenv("CHUNK_SIZE", "60")
+	svc := NewServiceWithEmbedder(NewHashEmbedder(64))
+	ctx := context.Background()
+
+	_, err := svc.IngestDocument(ctx, "faq", "refunds", "text", nil)
+	assert.ErrorIs(t, err, ErrCollectionNotFound)
+
+	_, err = svc.CreateCollection(Collection{Name: "faq", Description: "Support answers"})
+	require.NoError(t, err)
+	_, err = svc.CreateCollection(Collection{Name: "faq"})
+	assert.ErrorIs(t, err, ErrCollectionExists)
+	_, err = svc.CreateCollection(Collection{Name: "bad name"})
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	long := strings.Repeat("Refunds are issued within five business days. ", 4)
+	n, err := svc.IngestDocument(ctx, "faq", "refunds", long, map[string]string{"lang": "en"})
+	require.NoError(t, err)
+	assert.Greater(t, n, 1)
+	_, err = svc.IngestDocument(ctx, "faq", "shipping", "Orders ship within one day.", nil)
+	require.NoError(t, err)
+
+	docs, err := svc.ListDocuments("faq")
+	require.NoError(t, err)
+	require.Len(t, docs, 2)
+	assert.Equal(t, "refunds", docs[0].ID)
+	assert.Equal(t, n, docs[0].ChunkCount)
+	assert.Equal(t, map[string]string{"lang": "en"}, docs[0].Metadata)
+
+	// Fixing the answer replaces every old chunk
+	_, err = svc.IngestDocument(ctx, "faq", "refunds", "Refunds are issued within ten business days.", nil)
+	require.NoError(t, err)
+	doc, err := svc.GetDocument("faq", "refunds")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Refunds are issued within ten business days."}, doc.Chunks)
+	info, err := svc.GetCollection("faq")
+	require.NoError(t, err)
+	assert.Equal(t, 2, info.Documents)
+	assert.Equal(t, 2, info.Chunks)
+
+	results, err := svc.Search(ctx, SearchRequest{Namespace: "faq", Query: "refunds business days", K: 5})
+	require.NoError(t, err)
+	require.NotEmpty(t, results)
+	assert.Equal(t, "refunds", results[0].Metadata[MetadataDocumentID])
+	assert.NotContains(t, results[0].Text, "five")
+
+	require.NoError(t, svc.DeleteDocument(ctx, "faq", "refunds"))
+	assert.ErrorIs(t, svc.DeleteDocument(ctx, "faq", "refunds"), ErrDocumentNotFound)
+
+	require.NoError(t, svc.DeleteCollection(ctx, "faq"))
+	assert.Equal(t, 0, svc.Store().Len())
+	assert.Empty(t, svc.ListCollections())
+}
+
+func TestCollections_Persist(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("VECTOR_STORE_DIR", dir)
+	svc := NewServiceWithEmbedder(NewHashEmbedder(64))
+	ctx := context.Background()
+
+	rerank := RerankConfig{MMR: true, MMRLambda: 0.5}
+	_, err := svc.CreateCollection(Collection{Name: "docs", Rerank: rerank})
+	require.NoError(t, err)
+	_, err = svc.IngestDocument(ctx, "docs", "intro", "Welcome to the product manual.", nil)
+	require.NoError(t, err)
+	require.NoError(t, svc.Close())
+
+	reopened := NewServiceWithEmbedder(NewHashEmbedder(64))
+	info, err := reopened.GetCollection("docs")
+	require.NoError(t, err)
+	assert.Equal(t, 1, info.Documents)
+	assert.Equal(t, rerank, reopened.GetRerankConfig("docs"))
+}
@@ -0,0 +1,108 @@
+package vector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Embedder turns text into embedding vectors. Implementations must return
+// one vector per input text, in order, all of the same dimension.
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+	// Model identifies the embedding space; vectors from different models
+	// are not comparable.
+	Model() string
+}
+
+// DeepSeekEmbedder calls the DeepSeek embeddings API.
+type DeepSeekEmbedder struct {
+	apiKey     string
+	apiURL     string
+	model      string
+	httpClient *http.Client
+}
+
+type EmbeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type EmbeddingResponse struct {
+	Data []struct {
+		Embedding []float32 `json:"embedding"`
+		Index     int       `json:"index"`
+	} `json:"data"`
+}
+
+// NewDeepSeekEmbedder creates an embedder for the DeepSeek embeddings API.
+func NewDeepSeekEmbedder(apiKey string) *DeepSeekEmbedder {
+	return &DeepSeekEmbedder{
+		apiKey: apiKey,
+		apiURL: "https://api.deepseek.com/v1/embeddings",
+		model:  "deepseek-embed",
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+func (e *DeepSeekEmbedder) Model() string {
+	return e.model
+}
+
+// Embed generates embeddings for texts in a single API call
+func (e *DeepSeekEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if e.apiKey == "" {
+		return nil, fmt.Errorf("API key not configured")
+	}
+
+	req := EmbeddingRequest{
+		Model: e.model,
+		Input: texts,
+	}
+
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", e.apiURL, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", e.apiKey))
+
+	resp, err := e.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API request failed with status %d", resp.StatusCode)
+	}
+
+	var embeddingResp EmbeddingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&embeddingResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if len(embeddingResp.Data) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(embeddingResp.Data))
+	}
+
+	embeddings := make([][]float32, len(texts))
+	for _, d := range embeddingResp.Data {
+		if d.Index < 0 || d.Index >= len(texts) {
+			return nil, fmt.Errorf("embedding index %d out of range", d.Index)
+		}
+		embeddings[d.Index] = d.Embedding
+	}
+	return embeddings, nil
+}
@@ -0,0 +1,27 @@
+package vector
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrCollectionNotFound = errors.New("collection not found")
+	ErrCollectionExists   = errors.New("collection already exists")
+	ErrDocumentNotFound   = errors.New("document not found")
+	// ErrInvalid matches every error caused by invalid input, such as a bad
+	// filter or collection name, so callers can report it to the client.
+	ErrInvalid = errors.New("invalid input")
+)
+
+type validationError struct {
+	msg string
+}
+
+func (e *validationError) Error() string        { return e.msg }
+func (e *validationError) Is(target error) bool { return target == ErrInvalid }
+
+// invalidf formats an error that matches ErrInvalid.
+func invalidf(format string, args ...interface{}) error {
+	return &validationError{msg: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,149 @@
+package vector
+
+import (
+	"strconv"
+)
+
+// Filter is a structured predicate over record metadata. Exactly one of the
+// combinators (And, Or) or a field condition (Field with Eq, In or Range)
+// must be set. Filters are evaluated inside the index scan, before top-k
+// selection, so a filtered search still returns up to k results.
+//
+// In JSON:
+//
+//	{"and": [
+//	  {"field": "product", "eq": "router"},
+//	  {"field": "lang", "in": ["en", "zh"]},
+//	  {"field": "updated", "range": {"gte": 1700000000}}
+//	]}
+type Filter struct {
+	And []Filter `json:"and,omitempty"`
+	Or  []Filter `json:"or,omitempty"`
+
+	Field string   `json:"field,omitempty"`
+	Eq    *string  `json:"eq,omitempty"`
+	In    []string `json:"in,omitempty"`
+	Range *Range   `json:"range,omitempty"`
+}
+
+// Range matches metadata values that parse as numbers within the bounds.
+// Unset bounds are open.
+type Range struct {
+	Gt  *float64 `json:"gt,omitempty"`
+	Gte *float64 `json:"gte,omitempty"`
+	Lt  *float64 `json:"lt,omitempty"`
+	Lte *float64 `json:"lte,omitempty"`
+}
+
+// Validate checks that the filter is well formed.
+func (f *Filter) Validate() error {
+	if f == nil {
+		return nil
+	}
+
+	set := 0
+	if f.And != nil {
+		set++
+	}
+	if f.Or != nil {
+		set++
+	}
+	if f.Field != "" {
+		set++
+	}
+	if set != 1 {
+		return invalidf("filter must have exactly one of and, or, field")
+	}
+
+	for i := range f.And {
+		if err := f.And[i].Validate(); err != nil {
+			return err
+		}
+	}
+	for i := range f.Or {
+		if err := f.Or[i].Validate(); err != nil {
+			return err
+		}
+	}
+
+	if f.Field != "" {
+		conditions := 0
+		if f.Eq != nil {
+			conditions++
+		}
+		if f.In != nil {
+			conditions++
+		}
+		if f.Range != nil {
+			conditions++
+		}
+		if conditions != 1 {
+			return invalidf("filter on %q must have exactly one of eq, in, range", f.Field)
+		}
+	}
+	return nil
+}
+
+// Match reports whether metadata satisfies the filter. A nil filter matches
+// everything.
+func (f *Filter) Match(metadata map[string]string) bool {
+	if f == nil {
+		return true
+	}
+
+	switch {
+	case f.And != nil:
+		for i := range f.And {
+			if !f.And[i].Match(metadata) {
+				return false
+			}
+		}
+		return true
+	case f.Or != nil:
+		for i := range f.Or {
+			if f.Or[i].Match(metadata) {
+				return true
+			}
+		}
+		return false
+	}
+
+	value, ok := metadata[f.Field]
+	if !ok {
+		return false
+	}
+	switch {
+	case f.Eq != nil:
+		return value == *f.Eq
+	case f.In != nil:
+		for _, v := range f.In {
+			if value == v {
+				return true
+			}
+		}
+		return false
+	case f.Range != nil:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return false
+		}
+		return f.Range.contains(n)
+	}
+	return false
+}
+
+func (r *Range) contains(n float64) bool {
+	if r.Gt != nil && !(n > *r.Gt) {
+		return false
+	}
+	if r.Gte != nil && !(n >= *r.Gte) {
+		return false
+	}
+	if r.Lt != nil && !(n < *r.Lt) {
+		return false
+	}
+	if r.Lte != nil && !(n <= *r.Lte) {
+		return false
+	}
+	return true
+}
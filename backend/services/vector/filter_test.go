@@ -0,0 +1,110 @@
+package vector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilter_Match(t *testing.T) {
+	var f Filter
+	require.NoError(t, json.Unmarshal([]byte(`{"and": [
+		{"field": "product", "eq": "router"},
+		{"or": [
+			{"field": "lang", "in": ["en", "zh"]},
+			{"field": "version", "range": {"gte": 2, "lt": 3}}
+		]}
+	]}`), &f))
+	require.NoError(t, f.Validate())
+
+	assert.True(t, f.Match(map[string]string{"product": "router", "lang": "zh"}))
+	assert.True(t, f.Match(map[string]string{"product": "router", "lang": "fr", "version": "2.5"}))
+	assert.False(t, f.Match(map[string]string{"product": "router", "lang": "fr", "version": "3"}))
+	assert.False(t, f.Match(map[string]string{"product": "speaker", "lang": "en"}))
+	assert.False(t, f.Match(nil))
+
+	var nilFilter *Filter
+	assert.True(t, nilFilter.Match(nil))
+}
+
+func TestFilter_Validate(t *testing.T) {
+	eq := "x"
+	assert.Error(t, (&Filter{}).Validate())
+	assert.Error(t, (&Filter{Field: "a"}).Validate())
+	assert.Error(t, (&Filter{Field: "a", Eq: &eq, In: []string{"x"}}).Validate())
+	assert.Error(t, (&Filter{Field: "a", Eq: &eq, And: []Filter{}}).Validate())
+	assert.Error(t, (&Filter{And: []Filter{{Field: "a"}}}).Validate())
+	assert.NoError(t, (&Filter{Field: "a", Eq: &eq}).Validate())
+}
+
+func TestStore_FilterPushdown(t *testing.T) {
+	store := NewMemoryStore("test", StoreOptions{})
+
+	// The closest vectors belong to the wrong tenant; a filter applied after
+	// top-k would return nothing.
+	for i := 0; i < 20; i++ {
+		tenant := "a"
+		vec := []float32{1, float32(i) / 100}
+		if i >= 3 {
+			tenant = "b"
+			vec = []float32{1, float32(i)}
+		}
+		require.NoError(t, store.Put(Record{
+			Namespace: "kb",
+			ID:        fmt.Sprintf("doc-%d", i),
+			Vector:    vec,
+			Metadata:  map[string]string{"tenant": tenant},
+		}))
+	}
+
+	tenant := "b"
+	results := store.Search("kb", []float32{1, 0}, 5, &Filter{Field: "tenant", Eq: &tenant})
+	require.Len(t, results, 5)
+	for _, r := range results {
+		assert.Equal(t, "b", r.Metadata["tenant"])
+	}
+}
+
+func TestSearch_NamespacesAreIsolated(t *testing.T) {
+	t.Setenv("VECTOR_STORE_DIR", "")
+	svc := NewServiceWithEmbedder(NewHashEmbedder(64))
+	ctx := context.Background()
+
+	require.NoError(t, svc.Upsert(ctx, "tenant-a", "faq", "Reset the router by holding the button", nil))
+	require.NoError(t, svc.Upsert(ctx, "tenant-b", "faq", "Reset the speaker by holding the button", nil))
+
+	results, err := svc.Search(ctx, SearchRequest{Namespace: "tenant-a", Query: "reset speaker", K: 5})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "tenant-a", results[0].Namespace)
+	assert.Contains(t, results[0].Text, "router")
+
+	results, err = svc.Search(ctx, SearchRequest{Namespace: "tenant-c", Query: "reset", K: 5})
+	require.NoError(t, err)
+	assert.Empty(t, results)
+
+	_, err = svc.Search(ctx, SearchRequest{Namespace: "tenant-a", Query: "reset", K: 5, Filter: &Filter{}})
+	assert.Error(t, err)
+}
+
+func TestStore_NamespacesPersist(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := OpenStore(dir, "test", StoreOptions{})
+	require.NoError(t, err)
+	require.NoError(t, store.Put(Record{Namespace: "a", ID: "1", Vector: []float32{1, 0}}))
+	require.NoError(t, store.Put(Record{Namespace: "b", ID: "1", Vector: []float32{0, 1}}))
+	require.NoError(t, store.Snapshot())
+	_, err = store.Delete("a", "1")
+	require.NoError(t, err)
+
+	reopened, err := OpenStore(dir, "test", StoreOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"b": 1}, reopened.Namespaces())
+	_, ok := reopened.Get("b", "1")
+	assert.True(t, ok)
+}
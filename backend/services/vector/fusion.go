@@ -0,0 +1,88 @@
+package vector
+
+import (
+	"os"
+	"sort"
+	"strconv"
+)
+
+// FusionConfig tunes how the vector and keyword rankings of a hybrid search
+// are merged with reciprocal rank fusion. A result at rank r (1-based) in a
+// list contributes weight/(K+r) to its fused score.
+type FusionConfig struct {
+	VectorWeight  float64
+	KeywordWeight float64
+	// K dampens the advantage of the very top ranks; 60 is the value from
+	// the original RRF paper.
+	K float64
+	// CandidateFactor is how many candidates each retriever returns per
+	// requested result before fusion.
+	CandidateFactor int
+}
+
+// DefaultFusionConfig weights both retrievers equally.
+func DefaultFusionConfig() FusionConfig {
+	return FusionConfig{
+		VectorWeight:    1,
+		KeywordWeight:   1,
+		K:               60,
+		CandidateFactor: 4,
+	}
+}
+
+// fusionConfigFromEnv reads SEARCH_VECTOR_WEIGHT, SEARCH_KEYWORD_WEIGHT and
+// SEARCH_RRF_K on top of the defaults.
+func fusionConfigFromEnv() FusionConfig {
+	cfg := DefaultFusionConfig()
+	if v, err := strconv.ParseFloat(os.Getenv("SEARCH_VECTOR_WEIGHT"), 64); err == nil && v >= 0 {
+		cfg.VectorWeight = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("SEARCH_KEYWORD_WEIGHT"), 64); err == nil && v >= 0 {
+		cfg.KeywordWeight = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("SEARCH_RRF_K"), 64); err == nil && v > 0 {
+		cfg.K = v
+	}
+	return cfg
+}
+
+// FuseRankings merges a vector ranking and a keyword ranking into the top k
+// results. The fused score replaces Score; the original scores are kept in
+// VectorScore and KeywordScore.
+func FuseRankings(cfg FusionConfig, vectorResults, keywordResults []SearchResult, k int) []SearchResult {
+	fused := make(map[string]*SearchResult)
+	get := func(r SearchResult) *SearchResult {
+		if f, ok := fused[r.ID]; ok {
+			return f
+		}
+		f := &SearchResult{Namespace: r.Namespace, ID: r.ID, Text: r.Text, Metadata: r.Metadata}
+		fused[r.ID] = f
+		return f
+	}
+
+	for rank, r := range vectorResults {
+		f := get(r)
+		f.VectorScore = r.Score
+		f.Score += cfg.VectorWeight / (cfg.K + float64(rank+1))
+	}
+	for rank, r := range keywordResults {
+		f := get(r)
+		f.KeywordScore = r.Score
+		f.Score += cfg.KeywordWeight / (cfg.K + float64(rank+1))
+	}
+
+	results := make([]SearchResult, 0, len(fused))
+	for _, f := range fused {
+		results = append(results, *f)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID < results[j].ID
+	})
+	if len(results) > k {
+		results = results[:k]
+	}
+	return results
+}
@@ -0,0 +1,118 @@
+package vector
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+
+	"csdeepseek/backend/services/tokenizer"
+)
+
+// HashEmbedder is a deterministic, offline embedder based on feature hashing.
+// Latin-script words contribute the whole word plus its character trigrams,
+// so inflections and typos still overlap; CJK text, which has no spaces,
+// contributes character unigrams and bigrams. Features are hashed into a
+// fixed number of signed buckets and the result is L2-normalized.
+//
+// It captures lexical rather than semantic similarity, which is enough for
+// development and relevance tests without network access.
+type HashEmbedder struct {
+	dim int
+}
+
+// NewHashEmbedder creates a hash embedder producing vectors of size dim.
+func NewHashEmbedder(dim int) *HashEmbedder {
+	if dim <= 0 {
+		dim = 384
+	}
+	return &HashEmbedder{dim: dim}
+}
+
+func (e *HashEmbedder) Model() string {
+	return fmt.Sprintf("local-hash-%d", e.dim)
+}
+
+// Embed hashes each text into a vector. It never fails.
+func (e *HashEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	embeddings := make([][]float32, len(texts))
+	for i, text := range texts {
+		embeddings[i] = e.embed(text)
+	}
+	return embeddings, nil
+}
+
+func (e *HashEmbedder) embed(text string) []float32 {
+	vec := make([]float64, e.dim)
+	forEachFeature(text, func(feature string, weight float64) {
+		h := fnv.New64a()
+		h.Write([]byte(feature))
+		sum := h.Sum64()
+		// The top bit picks the sign so colliding features tend to cancel
+		// instead of piling up in one bucket.
+		sign := 1.0
+		if sum>>63 == 1 {
+			sign = -1.0
+		}
+		vec[sum%uint64(e.dim)] += sign * weight
+	})
+
+	var n float64
+	for _, v := range vec {
+		n += v * v
+	}
+	out := make([]float32, e.dim)
+	if n > 0 {
+		n = math.Sqrt(n)
+		for i, v := range vec {
+			out[i] = float32(v / n)
+		}
+	}
+	return out
+}
+
+// forEachFeature splits text into runs of CJK and non-CJK characters and
+// emits the hashed features for each run.
+func forEachFeature(text string, emit func(feature string, weight float64)) {
+	var word []rune
+	var cjk []rune
+
+	flushWord := func() {
+		if len(word) == 0 {
+			return
+		}
+		emit("w:"+string(word), 1.0)
+		padded := append(append([]rune{'<'}, word...), '>')
+		for i := 0; i+3 <= len(padded); i++ {
+			emit("t:"+string(padded[i:i+3]), 0.5)
+		}
+		word = word[:0]
+	}
+	flushCJK := func() {
+		for i := range cjk {
+			emit("u:"+string(cjk[i]), 0.5)
+			if i+1 < len(cjk) {
+				emit("b:"+string(cjk[i:i+2]), 1.0)
+			}
+		}
+		cjk = cjk[:0]
+	}
+
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case tokenizer.IsCJK(r):
+			flushWord()
+			cjk = append(cjk, r)
+		case unicode.IsLetter(r) || unicode.IsNumber(r):
+			flushCJK()
+			word = append(word, r)
+		default:
+			flushWord()
+			flushCJK()
+		}
+	}
+	flushWord()
+	flushCJK()
+}
@@ -0,0 +1,55 @@
+package vector
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashEmbedder_Deterministic(t *testing.T) {
+	e := NewHashEmbedder(128)
+	ctx := context.Background()
+
+	a, err := e.Embed(ctx, []string{"Reset your router"})
+	require.NoError(t, err)
+	b, err := NewHashEmbedder(128).Embed(ctx, []string{"Reset your router"})
+	require.NoError(t, err)
+
+	assert.Equal(t, a, b)
+	assert.Len(t, a[0], 128)
+	assert.Equal(t, "local-hash-128", e.Model())
+
+	assert.InDelta(t, 1.0, Norm(a[0]), 1e-6)
+}
+
+func TestHashEmbedder_Relevance(t *testing.T) {
+	t.Setenv("VECTOR_STORE_DIR", "")
+	svc := NewServiceWithEmbedder(NewHashEmbedder(512))
+	ctx := context.Background()
+
+	docs := map[string]string{
+		"refund":   "How to request a refund for a returned order",
+		"password": "Reset your account password from the login page",
+		"shipping": "Shipping usually takes three to five business days",
+		"退款":       "退货后如何申请退款",
+		"发货":       "订单一般在三到五个工作日内发货",
+	}
+	for id, text := range docs {
+		require.NoError(t, svc.Upsert(ctx, "faq", id, text, nil))
+	}
+
+	cases := map[string]string{
+		"refunds for returned orders": "refund",
+		"forgot my password":          "password",
+		"我想申请退款":                      "退款",
+		"什么时候发货":                      "发货",
+	}
+	for query, want := range cases {
+		results, err := svc.Search(ctx, SearchRequest{Namespace: "faq", Query: query, K: 1})
+		require.NoError(t, err)
+		require.Len(t, results, 1, query)
+		assert.Equal(t, want, results[0].ID, query)
+	}
+}
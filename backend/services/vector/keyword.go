@@ -0,0 +1,188 @@
+package vector
+
+import (
+	"container/heap"
+	"math"
+	"sync"
+
+	"csdeepseek/backend/services/tokenizer"
+)
+
+// BM25 parameters: k1 controls term frequency saturation, b the strength of
+// document length normalization.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// KeywordIndex is an in-memory BM25 inverted index over record text. Each
+// namespace has its own postings and corpus statistics, so one tenant's
+// documents never influence another's scores.
+type KeywordIndex struct {
+	mu     sync.RWMutex
+	shards map[string]*keywordShard
+}
+
+type keywordShard struct {
+	postings map[string]map[string]int // term -> record ID -> term frequency
+	docLen   map[string]int
+	docTerms map[string][]string
+	metadata map[string]map[string]string
+	totalLen int
+}
+
+// NewKeywordIndex creates an empty keyword index.
+func NewKeywordIndex() *KeywordIndex {
+	return &KeywordIndex{
+		shards: make(map[string]*keywordShard),
+	}
+}
+
+// Add indexes text under namespace and id, replacing any previous text.
+func (idx *KeywordIndex) Add(namespace, id, text string, metadata map[string]string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	shard, ok := idx.shards[namespace]
+	if !ok {
+		shard = &keywordShard{
+			postings: make(map[string]map[string]int),
+			docLen:   make(map[string]int),
+			docTerms: make(map[string][]string),
+			metadata: make(map[string]map[string]string),
+		}
+		idx.shards[namespace] = shard
+	}
+	shard.remove(id)
+
+	terms := tokenizer.Terms(text)
+	freqs := make(map[string]int)
+	for _, term := range terms {
+		freqs[term]++
+	}
+
+	unique := make([]string, 0, len(freqs))
+	for term, tf := range freqs {
+		docs, ok := shard.postings[term]
+		if !ok {
+			docs = make(map[string]int)
+			shard.postings[term] = docs
+		}
+		docs[id] = tf
+		unique = append(unique, term)
+	}
+
+	shard.docTerms[id] = unique
+	shard.docLen[id] = len(terms)
+	shard.metadata[id] = metadata
+	shard.totalLen += len(terms)
+}
+
+// Remove drops a record from the index.
+func (idx *KeywordIndex) Remove(namespace, id string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	shard, ok := idx.shards[namespace]
+	if !ok {
+		return
+	}
+	shard.remove(id)
+	if len(shard.docLen) == 0 {
+		delete(idx.shards, namespace)
+	}
+}
+
+func (shard *keywordShard) remove(id string) {
+	terms, ok := shard.docTerms[id]
+	if !ok {
+		return
+	}
+	for _, term := range terms {
+		docs := shard.postings[term]
+		delete(docs, id)
+		if len(docs) == 0 {
+			delete(shard.postings, term)
+		}
+	}
+	shard.totalLen -= shard.docLen[id]
+	delete(shard.docTerms, id)
+	delete(shard.docLen, id)
+	delete(shard.metadata, id)
+}
+
+// Len returns the number of records indexed in namespace.
+func (idx *KeywordIndex) Len(namespace string) int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if shard, ok := idx.shards[namespace]; ok {
+		return len(shard.docLen)
+	}
+	return 0
+}
+
+// Search returns the k records in namespace with the highest BM25 score for
+// query among those matching filter. Results carry only ID and Score.
+func (idx *KeywordIndex) Search(namespace, query string, k int, filter *Filter) []SearchResult {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	shard, ok := idx.shards[namespace]
+	if !ok || k <= 0 {
+		return nil
+	}
+	n := len(shard.docLen)
+	avgLen := float64(shard.totalLen) / float64(n)
+
+	seen := make(map[string]bool)
+	scores := make(map[string]float64)
+	for _, term := range tokenizer.Terms(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+
+		docs := shard.postings[term]
+		if len(docs) == 0 {
+			continue
+		}
+		idf := math.Log(1 + (float64(n)-float64(len(docs))+0.5)/(float64(len(docs))+0.5))
+		for id, tf := range docs {
+			if filter != nil && !filter.Match(shard.metadata[id]) {
+				continue
+			}
+			norm := bm25K1 * (1 - bm25B + bm25B*float64(shard.docLen[id])/avgLen)
+			scores[id] += idf * float64(tf) * (bm25K1 + 1) / (float64(tf) + norm)
+		}
+	}
+
+	results := topK(scores, k)
+	for i := range results {
+		results[i].Namespace = namespace
+	}
+	return results
+}
+
+// topK returns the k highest scoring IDs in descending order.
+func topK(scores map[string]float64, k int) []SearchResult {
+	ids := make([]string, 0, len(scores))
+	h := make(resultHeap, 0, k+1)
+	for id, score := range scores {
+		entry := scored{index: len(ids), score: score}
+		ids = append(ids, id)
+		if len(h) < k {
+			heap.Push(&h, entry)
+		} else if score > h[0].score {
+			h[0] = entry
+			heap.Fix(&h, 0)
+		}
+	}
+
+	results := make([]SearchResult, len(h))
+	for i := len(h) - 1; i >= 0; i-- {
+		top := heap.Pop(&h).(scored)
+		results[i] = SearchResult{ID: ids[top.index], Score: top.score}
+	}
+	return results
+}
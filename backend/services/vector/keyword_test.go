@@ -0,0 +1,105 @@
+package vector
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeywordIndex_BM25(t *testing.T) {
+	idx := NewKeywordIndex()
+	idx.Add("kb", "a", "error E1023 when pairing the speaker", nil)
+	idx.Add("kb", "b", "the speaker is too quiet", nil)
+	idx.Add("kb", "c", "订单 SKU-77812 已发货", nil)
+
+	results := idx.Search("kb", "E1023", 10, nil)
+	require.Len(t, results, 1)
+	assert.Equal(t, "a", results[0].ID)
+
+	results = idx.Search("kb", "speaker pairing", 10, nil)
+	require.Len(t, results, 2)
+	assert.Equal(t, "a", results[0].ID)
+	assert.Greater(t, results[0].Score, results[1].Score)
+
+	results = idx.Search("kb", "sku-77812", 10, nil)
+	require.Len(t, results, 1)
+	assert.Equal(t, "c", results[0].ID)
+
+	results = idx.Search("kb", "发货", 10, nil)
+	require.Len(t, results, 1)
+	assert.Equal(t, "c", results[0].ID)
+
+	idx.Remove("kb", "a")
+	assert.Empty(t, idx.Search("kb", "E1023", 10, nil))
+	assert.Equal(t, 2, idx.Len("kb"))
+}
+
+func TestFuseRankings(t *testing.T) {
+	cfg := DefaultFusionConfig()
+	vectorResults := []SearchResult{{ID: "a", Score: 0.9}, {ID: "b", Score: 0.8}}
+	keywordResults := []SearchResult{{ID: "b", Score: 7}, {ID: "c", Score: 3}}
+
+	fused := FuseRankings(cfg, vectorResults, keywordResults, 3)
+	require.Len(t, fused, 3)
+	// b is ranked by both retrievers, so it wins
+	assert.Equal(t, "b", fused[0].ID)
+	assert.Equal(t, 0.8, fused[0].VectorScore)
+	assert.Equal(t, 7.0, fused[0].KeywordScore)
+	assert.InDelta(t, 1.0/62+1.0/61, fused[0].Score, 1e-12)
+
+	cfg.KeywordWeight = 0
+	fused = FuseRankings(cfg, vectorResults, keywordResults, 1)
+	assert.Equal(t, "a", fused[0].ID)
+}
+
+func TestSearch_HybridFindsExactIdentifiers(t *testing.T) {
+	t.Setenv("VECTOR_STORE_DIR", "")
+	svc := NewServiceWithEmbedder(NewHashEmbedder(64))
+	ctx := context.Background()
+
+	require.NoError(t, svc.Upsert(ctx, "kb", "faq-1", "Error code E4021 means the battery is overheating", nil))
+	require.NoError(t, svc.Upsert(ctx, "kb", "faq-2", "Error code explained: what the battery light means", nil))
+	require.NoError(t, svc.Upsert(ctx, "kb", "faq-3", "How to update the firmware", nil))
+
+	results, err := svc.Search(ctx, SearchRequest{Namespace: "kb", Query: "E4021", K: 2})
+	require.NoError(t, err)
+	require.NotEmpty(t, results)
+	assert.Equal(t, "faq-1", results[0].ID)
+	assert.Equal(t, "Error code E4021 means the battery is overheating", results[0].Text)
+	assert.Greater(t, results[0].KeywordScore, 0.0)
+
+	require.NoError(t, svc.Delete(ctx, "kb", "faq-1"))
+	results, err = svc.Search(ctx, SearchRequest{Namespace: "kb", Query: "E4021", K: 2})
+	require.NoError(t, err)
+	for _, r := range results {
+		assert.NotEqual(t, "faq-1", r.ID)
+	}
+}
+
+func TestSearch_FusionConfigChangesDuringSearches(t *testing.T) {
+	t.Setenv("VECTOR_STORE_DIR", "")
+	svc := NewServiceWithEmbedder(NewHashEmbedder(64))
+	ctx := context.Background()
+	require.NoError(t, svc.Upsert(ctx, "kb", "faq-1", "Error code E4021 means the battery is overheating", nil))
+
+	// Run with -race: the config is swapped while searches read it
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			_, err := svc.Search(ctx, SearchRequest{Namespace: "kb", Query: "E4021", K: 1})
+			assert.NoError(t, err)
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		svc.SetFusionConfig(FusionConfig{KeywordWeight: 1, CandidateFactor: 1 + i%3})
+	}
+	<-done
+
+	results, err := svc.Search(ctx, SearchRequest{Namespace: "kb", Query: "E4021", K: 1})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Zero(t, results[0].VectorScore)
+}
@@ -0,0 +1,147 @@
+package vector
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKernels_MatchNaive(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	for _, n := range []int{1, 3, 4, 7, 128, 1023} {
+		a := make([]float32, n)
+		b := make([]float32, n)
+		var dot, l2 float64
+		for i := range a {
+			a[i] = rng.Float32()*2 - 1
+			b[i] = rng.Float32()*2 - 1
+			dot += float64(a[i]) * float64(b[i])
+			l2 += float64(a[i]-b[i]) * float64(a[i]-b[i])
+		}
+		assert.InDelta(t, dot, Dot(a, b), 1e-3, "n=%d", n)
+		assert.InDelta(t, l2, SquaredL2(a, b), 1e-3, "n=%d", n)
+	}
+}
+
+func TestCosineSimilarity(t *testing.T) {
+	// Parallel vectors of different lengths are perfectly similar
+	sim, err := CosineSimilarity([]float32{1, 0}, []float32{2, 0})
+	require.NoError(t, err)
+	assert.InDelta(t, 1.0, sim, 1e-9)
+
+	sim, err = CosineSimilarity([]float32{1, 1}, []float32{3, 0})
+	require.NoError(t, err)
+	assert.InDelta(t, math.Sqrt2/2, sim, 1e-6)
+
+	_, err = CosineSimilarity([]float32{0, 0}, []float32{1, 0})
+	assert.Error(t, err)
+	_, err = CosineSimilarity([]float32{1}, []float32{1, 0})
+	assert.Error(t, err)
+}
+
+func TestQuantize_RoundTrip(t *testing.T) {
+	v := []float32{0.5, -1, 0.25, 0}
+	codes := make([]int8, len(v))
+	scale := quantize(v, codes)
+	assert.Equal(t, int8(-127), codes[1])
+
+	out := make([]float32, len(v))
+	dequantize(codes, scale, out)
+	for i := range v {
+		assert.InDelta(t, v[i], out[i], float64(scale)/2+1e-7)
+	}
+}
+
+func TestStore_Metrics(t *testing.T) {
+	vectors := map[string][]float32{
+		"short": {1, 0},
+		"long":  {10, 1},
+		"near":  {0.9, 0.9},
+	}
+	query := []float32{1, 1}
+
+	cases := []struct {
+		opts StoreOptions
+		want string
+	}{
+		{StoreOptions{Metric: MetricCosine}, "near"},
+		{StoreOptions{Metric: MetricDot}, "long"},
+		{StoreOptions{Metric: MetricL2}, "near"},
+		{StoreOptions{Metric: MetricCosine, Quantize: true, RescoreFactor: 2}, "near"},
+		{StoreOptions{Metric: MetricDot, Quantize: true}, "long"},
+		{StoreOptions{Metric: MetricL2, Quantize: true}, "near"},
+	}
+	for _, c := range cases {
+		store := NewMemoryStore("test", c.opts)
+		for id, v := range vectors {
+			require.NoError(t, store.Put(Record{ID: id, Vector: v}))
+		}
+		results := store.Search("", query, 1, nil)
+		require.Len(t, results, 1)
+		assert.Equal(t, c.want, results[0].ID, "%+v", c.opts)
+	}
+}
+
+func TestStore_QuantizedRecall(t *testing.T) {
+	const count, dim, k = 2000, 64, 10
+	rng := rand.New(rand.NewSource(3))
+
+	exact := NewMemoryStore("test", StoreOptions{})
+	rescored := NewMemoryStore("test", StoreOptions{Quantize: true, RescoreFactor: 4})
+	for i := 0; i < count; i++ {
+		v := make([]float32, dim)
+		for j := range v {
+			v[j] = float32(rng.NormFloat64())
+		}
+		rec := Record{ID: fmt.Sprintf("v%d", i), Vector: v}
+		require.NoError(t, exact.Put(rec))
+		require.NoError(t, rescored.Put(rec))
+	}
+
+	hits := 0
+	for q := 0; q < 20; q++ {
+		query := make([]float32, dim)
+		for j := range query {
+			query[j] = float32(rng.NormFloat64())
+		}
+		want := make(map[string]bool)
+		for _, r := range exact.Search("", query, k, nil) {
+			want[r.ID] = true
+		}
+		got := rescored.Search("", query, k, nil)
+		for _, r := range got {
+			if want[r.ID] {
+				hits++
+			}
+		}
+		// Rescored scores are exact
+		assert.InDelta(t, exact.Search("", query, 1, nil)[0].Score, got[0].Score, 1e-5)
+	}
+	assert.GreaterOrEqual(t, float64(hits)/float64(20*k), 0.95)
+}
+
+func TestStore_QuantizedPersistence(t *testing.T) {
+	dir := t.TempDir()
+	opts := StoreOptions{Metric: MetricDot, Quantize: true}
+
+	store, err := OpenStore(dir, "test", opts)
+	require.NoError(t, err)
+	require.NoError(t, store.Put(Record{ID: "a", Vector: []float32{0.5, -1}}))
+	require.NoError(t, store.Put(Record{ID: "b", Vector: []float32{1, 1}}))
+	_, err = store.Delete("", "a")
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	reopened, err := OpenStore(dir, "test", opts)
+	require.NoError(t, err)
+	rec, ok := reopened.Get("", "b")
+	require.True(t, ok)
+	assert.InDeltaSlice(t, []float32{1, 1}, rec.Vector, 0.01)
+
+	_, err = OpenStore(dir, "test", StoreOptions{Metric: MetricL2})
+	assert.Error(t, err)
+}
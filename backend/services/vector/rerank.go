@@ -0,0 +1,243 @@
+package vector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"csdeepseek/backend/services/llm"
+)
+
+const (
+	defaultMMRLambda = 0.7
+	// rerankPassageLimit caps how much of each passage is sent to the LLM
+	// re-ranker, in bytes.
+	rerankPassageLimit = 1000
+)
+
+// Completer generates a chat completion. *llm.Service satisfies it.
+type Completer interface {
+	GenerateResponse(ctx context.Context, messages []llm.Message) (string, error)
+}
+
+// RerankConfig controls the optional post-retrieval stages of a search.
+// MMR runs first and picks a diverse subset of the fused candidates; the LLM
+// re-ranker then reorders what MMR (or fusion) returned.
+type RerankConfig struct {
+	MMR bool `json:"mmr"`
+	// MMRLambda trades relevance (1) against diversity (0).
+	MMRLambda float64 `json:"mmr_lambda"`
+	LLM       bool    `json:"llm_rerank"`
+	// LLMCandidates is how many results are sent to the re-ranker before
+	// cutting down to k. Zero sends 2*k.
+	LLMCandidates int `json:"llm_candidates"`
+}
+
+// rerankConfigFromEnv reads SEARCH_MMR_LAMBDA, which enables MMR when set,
+// and SEARCH_LLM_RERANK.
+func rerankConfigFromEnv() RerankConfig {
+	cfg := RerankConfig{MMRLambda: defaultMMRLambda}
+	if v, err := strconv.ParseFloat(os.Getenv("SEARCH_MMR_LAMBDA"), 64); err == nil && v >= 0 && v <= 1 {
+		cfg.MMR = true
+		cfg.MMRLambda = v
+	}
+	if v, err := strconv.ParseBool(os.Getenv("SEARCH_LLM_RERANK")); err == nil {
+		cfg.LLM = v
+	}
+	return cfg
+}
+
+// Validate checks that the configuration values are in range.
+func (c RerankConfig) Validate() error {
+	if c.MMRLambda < 0 || c.MMRLambda > 1 {
+		return invalidf("mmr_lambda must be between 0 and 1")
+	}
+	if c.LLMCandidates < 0 {
+		return invalidf("llm_candidates must not be negative")
+	}
+	return nil
+}
+
+// enabled reports whether any stage runs.
+func (c RerankConfig) enabled() bool {
+	return c.MMR || c.LLM
+}
+
+// selectMMR greedily picks up to k of the candidate vectors by maximal
+// marginal relevance: each step takes the candidate maximizing
+//
+//	lambda*sim(query, d) - (1-lambda)*max sim(d, s) over selected s
+//
+// where sim is cosine similarity. It returns the picked indices in order and
+// the objective value each was picked with. All vectors must be normalized.
+func selectMMR(query []float32, candidates [][]float32, lambda float64, k int) ([]int, []float64) {
+	k = min(k, len(candidates))
+	relevance := make([]float64, len(candidates))
+	redundancy := make([]float64, len(candidates))
+	picked := make([]bool, len(candidates))
+	for i, v := range candidates {
+		relevance[i] = float64(Dot(query, v))
+	}
+
+	order := make([]int, 0, k)
+	scores := make([]float64, 0, k)
+	for len(order) < k {
+		best, bestScore := -1, 0.0
+		for i := range candidates {
+			if picked[i] {
+				continue
+			}
+			score := lambda * relevance[i]
+			if len(order) > 0 {
+				score -= (1 - lambda) * redundancy[i]
+			}
+			if best < 0 || score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+
+		picked[best] = true
+		order = append(order, best)
+		scores = append(scores, bestScore)
+		for i, v := range candidates {
+			if picked[i] {
+				continue
+			}
+			if sim := float64(Dot(candidates[best], v)); len(order) == 1 || sim > redundancy[i] {
+				redundancy[i] = sim
+			}
+		}
+	}
+	return order, scores
+}
+
+// applyMMR reduces results to k diverse ones. Results whose vector is no
+// longer in the store are dropped.
+func (s *Service) applyMMR(namespace string, query []float32, results []SearchResult, lambda float64, k int) []SearchResult {
+	q := append([]float32(nil), query...)
+	Normalize(q)
+
+	kept := make([]SearchResult, 0, len(results))
+	vectors := make([][]float32, 0, len(results))
+	for _, r := range results {
+		rec, ok := s.store.Get(namespace, r.ID)
+		if !ok {
+			continue
+		}
+		Normalize(rec.Vector)
+		kept = append(kept, r)
+		vectors = append(vectors, rec.Vector)
+	}
+
+	order, scores := selectMMR(q, vectors, lambda, k)
+	selected := make([]SearchResult, len(order))
+	for j, i := range order {
+		selected[j] = kept[i]
+		selected[j].MMRScore = scores[j]
+	}
+	return selected
+}
+
+// llmRerank asks the completer to rate each result's relevance to query from
+// 0 to 10 and orders the results by that rating. Ties keep their previous
+// order.
+func (s *Service) llmRerank(ctx context.Context, query string, results []SearchResult) ([]SearchResult, error) {
+	if len(results) == 0 {
+		return results, nil
+	}
+
+	var prompt strings.Builder
+	fmt.Fprintf(&prompt, "Query: %s\n\nPassages:\n", query)
+	for i, r := range results {
+		text := r.Text
+		if len(text) > rerankPassageLimit {
+			text = strings.ToValidUTF8(text[:rerankPassageLimit], "")
+		}
+		fmt.Fprintf(&prompt, "[%d] %s\n", i+1, strings.Join(strings.Fields(text), " "))
+	}
+
+	reply, err := s.completer.GenerateResponse(ctx, []llm.Message{
+		{
+			Role: "system",
+			Content: "You rate how relevant passages are to a search query. " +
+				"Reply with only a JSON array of numbers from 0 (irrelevant) to 10 (answers the query), " +
+				"one per passage, in the order given.",
+		},
+		{Role: "user", Content: prompt.String()},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to rerank: %w", err)
+	}
+
+	scores, err := parseRerankScores(reply, len(results))
+	if err != nil {
+		return nil, err
+	}
+
+	reranked := append([]SearchResult(nil), results...)
+	for i := range reranked {
+		reranked[i].RerankScore = scores[i]
+	}
+	sort.SliceStable(reranked, func(i, j int) bool {
+		return reranked[i].RerankScore > reranked[j].RerankScore
+	})
+	return reranked, nil
+}
+
+// parseRerankScores extracts the JSON array of n scores from a model reply,
+// tolerating surrounding prose or code fences.
+func parseRerankScores(reply string, n int) ([]float64, error) {
+	start := strings.IndexByte(reply, '[')
+	end := strings.LastIndexByte(reply, ']')
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("rerank reply contains no score array")
+	}
+
+	var scores []float64
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &scores); err != nil {
+		return nil, fmt.Errorf("failed to parse rerank scores: %w", err)
+	}
+	if len(scores) != n {
+		return nil, fmt.Errorf("expected %d rerank scores, got %d", n, len(scores))
+	}
+	return scores, nil
+}
+
+// rerank runs the configured post-retrieval stages over the fused results
+// and cuts them down to k. A failing LLM re-ranker is logged and skipped so
+// that search keeps working without the LLM.
+func (s *Service) rerank(ctx context.Context, req SearchRequest, cfg RerankConfig, query []float32, results []SearchResult) []SearchResult {
+	n := req.K
+	if cfg.LLM && s.completer != nil {
+		n = cfg.LLMCandidates
+		if n == 0 {
+			n = 2 * req.K
+		}
+		n = max(n, req.K)
+	}
+
+	if cfg.MMR && query != nil {
+		results = s.applyMMR(req.Namespace, query, results, cfg.MMRLambda, n)
+	} else if len(results) > n {
+		results = results[:n]
+	}
+
+	if cfg.LLM && s.completer != nil {
+		reranked, err := s.llmRerank(ctx, req.Query, results)
+		if err != nil {
+			log.Printf("LLM re-ranking skipped: %v", err)
+		} else {
+			results = reranked
+		}
+	}
+
+	if len(results) > req.K {
+		results = results[:req.K]
+	}
+	return results
+}
@@ -0,0 +1,118 @@
+package vector
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"csdeepseek/backend/services/llm"
+)
+
+type fakeCompleter struct {
+	reply    string
+	err      error
+	messages []llm.Message
+}
+
+func (f *fakeCompleter) GenerateResponse(ctx context.Context, messages []llm.Message) (string, error) {
+	f.messages = messages
+	return f.reply, f.err
+}
+
+func TestSelectMMR_SkipsNearDuplicates(t *testing.T) {
+	query := []float32{1, 0}
+	candidates := [][]float32{
+		{0.99, 0.141}, // most relevant
+		{0.98, 0.199}, // near duplicate of the first
+		{0.8, -0.6},   // less relevant, different direction
+	}
+	for _, v := range candidates {
+		Normalize(v)
+	}
+
+	order, scores := selectMMR(query, candidates, 0.5, 2)
+	assert.Equal(t, []int{0, 2}, order)
+	require.Len(t, scores, 2)
+	assert.Greater(t, scores[0], scores[1])
+
+	// Pure relevance ignores redundancy
+	order, _ = selectMMR(query, candidates, 1, 2)
+	assert.Equal(t, []int{0, 1}, order)
+}
+
+func TestParseRerankScores(t *testing.T) {
+	scores, err := parseRerankScores("Here you go:\n```json\n[3, 9.5, 0]\n```", 3)
+	require.NoError(t, err)
+	assert.Equal(t, []float64{3, 9.5, 0}, scores)
+
+	_, err = parseRerankScores("[1, 2]", 3)
+	assert.Error(t, err)
+	_, err = parseRerankScores("no idea", 1)
+	assert.Error(t, err)
+}
+
+func newRerankTestService(t *testing.T) *Service {
+	t.Setenv("VECTOR_STORE_DIR", "")
+	svc := NewServiceWithEmbedder(NewHashEmbedder(256))
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		text := fmt.Sprintf("Reset the router by holding the reset button for ten seconds (page %d)", i)
+		require.NoError(t, svc.Upsert(ctx, "kb", fmt.Sprintf("dup-%d", i), text, nil))
+	}
+	require.NoError(t, svc.Upsert(ctx, "kb", "other", "After a reset the router light blinks until it reconnects", nil))
+	return svc
+}
+
+func TestSearch_MMRDiversifiesResults(t *testing.T) {
+	svc := newRerankTestService(t)
+	ctx := context.Background()
+	req := SearchRequest{Namespace: "kb", Query: "reset the router", K: 2}
+
+	results, err := svc.Search(ctx, req)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.NotEqual(t, "other", results[1].ID)
+
+	require.NoError(t, svc.SetRerankConfig("kb", RerankConfig{MMR: true, MMRLambda: 0.5}))
+	results, err = svc.Search(ctx, req)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Contains(t, results[0].ID, "dup-")
+	assert.Equal(t, "other", results[1].ID)
+	assert.NotZero(t, results[0].MMRScore)
+
+	// Other namespaces keep the defaults
+	assert.False(t, svc.GetRerankConfig("docs").MMR)
+	assert.Error(t, svc.SetRerankConfig("kb", RerankConfig{MMRLambda: 2}))
+}
+
+func TestSearch_LLMRerank(t *testing.T) {
+	svc := newRerankTestService(t)
+	ctx := context.Background()
+	completer := &fakeCompleter{}
+	svc.SetReranker(completer)
+	require.NoError(t, svc.SetRerankConfig("kb", RerankConfig{LLM: true, LLMCandidates: 4}))
+	req := SearchRequest{Namespace: "kb", Query: "reset the router", K: 2}
+
+	// The re-ranker sees four candidates and rates the last one highest
+	completer.reply = "[1, 2, 3, 10]"
+	results, err := svc.Search(ctx, req)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, 10.0, results[0].RerankScore)
+	assert.Equal(t, 3.0, results[1].RerankScore)
+	require.Len(t, completer.messages, 2)
+	assert.Contains(t, completer.messages[1].Content, "Query: reset the router")
+	assert.Contains(t, completer.messages[1].Content, "[4] ")
+
+	// A malformed reply falls back to the fused order
+	completer.reply = "I cannot help with that"
+	fallback, err := svc.Search(ctx, req)
+	require.NoError(t, err)
+	require.Len(t, fallback, 2)
+	assert.Zero(t, fallback[0].RerankScore)
+}
@@ -0,0 +1,111 @@
+package vector
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"runtime"
+	"testing"
+)
+
+// The search benchmarks compare vector representations at one million
+// vectors. Memory is reported as the MB held by the vector arenas; latency
+// is per query with k=10. Real embeddings are 768-1536 dimensional, so
+// absolute numbers grow linearly with dim while the ratios hold.
+//
+//	go test -run '^$' -bench Search1M ./services/vector/
+const (
+	benchCount = 1_000_000
+	benchDim   = 128
+)
+
+func benchVectors(fn func(i int, v []float32)) {
+	rng := rand.New(rand.NewSource(42))
+	v := make([]float32, benchDim)
+	for i := 0; i < benchCount; i++ {
+		for j := range v {
+			v[j] = float32(rng.NormFloat64())
+		}
+		fn(i, v)
+	}
+}
+
+func benchQuery() []float32 {
+	rng := rand.New(rand.NewSource(99))
+	q := make([]float32, benchDim)
+	for j := range q {
+		q[j] = float32(rng.NormFloat64())
+	}
+	return q
+}
+
+// BenchmarkSearch1M_Float64Baseline is the previous approach: float64
+// vectors with norms computed on every comparison.
+func BenchmarkSearch1M_Float64Baseline(b *testing.B) {
+	if testing.Short() {
+		b.Skip("skipping large benchmark in short mode")
+	}
+	arena := make([]float64, benchCount*benchDim)
+	benchVectors(func(i int, v []float32) {
+		for j, x := range v {
+			arena[i*benchDim+j] = float64(x)
+		}
+	})
+	query := benchQuery()
+
+	b.ReportMetric(float64(len(arena)*8)/(1<<20), "MB")
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		h := make(resultHeap, 0, 11)
+		for i := 0; i < benchCount; i++ {
+			v := arena[i*benchDim : (i+1)*benchDim]
+			var dot, normA, normB float64
+			for j := range v {
+				dot += float64(query[j]) * v[j]
+				normA += float64(query[j]) * float64(query[j])
+				normB += v[j] * v[j]
+			}
+			h.offer(scored{index: i, score: dot / (math.Sqrt(normA) * math.Sqrt(normB))}, 10)
+		}
+	}
+}
+
+func benchmarkStore(b *testing.B, opts StoreOptions) {
+	if testing.Short() {
+		b.Skip("skipping large benchmark in short mode")
+	}
+	store := NewMemoryStore("bench", opts)
+	store.dim = benchDim
+	store.grow(benchCount)
+	store.ids = make([]string, benchCount)
+	store.namespaces = make([]string, benchCount)
+	store.texts = make([]string, benchCount)
+	store.metadata = make([]map[string]string, benchCount)
+	benchVectors(func(i int, v []float32) {
+		store.ids[i] = fmt.Sprintf("doc-%d", i)
+		store.setVector(i, v)
+	})
+	query := benchQuery()
+	runtime.GC()
+
+	bytes := len(store.vectors)*4 + len(store.codes) + len(store.scales)*4 + len(store.sqNorms)*4
+	b.ReportMetric(float64(bytes)/(1<<20), "MB")
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if results := store.Search("", query, 10, nil); len(results) != 10 {
+			b.Fatalf("got %d results", len(results))
+		}
+	}
+}
+
+func BenchmarkSearch1M_Float32(b *testing.B) {
+	benchmarkStore(b, StoreOptions{Metric: MetricCosine})
+}
+
+func BenchmarkSearch1M_Int8Rescore(b *testing.B) {
+	benchmarkStore(b, StoreOptions{Metric: MetricCosine, Quantize: true, RescoreFactor: 4})
+}
+
+func BenchmarkSearch1M_Int8(b *testing.B) {
+	benchmarkStore(b, StoreOptions{Metric: MetricCosine, Quantize: true})
+}
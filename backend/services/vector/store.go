@@ -0,0 +1,611 @@
+package vector
+
+import (
+	"container/heap"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Record is a vector stored together with its source text and metadata.
+// Records live in a namespace, usually a collection; IDs are unique within a
+// namespace.
+type Record struct {
+	Namespace string            `json:"namespace"`
+	ID        string            `json:"id"`
+	Vector    []float32         `json:"-"`
+	Text      string            `json:"text"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+}
+
+// SearchResult is a record matched by a search, ordered by descending score.
+type SearchResult struct {
+	Namespace string            `json:"namespace"`
+	ID        string            `json:"id"`
+	Text      string            `json:"text"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	Score     float64           `json:"score"`
+	// Component scores of a hybrid search, for debugging
+	VectorScore  float64 `json:"vector_score,omitempty"`
+	KeywordScore float64 `json:"keyword_score,omitempty"`
+	// Scores of the optional re-ranking stages. When they run, results are
+	// ordered by the last stage rather than by Score.
+	MMRScore    float64 `json:"mmr_score,omitempty"`
+	RerankScore float64 `json:"rerank_score,omitempty"`
+}
+
+// StoreOptions controls how a store ranks and holds vectors.
+type StoreOptions struct {
+	// Metric ranks search results; it defaults to cosine.
+	Metric Metric
+	// Quantize keeps an int8 copy of every vector (one byte per dimension
+	// plus a scale) and scans that instead of the float32 vectors.
+	Quantize bool
+	// RescoreFactor applies to quantized stores. When positive, the
+	// k*RescoreFactor best approximate candidates are re-ranked with the
+	// full-precision vectors, which are then kept in memory as well. When
+	// zero, the float32 vectors are dropped and scores are approximate;
+	// memory use falls to about a quarter.
+	RescoreFactor int
+}
+
+// Store holds vectors in contiguous arenas: float32 at full precision and,
+// optionally, int8 codes for quantized scanning. A store opened on a
+// directory persists every change to an append-only write log and
+// periodically compacts the log into a snapshot, so its contents survive
+// restarts.
+type Store struct {
+	mu    sync.RWMutex
+	model string
+	dim   int
+	opts  StoreOptions
+
+	ids        []string
+	namespaces []string
+	texts      []string
+	metadata   []map[string]string
+	vectors    []float32      // nil for quantized stores without rescoring
+	codes      []int8         // int8 codes, only for quantized stores
+	scales     []float32      // per-vector code scale
+	sqNorms    []float32      // squared lengths, for L2 over codes
+	index      map[string]int // namespace/ID key -> slot
+	seq        uint64
+
+	dir         string
+	wal         *walWriter
+	segment     int
+	snapshotSeq uint64
+	snapshotMu  sync.Mutex
+}
+
+// NewMemoryStore creates a store that is not backed by disk.
+func NewMemoryStore(model string, opts StoreOptions) *Store {
+	if opts.Metric == "" {
+		opts.Metric = MetricCosine
+	}
+	return &Store{
+		model: model,
+		opts:  opts,
+		index: make(map[string]int),
+	}
+}
+
+// OpenStore opens or creates a persistent store in dir. The snapshot is
+// loaded first and write log segments newer than it are replayed on top; a
+// torn record at the end of a segment is discarded. Opening a store built
+// with a different embedding model or metric fails, since its vectors are
+// not comparable.
+func OpenStore(dir, model string, opts StoreOptions) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create store directory: %w", err)
+	}
+
+	s := NewMemoryStore(model, opts)
+	s.dir = dir
+
+	if err := s.loadSnapshot(filepath.Join(dir, snapshotFile)); err != nil {
+		return nil, err
+	}
+	s.snapshotSeq = s.seq
+
+	segments, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range segments {
+		if err := s.replaySegment(n); err != nil {
+			return nil, err
+		}
+		s.segment = n
+	}
+	// New writes always go to a fresh segment
+	s.segment++
+
+	return s, nil
+}
+
+// Model returns the embedding model the store's vectors were produced with.
+func (s *Store) Model() string {
+	return s.model
+}
+
+// Metric returns the metric the store ranks by.
+func (s *Store) Metric() Metric {
+	return s.opts.Metric
+}
+
+// Dimension returns the vector dimension, or 0 if the store is still empty.
+func (s *Store) Dimension() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.dim
+}
+
+// Len returns the number of stored records.
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.ids)
+}
+
+// Namespaces returns the number of records in each namespace.
+func (s *Store) Namespaces() map[string]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, ns := range s.namespaces {
+		counts[ns]++
+	}
+	return counts
+}
+
+// Put inserts or replaces a record.
+func (s *Store) Put(rec Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rec.ID == "" {
+		return fmt.Errorf("record ID cannot be empty")
+	}
+	if len(rec.Vector) == 0 {
+		return fmt.Errorf("vector cannot be empty")
+	}
+	if err := checkLengths(rec); err != nil {
+		return err
+	}
+	// The first vector fixes the store's dimension
+	firstPut := s.dim == 0
+	if firstPut {
+		s.dim = len(rec.Vector)
+	}
+	if len(rec.Vector) != s.dim {
+		return fmt.Errorf("vector dimension %d does not match store dimension %d", len(rec.Vector), s.dim)
+	}
+
+	s.seq++
+	if err := s.appendWAL(walEntry{op: opPut, seq: s.seq, record: rec}); err != nil {
+		s.seq--
+		if firstPut {
+			s.dim = 0
+		}
+		return err
+	}
+	s.applyPut(rec)
+	return nil
+}
+
+// checkLengths rejects records whose fields do not fit the 16-bit lengths
+// of the on-disk encoding, rather than letting them be cut short.
+func checkLengths(rec Record) error {
+	if len(rec.Namespace) > maxString16 {
+		return invalidf("namespace is longer than %d bytes", maxString16)
+	}
+	if len(rec.ID) > maxString16 {
+		return invalidf("record ID is longer than %d bytes", maxString16)
+	}
+	if len(rec.Metadata) > maxString16 {
+		return invalidf("record has more than %d metadata fields", maxString16)
+	}
+	for k := range rec.Metadata {
+		if len(k) > maxString16 {
+			return invalidf("metadata key is longer than %d bytes", maxString16)
+		}
+	}
+	return nil
+}
+
+// Delete removes a record, reporting whether it existed.
+func (s *Store) Delete(namespace, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.index[recordKey(namespace, id)]; !exists {
+		return false, nil
+	}
+
+	s.seq++
+	if err := s.appendWAL(walEntry{op: opDelete, seq: s.seq, record: Record{Namespace: namespace, ID: id}}); err != nil {
+		s.seq--
+		return false, err
+	}
+	s.applyDelete(namespace, id)
+	return true, nil
+}
+
+// Get returns the record with the given ID. Vectors of cosine stores are
+// returned normalized, and those of quantized stores without rescoring are
+// reconstructed from their codes.
+func (s *Store) Get(namespace, id string) (Record, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i, exists := s.index[recordKey(namespace, id)]
+	if !exists {
+		return Record{}, false
+	}
+	return s.record(i), true
+}
+
+// Scan calls fn for every record. The store is read-locked while it runs,
+// and rec.Vector is only valid until fn returns.
+func (s *Store) Scan(fn func(rec Record)) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	buf := make([]float32, s.dim)
+	for i := range s.ids {
+		fn(Record{
+			Namespace: s.namespaces[i],
+			ID:        s.ids[i],
+			Vector:    s.vector(i, buf),
+			Text:      s.texts[i],
+			Metadata:  s.metadata[i],
+		})
+	}
+}
+
+// List returns the records in namespace without their vectors.
+func (s *Store) List(namespace string) []Record {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var records []Record
+	for i := range s.ids {
+		if s.namespaces[i] == namespace {
+			records = append(records, Record{
+				Namespace: namespace,
+				ID:        s.ids[i],
+				Text:      s.texts[i],
+				Metadata:  s.metadata[i],
+			})
+		}
+	}
+	return records
+}
+
+// Search returns the k records in namespace most similar to query under the
+// store's metric. Records whose metadata does not match filter are skipped
+// during the scan, so up to k matching records are returned.
+func (s *Store) Search(namespace string, query []float32, k int, filter *Filter) []SearchResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if k <= 0 || len(query) != s.dim || len(s.ids) == 0 {
+		return nil
+	}
+	query = append([]float32(nil), query...)
+	if s.opts.Metric == MetricCosine {
+		if Norm(query) == 0 {
+			return nil
+		}
+		Normalize(query)
+	}
+
+	accept := func(i int) bool {
+		return s.namespaces[i] == namespace && filter.Match(s.metadata[i])
+	}
+
+	var top []scored
+	if !s.opts.Quantize {
+		top = s.scanExact(query, k, accept)
+	} else if !s.keepsVectors() {
+		top = s.scanQuantized(query, k, accept)
+	} else {
+		// Over-fetch with the cheap int8 scan, then re-rank the candidates
+		// at full precision.
+		candidates := s.scanQuantized(query, k*s.opts.RescoreFactor, accept)
+		h := make(resultHeap, 0, k+1)
+		for _, c := range candidates {
+			h.offer(scored{index: c.index, score: s.opts.Metric.score(query, s.vectors[c.index*s.dim:(c.index+1)*s.dim])}, k)
+		}
+		top = h.sorted()
+	}
+
+	results := make([]SearchResult, len(top))
+	for i, t := range top {
+		results[i] = SearchResult{
+			Namespace: namespace,
+			ID:        s.ids[t.index],
+			Text:      s.texts[t.index],
+			Metadata:  s.metadata[t.index],
+			Score:     t.score,
+		}
+	}
+	return results
+}
+
+// scanExact scores every accepted vector at full precision.
+func (s *Store) scanExact(query []float32, k int, accept func(int) bool) []scored {
+	h := make(resultHeap, 0, k+1)
+	for i := range s.ids {
+		if !accept(i) {
+			continue
+		}
+		h.offer(scored{index: i, score: s.opts.Metric.score(query, s.vectors[i*s.dim:(i+1)*s.dim])}, k)
+	}
+	return h.sorted()
+}
+
+// scanQuantized scores every accepted vector from its int8 codes. The query
+// is quantized the same way, so each comparison is an integer dot product.
+func (s *Store) scanQuantized(query []float32, k int, accept func(int) bool) []scored {
+	queryCodes := make([]int8, s.dim)
+	queryScale := quantize(query, queryCodes)
+	querySqNorm := float64(Dot(query, query))
+
+	h := make(resultHeap, 0, k+1)
+	for i := range s.ids {
+		if !accept(i) {
+			continue
+		}
+		dot := float64(dotInt8(queryCodes, s.codes[i*s.dim:(i+1)*s.dim])) * float64(queryScale) * float64(s.scales[i])
+		score := dot
+		if s.opts.Metric == MetricL2 {
+			score = -math.Sqrt(max(0, querySqNorm+float64(s.sqNorms[i])-2*dot))
+		}
+		h.offer(scored{index: i, score: score}, k)
+	}
+	return h.sorted()
+}
+
+// Snapshot compacts the store into a new snapshot and removes the write log
+// segments it covers. Searches continue while the snapshot is written; writes
+// wait for it to finish.
+func (s *Store) Snapshot() error {
+	if s.dir == "" {
+		return nil
+	}
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+
+	// Seal the active segment so everything written so far is covered by the
+	// snapshot and later writes land in a new segment.
+	s.mu.Lock()
+	if s.seq == s.snapshotSeq {
+		s.mu.Unlock()
+		return nil
+	}
+	sealed := s.segment
+	if err := s.closeWAL(); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	s.segment++
+	s.mu.Unlock()
+
+	s.mu.RLock()
+	seq := s.seq
+	err := s.writeSnapshot(filepath.Join(s.dir, snapshotFile))
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.snapshotSeq = seq
+	s.mu.Unlock()
+
+	segments, err := listSegments(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, n := range segments {
+		if n <= sealed {
+			if err := os.Remove(segmentPath(s.dir, n)); err != nil {
+				return fmt.Errorf("failed to remove write log segment: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// StartSnapshotLoop starts a background goroutine that snapshots the store
+// every interval.
+func (s *Store) StartSnapshotLoop(interval time.Duration) {
+	if s.dir == "" {
+		return
+	}
+	go func() {
+		for {
+			time.Sleep(interval)
+			if err := s.Snapshot(); err != nil {
+				log.Printf("[VectorStore] Snapshot failed: %v", err)
+			}
+		}
+	}()
+}
+
+// Close writes a final snapshot and releases the write log.
+func (s *Store) Close() error {
+	if s.dir == "" {
+		return nil
+	}
+	if err := s.Snapshot(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closeWAL()
+}
+
+// applyPut updates the in-memory state. Callers must hold the write lock.
+func (s *Store) applyPut(rec Record) {
+	key := recordKey(rec.Namespace, rec.ID)
+	i, exists := s.index[key]
+	if !exists {
+		i = len(s.ids)
+		s.index[key] = i
+		s.ids = append(s.ids, rec.ID)
+		s.namespaces = append(s.namespaces, rec.Namespace)
+		s.texts = append(s.texts, "")
+		s.metadata = append(s.metadata, nil)
+		s.grow(1)
+	}
+	s.texts[i] = rec.Text
+	s.metadata[i] = rec.Metadata
+	s.setVector(i, rec.Vector)
+}
+
+// grow extends the vector arenas by n slots. Callers must hold the write
+// lock.
+func (s *Store) grow(n int) {
+	if s.keepsVectors() {
+		s.vectors = append(s.vectors, make([]float32, n*s.dim)...)
+	}
+	if s.opts.Quantize {
+		s.codes = append(s.codes, make([]int8, n*s.dim)...)
+		s.scales = append(s.scales, make([]float32, n)...)
+		s.sqNorms = append(s.sqNorms, make([]float32, n)...)
+	}
+}
+
+// setVector writes vec into slot i of every arena, normalizing it first for
+// cosine stores. Callers must hold the write lock.
+func (s *Store) setVector(i int, vec []float32) {
+	v := append([]float32(nil), vec[:s.dim]...)
+	if s.opts.Metric == MetricCosine {
+		Normalize(v)
+	}
+	if s.keepsVectors() {
+		copy(s.vectors[i*s.dim:(i+1)*s.dim], v)
+	}
+	if s.opts.Quantize {
+		s.scales[i] = quantize(v, s.codes[i*s.dim:(i+1)*s.dim])
+		s.sqNorms[i] = Dot(v, v)
+	}
+}
+
+// keepsVectors reports whether full-precision vectors are held in memory.
+func (s *Store) keepsVectors() bool {
+	return !s.opts.Quantize || s.opts.RescoreFactor > 0
+}
+
+// vector returns the vector in slot i, reconstructing it into buf if only
+// codes are kept. Callers must hold at least the read lock.
+func (s *Store) vector(i int, buf []float32) []float32 {
+	if s.keepsVectors() {
+		return s.vectors[i*s.dim : (i+1)*s.dim : (i+1)*s.dim]
+	}
+	dequantize(s.codes[i*s.dim:(i+1)*s.dim], s.scales[i], buf)
+	return buf
+}
+
+// applyDelete removes a record by moving the last record into its slot.
+// Callers must hold the write lock.
+func (s *Store) applyDelete(namespace, id string) {
+	key := recordKey(namespace, id)
+	i, exists := s.index[key]
+	if !exists {
+		return
+	}
+	last := len(s.ids) - 1
+	if i != last {
+		s.ids[i] = s.ids[last]
+		s.namespaces[i] = s.namespaces[last]
+		s.texts[i] = s.texts[last]
+		s.metadata[i] = s.metadata[last]
+		if s.keepsVectors() {
+			copy(s.vectors[i*s.dim:(i+1)*s.dim], s.vectors[last*s.dim:])
+		}
+		if s.opts.Quantize {
+			copy(s.codes[i*s.dim:(i+1)*s.dim], s.codes[last*s.dim:])
+			s.scales[i] = s.scales[last]
+			s.sqNorms[i] = s.sqNorms[last]
+		}
+		s.index[recordKey(s.namespaces[i], s.ids[i])] = i
+	}
+
+	delete(s.index, key)
+	s.ids = s.ids[:last]
+	s.namespaces = s.namespaces[:last]
+	s.texts = s.texts[:last]
+	s.metadata = s.metadata[:last]
+	if s.keepsVectors() {
+		s.vectors = s.vectors[:last*s.dim]
+	}
+	if s.opts.Quantize {
+		s.codes = s.codes[:last*s.dim]
+		s.scales = s.scales[:last]
+		s.sqNorms = s.sqNorms[:last]
+	}
+}
+
+func (s *Store) record(i int) Record {
+	return Record{
+		Namespace: s.namespaces[i],
+		ID:        s.ids[i],
+		Vector:    append([]float32(nil), s.vector(i, make([]float32, s.dim))...),
+		Text:      s.texts[i],
+		Metadata:  s.metadata[i],
+	}
+}
+
+// recordKey identifies a record across namespaces. IDs may contain any
+// character, so the separator is one that cannot appear in a namespace.
+func recordKey(namespace, id string) string {
+	return namespace + "\x00" + id
+}
+
+type scored struct {
+	index int
+	score float64
+}
+
+// resultHeap is a min-heap on score used to keep the current top k.
+type resultHeap []scored
+
+func (h resultHeap) Len() int            { return len(h) }
+func (h resultHeap) Less(i, j int) bool  { return h[i].score < h[j].score }
+func (h resultHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *resultHeap) Push(x interface{}) { *h = append(*h, x.(scored)) }
+func (h *resultHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// offer adds c if it is among the best k seen so far.
+func (h *resultHeap) offer(c scored, k int) {
+	if len(*h) < k {
+		heap.Push(h, c)
+	} else if k > 0 && c.score > (*h)[0].score {
+		(*h)[0] = c
+		heap.Fix(h, 0)
+	}
+}
+
+// sorted empties the heap and returns its entries by descending score.
+func (h *resultHeap) sorted() []scored {
+	out := make([]scored, len(*h))
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = heap.Pop(h).(scored)
+	}
+	return out
+}
@@ -0,0 +1,573 @@
+package vector
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// On-disk layout of a persistent store directory:
+//
+//	snapshot.vec      compacted state as of some sequence number
+//	wal-NNNNNNNN.log  append-only write log segments, replayed in order
+//
+// Both file kinds start with a versioned header recording the embedding model,
+// vector dimension and metric. All integers are little endian.
+//
+// Snapshot: header, seq u64, count u64, count records (namespace, id, text,
+// metadata), then count*dim float32 values, then a CRC32 of everything
+// before it.
+//
+// Write log record: length u32, CRC32 u32, then a body of op u8, seq u64,
+// namespace, id and, for puts, text, metadata and dim float32 values.
+//
+// Version 1 files predate namespaces; their records load into the default
+// namespace. Versions 1 and 2 predate metrics and are read as cosine.
+const (
+	snapshotMagic      = "CSVS"
+	walMagic           = "CSVW"
+	storeVersion       = 3
+	snapshotFile       = "snapshot.vec"
+	maxWALRecord       = 64 << 20
+	ioBufferSize       = 1 << 20
+	maxString16        = math.MaxUint16
+	opPut         byte = 1
+	opDelete      byte = 2
+)
+
+type walEntry struct {
+	op     byte
+	seq    uint64
+	record Record
+}
+
+type walWriter struct {
+	file *os.File
+	buf  *bufio.Writer
+	// size is the length of the segment up to the last complete record
+	size int64
+}
+
+func segmentPath(dir string, n int) string {
+	return filepath.Join(dir, fmt.Sprintf("wal-%08d.log", n))
+}
+
+// listSegments returns the write log segment numbers in dir in ascending order.
+func listSegments(dir string) ([]int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read store directory: %w", err)
+	}
+
+	var segments []int
+	for _, e := range entries {
+		var n int
+		if _, err := fmt.Sscanf(e.Name(), "wal-%08d.log", &n); err == nil && strings.HasSuffix(e.Name(), ".log") {
+			segments = append(segments, n)
+		}
+	}
+	sort.Ints(segments)
+	return segments, nil
+}
+
+// appendWAL writes an entry to the active segment, creating it on first use.
+// Entries are flushed and synced to disk before returning, so they survive a
+// process crash or power loss. If the write fails, the partial record is cut
+// off and the segment sealed, so later writes start a fresh one. Callers must
+// hold the write lock.
+func (s *Store) appendWAL(e walEntry) error {
+	if s.dir == "" {
+		return nil
+	}
+
+	body := []byte{e.op}
+	body = binary.LittleEndian.AppendUint64(body, e.seq)
+	body = appendString16(body, e.record.Namespace)
+	body = appendString16(body, e.record.ID)
+	if e.op == opPut {
+		body = appendPayload(body, e.record)
+		body = appendVector(body, e.record.Vector)
+	}
+	if len(body) > maxWALRecord {
+		return invalidf("record of %d bytes exceeds the %d byte limit", len(body), maxWALRecord)
+	}
+
+	var record []byte
+	if s.wal == nil {
+		f, err := os.OpenFile(segmentPath(s.dir, s.segment), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to open write log: %w", err)
+		}
+		s.wal = &walWriter{file: f, buf: bufio.NewWriterSize(f, 64<<10)}
+		record = encodeHeader(walMagic, s.model, s.dim, s.opts.Metric)
+	}
+	record = binary.LittleEndian.AppendUint32(record, uint32(len(body)))
+	record = binary.LittleEndian.AppendUint32(record, crc32.ChecksumIEEE(body))
+	record = append(record, body...)
+
+	if _, err := s.wal.buf.Write(record); err != nil {
+		s.abandonWAL()
+		return fmt.Errorf("failed to write log record: %w", err)
+	}
+	if err := s.wal.buf.Flush(); err != nil {
+		s.abandonWAL()
+		return fmt.Errorf("failed to flush write log: %w", err)
+	}
+	if err := s.wal.file.Sync(); err != nil {
+		s.abandonWAL()
+		return fmt.Errorf("failed to sync write log: %w", err)
+	}
+	s.wal.size += int64(len(record))
+	return nil
+}
+
+// abandonWAL truncates the active segment back to its last complete record
+// and seals it, so a failed write neither leaves a torn record in front of
+// later ones nor poisons the buffered writer. Callers must hold the write
+// lock.
+func (s *Store) abandonWAL() {
+	w := s.wal
+	s.wal = nil
+	w.file.Close()
+
+	path := segmentPath(s.dir, s.segment)
+	if err := os.Truncate(path, w.size); err != nil {
+		log.Printf("[VectorStore] Failed to truncate %s after a failed write: %v", filepath.Base(path), err)
+	}
+	s.segment++
+}
+
+// closeWAL syncs and closes the active segment. Callers must hold the write
+// lock.
+func (s *Store) closeWAL() error {
+	if s.wal == nil {
+		return nil
+	}
+	w := s.wal
+	s.wal = nil
+
+	if err := w.buf.Flush(); err != nil {
+		w.file.Close()
+		return fmt.Errorf("failed to flush write log: %w", err)
+	}
+	if err := w.file.Sync(); err != nil {
+		w.file.Close()
+		return fmt.Errorf("failed to sync write log: %w", err)
+	}
+	return w.file.Close()
+}
+
+// replaySegment applies the entries of segment n that are newer than the
+// loaded state. A truncated or corrupt tail is cut off so later appends
+// start from the last good record.
+func (s *Store) replaySegment(n int) error {
+	path := segmentPath(s.dir, n)
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open write log: %w", err)
+	}
+	defer f.Close()
+
+	r := &binReader{r: bufio.NewReaderSize(f, ioBufferSize)}
+	version, model, dim, metric, err := readHeader(r, walMagic)
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			// Crashed before the header was written
+			return f.Truncate(0)
+		}
+		return fmt.Errorf("write log %s: %w", filepath.Base(path), err)
+	}
+	if err := s.checkHeader(model, dim, metric); err != nil {
+		return err
+	}
+
+	offset := r.n
+	for {
+		e, err := readWALEntry(r, version, s.dim)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Printf("[VectorStore] Discarding torn record in %s at offset %d: %v", filepath.Base(path), offset, err)
+				if err := f.Truncate(offset); err != nil {
+					return fmt.Errorf("failed to truncate write log: %w", err)
+				}
+			}
+			return nil
+		}
+		offset = r.n
+
+		if e.seq <= s.seq {
+			continue
+		}
+		s.seq = e.seq
+		switch e.op {
+		case opPut:
+			s.applyPut(e.record)
+		case opDelete:
+			s.applyDelete(e.record.Namespace, e.record.ID)
+		}
+	}
+}
+
+func readWALEntry(r *binReader, version uint16, dim int) (walEntry, error) {
+	var e walEntry
+
+	var frame [8]byte
+	if _, err := io.ReadFull(r, frame[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return e, fmt.Errorf("truncated frame")
+		}
+		return e, err
+	}
+	size := binary.LittleEndian.Uint32(frame[:4])
+	sum := binary.LittleEndian.Uint32(frame[4:])
+	if size == 0 || size > maxWALRecord {
+		return e, fmt.Errorf("invalid record length %d", size)
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return e, fmt.Errorf("truncated record")
+	}
+	if crc32.ChecksumIEEE(body) != sum {
+		return e, fmt.Errorf("checksum mismatch")
+	}
+
+	br := &binReader{r: bytes.NewReader(body)}
+	e.op = br.u8()
+	e.seq = br.u64()
+	if version >= 2 {
+		e.record.Namespace = br.string16()
+	}
+	e.record.ID = br.string16()
+	if e.op == opPut {
+		readPayload(br, &e.record)
+		e.record.Vector = make([]float32, dim)
+		br.float32s(e.record.Vector)
+	}
+	if br.err != nil {
+		return e, fmt.Errorf("malformed record: %w", br.err)
+	}
+	if e.op != opPut && e.op != opDelete {
+		return e, fmt.Errorf("unknown op %d", e.op)
+	}
+	return e, nil
+}
+
+// writeSnapshot writes the current state to a temporary file, syncs it and
+// renames it over path, so a crash leaves either the old or the new snapshot.
+// Callers must hold at least the read lock.
+func (s *Store) writeSnapshot(path string) error {
+	tmp, err := os.CreateTemp(s.dir, snapshotFile+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	crc := crc32.NewIEEE()
+	w := bufio.NewWriterSize(io.MultiWriter(tmp, crc), ioBufferSize)
+
+	buf := encodeHeader(snapshotMagic, s.model, s.dim, s.opts.Metric)
+	buf = binary.LittleEndian.AppendUint64(buf, s.seq)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(len(s.ids)))
+	if _, err := w.Write(buf); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	for i := range s.ids {
+		buf = appendString16(buf[:0], s.namespaces[i])
+		buf = appendString16(buf, s.ids[i])
+		buf = appendPayload(buf, Record{Text: s.texts[i], Metadata: s.metadata[i]})
+		if _, err := w.Write(buf); err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to write snapshot: %w", err)
+		}
+	}
+	vec := make([]float32, s.dim)
+	for i := range s.ids {
+		buf = appendVector(buf[:0], s.vector(i, vec))
+		if _, err := w.Write(buf); err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to write snapshot: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if _, err := tmp.Write(binary.LittleEndian.AppendUint32(nil, crc.Sum32())); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to install snapshot: %w", err)
+	}
+	return syncDir(s.dir)
+}
+
+// loadSnapshot reads the snapshot at path, if there is one.
+func (s *Store) loadSnapshot(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer f.Close()
+
+	// Everything before the trailer is fed through the checksum
+	crc := crc32.NewIEEE()
+	buffered := bufio.NewReaderSize(f, ioBufferSize)
+	r := &binReader{r: io.TeeReader(buffered, crc)}
+
+	version, model, dim, metric, err := readHeader(r, snapshotMagic)
+	if err != nil {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+	if err := s.checkHeader(model, dim, metric); err != nil {
+		return err
+	}
+
+	seq := r.u64()
+	count := r.u64()
+	if r.err != nil {
+		return fmt.Errorf("snapshot: %w", r.err)
+	}
+
+	s.ids = make([]string, count)
+	s.namespaces = make([]string, count)
+	s.texts = make([]string, count)
+	s.metadata = make([]map[string]string, count)
+	s.index = make(map[string]int, count)
+	for i := range s.ids {
+		var rec Record
+		if version >= 2 {
+			rec.Namespace = r.string16()
+		}
+		rec.ID = r.string16()
+		readPayload(r, &rec)
+		s.ids[i] = rec.ID
+		s.namespaces[i] = rec.Namespace
+		s.texts[i] = rec.Text
+		s.metadata[i] = rec.Metadata
+		s.index[recordKey(rec.Namespace, rec.ID)] = i
+	}
+
+	// Vectors are read in batches and passed through setVector, which
+	// normalizes and quantizes them as the store's options require.
+	s.grow(int(count))
+	batch := make([]float32, max(1, ioBufferSize/4/max(s.dim, 1))*s.dim)
+	for start := 0; start < int(count) && r.err == nil; {
+		n := min(int(count)-start, len(batch)/max(s.dim, 1))
+		r.float32s(batch[:n*s.dim])
+		for j := 0; j < n; j++ {
+			s.setVector(start+j, batch[j*s.dim:(j+1)*s.dim])
+		}
+		start += n
+	}
+	if r.err != nil {
+		return fmt.Errorf("snapshot: %w", r.err)
+	}
+
+	var trailer [4]byte
+	if _, err := io.ReadFull(buffered, trailer[:]); err != nil {
+		return fmt.Errorf("snapshot: missing checksum: %w", err)
+	}
+	if binary.LittleEndian.Uint32(trailer[:]) != crc.Sum32() {
+		return fmt.Errorf("snapshot: checksum mismatch")
+	}
+
+	s.seq = seq
+	return nil
+}
+
+// checkHeader validates a file header against the store, adopting the
+// dimension if the store does not have one yet.
+func (s *Store) checkHeader(model string, dim int, metric Metric) error {
+	if s.model != "" && model != s.model {
+		return fmt.Errorf("store was built with embedding model %q, not %q", model, s.model)
+	}
+	if metric != s.opts.Metric {
+		return fmt.Errorf("store was built with metric %q, not %q", metric, s.opts.Metric)
+	}
+	s.model = model
+	if s.dim != 0 && dim != 0 && dim != s.dim {
+		return fmt.Errorf("store dimension mismatch: %d vs %d", dim, s.dim)
+	}
+	if s.dim == 0 {
+		s.dim = dim
+	}
+	return nil
+}
+
+func encodeHeader(magic, model string, dim int, metric Metric) []byte {
+	buf := []byte(magic)
+	buf = binary.LittleEndian.AppendUint16(buf, storeVersion)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(dim))
+	buf = appendString16(buf, model)
+	return appendString16(buf, string(metric))
+}
+
+func readHeader(r *binReader, magic string) (version uint16, model string, dim int, metric Metric, err error) {
+	var m [4]byte
+	if _, err := io.ReadFull(r, m[:]); err != nil {
+		return 0, "", 0, "", err
+	}
+	if string(m[:]) != magic {
+		return 0, "", 0, "", fmt.Errorf("bad magic %q", m[:])
+	}
+	version = r.u16()
+	dim = int(r.u32())
+	model = r.string16()
+	metric = MetricCosine
+	if version >= 3 {
+		metric = Metric(r.string16())
+	}
+	if r.err != nil {
+		return 0, "", 0, "", r.err
+	}
+	if version < 1 || version > storeVersion {
+		return 0, "", 0, "", fmt.Errorf("unsupported format version %d", version)
+	}
+	return version, model, dim, metric, nil
+}
+
+// appendString16 encodes a string of at most maxString16 bytes; Put rejects
+// records with longer fields.
+func appendString16(buf []byte, s string) []byte {
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(len(s)))
+	return append(buf, s...)
+}
+
+func appendString32(buf []byte, s string) []byte {
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(s)))
+	return append(buf, s...)
+}
+
+// appendPayload encodes a record's text and metadata.
+func appendPayload(buf []byte, rec Record) []byte {
+	buf = appendString32(buf, rec.Text)
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(len(rec.Metadata)))
+	keys := make([]string, 0, len(rec.Metadata))
+	for k := range rec.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		buf = appendString16(buf, k)
+		buf = appendString32(buf, rec.Metadata[k])
+	}
+	return buf
+}
+
+func readPayload(r *binReader, rec *Record) {
+	rec.Text = r.string32()
+	n := int(r.u16())
+	if n > 0 {
+		rec.Metadata = make(map[string]string, n)
+	}
+	for i := 0; i < n && r.err == nil; i++ {
+		k := r.string16()
+		rec.Metadata[k] = r.string32()
+	}
+}
+
+func appendVector(buf []byte, v []float32) []byte {
+	for _, x := range v {
+		buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(x))
+	}
+	return buf
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// binReader decodes little-endian values, remembering the first error so
+// callers can check once after a sequence of reads.
+type binReader struct {
+	r   io.Reader
+	n   int64
+	buf [8]byte
+	err error
+}
+
+func (b *binReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+func (b *binReader) fill(n int) []byte {
+	if b.err != nil {
+		return b.buf[:n]
+	}
+	if _, err := io.ReadFull(b, b.buf[:n]); err != nil {
+		b.err = err
+	}
+	return b.buf[:n]
+}
+
+func (b *binReader) u8() byte    { return b.fill(1)[0] }
+func (b *binReader) u16() uint16 { return binary.LittleEndian.Uint16(b.fill(2)) }
+func (b *binReader) u32() uint32 { return binary.LittleEndian.Uint32(b.fill(4)) }
+func (b *binReader) u64() uint64 { return binary.LittleEndian.Uint64(b.fill(8)) }
+func (b *binReader) string16() string {
+	return b.bytes(int(b.u16()))
+}
+func (b *binReader) string32() string {
+	return b.bytes(int(b.u32()))
+}
+
+func (b *binReader) bytes(n int) string {
+	if b.err != nil || n == 0 {
+		return ""
+	}
+	p := make([]byte, n)
+	if _, err := io.ReadFull(b, p); err != nil {
+		b.err = err
+		return ""
+	}
+	return string(p)
+}
+
+// float32s fills dst, reading in large chunks to keep loading fast.
+func (b *binReader) float32s(dst []float32) {
+	if b.err != nil {
+		return
+	}
+	chunk := make([]byte, min(len(dst)*4, ioBufferSize))
+	for len(dst) > 0 {
+		n := min(len(dst)*4, len(chunk))
+		if _, err := io.ReadFull(b, chunk[:n]); err != nil {
+			b.err = err
+			return
+		}
+		for i := 0; i < n/4; i++ {
+			dst[i] = math.Float32frombits(binary.LittleEndian.Uint32(chunk[i*4:]))
+		}
+		dst = dst[n/4:]
+	}
+}
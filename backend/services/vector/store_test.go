@@ -0,0 +1,221 @@
+package vector
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_PutSearch(t *testing.T) {
+	store := NewMemoryStore("test", StoreOptions{})
+
+	require.NoError(t, store.Put(Record{ID: "x", Vector: []float32{1, 0}, Text: "x axis"}))
+	require.NoError(t, store.Put(Record{ID: "y", Vector: []float32{0, 1}, Text: "y axis"}))
+	require.NoError(t, store.Put(Record{ID: "xy", Vector: []float32{1, 1}, Text: "diagonal"}))
+
+	results := store.Search("", []float32{2, 0.1}, 2, nil)
+	require.Len(t, results, 2)
+	assert.Equal(t, "x", results[0].ID)
+	assert.Equal(t, "xy", results[1].ID)
+	assert.InDelta(t, 0.9988, results[0].Score, 1e-3)
+
+	err := store.Put(Record{ID: "bad", Vector: []float32{1, 2, 3}})
+	assert.Error(t, err)
+}
+
+func TestStore_Delete(t *testing.T) {
+	store := NewMemoryStore("test", StoreOptions{})
+	require.NoError(t, store.Put(Record{ID: "a", Vector: []float32{1, 0}}))
+	require.NoError(t, store.Put(Record{ID: "b", Vector: []float32{0, 1}}))
+
+	existed, err := store.Delete("", "a")
+	require.NoError(t, err)
+	assert.True(t, existed)
+	assert.Equal(t, 1, store.Len())
+
+	rec, ok := store.Get("", "b")
+	require.True(t, ok)
+	assert.Equal(t, []float32{0, 1}, rec.Vector)
+
+	existed, err = store.Delete("", "a")
+	require.NoError(t, err)
+	assert.False(t, existed)
+}
+
+func TestStore_RecoversFromWriteLog(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := OpenStore(dir, "test", StoreOptions{Metric: MetricDot})
+	require.NoError(t, err)
+	require.NoError(t, store.Put(Record{ID: "a", Vector: []float32{1, 2}, Text: "alpha", Metadata: map[string]string{"lang": "en"}}))
+	require.NoError(t, store.Put(Record{ID: "b", Vector: []float32{3, 4}, Text: "beta"}))
+	_, err = store.Delete("", "b")
+	require.NoError(t, err)
+	// Simulate a crash: no Close, so nothing but the write log exists.
+	// Dot product stores keep vectors unnormalized, so they round-trip exactly.
+
+	reopened, err := OpenStore(dir, "test", StoreOptions{Metric: MetricDot})
+	require.NoError(t, err)
+	assert.Equal(t, 1, reopened.Len())
+	assert.Equal(t, 2, reopened.Dimension())
+	rec, ok := reopened.Get("", "a")
+	require.True(t, ok)
+	assert.Equal(t, "alpha", rec.Text)
+	assert.Equal(t, map[string]string{"lang": "en"}, rec.Metadata)
+	assert.Equal(t, []float32{1, 2}, rec.Vector)
+}
+
+func TestStore_SnapshotAndReplay(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := OpenStore(dir, "test", StoreOptions{})
+	require.NoError(t, err)
+	require.NoError(t, store.Put(Record{ID: "a", Vector: []float32{1, 0}}))
+	require.NoError(t, store.Snapshot())
+
+	segments, err := listSegments(dir)
+	require.NoError(t, err)
+	assert.Empty(t, segments)
+
+	// Writes after the snapshot go to a new segment and are replayed on top
+	require.NoError(t, store.Put(Record{ID: "b", Vector: []float32{0, 1}}))
+
+	reopened, err := OpenStore(dir, "test", StoreOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, reopened.Len())
+	require.NoError(t, reopened.Close())
+
+	again, err := OpenStore(dir, "test", StoreOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, again.Len())
+}
+
+func TestStore_DiscardsTornRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := OpenStore(dir, "test", StoreOptions{})
+	require.NoError(t, err)
+	require.NoError(t, store.Put(Record{ID: "a", Vector: []float32{1, 0}}))
+	require.NoError(t, store.Put(Record{ID: "b", Vector: []float32{0, 1}}))
+
+	// Chop the last record in half as if the process died mid-write
+	path := segmentPath(dir, 1)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(path, info.Size()-5))
+
+	reopened, err := OpenStore(dir, "test", StoreOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, reopened.Len())
+	_, ok := reopened.Get("", "a")
+	assert.True(t, ok)
+
+	// The store keeps working after recovery
+	require.NoError(t, reopened.Put(Record{ID: "c", Vector: []float32{1, 1}}))
+	require.NoError(t, reopened.Close())
+	final, err := OpenStore(dir, "test", StoreOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, final.Len())
+}
+
+func TestStore_RejectsCorruptSnapshot(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := OpenStore(dir, "test", StoreOptions{})
+	require.NoError(t, err)
+	require.NoError(t, store.Put(Record{ID: "a", Vector: []float32{1, 0}}))
+	require.NoError(t, store.Close())
+
+	path := filepath.Join(dir, snapshotFile)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	data[len(data)-6] ^= 0xff
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+
+	_, err = OpenStore(dir, "test", StoreOptions{})
+	assert.Error(t, err)
+}
+
+func TestStore_ModelMismatch(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := OpenStore(dir, "model-a", StoreOptions{})
+	require.NoError(t, err)
+	require.NoError(t, store.Put(Record{ID: "a", Vector: []float32{1, 0}}))
+	require.NoError(t, store.Close())
+
+	_, err = OpenStore(dir, "model-b", StoreOptions{})
+	assert.Error(t, err)
+}
+
+// BenchmarkStore_Open measures loading a snapshot of one million vectors.
+func BenchmarkStore_Open(b *testing.B) {
+	const count, dim = 1_000_000, 64
+	if testing.Short() {
+		b.Skip("skipping large benchmark in short mode")
+	}
+
+	dir := b.TempDir()
+	store, err := OpenStore(dir, "bench", StoreOptions{})
+	require.NoError(b, err)
+
+	// Fill the arena directly; going through the write log would only slow
+	// down the setup.
+	rng := rand.New(rand.NewSource(1))
+	store.dim = dim
+	for i := 0; i < count; i++ {
+		vec := make([]float32, dim)
+		for j := range vec {
+			vec[j] = rng.Float32()
+		}
+		store.applyPut(Record{ID: fmt.Sprintf("doc-%d", i), Vector: vec})
+	}
+	store.seq = count
+	require.NoError(b, store.Close())
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		loaded, err := OpenStore(dir, "bench", StoreOptions{})
+		require.NoError(b, err)
+		require.Equal(b, count, loaded.Len())
+	}
+}
+
+func TestStore_RecoversFromFailedWrite(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := OpenStore(dir, "test", StoreOptions{})
+	require.NoError(t, err)
+	require.NoError(t, store.Put(Record{ID: "a", Vector: []float32{1, 0}}))
+
+	// Pull the file out from under the write log so the next write fails
+	require.NoError(t, store.wal.file.Close())
+	assert.Error(t, store.Put(Record{ID: "b", Vector: []float32{0, 1}}))
+
+	// The failure is not sticky: later writes go to a fresh segment
+	require.NoError(t, store.Put(Record{ID: "c", Vector: []float32{1, 1}}))
+
+	reopened, err := OpenStore(dir, "test", StoreOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, reopened.Len())
+	_, ok := reopened.Get("", "c")
+	assert.True(t, ok)
+}
+
+func TestStore_RejectsOversizedFields(t *testing.T) {
+	store, err := OpenStore(t.TempDir(), "test", StoreOptions{})
+	require.NoError(t, err)
+
+	long := strings.Repeat("k", maxString16+1)
+	err = store.Put(Record{ID: "a", Vector: []float32{1, 0}, Metadata: map[string]string{long: "v"}})
+	assert.ErrorIs(t, err, ErrInvalid)
+	err = store.Put(Record{ID: long, Vector: []float32{1, 0}})
+	assert.ErrorIs(t, err, ErrInvalid)
+	assert.Equal(t, 0, store.Len())
+}
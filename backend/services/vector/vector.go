@@ -1,108 +1,393 @@
 package vector
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
-	"net/http"
+	"log"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
+const defaultEmbeddingCacheSize = 10000
+
 type Service struct {
-	apiKey     string
-	apiURL     string
-	httpClient *http.Client
+	embedder Embedder
+	cache    *EmbeddingCache
+	store    *Store
+	keywords *KeywordIndex
+	fusionMu sync.RWMutex
+	fusion   FusionConfig
+
+	completer     Completer
+	rerankMu      sync.RWMutex
+	rerankDefault RerankConfig
+	rerankConfigs map[string]RerankConfig // per namespace overrides
+
+	collectionsMu sync.RWMutex
+	collections   map[string]*Collection
+	// documentsMu serializes document writes, so concurrent ingestion of the
+	// same document cannot interleave chunks
+	documentsMu  sync.Mutex
+	chunkSize    int
+	chunkOverlap int
 }
 
-type EmbeddingRequest struct {
-	Model string   `json:"model"`
-	Input []string `json:"input"`
+// NewService creates a vector service configured from the environment.
+// EMBEDDING_PROVIDER selects the embedder: "deepseek" (default) or "local"
+// for the offline hash embedder.
+func NewService() *Service {
+	var embedder Embedder
+	switch provider := os.Getenv("EMBEDDING_PROVIDER"); provider {
+	case "local":
+		dim, _ := strconv.Atoi(os.Getenv("EMBEDDING_DIMENSION"))
+		embedder = NewHashEmbedder(dim)
+	case "", "deepseek":
+		embedder = NewDeepSeekEmbedder(os.Getenv("DEEPSEEK_API_KEY"))
+	default:
+		log.Printf("Unknown EMBEDDING_PROVIDER %q, using deepseek", provider)
+		embedder = NewDeepSeekEmbedder(os.Getenv("DEEPSEEK_API_KEY"))
+	}
+
+	return NewServiceWithEmbedder(embedder)
 }
 
-type EmbeddingResponse struct {
-	Data []struct {
-		Embedding []float64 `json:"embedding"`
-		Index     int       `json:"index"`
-	} `json:"data"`
+// NewServiceWithEmbedder creates a vector service around embedder. The cache
+// and store are still configured from the environment.
+func NewServiceWithEmbedder(embedder Embedder) *Service {
+	s := &Service{
+		embedder: embedder,
+		keywords: NewKeywordIndex(),
+		fusion:   fusionConfigFromEnv(),
+
+		rerankDefault: rerankConfigFromEnv(),
+		rerankConfigs: make(map[string]RerankConfig),
+		collections:   make(map[string]*Collection),
+		chunkSize:     defaultChunkSize,
+		chunkOverlap:  defaultChunkOverlap,
+	}
+	if n, err := strconv.Atoi(os.Getenv("CHUNK_SIZE")); err == nil && n > 0 {
+		s.chunkSize = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("CHUNK_OVERLAP")); err == nil && n >= 0 {
+		s.chunkOverlap = n
+	}
+
+	// Embedding cache: EMBEDDING_CACHE_SIZE=0 disables it, EMBEDDING_CACHE_DIR
+	// enables the on-disk tier.
+	size := defaultEmbeddingCacheSize
+	if v := os.Getenv("EMBEDDING_CACHE_SIZE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			size = n
+		}
+	}
+	if size > 0 {
+		cache, err := NewEmbeddingCache(size, os.Getenv("EMBEDDING_CACHE_DIR"))
+		if err != nil {
+			log.Printf("Embedding cache disabled: %v", err)
+		} else {
+			s.cache = cache
+		}
+	}
+
+	// Vector store: VECTOR_STORE_DIR makes it persistent, otherwise it lives
+	// in memory only.
+	opts := storeOptionsFromEnv()
+	s.store = NewMemoryStore(embedder.Model(), opts)
+	if dir := os.Getenv("VECTOR_STORE_DIR"); dir != "" {
+		start := time.Now()
+		store, err := OpenStore(dir, embedder.Model(), opts)
+		if err != nil {
+			log.Printf("Failed to open vector store, falling back to memory: %v", err)
+		} else {
+			log.Printf("Loaded %d vectors from %s in %v", store.Len(), dir, time.Since(start))
+			s.store = store
+		}
+	}
+
+	if err := s.loadCollections(); err != nil {
+		log.Printf("Failed to load collections: %v", err)
+	}
+
+	// The keyword index is derived from the stored text, so it is rebuilt
+	// rather than persisted.
+	s.store.Scan(func(rec Record) {
+		s.keywords.Add(rec.Namespace, rec.ID, rec.Text, rec.Metadata)
+	})
+
+	return s
 }
 
-func NewService() *Service {
-	return &Service{
-		apiKey: os.Getenv("DEEPSEEK_API_KEY"),
-		apiURL: "https://api.deepseek.com/v1/embeddings",
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+// storeOptionsFromEnv reads VECTOR_METRIC (cosine, dot, l2),
+// VECTOR_QUANTIZATION (none, int8) and VECTOR_RESCORE_FACTOR.
+func storeOptionsFromEnv() StoreOptions {
+	opts := StoreOptions{Metric: MetricCosine, RescoreFactor: 4}
+	if metric, err := ParseMetric(os.Getenv("VECTOR_METRIC")); err != nil {
+		log.Printf("%v, using cosine", err)
+	} else {
+		opts.Metric = metric
+	}
+	switch q := os.Getenv("VECTOR_QUANTIZATION"); q {
+	case "int8":
+		opts.Quantize = true
+	case "", "none":
+	default:
+		log.Printf("Unknown VECTOR_QUANTIZATION %q, storing float32", q)
 	}
+	if v := os.Getenv("VECTOR_RESCORE_FACTOR"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			opts.RescoreFactor = n
+		}
+	}
+	return opts
+}
+
+// SetFusionConfig changes how hybrid search merges its rankings.
+func (s *Service) SetFusionConfig(cfg FusionConfig) {
+	s.fusionMu.Lock()
+	defer s.fusionMu.Unlock()
+	s.fusion = cfg
+}
+
+func (s *Service) fusionConfig() FusionConfig {
+	s.fusionMu.RLock()
+	defer s.fusionMu.RUnlock()
+	return s.fusion
+}
+
+// SetReranker sets the completer used for LLM re-ranking. Without one, the
+// LLM stage is skipped even where it is enabled.
+func (s *Service) SetReranker(completer Completer) {
+	s.completer = completer
 }
 
-// GetEmbedding generates embeddings for the given text
-func (s *Service) GetEmbedding(ctx context.Context, text string) ([]float64, error) {
-	if s.apiKey == "" {
-		return nil, fmt.Errorf("API key not configured")
+// SetRerankConfig sets the post-retrieval stages for searches in namespace.
+func (s *Service) SetRerankConfig(namespace string, cfg RerankConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
 	}
+	s.setRerankConfig(namespace, cfg)
+	return nil
+}
 
-	req := EmbeddingRequest{
-		Model: "deepseek-embed",
-		Input: []string{text},
+func (s *Service) setRerankConfig(namespace string, cfg RerankConfig) {
+	s.rerankMu.Lock()
+	defer s.rerankMu.Unlock()
+	s.rerankConfigs[namespace] = cfg
+}
+
+// GetRerankConfig returns the post-retrieval stages for namespace, falling
+// back to the environment defaults.
+func (s *Service) GetRerankConfig(namespace string) RerankConfig {
+	s.rerankMu.RLock()
+	defer s.rerankMu.RUnlock()
+	if cfg, ok := s.rerankConfigs[namespace]; ok {
+		return cfg
 	}
+	return s.rerankDefault
+}
 
-	reqBody, err := json.Marshal(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
+// Model returns the embedding model of the configured embedder.
+func (s *Service) Model() string {
+	return s.embedder.Model()
+}
+
+// Store returns the underlying vector store.
+func (s *Service) Store() *Store {
+	return s.store
+}
+
+// SearchRequest describes a search within one namespace.
+type SearchRequest struct {
+	Namespace string  `json:"namespace"`
+	Query     string  `json:"query"`
+	K         int     `json:"k"`
+	Filter    *Filter `json:"filter,omitempty"`
+}
+
+// ValidateNamespace checks that a namespace name can be stored.
+func ValidateNamespace(namespace string) error {
+	if len(namespace) > 255 {
+		return invalidf("namespace too long")
 	}
+	if strings.ContainsRune(namespace, 0) {
+		return invalidf("namespace contains invalid characters")
+	}
+	return nil
+}
 
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", s.apiURL, bytes.NewBuffer(reqBody))
+// Upsert embeds text and stores it under namespace and id, replacing any
+// previous record
+func (s *Service) Upsert(ctx context.Context, namespace, id, text string, metadata map[string]string) error {
+	if err := ValidateNamespace(namespace); err != nil {
+		return err
+	}
+
+	embedding, err := s.GetEmbedding(ctx, text)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return err
 	}
 
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.apiKey))
+	if err := s.store.Put(Record{
+		Namespace: namespace,
+		ID:        id,
+		Vector:    embedding,
+		Text:      text,
+		Metadata:  metadata,
+	}); err != nil {
+		return err
+	}
+	s.keywords.Add(namespace, id, text, metadata)
+	return nil
+}
 
-	resp, err := s.httpClient.Do(httpReq)
+// Delete removes the record stored under namespace and id
+func (s *Service) Delete(ctx context.Context, namespace, id string) error {
+	existed, err := s.store.Delete(namespace, id)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return err
+	}
+	if !existed {
+		return fmt.Errorf("record not found")
+	}
+	s.keywords.Remove(namespace, id)
+	return nil
+}
+
+// Search returns the req.K records in req.Namespace that best match
+// req.Query and req.Filter. It runs a vector search and a BM25 keyword
+// search and merges them with reciprocal rank fusion, so exact matches on
+// identifiers are found even when their embeddings are not close. The filter
+// is applied inside both retrievers, never to their top-k output.
+//
+// If the namespace has re-ranking configured, fusion keeps a larger pool of
+// candidates which is then diversified with MMR and/or reordered by the LLM.
+func (s *Service) Search(ctx context.Context, req SearchRequest) ([]SearchResult, error) {
+	if err := req.Filter.Validate(); err != nil {
+		return nil, err
+	}
+	if req.K <= 0 || s.keywords.Len(req.Namespace) == 0 {
+		return []SearchResult{}, nil
+	}
+	cfg := s.GetRerankConfig(req.Namespace)
+	fusion := s.fusionConfig()
+	candidates := req.K * max(fusion.CandidateFactor, 1)
+
+	var embedding []float32
+	if fusion.VectorWeight > 0 || cfg.MMR {
+		var err error
+		embedding, err = s.GetEmbedding(ctx, req.Query)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var vectorResults []SearchResult
+	if fusion.VectorWeight > 0 {
+		vectorResults = s.store.Search(req.Namespace, embedding, candidates, req.Filter)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API request failed with status %d", resp.StatusCode)
+	var keywordResults []SearchResult
+	if fusion.KeywordWeight > 0 {
+		keywordResults = s.keywords.Search(req.Namespace, req.Query, candidates, req.Filter)
 	}
 
-	var embeddingResp EmbeddingResponse
-	if err := json.NewDecoder(resp.Body).Decode(&embeddingResp); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+	keep := req.K
+	if cfg.enabled() {
+		keep = candidates
+	}
+	results := FuseRankings(fusion, vectorResults, keywordResults, keep)
+	for i := range results {
+		// Keyword-only hits carry just an ID
+		if results[i].Text == "" {
+			if rec, ok := s.store.Get(req.Namespace, results[i].ID); ok {
+				results[i].Text = rec.Text
+				results[i].Metadata = rec.Metadata
+			}
+		}
 	}
 
-	if len(embeddingResp.Data) == 0 {
-		return nil, fmt.Errorf("no embeddings returned")
+	if cfg.enabled() {
+		results = s.rerank(ctx, req, cfg, embedding, results)
 	}
+	return results, nil
+}
 
-	return embeddingResp.Data[0].Embedding, nil
+// StartSnapshotLoop periodically compacts the vector store's write log.
+func (s *Service) StartSnapshotLoop(interval time.Duration) {
+	s.store.StartSnapshotLoop(interval)
 }
 
-// CosineSimilarity calculates the cosine similarity between two vectors
-func (s *Service) CosineSimilarity(a, b []float64) (float64, error) {
-	if len(a) != len(b) {
-		return 0, fmt.Errorf("vectors must have the same length")
+// Close flushes the vector store to disk
+func (s *Service) Close() error {
+	return s.store.Close()
+}
+
+// CacheStats returns the embedding cache counters. ok is false when the cache
+// is disabled.
+func (s *Service) CacheStats() (stats CacheStats, ok bool) {
+	if s.cache == nil {
+		return CacheStats{}, false
+	}
+	return s.cache.Stats(), true
+}
+
+// GetEmbedding generates embeddings for the given text, consulting the
+// embedding cache first
+func (s *Service) GetEmbedding(ctx context.Context, text string) ([]float32, error) {
+	embeddings, err := s.GetEmbeddings(ctx, []string{text})
+	if err != nil {
+		return nil, err
 	}
+	return embeddings[0], nil
+}
 
-	var dotProduct float64
-	var normA float64
-	var normB float64
+// GetEmbeddings generates embeddings for several texts, sending only the
+// texts missing from the cache to the embedder in one batch
+func (s *Service) GetEmbeddings(ctx context.Context, texts []string) ([][]float32, error) {
+	model := s.embedder.Model()
+	embeddings := make([][]float32, len(texts))
 
-	for i := range a {
-		dotProduct += a[i] * b[i]
-		normA += a[i] * a[i]
-		normB += b[i] * b[i]
+	var missing []int
+	for i, text := range texts {
+		if s.cache != nil {
+			if embedding, ok := s.cache.Get(model, text); ok {
+				embeddings[i] = embedding
+				continue
+			}
+		}
+		missing = append(missing, i)
+	}
+	if len(missing) == 0 {
+		return embeddings, nil
 	}
 
-	if normA == 0 || normB == 0 {
-		return 0, fmt.Errorf("zero vector")
+	batch := make([]string, len(missing))
+	for j, i := range missing {
+		batch[j] = texts[i]
+	}
+	fetched, err := s.embedder.Embed(ctx, batch)
+	if err != nil {
+		return nil, err
+	}
+	if len(fetched) != len(batch) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(batch), len(fetched))
 	}
 
-	return dotProduct / (normA * normB), nil
+	for j, i := range missing {
+		embeddings[i] = fetched[j]
+		if s.cache != nil {
+			if err := s.cache.Put(model, texts[i], fetched[j]); err != nil {
+				log.Printf("Failed to cache embedding: %v", err)
+			}
+		}
+	}
+	return embeddings, nil
+}
+
+// CosineSimilarity calculates the cosine similarity between two vectors
+func (s *Service) CosineSimilarity(a, b []float32) (float64, error) {
+	return CosineSimilarity(a, b)
 }